@@ -0,0 +1,460 @@
+// archive module for gofer 0.9
+// lists zip, tar, gzip, and compress (.Z) files from type 5 and 9 items as a
+// menu, with each member viewable or downloadable on its own
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ARCHIVE_PEEK = 512 // enough to see a tar header
+
+	FORMAT_ZIP      = "zip"
+	FORMAT_TAR      = "tar"
+	FORMAT_GZIP     = "gzip"
+	FORMAT_COMPRESS = "compress"
+)
+
+// extensions of members shown in the browser rather than only downloaded;
+// DOS archives mostly hold text under names like these
+var textMemberExts = map[string]bool{
+	".txt": true, ".doc": true, ".me": true, ".1st": true, ".nfo": true, ".diz": true,
+	".asc": true, ".lst": true, ".bat": true, ".bas": true, ".c": true, ".h": true,
+	".pas": true, ".asm": true, ".ini": true, ".cfg": true, ".log": true, ".md": true,
+	".sh": true, ".pl": true, ".py": true, ".man": true, ".faq": true, ".lsm": true,
+}
+
+// names that are text whatever their extension (README, COPYING, ...)
+var textMemberNames = map[string]bool{
+	"readme": true, "read.me": true, "copying": true, "license": true, "install": true,
+	"changes": true, "changelog": true, "news": true, "todo": true, "authors": true,
+	"makefile": true, "file_id.diz": true,
+}
+
+// archiveMember is one file or directory in an archive.
+type archiveMember struct {
+	Name string // slash-separated, without a leading "./" or "/"
+	Size int64
+	Dir  bool
+
+	zip  *zip.File // zip members are decompressed on demand
+	data []byte    // tar and single-file members are already in memory
+}
+
+// read returns the member's contents.
+func (m *archiveMember) read() ([]byte, error) {
+	if m.zip == nil {
+		return m.data, nil
+	}
+	rc, err := m.zip.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, DECODE_MAX_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > DECODE_MAX_SIZE {
+		return nil, errors.New("member is too large to extract")
+	}
+	return b, nil
+}
+
+// archive is the list of members read from a type 5 or 9 item.
+type archive struct {
+	Format  string // e.g. "zip", "tar.gz", "compress"
+	Members []*archiveMember
+}
+
+// archiveFormat recognizes an archive from its first bytes, or returns "".
+func archiveFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return FORMAT_ZIP
+	case bytes.HasPrefix(head, []byte{0x1F, 0x8B}):
+		return FORMAT_GZIP
+	case bytes.HasPrefix(head, []byte{LZW_MAGIC_1, LZW_MAGIC_2}):
+		return FORMAT_COMPRESS
+	case isTarHeader(head):
+		return FORMAT_TAR
+	}
+	return ""
+}
+
+// isTarHeader checks a tar header block's checksum, which old (pre-POSIX)
+// tar files have even though they lack the "ustar" magic.
+func isTarHeader(block []byte) bool {
+	if len(block) < 512 {
+		return false
+	}
+	field := strings.Trim(string(block[148:156]), " \x00")
+	want, err := strconv.ParseInt(field, 8, 64)
+	if err != nil {
+		return false
+	}
+	sum := int64(0)
+	for i, c := range block[:512] {
+		if i >= 148 && i < 156 {
+			c = ' '
+		}
+		sum += int64(c)
+	}
+	return sum == want
+}
+
+// -----------------------------------------------------------
+// openArchive(data, name) -> archive, error
+//
+// gzip and compress hold a single file, usually a tar file;
+// a tar file inside is listed, anything else is listed as
+// one member named after the item without its .gz or .Z.
+// -----------------------------------------------------------
+func openArchive(data []byte, name string) (*archive, error) {
+	switch archiveFormat(data) {
+	case FORMAT_ZIP:
+		return openZip(data)
+	case FORMAT_TAR:
+		return openTar(data, FORMAT_TAR)
+
+	case FORMAT_GZIP:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		inner, err := io.ReadAll(io.LimitReader(zr, DECODE_MAX_SIZE+1))
+		if err != nil {
+			return nil, fmt.Errorf("bad gzip data: %w", err)
+		}
+		if len(inner) > DECODE_MAX_SIZE {
+			return nil, errors.New("gzip data expands past the size limit")
+		}
+		if zr.Name != "" {
+			name = zr.Name
+		}
+		return openCompressed(inner, name, FORMAT_GZIP, ".gz", ".z", ".tgz")
+
+	case FORMAT_COMPRESS:
+		inner, err := uncompressLZW(data)
+		if err != nil {
+			return nil, err
+		}
+		return openCompressed(inner, name, FORMAT_COMPRESS, ".Z", ".taz")
+	}
+	return nil, errors.New("not an archive gofer can read")
+}
+
+// openCompressed lists what a gzip or compress file held.
+func openCompressed(inner []byte, name string, format string, exts ...string) (*archive, error) {
+	if isTarHeader(inner) {
+		return openTar(inner, "tar."+map[string]string{FORMAT_GZIP: "gz", FORMAT_COMPRESS: "Z"}[format])
+	}
+	for _, ext := range exts {
+		if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			name = strings.TrimSuffix(name, ext)
+			break
+		}
+	}
+	return &archive{
+		Format:  format,
+		Members: []*archiveMember{{Name: path.Base(name), Size: int64(len(inner)), data: inner}},
+	}, nil
+}
+
+func openZip(data []byte) (*archive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("bad zip file: %w", err)
+	}
+	a := &archive{Format: FORMAT_ZIP}
+	for _, f := range zr.File {
+		name := f.Name
+		if f.NonUTF8 {
+			name = cp437String([]byte(name)) // DOS zips
+		}
+		a.Members = append(a.Members, &archiveMember{
+			Name: cleanMemberName(name),
+			Size: int64(f.UncompressedSize64),
+			Dir:  f.FileInfo().IsDir(),
+			zip:  f,
+		})
+	}
+	return a, nil
+}
+
+// openTar reads every file into memory. A member's size comes from its
+// header, and PAX sparse headers can claim gigabytes for a few bytes of
+// archive, so each member, and all of them together, stay under
+// DECODE_MAX_SIZE.
+func openTar(data []byte, format string) (*archive, error) {
+	tr := tar.NewReader(bytes.NewReader(data))
+	a := &archive{Format: format}
+	total := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad tar file: %w", err)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			a.Members = append(a.Members, &archiveMember{Name: cleanMemberName(h.Name), Dir: true})
+		case tar.TypeReg:
+			room := DECODE_MAX_SIZE - total
+			if h.Size > int64(room) {
+				return nil, fmt.Errorf("%s: the tar file expands past the size limit", cleanMemberName(h.Name))
+			}
+			b, err := io.ReadAll(io.LimitReader(tr, int64(room)+1))
+			if err != nil {
+				return nil, fmt.Errorf("bad tar file: %w", err)
+			}
+			if len(b) > room {
+				return nil, fmt.Errorf("%s: the tar file expands past the size limit", cleanMemberName(h.Name))
+			}
+			total += len(b)
+			a.Members = append(a.Members, &archiveMember{Name: cleanMemberName(h.Name), Size: int64(len(b)), data: b})
+		}
+		// links, devices, and the like have no contents to show
+	}
+}
+
+// cleanMemberName normalizes a stored path: slashes, no leading "./" or "/".
+func cleanMemberName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	dir := strings.HasSuffix(name, "/")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if dir && name != "" {
+		name += "/"
+	}
+	return name
+}
+
+// member finds a file by its full name.
+func (a *archive) member(name string) *archiveMember {
+	for _, m := range a.Members {
+		if m.Name == name && !m.Dir {
+			return m
+		}
+	}
+	return nil
+}
+
+// list returns the subdirectories and files directly inside dir ("" or "a/b/").
+// Directories that only appear in their files' paths are listed as well.
+func (a *archive) list(dir string) ([]string, []*archiveMember) {
+	seen := map[string]bool{}
+	var dirs []string
+	var files []*archiveMember
+
+	for _, m := range a.Members {
+		rest, ok := strings.CutPrefix(m.Name, dir)
+		if !ok || rest == "" {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if sub := dir + rest[:i+1]; !seen[sub] {
+				seen[sub] = true
+				dirs = append(dirs, sub)
+			}
+		} else if !m.Dir {
+			files = append(files, m)
+		}
+	}
+
+	sort.Strings(dirs)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return dirs, files
+}
+
+// --- Member Text ---
+
+// the upper half of code page 437, the DOS character set
+const cp437High = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+	"└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■ "
+
+var cp437 = []rune(cp437High)
+
+// cp437String converts DOS text to UTF-8.
+func cp437String(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c < 0x80 {
+			s.WriteByte(c)
+		} else {
+			s.WriteRune(cp437[c-0x80])
+		}
+	}
+	return s.String()
+}
+
+// isTextMember says whether a member gets a "view" link.
+func isTextMember(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	if textMemberNames[base] || textMemberExts[path.Ext(base)] {
+		return true
+	}
+	return strings.HasPrefix(guessType(base), "text/")
+}
+
+// memberText prepares a text member for the browser. Text that isn't UTF-8
+// is read as DOS text from type 5 items and as Latin-1 from anything else.
+func memberText(b []byte, itemType byte) []byte {
+	b = bytes.TrimRight(b, "\x1A") // DOS end-of-file marker
+	if utf8.Valid(b) {
+		return b
+	}
+	if itemType == '5' {
+		return []byte(cp437String(b))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return []byte(string(runes))
+}
+
+// --- HTTP Handler ---
+
+// archiveLineView is one line of the "archive" menu.
+type archiveLineView struct {
+	Icon     string
+	Name     string
+	Href     string // directories and viewable members
+	Size     int64
+	Download string // files only
+}
+
+// archiveView is the data for the "archive" template.
+type archiveView struct {
+	pageHead
+	Name     string
+	Format   string
+	Count    int
+	Dir      string
+	Lines    []archiveLineView
+	Error    string
+	Original string
+	Return   returnLink
+}
+
+// -----------------------------------------------------------
+// serveArchive(w, r, g, conn) -> error
+//
+// Type 5 and 9 items that turn out to be archives are listed
+// as a menu: dir=path/ opens a directory, member=name sends a
+// file, and member=name&view=1 shows a text file in the
+// browser. Anything else is downloaded as before, and raw=1
+// (handled by serveGopher) always downloads the item itself.
+// -----------------------------------------------------------
+func serveArchive(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	head, _ := conn.reader.Peek(ARCHIVE_PEEK)
+	if archiveFormat(head) == "" {
+		return serveBinary(w, r, g, conn)
+	}
+
+	query := r.URL.Query()
+	view := archiveView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Name:     itemFileName(g),
+		Dir:      cleanMemberName(query.Get("dir")),
+		Original: withParams(r, "raw", "1", "download", "1"),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	}
+	if view.Dir != "" && !strings.HasSuffix(view.Dir, "/") {
+		view.Dir += "/"
+	}
+
+	data, err := readDecodable(conn)
+	var a *archive
+	if err == nil {
+		a, err = openArchive(data, itemFileName(g))
+	}
+
+	if name := query.Get("member"); name != "" && err == nil {
+		if m := a.member(name); m == nil {
+			err = fmt.Errorf("the archive has no file named %q", name)
+		} else if b, readErr := m.read(); readErr != nil {
+			err = fmt.Errorf("%s: %w", name, readErr)
+		} else if query.Get("view") == "1" {
+			setRemoteHeaders(w, "text/plain; charset=utf-8")
+			_, err := w.Write(memberText(b, g.Type))
+			return err
+		} else {
+			return sendDownload(w, safeFileName(path.Base(name)), b)
+		}
+	}
+
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Format = a.Format
+		for _, m := range a.Members {
+			if !m.Dir {
+				view.Count++
+			}
+		}
+		view.Lines = archiveLines(r, a, view.Dir)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return renderTemplate(w, "archive", view)
+}
+
+// archiveLines builds the menu for one directory of an archive.
+func archiveLines(r *http.Request, a *archive, dir string) []archiveLineView {
+	var lines []archiveLineView
+	menuIcon := kindOf('1').Icon
+
+	if dir != "" {
+		parent := path.Dir(strings.TrimSuffix(dir, "/")) + "/"
+		if parent == "./" {
+			parent = ""
+		}
+		lines = append(lines, archiveLineView{Icon: menuIcon, Name: "..", Href: withParams(r, "dir", parent)})
+	}
+
+	dirs, files := a.list(dir)
+	for _, d := range dirs {
+		lines = append(lines, archiveLineView{
+			Icon: menuIcon,
+			Name: strings.TrimPrefix(d, dir),
+			Href: withParams(r, "dir", d),
+		})
+	}
+	for _, m := range files {
+		line := archiveLineView{
+			Icon:     kindOf('9').Icon,
+			Name:     strings.TrimPrefix(m.Name, dir),
+			Size:     m.Size,
+			Download: withParams(r, "member", m.Name),
+		}
+		switch {
+		case isTextMember(m.Name):
+			line.Icon = kindOf('0').Icon
+			line.Href = withParams(r, "member", m.Name, "view", "1")
+		case strings.HasPrefix(guessType(m.Name), "image/"):
+			line.Icon = kindOf('I').Icon
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
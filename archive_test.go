@@ -0,0 +1,207 @@
+// archive tests for gofer 0.9
+// zip, tar, and gzip listings, and tar files that claim more than they hold
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testTar writes a tar file with a directory, two files, and a symlink.
+func testTar(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, h := range []*tar.Header{
+		{Name: "./pkg/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./pkg/README", Typeflag: tar.TypeReg, Mode: 0o644, Size: 12},
+		{Name: "./pkg/src/main.c", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+		{Name: "./pkg/link", Typeflag: tar.TypeSymlink, Linkname: "README"},
+	} {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(map[string]string{"./pkg/README": "Read me now\n", "./pkg/src/main.c": "int;\n"}[h.Name]))
+	}
+	tw.Close()
+	return b.Bytes()
+}
+
+func gzipped(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Name = name
+	zw.Write(data)
+	zw.Close()
+	return b.Bytes()
+}
+
+// memberNames lists an archive's members in order, directories ending in "/".
+func memberNames(a *archive) string {
+	var names []string
+	for _, m := range a.Members {
+		names = append(names, m.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestOpenZip(t *testing.T) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	zw.Create("DOCS/")
+	w, _ := zw.Create("DOCS\\READ.ME")
+	w.Write([]byte("Hello from DOS\r\n"))
+	w, _ = zw.CreateHeader(&zip.FileHeader{Name: "caf\x82.txt", NonUTF8: true, Method: zip.Deflate})
+	w.Write([]byte(strings.Repeat("coffee ", 100)))
+	zw.Close()
+
+	a, err := openArchive(b.Bytes(), "docs.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Format != FORMAT_ZIP || memberNames(a) != "DOCS/ DOCS/READ.ME café.txt" {
+		t.Fatalf("%s: %s", a.Format, memberNames(a))
+	}
+	if !a.Members[0].Dir || a.Members[1].Dir {
+		t.Error("directory flags are wrong")
+	}
+	if got, err := a.member("café.txt").read(); err != nil || len(got) != 700 {
+		t.Errorf("deflated member: %d bytes, %v", len(got), err)
+	}
+	if got, _ := a.member("DOCS/READ.ME").read(); string(got) != "Hello from DOS\r\n" {
+		t.Errorf("stored member: %q", got)
+	}
+
+	if _, err := openArchive([]byte("PK\x03\x04 but nothing else"), "bad.zip"); err == nil {
+		t.Error("a truncated zip file opened")
+	}
+}
+
+func TestOpenTar(t *testing.T) {
+	data := testTar(t)
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{"pkg.tar", data, FORMAT_TAR},
+		{"pkg.tar.gz", gzipped(t, "pkg.tar", data), "tar.gz"},
+		{"pkg.tar.Z", compressLZW(data, 16, false), "tar.Z"},
+	}
+	for _, tt := range tests {
+		a, err := openArchive(tt.data, tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if a.Format != tt.format || memberNames(a) != "pkg/ pkg/README pkg/src/main.c" {
+			t.Errorf("%s: %s: %s", tt.name, a.Format, memberNames(a))
+			continue
+		}
+		if got, _ := a.member("pkg/README").read(); string(got) != "Read me now\n" {
+			t.Errorf("%s: README = %q", tt.name, got)
+		}
+		if dirs, files := a.list("pkg/"); len(dirs) != 1 || dirs[0] != "pkg/src/" || len(files) != 1 {
+			t.Errorf("%s: pkg/ lists %q and %d files", tt.name, dirs, len(files))
+		}
+	}
+}
+
+func TestOpenCompressedSingleFile(t *testing.T) {
+	tests := []struct {
+		name, format, member string
+		data                 []byte
+	}{
+		{"notes.txt.gz", FORMAT_GZIP, "notes.txt", gzipped(t, "", []byte("plain notes\n"))},
+		{"renamed.gz", FORMAT_GZIP, "original.txt", gzipped(t, "original.txt", []byte("plain notes\n"))},
+		{"notes.txt.Z", FORMAT_COMPRESS, "notes.txt", compressLZW([]byte("plain notes\n"), 16, false)},
+	}
+	for _, tt := range tests {
+		a, err := openArchive(tt.data, tt.name)
+		if err != nil || a.Format != tt.format || memberNames(a) != tt.member {
+			t.Errorf("%s: %v, %+v", tt.name, err, a)
+			continue
+		}
+		if got, _ := a.Members[0].read(); string(got) != "plain notes\n" {
+			t.Errorf("%s: %q", tt.name, got)
+		}
+	}
+}
+
+// tarBlock builds a ustar header block by hand, for headers tar.Writer won't write.
+func tarBlock(name string, typeflag byte, size int) []byte {
+	block := make([]byte, 512)
+	copy(block[0:], name)
+	copy(block[100:], "0000644\x00")
+	copy(block[108:], "0000000\x00")
+	copy(block[116:], "0000000\x00")
+	copy(block[124:], fmt.Sprintf("%011o\x00", size))
+	copy(block[136:], "00000000000\x00")
+	block[156] = typeflag
+	copy(block[257:], "ustar\x0000")
+	copy(block[148:], "        ")
+	sum := 0
+	for _, c := range block {
+		sum += int(c)
+	}
+	copy(block[148:], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// paxRecord formats one "length key=value" line, the length counting itself.
+func paxRecord(key, value string) string {
+	rest := " " + key + "=" + value + "\n"
+	n := len(rest) + 1
+	for len(fmt.Sprint(n))+len(rest) != n {
+		n++
+	}
+	return fmt.Sprint(n) + rest
+}
+
+func padBlock(b []byte) []byte {
+	return append(b, make([]byte, (512-len(b)%512)%512)...)
+}
+
+// sparseMember is a PAX 1.0 sparse file of realSize bytes, all of it a hole.
+func sparseMember(name string, realSize int) []byte {
+	pax := paxRecord("GNU.sparse.major", "1") + paxRecord("GNU.sparse.minor", "0") +
+		paxRecord("GNU.sparse.name", name) + paxRecord("GNU.sparse.realsize", fmt.Sprint(realSize))
+	b := tarBlock("PaxHeaders/"+name, tar.TypeXHeader, len(pax))
+	b = append(b, padBlock([]byte(pax))...)
+	b = append(b, tarBlock("GNUSparseFile.0/"+name, tar.TypeReg, 512)...)
+	return append(b, padBlock([]byte("0\n"))...) // a sparse map with no data regions
+}
+
+func TestOpenTarSparse(t *testing.T) {
+	huge := append(sparseMember("huge.bin", 3_000_000_000), make([]byte, 1024)...)
+
+	// make sure tar.Reader really believes the header
+	h, err := tar.NewReader(bytes.NewReader(huge)).Next()
+	if err != nil || h.Size != 3_000_000_000 {
+		t.Fatalf("test file: %+v, %v", h, err)
+	}
+
+	if _, err := openArchive(huge, "huge.tar"); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("3 GB sparse member: err = %v", err)
+	}
+	if _, err := openArchive(gzipped(t, "huge.tar", huge), "huge.tar.gz"); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("3 GB sparse member in a .tar.gz: err = %v", err)
+	}
+
+	// two members under the limit that add up past it
+	half := DECODE_MAX_SIZE/2 + 1
+	twice := append(sparseMember("a.bin", half), sparseMember("b.bin", half)...)
+	twice = append(twice, make([]byte, 1024)...)
+	if _, err := openArchive(twice, "twice.tar"); err == nil || !strings.Contains(err.Error(), "b.bin: the tar file expands past the size limit") {
+		t.Errorf("members adding up past the limit: err = %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+// gopher+ ASK forms for gofer 0.9
+// renders +ASK blocks as HTML forms and posts the answers back
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	ASK_ENDPOINT      = "/ask"
+	ASK_MAX_FILE_SIZE = 1 << 20 // ChooseFile uploads are sent inline, keep them small
+)
+
+// AskQuery is one line of a +ASK block, e.g. "Choose: Flavour?\tvanilla\tchocolate".
+type AskQuery struct {
+	Kind     string   // Ask, AskP, AskL, Choose, Select, ChooseFile, Note
+	Prompt   string   // the question shown to the user
+	Defaults []string // default answer, or the options for Choose
+}
+
+// answers reports whether the query contributes a line to the data block.
+func (q AskQuery) answers() bool {
+	return q.Kind != "Note"
+}
+
+// -----------------------------------------------------------
+// parseAskBlock(lines) -> queries
+//
+// Select defaults ride on the prompt ("Select: Include maps:1"),
+// everything else separates defaults and options with tabs.
+// -----------------------------------------------------------
+func parseAskBlock(lines []string) []AskQuery {
+	var queries []AskQuery
+
+	for _, line := range lines {
+		kind, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kind = strings.TrimSpace(kind)
+		rest = strings.TrimLeft(rest, " ")
+
+		parts := strings.Split(rest, "\t")
+		q := AskQuery{Kind: kind, Prompt: parts[0], Defaults: parts[1:]}
+
+		switch kind {
+		case "Ask", "AskP", "AskL", "Choose", "ChooseFile", "Note":
+		case "Select":
+			if i := strings.LastIndex(q.Prompt, ":"); i >= 0 {
+				q.Defaults = []string{strings.TrimSpace(q.Prompt[i+1:])}
+				q.Prompt = q.Prompt[:i]
+			}
+		default:
+			// unknown query types are shown as notes so the numbering stays honest
+			q.Kind = "Note"
+			q.Prompt = line
+		}
+
+		queries = append(queries, q)
+	}
+
+	return queries
+}
+
+// fetchAskQueries retrieves the +ASK block of an item via "selector\t!".
+func fetchAskQueries(host, port, selector string) ([]AskQuery, error) {
+	raw, err := gopherRequestBytes(host, port, selector+GOPHERPLUS_ITEM_ATTRIBUTES)
+	if err != nil {
+		return nil, err
+	}
+	body, err := stripPlusHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := parsePlusAttributes(string(body))
+	if len(attrs) == 0 || len(attrs[0].Blocks["ASK"]) == 0 {
+		return nil, fmt.Errorf("item has no +ASK block")
+	}
+	return parseAskBlock(attrs[0].Blocks["ASK"]), nil
+}
+
+// -----------------------------------------------------------
+// plusDataBlock(lines) -> "+-1\r\n...\r\n."
+//
+// A period-terminated Gopher+ data block. The terminator added
+// by gopherRequestData supplies the final CRLF.
+// -----------------------------------------------------------
+func plusDataBlock(lines []string) string {
+	var b strings.Builder
+	b.WriteString("+-1" + GOPHER_REQUEST_TERMINATOR)
+	for _, line := range lines {
+		if strings.HasPrefix(line, ".") {
+			line = "." + line // dot-stuffing
+		}
+		b.WriteString(line + GOPHER_REQUEST_TERMINATOR)
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+// splitAnswerLines normalises browser line endings for AskL and ChooseFile answers.
+func splitAnswerLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// collectAskAnswers turns the submitted form into data block lines, in query order.
+// Multi-line answers (AskL, ChooseFile) are prefixed with their line count.
+func collectAskAnswers(r *http.Request, queries []AskQuery) ([]string, error) {
+	var lines []string
+
+	for i, q := range queries {
+		if !q.answers() {
+			continue
+		}
+		name := fmt.Sprintf("q%d", i)
+
+		switch q.Kind {
+		case "AskL":
+			multi := splitAnswerLines(r.FormValue(name))
+			lines = append(lines, fmt.Sprint(len(multi)))
+			lines = append(lines, multi...)
+
+		case "Select":
+			if r.FormValue(name) != "" {
+				lines = append(lines, "1")
+			} else {
+				lines = append(lines, "0")
+			}
+
+		case "ChooseFile":
+			var multi []string
+			if file, _, err := r.FormFile(name); err == nil {
+				data, err := io.ReadAll(io.LimitReader(file, ASK_MAX_FILE_SIZE))
+				file.Close()
+				if err != nil {
+					return nil, fmt.Errorf("failed to read upload: %w", err)
+				}
+				multi = splitAnswerLines(string(data))
+			}
+			lines = append(lines, fmt.Sprint(len(multi)))
+			lines = append(lines, multi...)
+
+		default: // Ask, AskP, Choose
+			answer := strings.ReplaceAll(r.FormValue(name), "\r", "")
+			answer = strings.ReplaceAll(answer, "\n", " ")
+			lines = append(lines, answer)
+		}
+	}
+
+	return lines, nil
+}
+
+// askFieldView is the data for one form row of the "ask" template.
+type askFieldView struct {
+	Kind    string
+	Name    string // q<index>, read back by collectAskAnswers
+	Prompt  string
+	Value   string
+	Options []string
+	Checked bool
+}
+
+// formatAskForm prepares one form field per +ASK query.
+func formatAskForm(queries []AskQuery) []askFieldView {
+	var fields []askFieldView
+
+	for i, q := range queries {
+		field := askFieldView{Kind: q.Kind, Name: fmt.Sprintf("q%d", i), Prompt: q.Prompt}
+		if len(q.Defaults) > 0 {
+			field.Value = q.Defaults[0]
+		}
+
+		switch q.Kind {
+		case "AskL":
+			field.Value = strings.Join(q.Defaults, "\n")
+		case "Choose":
+			field.Options = q.Defaults
+		case "Select":
+			field.Checked = field.Value == "1"
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// --- HTTP Handler ---
+
+// HandleAsk renders the +ASK form on GET and submits the answers on POST.
+func HandleAsk(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	selector := query.Get("selector")
+	itemType := query.Get("type")
+
+	if host == "" || port == "" {
+		http.Error(w, "Missing host or port", http.StatusBadRequest)
+		return
+	}
+	if itemType == "" {
+		itemType = "0"
+	}
+
+	returnURL := query.Get("return")
+	if returnURL == "" {
+		returnURL = "/"
+	}
+
+	queries, err := fetchAskQueries(host, port, selector)
+	if servePinWarning(w, r, err) || servePolicyBlock(w, r, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodGet:
+		page := formatAskPage(host, port, selector, formatAskForm(queries), returnURL)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(ASK_MAX_FILE_SIZE); err != nil && err != http.ErrNotMultipart {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+
+		answers, err := collectAskAnswers(r, queries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// a Gopher+ server always sends a length line, and "--1" is an error;
+		// only a reply with no length line at all is taken as the document
+		raw, err := gopherRequestData(host, port, selector+"\t+\t1", plusDataBlock(answers))
+		if err == nil {
+			var body []byte
+			if body, err = stripPlusHeader(raw); err == nil {
+				raw = body
+			} else if errors.Is(err, errNotGopherPlus) {
+				err = nil
+			}
+		}
+		if err != nil {
+			synthetic := fmt.Sprintf("3Form submission failed: %s\t/\t%s\t%s\n.\n", err.Error(), host, port)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(formatMenuHTML(synthetic, host, port, selector, false)))
+			return
+		}
+
+		// the reply is the document itself, shown according to the item type
+		switch itemType[0] {
+		case '1':
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(formatMenuHTML(string(raw), host, port, selector, false)))
+		case '0':
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(raw)
+		default:
+			t := declaredType(&GopherURL{Type: itemType[0], Selector: selector})
+			if t == "" {
+				t = http.DetectContentType(raw)
+			}
+			setRemoteHeaders(w, t)
+			w.Write(raw)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// askLink builds the /ask route for a menu item, returning to the current menu afterwards.
+func askLink(itemType byte, host, port, selector, returnTo string) string {
+	return fmt.Sprintf("%s?type=%c&host=%s&port=%s&selector=%s&return=%s",
+		ASK_ENDPOINT, itemType,
+		url.QueryEscape(host), url.QueryEscape(port),
+		url.QueryEscape(selector), url.QueryEscape(returnTo),
+	)
+}
+
+// askView is the data for the "ask" template.
+type askView struct {
+	pageHead
+	Fields []askFieldView
+	Return returnLink
+}
+
+// HTML UI formatting function
+func formatAskPage(host, port, selector string, fields []askFieldView, returnURL string) string {
+	return renderString("ask", askView{
+		pageHead: pageHead{Title: fmt.Sprintf("gofer ask - %s:%s %s", host, port, selector)},
+		Fields:   fields,
+		Return:   returnLink{Href: returnURL, Label: "Exit Form"},
+	})
+}
@@ -0,0 +1,94 @@
+// ask tests for gofer 0.9
+// Gopher+ length lines on the replies to submitted +ASK forms
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestStripPlusHeader(t *testing.T) {
+	tests := []struct {
+		reply   string
+		body    string
+		err     string // substring of the error, "" for none
+		notPlus bool
+	}{
+		{reply: "+-1\r\nline\r\n.\r\n", body: "line\r\n"},
+		{reply: "+-2\r\nall of it", body: "all of it"},
+		{reply: "+4\r\nfour and more", body: "four"},
+		{reply: "--1\r\n1 No such item\r\n.\r\n", err: "No such item"},
+		{reply: "--2\r\n3 Try later\r\n", err: "Try later"},
+		{reply: "- a list item\r\n", notPlus: true},
+		{reply: "+1 is not a header\r\n", notPlus: true},
+		{reply: "plain text\r\n", notPlus: true},
+	}
+	for _, tt := range tests {
+		body, err := stripPlusHeader([]byte(tt.reply))
+		switch {
+		case tt.notPlus:
+			if err != errNotGopherPlus {
+				t.Errorf("%q: err = %v, want errNotGopherPlus", tt.reply, err)
+			}
+		case tt.err != "":
+			if err == nil || err == errNotGopherPlus || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err = %v, want the server's error", tt.reply, err)
+			}
+		case err != nil || string(body) != tt.body:
+			t.Errorf("%q: %q, %v, want %q", tt.reply, body, err, tt.body)
+		}
+	}
+}
+
+func TestHandleAskReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		want   string
+		forbid string
+	}{
+		{reply: "+-1\r\nThanks, Ann\r\n.\r\n", want: "Thanks, Ann", forbid: "+-1"},
+		{reply: "--1\r\n1 Name refused\r\n.\r\n", want: "server error: 1 Name refused"},
+		{reply: "- not a header\r\n", want: "- not a header"},
+	}
+
+	for _, tt := range tests {
+		host, port := listenLoopback(t, func(c net.Conn) {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			request, _ := r.ReadString('\n')
+			if strings.HasSuffix(request, GOPHERPLUS_ITEM_ATTRIBUTES+"\r\n") {
+				c.Write([]byte("+-1\r\n+INFO: 0Form\t/form\texample.org\t70\t+\r\n+ASK:\r\n Ask: Name\r\n.\r\n"))
+				return
+			}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+			}
+			c.Write([]byte(tt.reply))
+		})
+
+		target := "/ask?type=0&host=" + host + "&port=" + port + "&selector=%2Fform"
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(url.Values{"q0": {"Ann"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		HandleAsk(rec, req)
+
+		body := rec.Body.String()
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("%q: reply page lacks %q:\n%s", tt.reply, tt.want, body)
+		}
+		if tt.forbid != "" && strings.Contains(body, tt.forbid) {
+			t.Errorf("%q: reply page still has %q", tt.reply, tt.forbid)
+		}
+	}
+}
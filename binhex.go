@@ -0,0 +1,344 @@
+// binhex module for gofer 0.9
+// decodes BinHex 4.0 (type 4 items) into the data fork, the resource fork,
+// and an AppleDouble file, with a page showing the Finder info first
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	BINHEX_BANNER   = "(This file must be converted with BinHex"
+	BINHEX_ALPHABET = "!\"#$%&'()*+,-012345689@ABCDEFGHIJKLMNPQRSTUVXYZ[`abcdefhijklmpqr"
+	BINHEX_RLE      = 0x90
+)
+
+// binhexValues maps each character of the alphabet to its 6-bit value, or 0xFF.
+var binhexValues = func() (t [256]byte) {
+	for i := range t {
+		t[i] = 0xFF
+	}
+	for i := 0; i < len(BINHEX_ALPHABET); i++ {
+		t[BINHEX_ALPHABET[i]] = byte(i)
+	}
+	return t
+}()
+
+// BinHexFile is a decoded BinHex 4.0 file.
+type BinHexFile struct {
+	Name     string // converted from Mac Roman
+	Type     [4]byte
+	Creator  [4]byte
+	Flags    uint16 // Finder flags
+	Data     []byte
+	Resource []byte
+}
+
+// -----------------------------------------------------------
+// DecodeBinHex(text) -> file, error
+//
+// The encoded part runs from a ":" at the start of a line to
+// the next ":". Its characters carry 6 bits each; the bytes
+// they make are run-length encoded with 0x90, and hold the
+// name, type, creator, flags, and fork lengths, then the data
+// fork and the resource fork, each part followed by its CRC.
+// -----------------------------------------------------------
+func DecodeBinHex(text []byte) (*BinHexFile, error) {
+	if i := bytes.Index(text, []byte(BINHEX_BANNER)); i >= 0 {
+		text = text[i+len(BINHEX_BANNER):]
+	}
+
+	start := -1
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == 0 || text[i-1] == '\n' || text[i-1] == '\r') {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil, errors.New("no BinHex data found (missing the opening \":\")")
+	}
+
+	packed, err := binhexSixBit(text[start:])
+	if err != nil {
+		return nil, err
+	}
+	stream, err := binhexExpand(packed)
+	if err != nil {
+		return nil, err
+	}
+	return parseBinHexStream(stream)
+}
+
+// binhexSixBit turns the encoded characters into bytes, up to the closing ":".
+func binhexSixBit(text []byte) ([]byte, error) {
+	out := make([]byte, 0, len(text)*3/4)
+	var bits uint32
+	var nbits uint
+
+	for i, c := range text {
+		switch c {
+		case ':':
+			return out, nil
+		case '\r', '\n', '\t', ' ':
+			continue
+		}
+
+		v := binhexValues[c]
+		if v == 0xFF {
+			return nil, fmt.Errorf("invalid BinHex character %q at offset %d", c, i)
+		}
+		bits = bits<<6 | uint32(v)
+		nbits += 6
+		if nbits >= 8 {
+			nbits -= 8
+			out = append(out, byte(bits>>nbits))
+		}
+	}
+	return nil, errors.New("BinHex data ends without the closing \":\"")
+}
+
+// binhexExpand undoes the run-length encoding: 0x90 n repeats the previous
+// byte to n copies in all, and 0x90 0 is a literal 0x90.
+func binhexExpand(packed []byte) ([]byte, error) {
+	out := make([]byte, 0, len(packed))
+
+	for i := 0; i < len(packed); i++ {
+		c := packed[i]
+		if c != BINHEX_RLE {
+			out = append(out, c)
+			continue
+		}
+
+		i++
+		if i == len(packed) {
+			return nil, errors.New("BinHex run-length marker at the end of the data")
+		}
+		n := int(packed[i])
+		if n == 0 {
+			out = append(out, BINHEX_RLE)
+			continue
+		}
+		if len(out) == 0 {
+			return nil, errors.New("BinHex run-length marker with nothing to repeat")
+		}
+		if len(out)+n > DECODE_MAX_SIZE {
+			return nil, errors.New("BinHex data expands past the size limit")
+		}
+		last := out[len(out)-1]
+		for k := 1; k < n; k++ {
+			out = append(out, last)
+		}
+	}
+	return out, nil
+}
+
+// parseBinHexStream reads the header and both forks, checking each CRC.
+func parseBinHexStream(b []byte) (*BinHexFile, error) {
+	truncated := errors.New("BinHex data is truncated")
+
+	if len(b) < 1 {
+		return nil, truncated
+	}
+	nameLen := int(b[0])
+	headerLen := 1 + nameLen + 1 + 4 + 4 + 2 + 4 + 4
+	if len(b) < headerLen+2 {
+		return nil, truncated
+	}
+	if err := checkBinHexCRC("header", b[:headerLen], b[headerLen:]); err != nil {
+		return nil, err
+	}
+
+	f := &BinHexFile{Name: macRomanString(b[1 : 1+nameLen])}
+	h := b[1+nameLen+1:]
+	copy(f.Type[:], h[0:4])
+	copy(f.Creator[:], h[4:8])
+	f.Flags = binary.BigEndian.Uint16(h[8:10])
+	dataLen := int64(binary.BigEndian.Uint32(h[10:14]))
+	rsrcLen := int64(binary.BigEndian.Uint32(h[14:18]))
+
+	rest := b[headerLen+2:]
+	if int64(len(rest)) < dataLen+2 {
+		return nil, truncated
+	}
+	f.Data = rest[:dataLen]
+	if err := checkBinHexCRC("data fork", f.Data, rest[dataLen:]); err != nil {
+		return nil, err
+	}
+
+	rest = rest[dataLen+2:]
+	if int64(len(rest)) < rsrcLen+2 {
+		return nil, truncated
+	}
+	f.Resource = rest[:rsrcLen]
+	if err := checkBinHexCRC("resource fork", f.Resource, rest[rsrcLen:]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkBinHexCRC compares the CRC stored in the first two bytes of stored.
+func checkBinHexCRC(part string, data, stored []byte) error {
+	want := binary.BigEndian.Uint16(stored)
+	if got := crc16XModem(data); got != want {
+		return fmt.Errorf("%s CRC mismatch (computed %04X, file says %04X)", part, got, want)
+	}
+	return nil
+}
+
+// crc16XModem is the CCITT CRC (polynomial 0x1021, starting at 0) BinHex uses.
+func crc16XModem(data []byte) uint16 {
+	var crc uint16
+	for _, c := range data {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// --- Mac Files ---
+
+// the upper half of Mac Roman; the lower half is ASCII
+const macRomanHigh = "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø" +
+	"¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ"
+
+var macRoman = []rune(macRomanHigh)
+
+// macRomanString converts Mac Roman text (file names, type codes) to UTF-8.
+func macRomanString(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c < 0x80 {
+			s.WriteByte(c)
+		} else {
+			s.WriteRune(macRoman[c-0x80])
+		}
+	}
+	return s.String()
+}
+
+// fourCC shows a type or creator code the way the Finder does, e.g. 'TEXT'.
+func fourCC(code [4]byte) string {
+	for _, c := range code {
+		if c < 0x20 || c == 0x7F {
+			return fmt.Sprintf("0x%08X", binary.BigEndian.Uint32(code[:]))
+		}
+	}
+	return "'" + macRomanString(code[:]) + "'"
+}
+
+// FileName is the Mac name made safe for other file systems.
+func (f *BinHexFile) FileName() string {
+	return safeFileName(f.Name)
+}
+
+// AppleDouble returns the AppleDouble header file (RFC 1740) carrying the
+// Finder info and resource fork, named "._" plus the file name by convention.
+func (f *BinHexFile) AppleDouble() []byte {
+	const (
+		headerLen      = 26
+		entryLen       = 12
+		idResourceFork = 2
+		idFinderInfo   = 9
+		finderInfoLen  = 32
+	)
+
+	finderInfo := make([]byte, finderInfoLen)
+	copy(finderInfo[0:4], f.Type[:])
+	copy(finderInfo[4:8], f.Creator[:])
+	binary.BigEndian.PutUint16(finderInfo[8:10], f.Flags)
+
+	offset := uint32(headerLen + 2*entryLen)
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(0x00051607)) // AppleDouble magic
+	binary.Write(&b, binary.BigEndian, uint32(0x00020000)) // version 2
+	b.Write(make([]byte, 16))                              // filler
+	binary.Write(&b, binary.BigEndian, uint16(2))
+	binary.Write(&b, binary.BigEndian, [3]uint32{idFinderInfo, offset, finderInfoLen})
+	binary.Write(&b, binary.BigEndian, [3]uint32{idResourceFork, offset + finderInfoLen, uint32(len(f.Resource))})
+	b.Write(finderInfo)
+	b.Write(f.Resource)
+	return b.Bytes()
+}
+
+// --- HTTP Handler ---
+
+// binhexView is the data for the "binhex" template.
+type binhexView struct {
+	pageHead
+	Name         string
+	FileName     string
+	Type         string
+	Creator      string
+	Flags        string
+	DataSize     int
+	ResourceSize int
+	Data         string // download links
+	Resource     string
+	AppleDouble  string
+	Original     string
+	Error        string
+	Return       returnLink
+}
+
+// serveBinHex decodes a type 4 item. Without a fork parameter it shows the
+// file's Finder info and the downloads; fork=data, fork=rsrc, and
+// fork=appledouble send the parts. raw=1 (handled by serveGopher) is the .hqx itself.
+func serveBinHex(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	text, err := readDecodable(conn)
+
+	var file *BinHexFile
+	if err == nil {
+		file, err = DecodeBinHex(text)
+	}
+
+	if err == nil {
+		switch r.URL.Query().Get("fork") {
+		case "data":
+			return sendDownload(w, file.FileName(), file.Data)
+		case "rsrc":
+			return sendDownload(w, file.FileName()+".rsrc", file.Resource)
+		case "appledouble":
+			return sendDownload(w, "._"+file.FileName(), file.AppleDouble())
+		}
+	}
+
+	view := binhexView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Name:     itemFileName(g),
+		Original: withParams(r, "raw", "1", "download", "1"),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	}
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		view.Name = file.Name
+		view.FileName = file.FileName()
+		view.Type = fourCC(file.Type)
+		view.Creator = fourCC(file.Creator)
+		view.Flags = fmt.Sprintf("0x%04X", file.Flags)
+		view.DataSize = len(file.Data)
+		view.ResourceSize = len(file.Resource)
+		view.Data = withParams(r, "fork", "data")
+		view.Resource = withParams(r, "fork", "rsrc")
+		view.AppleDouble = withParams(r, "fork", "appledouble")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return renderTemplate(w, "binhex", view)
+}
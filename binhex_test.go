@@ -0,0 +1,110 @@
+// binhex tests for gofer 0.9
+// CRCs, run-length escapes, and headers of BinHex 4.0 files
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testHQX was made by an independent encoder: "Read Me", TEXT/ttxt, Finder
+// flags 0x0100, a data fork with a run of 40 "z"s and two literal 0x90s,
+// and an 8-byte resource fork.
+const testHQX = "(This file must be converted with BinHex 4.0)\r\n\r\n" +
+	":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%1`#3!`M9PdKPE'a[,#\"0B@-K$AU3+*!!N!!\r\n" +
+	"JC@jN$GTY!!!\"!(*cFQ0C'`:\r\n"
+
+func TestCRC16XModem(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		{"", 0x0000},
+		{"A", 0x58E5},
+		{"123456789", 0x31C3}, // the standard check value
+		{"\x90\x90\x90", 0xE36B},
+	}
+	for _, tt := range tests {
+		if got := crc16XModem([]byte(tt.data)); got != tt.want {
+			t.Errorf("crc16XModem(%q) = %04X, want %04X", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestBinhexExpand(t *testing.T) {
+	tests := []struct {
+		packed string
+		want   string
+		err    string
+	}{
+		{packed: "abc", want: "abc"},
+		{packed: "A\x90\x04B", want: "AAAAB"},
+		{packed: "\x90\x00", want: "\x90"},                   // the escape for a literal 0x90
+		{packed: "A\x90\x00\x90\x03", want: "A\x90\x90\x90"}, // a run of the literal
+		{packed: "A\x90\x01", want: "A"},                     // one copy in all
+		{packed: "A\x90", err: "at the end"},                 // marker with no count
+		{packed: "\x90\x05", err: "nothing to repeat"},       // run before any byte
+		{packed: "\x00\x90\xFF\x90\x00z", want: strings.Repeat("\x00", 255) + "\x90z"},
+	}
+	for _, tt := range tests {
+		got, err := binhexExpand([]byte(tt.packed))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err = %v, want %q", tt.packed, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%q = %q, %v, want %q", tt.packed, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeBinHex(t *testing.T) {
+	f, err := DecodeBinHex([]byte(testHQX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData := "Hello, Mac!\r" + strings.Repeat("z", 40) + "\x90\x90 end\r"
+	if f.Name != "Read Me" || fourCC(f.Type) != "'TEXT'" || fourCC(f.Creator) != "'ttxt'" || f.Flags != 0x0100 {
+		t.Errorf("header = %q %s %s %04X", f.Name, fourCC(f.Type), fourCC(f.Creator), f.Flags)
+	}
+	if string(f.Data) != wantData {
+		t.Errorf("data fork = %q", f.Data)
+	}
+	if !bytes.Equal(f.Resource, []byte("\x00\x00\x01\x00rsrc")) {
+		t.Errorf("resource fork = %q", f.Resource)
+	}
+
+	ad := f.AppleDouble()
+	if !bytes.HasPrefix(ad, []byte{0x00, 0x05, 0x16, 0x07}) || !bytes.HasSuffix(ad, f.Resource) || !bytes.Contains(ad, []byte("TEXTttxt\x01\x00")) {
+		t.Errorf("AppleDouble = % X", ad)
+	}
+}
+
+func TestDecodeBinHexCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"no opening colon", "(This file must be converted with BinHex 4.0)\r\nno data\r\n", "opening"},
+		{"no closing colon", strings.TrimSuffix(testHQX, ":\r\n"), "closing"},
+		{"invalid character", ":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%1`#3!`M9PdKPE'a[,#\"0B@-K$AU3+*!!N!!\r\nJC@jN$GTY!!!\"!(*cFQ0C'~:", "invalid BinHex character"},
+		{"header CRC", ":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%$!#3!`3!!%KPE'a[,#\"0B@-K$562FR0bBabl:", "header CRC mismatch"},
+		{"data fork CRC", ":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%$!#3!`4r)8KPE'a[,#\"0B@-K$3!!FR0bBabl:", "data fork CRC mismatch"},
+		{"resource fork CRC", ":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%$!#3!`4r)8KPE'a[,#\"0B@-K$562FR0bB`!\":", "resource fork CRC mismatch"},
+		{"truncated fork", ":\"e*PB@3J6@8!9%9B9(4dH(3\"!*!%$!#3!`4r)8KPE'a[,#\"0B@-K$562FR-:", "truncated"},
+		{"empty", "::", "truncated"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeBinHex([]byte(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
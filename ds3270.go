@@ -0,0 +1,729 @@
+// 3270 data stream module for gofer 0.9
+// decodes host writes (commands, orders, fields, EBCDIC) into a screen model
+// and encodes the inbound replies (AID, cursor, modified fields)
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TN3270_ROWS = 24
+	TN3270_COLS = 80
+)
+
+// 3270 commands; hosts use either the SNA or the local (CCW) code
+const (
+	CMD_W   = 0xF1 // write
+	CMD_EW  = 0xF5 // erase/write
+	CMD_EWA = 0x7E // erase/write alternate
+	CMD_RB  = 0xF2 // read buffer
+	CMD_RM  = 0xF6 // read modified
+	CMD_RMA = 0x6E // read modified all
+	CMD_EAU = 0x6F // erase all unprotected
+	CMD_WSF = 0xF3 // write structured field
+)
+
+var localCommands = map[byte]byte{
+	0x01: CMD_W, 0x05: CMD_EW, 0x0D: CMD_EWA, 0x02: CMD_RB,
+	0x06: CMD_RM, 0x0E: CMD_RMA, 0x0F: CMD_EAU, 0x11: CMD_WSF,
+}
+
+// buffer orders
+const (
+	ORDER_PT  = 0x05 // program tab
+	ORDER_GE  = 0x08 // graphic escape
+	ORDER_SBA = 0x11 // set buffer address
+	ORDER_EUA = 0x12 // erase unprotected to address
+	ORDER_IC  = 0x13 // insert cursor
+	ORDER_SF  = 0x1D // start field
+	ORDER_SA  = 0x28 // set attribute
+	ORDER_SFE = 0x29 // start field extended
+	ORDER_MF  = 0x2C // modify field
+	ORDER_RA  = 0x3C // repeat to address
+)
+
+// field attribute bits
+const (
+	FA_PROTECTED  = 0x20
+	FA_NUMERIC    = 0x10
+	FA_DISPLAY    = 0x0C // both bits set: nondisplay
+	FA_INTENSIFY  = 0x08
+	FA_NONDISPLAY = 0x0C
+	FA_MDT        = 0x01 // modified data tag
+)
+
+// write control character bits
+const (
+	WCC_RESET_MDT = 0x01
+	WCC_RESTORE   = 0x02 // unlock the keyboard
+	WCC_ALARM     = 0x04
+)
+
+// extended attribute types (SFE, SA, MF)
+const (
+	XA_ALL   = 0x00
+	XA_3270  = 0xC0
+	XA_COLOR = 0x42
+)
+
+// attention identifiers
+const (
+	AID_NONE  = 0x60
+	AID_SF    = 0x88 // structured field (query reply)
+	AID_ENTER = 0x7D
+	AID_CLEAR = 0x6D
+	AID_PA1   = 0x6C
+	AID_PA2   = 0x6E
+	AID_PA3   = 0x6B
+)
+
+// AID codes for the browser's key names
+var aidCodes = map[string]byte{
+	"enter": AID_ENTER, "clear": AID_CLEAR,
+	"pa1": AID_PA1, "pa2": AID_PA2, "pa3": AID_PA3,
+	"pf1": 0xF1, "pf2": 0xF2, "pf3": 0xF3, "pf4": 0xF4, "pf5": 0xF5, "pf6": 0xF6,
+	"pf7": 0xF7, "pf8": 0xF8, "pf9": 0xF9, "pf10": 0x7A, "pf11": 0x7B, "pf12": 0x7C,
+	"pf13": 0xC1, "pf14": 0xC2, "pf15": 0xC3, "pf16": 0xC4, "pf17": 0xC5, "pf18": 0xC6,
+	"pf19": 0xC7, "pf20": 0xC8, "pf21": 0xC9, "pf22": 0x4A, "pf23": 0x4B, "pf24": 0x4C,
+}
+
+// 12-bit buffer addresses are sent as two bytes from this table
+var addressCodes = [64]byte{
+	0x40, 0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6, 0xC7, 0xC8, 0xC9, 0x4A, 0x4B, 0x4C, 0x4D, 0x4E, 0x4F,
+	0x50, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7, 0xD8, 0xD9, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F,
+	0x60, 0x61, 0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0x6A, 0x6B, 0x6C, 0x6D, 0x6E, 0x6F,
+	0xF0, 0xF1, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8, 0xF9, 0x7A, 0x7B, 0x7C, 0x7D, 0x7E, 0x7F,
+}
+
+// --- EBCDIC ---
+
+// cp037 maps EBCDIC code page 037 (US/Canada) to Unicode.
+var cp037 = [256]rune{
+	0x0000, 0x0001, 0x0002, 0x0003, 0x009C, 0x0009, 0x0086, 0x007F,
+	0x0097, 0x008D, 0x008E, 0x000B, 0x000C, 0x000D, 0x000E, 0x000F,
+	0x0010, 0x0011, 0x0012, 0x0013, 0x009D, 0x0085, 0x0008, 0x0087,
+	0x0018, 0x0019, 0x0092, 0x008F, 0x001C, 0x001D, 0x001E, 0x001F,
+	0x0080, 0x0081, 0x0082, 0x0083, 0x0084, 0x000A, 0x0017, 0x001B,
+	0x0088, 0x0089, 0x008A, 0x008B, 0x008C, 0x0005, 0x0006, 0x0007,
+	0x0090, 0x0091, 0x0016, 0x0093, 0x0094, 0x0095, 0x0096, 0x0004,
+	0x0098, 0x0099, 0x009A, 0x009B, 0x0014, 0x0015, 0x009E, 0x001A,
+	0x0020, 0x00A0, 0x00E2, 0x00E4, 0x00E0, 0x00E1, 0x00E3, 0x00E5,
+	0x00E7, 0x00F1, 0x00A2, 0x002E, 0x003C, 0x0028, 0x002B, 0x007C,
+	0x0026, 0x00E9, 0x00EA, 0x00EB, 0x00E8, 0x00ED, 0x00EE, 0x00EF,
+	0x00EC, 0x00DF, 0x0021, 0x0024, 0x002A, 0x0029, 0x003B, 0x00AC,
+	0x002D, 0x002F, 0x00C2, 0x00C4, 0x00C0, 0x00C1, 0x00C3, 0x00C5,
+	0x00C7, 0x00D1, 0x00A6, 0x002C, 0x0025, 0x005F, 0x003E, 0x003F,
+	0x00F8, 0x00C9, 0x00CA, 0x00CB, 0x00C8, 0x00CD, 0x00CE, 0x00CF,
+	0x00CC, 0x0060, 0x003A, 0x0023, 0x0040, 0x0027, 0x003D, 0x0022,
+	0x00D8, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067,
+	0x0068, 0x0069, 0x00AB, 0x00BB, 0x00F0, 0x00FD, 0x00FE, 0x00B1,
+	0x00B0, 0x006A, 0x006B, 0x006C, 0x006D, 0x006E, 0x006F, 0x0070,
+	0x0071, 0x0072, 0x00AA, 0x00BA, 0x00E6, 0x00B8, 0x00C6, 0x00A4,
+	0x00B5, 0x007E, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, 0x0078,
+	0x0079, 0x007A, 0x00A1, 0x00BF, 0x00D0, 0x00DD, 0x00DE, 0x00AE,
+	0x005E, 0x00A3, 0x00A5, 0x00B7, 0x00A9, 0x00A7, 0x00B6, 0x00BC,
+	0x00BD, 0x00BE, 0x005B, 0x005D, 0x00AF, 0x00A8, 0x00B4, 0x00D7,
+	0x007B, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047,
+	0x0048, 0x0049, 0x00AD, 0x00F4, 0x00F6, 0x00F2, 0x00F3, 0x00F5,
+	0x007D, 0x004A, 0x004B, 0x004C, 0x004D, 0x004E, 0x004F, 0x0050,
+	0x0051, 0x0052, 0x00B9, 0x00FB, 0x00FC, 0x00F9, 0x00FA, 0x00FF,
+	0x005C, 0x00F7, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, 0x0058,
+	0x0059, 0x005A, 0x00B2, 0x00D4, 0x00D6, 0x00D2, 0x00D3, 0x00D5,
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037,
+	0x0038, 0x0039, 0x00B3, 0x00DB, 0x00DC, 0x00D9, 0x00DA, 0x009F,
+}
+
+// cp037Reverse maps Unicode back to EBCDIC for keyboard input.
+var cp037Reverse = func() map[rune]byte {
+	m := make(map[rune]byte, 256)
+	for i, r := range cp037 {
+		m[r] = byte(i)
+	}
+	return m
+}()
+
+// ebcdicString decodes EBCDIC text, showing nulls and controls as spaces.
+func ebcdicString(b []byte) string {
+	var out strings.Builder
+	for _, c := range b {
+		r := cp037[c]
+		if r < 0x20 || (r >= 0x7F && r < 0xA0) {
+			r = ' '
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// ebcdicByte encodes one character typed in the browser; unmappable ones become "?".
+func ebcdicByte(r rune) byte {
+	if c, ok := cp037Reverse[r]; ok && r >= 0x20 {
+		return c
+	}
+	return 0x6F
+}
+
+// --- Screen Model ---
+
+// screen3270 is the presentation space of one 3270 display.
+type screen3270 struct {
+	rows, cols int
+	buf        []byte // EBCDIC characters, 0 for null
+	attr       []int  // field attribute at this position, or -1
+	color      []byte // extended color from SFE/SA, 0 for the default
+	cursor     int
+	locked     bool // keyboard locked between an AID and the host's restore
+	alarm      bool
+	saColor    byte // SA color applied to characters in the current write
+}
+
+func newScreen3270(rows, cols int) *screen3270 {
+	s := &screen3270{rows: rows, cols: cols}
+	s.buf = make([]byte, rows*cols)
+	s.attr = make([]int, rows*cols)
+	s.color = make([]byte, rows*cols)
+	s.clear()
+	return s
+}
+
+func (s *screen3270) size() int { return s.rows * s.cols }
+
+// clear erases the presentation space, leaving it unformatted.
+func (s *screen3270) clear() {
+	for i := range s.buf {
+		s.buf[i] = 0
+		s.attr[i] = -1
+		s.color[i] = 0
+	}
+	s.cursor = 0
+}
+
+// decodeAddress reads a 12- or 14-bit buffer address.
+func (s *screen3270) decodeAddress(b1, b2 byte) int {
+	var addr int
+	if b1&0xC0 == 0 {
+		addr = int(b1&0x3F)<<8 | int(b2)
+	} else {
+		addr = int(b1&0x3F)<<6 | int(b2&0x3F)
+	}
+	return addr % s.size()
+}
+
+// encodeAddress writes a 12-bit buffer address.
+func encodeAddress(addr int) []byte {
+	return []byte{addressCodes[(addr>>6)&0x3F], addressCodes[addr&0x3F]}
+}
+
+// fieldStart returns the attribute position of the field holding addr,
+// or -1 on an unformatted screen.
+func (s *screen3270) fieldStart(addr int) int {
+	n := s.size()
+	for i := 0; i < n; i++ {
+		p := (addr - i + n) % n
+		if s.attr[p] >= 0 {
+			return p
+		}
+	}
+	return -1
+}
+
+// protected reports whether the character position addr can't be typed into.
+func (s *screen3270) protected(addr int) bool {
+	if s.attr[addr] >= 0 {
+		return true
+	}
+	start := s.fieldStart(addr)
+	return start >= 0 && s.attr[start]&FA_PROTECTED != 0
+}
+
+// putChar stores a character, replacing any field attribute at addr.
+func (s *screen3270) putChar(addr int, c byte) {
+	s.attr[addr] = -1
+	s.buf[addr] = c
+	s.color[addr] = s.saColor
+}
+
+// -----------------------------------------------------------
+// process(record) -> reply
+//
+// Runs one outbound 3270 record. Read commands and queries
+// return the inbound record to send back to the host.
+// -----------------------------------------------------------
+func (s *screen3270) process(record []byte) ([]byte, error) {
+	if len(record) == 0 {
+		return nil, nil
+	}
+
+	cmd := record[0]
+	if c, ok := localCommands[cmd]; ok {
+		cmd = c
+	}
+
+	switch cmd {
+	case CMD_W:
+		s.write(record[1:], false)
+	case CMD_EW, CMD_EWA:
+		s.clear()
+		s.write(record[1:], true)
+	case CMD_RB:
+		return s.readBuffer(AID_NONE), nil
+	case CMD_RM:
+		return s.readModified(AID_NONE, false), nil
+	case CMD_RMA:
+		return s.readModified(AID_NONE, true), nil
+	case CMD_EAU:
+		s.eraseUnprotected()
+	case CMD_WSF:
+		return s.structuredFields(record[1:])
+	default:
+		return nil, fmt.Errorf("unknown 3270 command %#02x", record[0])
+	}
+	return nil, nil
+}
+
+// write applies a WCC and a run of orders and data.
+func (s *screen3270) write(data []byte, erased bool) {
+	if len(data) == 0 {
+		return
+	}
+
+	wcc := data[0]
+	if wcc&WCC_RESET_MDT != 0 {
+		for i, a := range s.attr {
+			if a >= 0 {
+				s.attr[i] = a &^ FA_MDT
+			}
+		}
+	}
+
+	n := s.size()
+	addr := s.cursor
+	if erased {
+		addr = 0
+	}
+	s.saColor = 0
+
+	next := func(a int) int { return (a + 1) % n }
+
+	for i := 1; i < len(data); {
+		b := data[i]
+		switch b {
+		case ORDER_SF:
+			if i+1 >= len(data) {
+				return
+			}
+			s.buf[addr], s.color[addr] = 0, 0
+			s.attr[addr] = int(data[i+1])
+			addr = next(addr)
+			i += 2
+
+		case ORDER_SFE, ORDER_MF:
+			if i+1 >= len(data) || i+2+2*int(data[i+1]) > len(data) {
+				return
+			}
+			pairs := data[i+2 : i+2+2*int(data[i+1])]
+			if b == ORDER_SFE {
+				s.buf[addr], s.color[addr] = 0, 0
+				s.attr[addr] = 0
+			}
+			if s.attr[addr] >= 0 {
+				for j := 0; j+1 < len(pairs); j += 2 {
+					switch pairs[j] {
+					case XA_3270:
+						s.attr[addr] = int(pairs[j+1])
+					case XA_COLOR:
+						s.color[addr] = pairs[j+1]
+					}
+				}
+			}
+			addr = next(addr)
+			i += 2 + len(pairs)
+
+		case ORDER_SBA:
+			if i+2 >= len(data) {
+				return
+			}
+			addr = s.decodeAddress(data[i+1], data[i+2])
+			i += 3
+
+		case ORDER_SA:
+			if i+2 >= len(data) {
+				return
+			}
+			switch data[i+1] {
+			case XA_COLOR:
+				s.saColor = data[i+2]
+			case XA_ALL:
+				s.saColor = 0
+			}
+			i += 3
+
+		case ORDER_IC:
+			s.cursor = addr
+			i++
+
+		case ORDER_PT:
+			addr = s.nextInputPosition(addr)
+			i++
+
+		case ORDER_RA:
+			if i+3 >= len(data) {
+				return
+			}
+			stop := s.decodeAddress(data[i+1], data[i+2])
+			c := data[i+3]
+			i += 4
+			if c == ORDER_GE && i < len(data) {
+				c = data[i]
+				i++
+			}
+			for {
+				s.putChar(addr, c)
+				addr = next(addr)
+				if addr == stop {
+					break
+				}
+			}
+
+		case ORDER_EUA:
+			if i+2 >= len(data) {
+				return
+			}
+			stop := s.decodeAddress(data[i+1], data[i+2])
+			i += 3
+			for {
+				if !s.protected(addr) {
+					s.buf[addr] = 0
+				}
+				addr = next(addr)
+				if addr == stop {
+					break
+				}
+			}
+
+		case ORDER_GE:
+			if i+1 >= len(data) {
+				return
+			}
+			s.putChar(addr, data[i+1])
+			addr = next(addr)
+			i += 2
+
+		default:
+			s.putChar(addr, b)
+			addr = next(addr)
+			i++
+		}
+	}
+
+	if wcc&WCC_RESTORE != 0 {
+		s.locked = false
+	}
+	if wcc&WCC_ALARM != 0 {
+		s.alarm = true
+	}
+}
+
+// nextInputPosition finds the first character position of the next unprotected field.
+func (s *screen3270) nextInputPosition(addr int) int {
+	n := s.size()
+	for i := 1; i <= n; i++ {
+		p := (addr + i) % n
+		if s.attr[p] >= 0 && s.attr[p]&FA_PROTECTED == 0 {
+			return (p + 1) % n
+		}
+	}
+	return addr
+}
+
+// eraseUnprotected nulls every input field, resets MDTs, and unlocks the keyboard.
+func (s *screen3270) eraseUnprotected() {
+	for i := range s.buf {
+		if s.attr[i] >= 0 {
+			s.attr[i] &^= FA_MDT
+		} else if !s.protected(i) {
+			s.buf[i] = 0
+		}
+	}
+	s.cursor = s.nextInputPosition(s.size() - 1)
+	s.locked = false
+}
+
+// --- Inbound Records ---
+
+// readModified builds the reply to an AID or a Read Modified command:
+// AID, cursor address, then SBA plus data for every modified field.
+func (s *screen3270) readModified(aid byte, all bool) []byte {
+	out := []byte{aid}
+
+	// Clear and the PA keys send only the AID (a "short read")
+	if !all && (aid == AID_CLEAR || aid == AID_PA1 || aid == AID_PA2 || aid == AID_PA3) {
+		return out
+	}
+	out = append(out, encodeAddress(s.cursor)...)
+
+	if s.fieldStart(0) < 0 { // unformatted screen: all the text, nulls suppressed
+		for _, c := range s.buf {
+			if c != 0 {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+
+	n := s.size()
+	for p := 0; p < n; p++ {
+		if s.attr[p] < 0 || s.attr[p]&FA_MDT == 0 {
+			continue
+		}
+		out = append(out, ORDER_SBA)
+		out = append(out, encodeAddress((p+1)%n)...)
+		for q := (p + 1) % n; s.attr[q] < 0 && q != p; q = (q + 1) % n {
+			if s.buf[q] != 0 {
+				out = append(out, s.buf[q])
+			}
+		}
+	}
+	return out
+}
+
+// readBuffer returns the whole presentation space, with SF orders for the attributes.
+func (s *screen3270) readBuffer(aid byte) []byte {
+	out := []byte{aid}
+	out = append(out, encodeAddress(s.cursor)...)
+	for i, c := range s.buf {
+		if s.attr[i] >= 0 {
+			out = append(out, ORDER_SF, byte(s.attr[i]))
+		} else {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// structuredFields handles WSF. Read Partition queries get a minimal
+// query reply; an outbound 3270DS field runs its embedded write.
+func (s *screen3270) structuredFields(data []byte) ([]byte, error) {
+	var reply []byte
+
+	for len(data) >= 3 {
+		length := int(data[0])<<8 | int(data[1])
+		if length == 0 || length > len(data) {
+			length = len(data)
+		}
+		if length < 3 {
+			break
+		}
+		field := data[:length]
+		data = data[length:]
+
+		switch field[2] {
+		case 0x01: // Read Partition
+			if len(field) >= 5 && (field[4] == 0x02 || field[4] == 0x03) {
+				reply = s.queryReply()
+			}
+		case 0x40: // Outbound 3270DS: partition, command, then a normal write
+			if len(field) >= 5 {
+				r, err := s.process(field[4:])
+				if err != nil {
+					return nil, err
+				}
+				if r != nil {
+					reply = r
+				}
+			}
+		}
+	}
+	return reply, nil
+}
+
+// queryReply describes the display: summary, usable area, and implicit partition.
+func (s *screen3270) queryReply() []byte {
+	w, h := s.cols, s.rows
+	summary := []byte{0x00, 0x07, 0x81, 0x80, 0x80, 0x81, 0xA6}
+
+	usable := []byte{0x00, 0x17, 0x81, 0x81,
+		0x01, 0x00, // 12/14-bit addressing
+		byte(w >> 8), byte(w), byte(h >> 8), byte(h),
+		0x01,                   // units: millimetres
+		0x00, 0x0A, 0x02, 0xE5, // horizontal resolution
+		0x00, 0x02, 0x00, 0x6F, // vertical resolution
+		0x09, 0x0C, // cell width and height
+		byte((w * h) >> 8), byte(w * h),
+	}
+
+	partition := []byte{0x00, 0x11, 0x81, 0xA6,
+		0x00, 0x00,
+		0x0B, 0x01, 0x00,
+		byte(w >> 8), byte(w), byte(h >> 8), byte(h),
+		byte(w >> 8), byte(w), byte(h >> 8), byte(h),
+	}
+
+	out := []byte{AID_SF}
+	out = append(out, summary...)
+	out = append(out, usable...)
+	return append(out, partition...)
+}
+
+// --- Keyboard Input ---
+
+// typeInto writes text typed into the input at addr (width n) into the
+// buffer, padding with nulls, and sets the field's MDT if anything changed.
+func (s *screen3270) typeInto(addr, n int, text string) {
+	size := s.size()
+	if addr < 0 || addr >= size || n <= 0 {
+		return
+	}
+	runes := []rune(text)
+
+	for i := 0; i < n; i++ {
+		p := (addr + i) % size
+		if s.protected(p) {
+			break
+		}
+		var c byte
+		if i < len(runes) {
+			c = ebcdicByte(runes[i])
+		}
+		if s.buf[p] != c {
+			s.buf[p] = c
+			if start := s.fieldStart(p); start >= 0 {
+				s.attr[start] |= FA_MDT
+			}
+		}
+	}
+}
+
+// aid presses an attention key and returns the inbound record. Clear also
+// erases the screen; every AID locks the keyboard until the host restores it.
+func (s *screen3270) aid(code byte) []byte {
+	if code == AID_CLEAR {
+		s.clear()
+	}
+	s.locked = true
+	s.alarm = false
+	return s.readModified(code, false)
+}
+
+// --- Browser View ---
+
+// segment3270 is a run of one row with the same presentation.
+type segment3270 struct {
+	Text    string `json:"t"`
+	Addr    int    `json:"a"`
+	Len     int    `json:"n"`
+	Input   bool   `json:"in,omitempty"`
+	Hidden  bool   `json:"hide,omitempty"`
+	Numeric bool   `json:"num,omitempty"`
+	Bold    bool   `json:"b,omitempty"`
+	Color   string `json:"c"`
+}
+
+// screenView3270 is the screen as sent to the browser.
+type screenView3270 struct {
+	Rows   [][]segment3270 `json:"rows"`
+	Cursor int             `json:"cursor"`
+	Locked bool            `json:"locked"`
+	Alarm  bool            `json:"alarm"`
+}
+
+var colorNames = map[byte]string{
+	0xF1: "blue", 0xF2: "red", 0xF3: "pink", 0xF4: "green",
+	0xF5: "turquoise", 0xF6: "yellow", 0xF7: "white",
+}
+
+// view splits the screen into per-row segments of text and input fields.
+func (s *screen3270) view() screenView3270 {
+	v := screenView3270{Cursor: s.cursor, Locked: s.locked, Alarm: s.alarm}
+
+	for r := 0; r < s.rows; r++ {
+		var row []segment3270
+		var text []byte
+
+		for c := 0; c < s.cols; c++ {
+			p := r*s.cols + c
+			seg := s.cellSegment(p)
+
+			last := len(row) - 1
+			if last >= 0 && sameSegment(row[last], seg) {
+				row[last].Len++
+				text = append(text, s.buf[p])
+			} else {
+				if last >= 0 {
+					row[last].Text = segmentText(text, row[last])
+				}
+				row = append(row, seg)
+				text = []byte{s.buf[p]}
+			}
+		}
+		row[len(row)-1].Text = segmentText(text, row[len(row)-1])
+
+		v.Rows = append(v.Rows, row)
+	}
+	return v
+}
+
+// cellSegment describes position p as a one-cell segment.
+func (s *screen3270) cellSegment(p int) segment3270 {
+	seg := segment3270{Addr: p, Len: 1}
+
+	if s.attr[p] >= 0 { // attribute positions show as blanks
+		seg.Color = "blue"
+		return seg
+	}
+
+	start := s.fieldStart(p)
+	fa := 0 // an unformatted screen is one big input field
+	if start >= 0 {
+		fa = s.attr[start]
+	}
+
+	seg.Input = fa&FA_PROTECTED == 0
+	seg.Numeric = seg.Input && fa&FA_NUMERIC != 0
+	seg.Hidden = fa&FA_DISPLAY == FA_NONDISPLAY
+	seg.Bold = fa&FA_DISPLAY == FA_INTENSIFY
+
+	switch {
+	case colorNames[s.color[p]] != "":
+		seg.Color = colorNames[s.color[p]]
+	case start >= 0 && colorNames[s.color[start]] != "":
+		seg.Color = colorNames[s.color[start]]
+	case seg.Input && seg.Bold:
+		seg.Color = "red"
+	case seg.Input:
+		seg.Color = "green"
+	case seg.Bold:
+		seg.Color = "white"
+	default:
+		seg.Color = "blue"
+	}
+	return seg
+}
+
+// sameSegment reports whether b continues segment a. Two input fields never
+// merge, since a new field always starts with an (uninputtable) attribute position.
+func sameSegment(a, b segment3270) bool {
+	return a.Input == b.Input && a.Hidden == b.Hidden && a.Numeric == b.Numeric &&
+		a.Bold == b.Bold && a.Color == b.Color && a.Addr+a.Len == b.Addr
+}
+
+// segmentText decodes a segment; inputs drop trailing nulls so the browser
+// sees only what was typed, and nondisplay text never leaves gofer.
+func segmentText(b []byte, seg segment3270) string {
+	if seg.Hidden && !seg.Input {
+		return strings.Repeat(" ", len(b))
+	}
+	if seg.Input {
+		end := len(b)
+		for end > 0 && b[end-1] == 0 {
+			end--
+		}
+		b = b[:end]
+	}
+	return ebcdicString(b)
+}
@@ -0,0 +1,208 @@
+// 3270 data stream tests for gofer 0.9
+// orders decoded into the screen model, and the inbound records read back
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ebcdic encodes text the way a host sends it.
+func ebcdic(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		b = append(b, ebcdicByte(r))
+	}
+	return b
+}
+
+// stream joins orders, addresses, and text into one record.
+func stream(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestAddressRoundTrip(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	for _, addr := range []int{0, 1, 63, 64, 79, 80, 1000, s.size() - 1} {
+		b := encodeAddress(addr)
+		if got := s.decodeAddress(b[0], b[1]); got != addr {
+			t.Errorf("decodeAddress(encodeAddress(%d)) = %d", addr, got)
+		}
+	}
+
+	// 14-bit addresses have the top two bits of the first byte clear
+	if got := s.decodeAddress(0x07, 0x7F); got != 0x77F {
+		t.Errorf("14-bit address = %d, want %d", got, 0x77F)
+	}
+}
+
+func TestScreen3270Orders(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	record := stream(
+		[]byte{CMD_EW, 0xC3}, // reset MDTs, restore the keyboard
+		[]byte{ORDER_SBA}, encodeAddress(0),
+		[]byte{ORDER_SF, 0x60}, ebcdic("HELLO"), // protected label at 1..5
+		[]byte{ORDER_SFE, 2, XA_3270, 0x40, XA_COLOR, 0xF2}, ebcdic("AB"), // red input from 7
+		[]byte{ORDER_IC},
+		[]byte{ORDER_RA}, encodeAddress(15), ebcdic("X"), // 9..14
+		[]byte{ORDER_SBA}, encodeAddress(7),
+		[]byte{ORDER_EUA}, encodeAddress(9), // erases the "AB"
+	)
+	s.locked = true
+
+	if reply, err := s.process(record); err != nil || reply != nil {
+		t.Fatalf("process = %x, %v", reply, err)
+	}
+
+	if s.attr[0] != 0x60 {
+		t.Errorf("SF attribute = %#x, want 0x60", s.attr[0])
+	}
+	if got := ebcdicString(s.buf[1:6]); got != "HELLO" {
+		t.Errorf("label = %q, want HELLO", got)
+	}
+	if s.attr[6] != 0x40 || s.color[6] != 0xF2 {
+		t.Errorf("SFE attribute = %#x color %#x, want 0x40 and 0xF2", s.attr[6], s.color[6])
+	}
+	if s.buf[7] != 0 || s.buf[8] != 0 {
+		t.Errorf("EUA left %q in the input field", ebcdicString(s.buf[7:9]))
+	}
+	if s.cursor != 9 {
+		t.Errorf("IC cursor = %d, want 9", s.cursor)
+	}
+	if got := ebcdicString(s.buf[9:15]); got != "XXXXXX" || s.buf[15] != 0 {
+		t.Errorf("RA filled %q, want XXXXXX up to 15", got)
+	}
+	if s.locked {
+		t.Error("keyboard still locked after a WCC with restore")
+	}
+	if !s.protected(3) || s.protected(10) {
+		t.Error("protected() disagrees with the field attributes")
+	}
+}
+
+func TestScreen3270UnknownCommand(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	if _, err := s.process([]byte{0x99}); err == nil {
+		t.Error("process accepted an unknown command")
+	}
+}
+
+// formScreen is a label and one input field, as a logon screen has.
+func formScreen() *screen3270 {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	s.process(stream(
+		[]byte{CMD_EW, 0xC3},
+		[]byte{ORDER_SF, 0x60}, ebcdic("NAME:"),
+		[]byte{ORDER_SF, 0x40, ORDER_IC},
+		[]byte{ORDER_SBA}, encodeAddress(20),
+		[]byte{ORDER_SF, 0x60},
+	))
+	return s
+}
+
+func TestScreen3270AID(t *testing.T) {
+	s := formScreen()
+	if s.cursor != 7 {
+		t.Fatalf("cursor = %d, want 7", s.cursor)
+	}
+
+	s.typeInto(7, 13, "BOB")
+	if s.attr[6]&FA_MDT == 0 {
+		t.Fatal("typing didn't set the field's MDT")
+	}
+	s.cursor = 10
+
+	got := s.aid(AID_ENTER)
+	want := stream([]byte{AID_ENTER}, encodeAddress(10), []byte{ORDER_SBA}, encodeAddress(7), ebcdic("BOB"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("enter = % x, want % x", got, want)
+	}
+	if !s.locked {
+		t.Error("an AID didn't lock the keyboard")
+	}
+
+	// Read Modified from the host reports the same field, without an AID
+	got, _ = s.process([]byte{CMD_RM})
+	if !bytes.HasPrefix(got, []byte{AID_NONE}) || !bytes.HasSuffix(got, ebcdic("BOB")) {
+		t.Errorf("read modified = % x", got)
+	}
+
+	// a write with reset MDT clears the tag, so nothing is sent again
+	s.process([]byte{CMD_W, WCC_RESET_MDT | WCC_RESTORE})
+	got = s.aid(AID_ENTER)
+	if want := stream([]byte{AID_ENTER}, encodeAddress(10)); !bytes.Equal(got, want) {
+		t.Errorf("enter after reset MDT = % x, want % x", got, want)
+	}
+}
+
+func TestScreen3270ShortRead(t *testing.T) {
+	for _, aid := range []byte{AID_CLEAR, AID_PA1, AID_PA2, AID_PA3} {
+		s := formScreen()
+		s.typeInto(7, 13, "BOB")
+		if got := s.aid(aid); !bytes.Equal(got, []byte{aid}) {
+			t.Errorf("AID %#x = % x, want the AID alone", aid, got)
+		}
+	}
+
+	s := formScreen()
+	s.aid(AID_CLEAR)
+	if s.fieldStart(0) >= 0 || s.buf[1] != 0 {
+		t.Error("clear didn't erase the screen")
+	}
+}
+
+func TestScreen3270Unformatted(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	s.process(stream([]byte{CMD_EW, WCC_RESTORE}, ebcdic("READY"), []byte{ORDER_IC}))
+
+	got := s.aid(AID_ENTER)
+	want := stream([]byte{AID_ENTER}, encodeAddress(5), ebcdic("READY"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("enter = % x, want % x", got, want)
+	}
+}
+
+func TestScreen3270ReadBuffer(t *testing.T) {
+	s := formScreen()
+	got, err := s.process([]byte{CMD_RB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := stream([]byte{AID_NONE}, encodeAddress(7), []byte{ORDER_SF, 0x60}, ebcdic("NAME:"), []byte{ORDER_SF, 0x40})
+	if !bytes.HasPrefix(got, want) {
+		t.Errorf("read buffer starts % x, want % x", got[:len(want)], want)
+	}
+	if len(got) != 1+2+s.size()+3 { // one extra byte per SF order
+		t.Errorf("read buffer is %d bytes", len(got))
+	}
+}
+
+func TestScreen3270Query(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	got, err := s.process([]byte{CMD_WSF, 0x00, 0x05, 0x01, 0xFF, 0x02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || got[0] != AID_SF {
+		t.Fatalf("query reply = % x", got)
+	}
+	// usable area: 80 columns by 24 rows
+	if !bytes.Contains(got, []byte{0x81, 0x81, 0x01, 0x00, 0x00, 80, 0x00, 24}) {
+		t.Errorf("query reply lacks the screen size: % x", got)
+	}
+}
+
+func TestScreen3270HiddenText(t *testing.T) {
+	s := newScreen3270(TN3270_ROWS, TN3270_COLS)
+	s.process(stream([]byte{CMD_EW, WCC_RESTORE}, []byte{ORDER_SF, 0x6C}, ebcdic("SECRET")))
+
+	for _, seg := range s.view().Rows[0] {
+		if strings.Contains(seg.Text, "SECRET") {
+			t.Errorf("nondisplay text sent to the browser: %+v", seg)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// external links module for gofer 0.9
+// "URL:" selectors: links out of gopherspace, and the warning page before them
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const EXTERNAL_ENDPOINT = "/external"
+
+// schemes gofer will link to; anything else in a URL: selector is fetched as a plain selector
+var externalSchemes = map[string]string{
+	"http":   "a web page",
+	"https":  "a web page",
+	"ftp":    "an FTP server",
+	"mailto": "your mail program",
+	"telnet": "a telnet session",
+	"gemini": "a Gemini capsule",
+}
+
+// -----------------------------------------------------------
+// urlSelector("URL:https://example.org/") -> gopher, external
+//
+// The convention (from gophernicus, pygopherd, and others)
+// is a type h item whose selector is "URL:" and a full URL,
+// sometimes with a leading slash. gopher:// URLs stay inside
+// gofer; the schemes above become outbound links. Both are
+// nil for anything else.
+// -----------------------------------------------------------
+func urlSelector(selector string) (*GopherURL, *url.URL) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(selector, "/"), "URL:")
+	if !ok {
+		return nil, nil
+	}
+	rest = strings.TrimSpace(rest)
+
+	scheme, _, _ := strings.Cut(rest, ":")
+	switch strings.ToLower(scheme) {
+	case "gopher", "gophers":
+		g, err := ParseGopherURL(rest)
+		if err != nil {
+			return nil, nil
+		}
+		return g, nil
+	}
+
+	u, err := url.Parse(rest)
+	if err != nil || externalSchemes[strings.ToLower(u.Scheme)] == "" {
+		return nil, nil
+	}
+	if u.Opaque == "" && u.Host == "" {
+		return nil, nil // "http:foo" and the like
+	}
+	return nil, u
+}
+
+// externalLink leads to the warning page for an outbound URL.
+func externalLink(u *url.URL, returnTo string) string {
+	return fmt.Sprintf("%s?url=%s&return=%s", EXTERNAL_ENDPOINT, url.QueryEscape(u.String()), url.QueryEscape(returnTo))
+}
+
+// externalView is the data for the "external" template.
+type externalView struct {
+	pageHead
+	URL         template.URL // scheme already checked against externalSchemes
+	Text        string
+	Destination string
+	Terminal    string // gofer's own terminal for telnet URLs
+	Return      returnLink
+}
+
+// handleExternal shows where a URL: link goes before the browser leaves gopherspace.
+func handleExternal(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	returnURL := query.Get("return")
+	if returnURL == "" {
+		returnURL = "/"
+	}
+
+	_, u := urlSelector("URL:" + query.Get("url"))
+	if u == nil {
+		http.Error(w, "Not a URL gofer can link to", http.StatusBadRequest)
+		return
+	}
+
+	view := externalView{
+		pageHead:    pageHead{Title: "gofer - leaving gopherspace"},
+		URL:         template.URL(u.String()),
+		Text:        u.String(),
+		Destination: externalSchemes[strings.ToLower(u.Scheme)],
+		Return:      returnLink{Href: returnURL, Label: "Stay in gopherspace"},
+	}
+
+	// telnet URLs can also open in the in-browser terminal
+	if strings.EqualFold(u.Scheme, "telnet") {
+		port := u.Port()
+		if port == "" {
+			port = TELNET_DEFAULT_PORT
+		}
+		view.Terminal = telnetLink(u.Hostname(), port, u.User.Username(), returnURL)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	renderTemplate(w, "external", view)
+}
+
+// externalHost names the host a URL: link leads to, for the menu's marker.
+func externalHost(u *url.URL) string {
+	if u.Opaque != "" { // mailto:
+		return u.Scheme
+	}
+	host := u.Hostname()
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return u.Scheme + " " + host
+}
@@ -7,6 +7,8 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"net"
@@ -18,6 +20,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"gofer/gophermap"
 )
 
 // --- Configuration Constants ---
@@ -27,6 +31,8 @@ const (
 	DEFAULT_GOPHER_PORT       = "70"
 	SHUTDOWN_TIMEOUT_SECONDS  = 60
 	TCP_TIMEOUT               = 5 * time.Second
+	IDLE_READ_TIMEOUT         = 15 * time.Second
+	SNIFF_LENGTH              = 512 // bytes http.DetectContentType looks at
 	GOPHER_REQUEST_TERMINATOR = "\r\n"
 	FOCUS_ENDPOINT            = "/focus"
 )
@@ -83,432 +89,543 @@ func launchBrowser(url string) {
 	}
 }
 
-// gopherRequestBytes connects to a remote Gopher server, sends the selector, and returns raw bytes.
-// This is the default case; for menu/text handling use gopherRequest.
-func gopherRequestBytes(host string, port string, selector string) ([]byte, error) {
+// --- Gopher Connections ---
+
+// gopherConn is one outbound gopher transaction: the selector has been sent
+// and the reply is read from the connection as it arrives. Text, menu, and
+// binary handlers all read from it, so every selector is fetched exactly once.
+type gopherConn struct {
+	Host     string
+	Port     string
+	Selector string
+	Tried    []string // servers that failed before this one answered (type + failover)
+
+	conn     net.Conn
+	reader   *bufio.Reader
+	deadline time.Time // the whole reply must arrive by then, if set
+}
+
+// openGopher connects to a remote Gopher server and sends the selector.
+// The caller must Close the returned connection.
+func openGopher(host string, port string, selector string) (*gopherConn, error) {
+	return openGopherData(host, port, selector, "", false)
+}
+
+// -----------------------------------------------------------
+// openGopherData(host, port, selector, data, wantTLS) -> conn, error
+//
+// Like openGopher, but sends data after the request line, for
+// the Gopher+ requests that carry a data block (+ASK answers),
+// and connects with TLS when wantTLS asks for it.
+// The request line itself may not hold a line break, or one
+// selector could smuggle a second request past the policy;
+// data is written as it is, so it must be framed by the caller.
+// -----------------------------------------------------------
+func openGopherData(host string, port string, selector string, data string, wantTLS bool) (*gopherConn, error) {
+	if err := checkRequestLine(host, port, selector); err != nil {
+		return nil, err
+	}
 	address := net.JoinHostPort(host, port)
 
-	conn, err := net.DialTimeout("tcp", address, TCP_TIMEOUT)
+	conn, err := dialRemote(host, port, TCP_TIMEOUT, wantTLS)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Gopher server %s: %w", address, err)
 	}
-	defer conn.Close()
 
-	conn.SetDeadline(time.Now().Add(TCP_TIMEOUT))
+	conn.SetWriteDeadline(time.Now().Add(TCP_TIMEOUT))
 
 	request := selector + GOPHER_REQUEST_TERMINATOR
+	if data != "" {
+		request += data + GOPHER_REQUEST_TERMINATOR
+	}
 
 	if _, err := conn.Write([]byte(request)); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to write selector to socket: %w", err)
 	}
 
-	// Read everything until EOF / timeout
-	b, err := io.ReadAll(conn)
-	if err != nil {
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return nil, fmt.Errorf("socket timeout while reading from %s", address)
+	c := &gopherConn{Host: host, Port: port, Selector: selector, conn: conn}
+	c.reader = bufio.NewReaderSize(readerFunc(c.readIdle), SNIFF_LENGTH)
+	return c, nil
+}
+
+// readerFunc adapts a read method to io.Reader.
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// readIdle pushes the read deadline forward before every read, so a transfer
+// only fails when the server goes quiet, not when it is merely large.
+// A deadline set with SetDeadline is never pushed back.
+func (c *gopherConn) readIdle(p []byte) (int, error) {
+	deadline := time.Now().Add(IDLE_READ_TIMEOUT)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	c.conn.SetReadDeadline(deadline)
+	n, err := c.conn.Read(p)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if deadline.Equal(c.deadline) {
+			return n, fmt.Errorf("no complete reply from %s in the time allowed", net.JoinHostPort(c.Host, c.Port))
 		}
-		return nil, fmt.Errorf("error reading from socket: %w", err)
+		return n, fmt.Errorf("socket idle for %s while reading from %s", IDLE_READ_TIMEOUT, net.JoinHostPort(c.Host, c.Port))
 	}
+	return n, err
+}
+
+// SetDeadline bounds the whole reply, however steadily it trickles in.
+func (c *gopherConn) SetDeadline(t time.Time) { c.deadline = t }
 
+func (c *gopherConn) Read(p []byte) (int, error) { return c.reader.Read(p) }
+
+func (c *gopherConn) Close() error {
+	if c.conn == nil {
+		return nil // a reply replayed from the decode cache
+	}
+	return c.conn.Close()
+}
+
+// Address is host:port of the server that answered.
+func (c *gopherConn) Address() string { return net.JoinHostPort(c.Host, c.Port) }
+
+// Sniff returns the Content-Type suggested by the first bytes of the reply,
+// without consuming them.
+func (c *gopherConn) Sniff() string {
+	head, _ := c.reader.Peek(SNIFF_LENGTH)
+	return http.DetectContentType(head)
+}
+
+// ReadAll buffers the rest of the reply. Only use it for small replies
+// (attributes, forms, search results); documents are streamed.
+func (c *gopherConn) ReadAll() ([]byte, error) {
+	b, err := io.ReadAll(c)
+	if err != nil {
+		return nil, fmt.Errorf("error reading from socket: %w", err)
+	}
 	return b, nil
 }
 
-// gopherRequest returns text for menu/text handling.
-// This is NOT safe for binary. Use gopherRequestBytes for that.
-func gopherRequest(host string, port string, selector string) (string, error) {
-	b, err := gopherRequestBytes(host, port, selector)
+// gopherRequestBytes opens a connection, reads the whole reply, and closes it.
+func gopherRequestBytes(host string, port string, selector string) ([]byte, error) {
+	return gopherRequestData(host, port, selector, "")
+}
+
+// gopherRequestData is gopherRequestBytes with a data block after the request line.
+func gopherRequestData(host string, port string, selector string, data string) ([]byte, error) {
+	c, err := openGopherData(host, port, selector, data, false)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(b), nil
+	defer c.Close()
+	return c.ReadAll()
 }
 
-// helper to determine whether a link goes to the text pipeline or the byte pipeline
-func isTransparentType(t byte) bool {
-	switch t {
-	case '0', '1':
-		return true
-	default:
-		return false
+// copyStream copies a gopher response to the browser, flushing as data arrives.
+func copyStream(w http.ResponseWriter, body io.Reader) error {
+	flusher := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr // the browser went away
+			}
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
 }
 
+// serveFetchError renders a failed fetch as a synthetic type-3 menu line,
+// or as the certificate warning page if a pinned certificate changed.
+func serveFetchError(w http.ResponseWriter, r *http.Request, err error, host, port, selector string) {
+	if servePinWarning(w, r, err) || servePolicyBlock(w, r, err) {
+		return
+	}
+
+	synthetic := fmt.Sprintf("3Connection failed: %s\t/\t%s\t%s\n.\n",
+		err.Error(), host, port)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	htmlContent := formatMenuHTML(synthetic, host, port, selector, false)
+	w.Write([]byte(htmlContent))
+}
+
 // --- HTML Formatting Component ---
 
-// formatMenuHTML takes raw Gopher data and turns it into minimal HTML.
-// It requires the current host, port, and selector for form pre-filling and links.
-func formatMenuHTML(rawGopherData, currentHost, currentPort, currentSelector string, embedded bool) string {
+// menuRenderer carries the per-menu state shared by formatMenuHTML and streamMenuHTML.
+type menuRenderer struct {
+	currentHost, currentPort, currentSelector string
 
-	// Start with the HTML boilerplate, including the input form at the top
-	var html strings.Builder
+	// Gopher+ attributes are only fetched if the menu actually contains plus items
+	plusAttrs *plusMenuAttributes
 
-	// 1. Helper for writing the common item types
-	writeLink := func(icon string, itemType byte, host, port, selector, display string) {
-		link := fmt.Sprintf(
-			"<a href=\"/?type=%c&host=%s&port=%s&selector=%s\">%s</a>",
-			itemType, host, port, url.QueryEscape(selector), display,
-		)
-		html.WriteString(fmt.Sprintf(
-			"<p class=\"gopher-link\">%s%s</p>\n",
-			icon,
-			link,
-		))
+	// set when a type + mirror answered in place of the server asked for
+	mirrorNote string
+}
+
+func newMenuRenderer(currentHost, currentPort, currentSelector string) *menuRenderer {
+	return &menuRenderer{
+		currentHost:     currentHost,
+		currentPort:     currentPort,
+		currentSelector: currentSelector,
+		plusAttrs:       newPlusMenuAttributes(currentHost, currentPort, currentSelector),
 	}
+}
 
-	// 1. Construct the current Gopher URI for the input field's value
-	currentGopherURI := fmt.Sprintf("%s:%s%s", currentHost, currentPort, currentSelector)
+// itemURL builds the canonical URL of a menu item.
+func (m *menuRenderer) itemURL(itemType byte, host, port, selector string) *GopherURL {
+	return &GopherURL{TLS: isSecureHost(host, port), Host: host, Port: port, Type: itemType, Selector: selector}
+}
 
-	if !embedded {
-		html.WriteString(fmt.Sprintf(`
-		
-	
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>gofer - %s:%s%s</title>
-			<style>
-			
-				:root { color-scheme: light dark; }
-
-				body { 
-					font-family: monospace;
-					line-height: 1.4;
-					width: 100ch; 
-					margin: 0 auto; 
-					padding-bottom: 1ch;
-				}
-				
-				.gopher-link { 
-					margin: 0;
-				 	white-space: pre;
-				} 
-
-				.gopher-link:last-child {
-					margin-bottom: 1ch;
-				}
-
-				.query-bar {
-					width: 100%%;
-					margin: 1ch 0 1ch 0;		
-				}
-
-				.query-bar form {
-        			display: flex; /* Activate Flexbox */
-        			width: 100%%; /* Ensure the form uses the full 100ch of .query-bar */
-        			align-items: center; /* Vertically center the text and input */
-    			}
-
-				.query-label {
-					font-size: 1.5em;
-					font-weight: bold;
-					padding: 0 0 0 0;
-					flex-shrink: 0;
-				}
-
-				input[type="text"] { 
-					font-family: monospace;
-					font-size: 1.5em;
-					font-weight: bold;
-
-					flex-grow: 1;
-					min-width: 0; 	
-
-					outline: 0;	
-					caret-style: underscore;
-  				}	
-
-			</style>
-		</head>
-		<body>
-		
-		<div class="query-bar">
-			<form action="/" method="GET">
-				<span class="query-label">gopher://</span>
-				<input type="text" id="uri" name="uri" value="%s" placeholder="freeshell.org:70/">			
-			</form>
-		</div>
-
-	`,
-			// Arguments 1, 2, 3, 4: For the title and the URI input value
-			currentHost, currentPort, currentSelector, currentGopherURI))
-	}
-
-	// --- End of the argument list ---
-
-	// Process the lines from the Gopher response
-	lines := strings.Split(rawGopherData, "\n")
-
-	for _, line := range lines {
-		// Check for empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+// currentURL is the URL of the menu being rendered.
+func (m *menuRenderer) currentURL() *GopherURL {
+	return m.itemURL('1', m.currentHost, m.currentPort, m.currentSelector)
+}
 
-		// Check for Gopher EOF
-		if strings.TrimSpace(line) == "." {
-			break
-		}
+// menuHeadView is the data for the "menu-head" template.
+type menuHeadView struct {
+	pageHead
+	Scheme string // gopher:// or gophers://
+	URI    string // the current URI without its scheme
+	Mirror string // which mirror answered, after a failover
+}
 
-		// Gopher line format: TypeDisplayString\tSelector\tHost\tPort
-		fields := strings.Split(line, "\t")
+// menuLineView is the data for one "menu-item" template.
+type menuLineView struct {
+	Icon      string
+	IconStyle string // "" for links, "error" or "info" for colored icons
+	Href      string // empty for items that are not links
+	Display   string
+	Secure    bool // reached over TLS
+	Plus      *plusDetailsView
+	Mirrors   []returnLink // type + redundant servers for this item
+	Title     string       // shown on hover: where a URL: link really goes
+	External  string       // scheme and host of a link that leaves gopherspace
+}
 
-		var itemType byte
-		var displayString, selector, host, port string
+// header returns the HTML boilerplate, including the input form at the top.
+func (m *menuRenderer) header() string {
 
-		// If the line is malformed, assume itemType 3
+	// Construct the current Gopher URI (without the scheme) for the input field's value
+	current := m.currentURL()
 
-		if len(fields) < 4 {
-			itemType = '3'
-			displayString = "Malformed Line (Type 3 Error): " + strings.TrimSpace(line)
-			selector = "/"
-			host = currentHost
-			port = currentPort
-		} else {
+	// pages reached over TLS show (and resubmit) the gophers:// scheme
+	currentScheme := "gopher://"
+	if current.TLS {
+		currentScheme = "gophers://"
+	}
 
-			// 1. Extract Item Type and Display String
-			itemType = fields[0][0]
-			displayString = fields[0][1:]
+	return renderString("menu-head", menuHeadView{
+		pageHead: pageHead{Title: fmt.Sprintf("gofer - %s:%s%s", m.currentHost, m.currentPort, m.currentSelector)},
+		Scheme:   currentScheme,
+		URI:      current.Authority() + current.Path(),
+		Mirror:   m.mirrorNote,
+	})
+}
 
-			// 2. Extract Selector, Host, and Port
-			selector = fields[1]
-			host = fields[2]
-			port = fields[3]
-		}
+// footer closes the page opened by header.
+func (m *menuRenderer) footer() string {
+	return renderString("menu-foot", nil)
+}
 
-		displayString = strings.TrimRight(displayString, " \t\r")
+// item formats one parsed menu item into html. How each type looks and
+// where it links comes from the item type registry (itemKinds).
+func (m *menuRenderer) item(html *strings.Builder, it *gophermap.Item) {
+	itemType := it.Type
+	selector := it.Selector
+	host := it.Host
+	port := it.Port
 
-		if displayString == "" {
-			continue
-		}
+	kind := kindOf(itemType)
+	view := menuLineView{Display: it.Display, Icon: kind.Icon, IconStyle: kind.Style}
 
-		typeIcon := ""
-
-		// 3. Determine HTML output based on the MINIMAL set of Item Types
-		switch itemType {
-
-		case '0': // Linkable item: Text file (Type 0)
-			typeIcon = "[TXT]"
-			// Build the link back to the gofer html engine
-			link := fmt.Sprintf("<a href=\"/?type=%c&host=%s&port=%s&selector=%s\">%s</a>", itemType, host, port, url.QueryEscape(selector), displayString)
-			// future gopher version link := fmt.Sprintf("<a href=\"gopher://%s:%s/%c%s\">%s</a>", host, port, itemType, selector, displayString)
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\">%s%s</p>\n", typeIcon, link))
-
-		case '1': // Linkable items: Menu (Type 1)
-			typeIcon = "[ 1 ]"
-			// Build the link back to the gofer html engine
-			link := fmt.Sprintf("<a href=\"/?type=%c&host=%s&port=%s&selector=%s\">%s</a>", itemType, host, port, url.QueryEscape(selector), displayString)
-			// future gopher version link := fmt.Sprintf("<a href=\"gopher://%s:%s/%c%s\">%s</a>", host, port, itemType, selector, displayString)
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\">%s%s</p>\n", typeIcon, link))
-
-		case '2': // PH/CSO directory server entry
-			typeIcon = "[PhC]"
-
-			// Host/port from the Gopher line
-			phHost := host
-			phPort := port
-			if phPort == "" {
-				phPort = "105" // PH default
-			}
+	// lines that could not be parsed are shown as type 3 errors
+	if itemType == '3' && len(it.Warnings) > 0 {
+		view.Display = "Malformed Line (Type 3 Error): " + view.Display
+	}
 
-			// Build base PH URL: /ph:host:port, also create the return link
-			returnTo := fmt.Sprintf("/?host=%s&port=%s&selector=%s",
-				currentHost,
-				currentPort,
-				url.QueryEscape(currentSelector),
-			)
+	// links with missing fields point back at the current server,
+	// or at the usual port for types that have one (Ph, telnet)
+	if host == "" {
+		host = m.currentHost
+	}
+	if port == "" {
+		port = kind.DefaultPort
+	}
+	if port == "" {
+		port = m.currentPort
+	}
 
-			phURL := fmt.Sprintf("/ph/%s:%s?return=%s",
-				phHost,
-				phPort,
-				url.QueryEscape(returnTo),
-			)
+	if view.Display == "" {
+		return
+	}
 
-			// Only attach selector parameter if the gopher entry actually had one
-			if selector != "" {
-				phURL = fmt.Sprintf("%s?selector=%s",
-					phURL,
-					url.QueryEscape(selector),
-				)
-			}
+	if kind.NoLink {
+		html.WriteString(renderString("menu-item", view))
+		return
+	}
 
-			link := fmt.Sprintf("<a href=\"%s\">%s</a>", phURL, displayString)
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\">%s%s</p>\n", typeIcon, link))
-			continue
+	// "URL:" selectors link to other protocols, or to gopher items elsewhere
+	if g, u := urlSelector(selector); g != nil || u != nil {
+		if u != nil {
+			view.Icon, view.IconStyle = "[URL]", ""
+			view.Href = externalLink(u, m.currentURL().LocalPath())
+			view.Title, view.External = u.String(), externalHost(u)
+		} else {
+			view.Icon = kindOf(g.Type).Icon
+			view.Href, view.Title = g.LocalPath(), g.String()
+			view.Secure = g.TLS
+		}
+		html.WriteString(renderString("menu-item", view))
+		return
+	}
 
-		case '3': // Error (transparent)
-			typeIcon = "[ERR]"
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\"><span style=\"color: red;\">%s</span>%s</p>\n", typeIcon, displayString))
+	// items on hosts reached over TLS carry a lock marker
+	view.Secure = isSecureHost(host, port)
 
-		case '4': // Macintosh BinHex File (opaque)
-			writeLink("[HQX]", itemType, host, port, selector, displayString)
+	// Gopher+ items (fifth field "+") get their attributes listed under the link
+	if it.IsPlus() {
+		view.Plus = plusDetails(m.plusAttrs.lookup(selector, host, port), itemType, host, port, selector)
+	}
 
-		case '5': // MS DOS Binary File (opaque)
-			writeLink("[DOS]", itemType, host, port, selector, displayString)
+	// the default is a link back to the gofer html engine; some types have a route of their own
+	target := m.itemURL(itemType, host, port, selector)
+	view.Href = target.LocalPath()
+	fetched := true
+	if kind.Route != nil {
+		if route := kind.Route(target, m.currentURL().LocalPath()); route != "" {
+			view.Href, fetched = route, kind.Mirrors
+		}
+	}
 
-		case '6': // Unix UUEncoded File (opaque)
-			writeLink("[UUE]", itemType, host, port, selector, displayString)
+	// Gopher+ items with an +ASK block ("?") are answered through a form first
+	if it.IsAsk() {
+		view.Icon = "[ASK]"
+		view.Href, fetched = askLink(itemType, host, port, selector, m.currentURL().LocalPath()), false
+	}
 
-		case '7': // Searchable Index (Type 7)
-			typeIcon = "[ 7 ]"
+	// type + lines are listed as alternates; links fetched through gofer
+	// carry them along, so the fetch can fail over when this server is down
+	if mirrors := mirrorURLs(it); len(mirrors) > 0 {
+		for _, mirror := range mirrors {
+			if mirror.Host == "" {
+				mirror.Host = m.currentHost
+			}
+			if mirror.Port == "" {
+				mirror.Port = m.currentPort
+			}
+			mirror.TLS = isSecureHost(mirror.Host, mirror.Port)
+			view.Mirrors = append(view.Mirrors, returnLink{Href: mirror.LocalPath(), Label: mirror.Address()})
+		}
+		if fetched {
+			view.Href += mirrorQuery(mirrors)
+		}
+	}
 
-			// Route to search handler (to be implemented)
-			link := fmt.Sprintf(
-				"<a href=\"/search?host=%s&port=%s&selector=%s\">%s</a>",
-				host,
-				port,
-				url.QueryEscape(selector),
-				displayString,
-			)
+	html.WriteString(renderString("menu-item", view))
+}
 
-			html.WriteString(fmt.Sprintf(
-				"<p class=\"gopher-link\">%s%s</p>\n",
-				typeIcon,
-				link,
-			))
+// formatMenuHTML takes raw Gopher data and turns it into minimal HTML.
+// It requires the current host, port, and selector for form pre-filling and links.
+func formatMenuHTML(rawGopherData, currentHost, currentPort, currentSelector string, embedded bool) string {
+	items, _ := gophermap.ParseMenuString(rawGopherData, gophermap.PARSE_LENIENT)
+	return formatItemsHTML(items, currentHost, currentPort, currentSelector, embedded)
+}
 
-		case 'g': // GIF image (opaque)
-			writeLink("[GIF]", itemType, host, port, selector, displayString)
+// formatItemsHTML renders already parsed menu items.
+func formatItemsHTML(items []*gophermap.Item, currentHost, currentPort, currentSelector string, embedded bool) string {
+	var html strings.Builder
 
-		case 'I': // Generic image (opaque)
-			writeLink("[IMG]", itemType, host, port, selector, displayString)
+	m := newMenuRenderer(currentHost, currentPort, currentSelector)
 
-		case 'i': // Informational text (transparent)
-			typeIcon = "[ i ]"
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\"><span style=\"color: gray;\">%s</span>%s</p>\n", typeIcon, displayString))
+	if !embedded {
+		html.WriteString(m.header())
+	}
 
-		default: // Unknown type: treated as opaque.
-			typeIcon = fmt.Sprintf("[!%c!]", itemType)
-			link := fmt.Sprintf("<a href=\"/?type=%c&host=%s&port=%s&selector=%s\">%s</a>", itemType, host, port, url.QueryEscape(selector), displayString)
-			html.WriteString(fmt.Sprintf("<p class=\"gopher-link\"><span style=\"color: red;\">%s</span>%s</p>\n", typeIcon, link))
-		}
+	for _, it := range items {
+		m.item(&html, it)
 	}
 
 	if !embedded {
-		html.WriteString(`</body></html>`)
+		html.WriteString(m.footer())
 	}
 	return html.String()
 }
 
+// streamMenuHTML renders a menu progressively, flushing each item to the
+// browser as it arrives so slow servers still show something right away.
+func streamMenuHTML(w http.ResponseWriter, body io.Reader, currentHost, currentPort, currentSelector, mirrorNote string) error {
+	m := newMenuRenderer(currentHost, currentPort, currentSelector)
+	m.mirrorNote = mirrorNote
+	flusher := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, m.header())
+	flusher.Flush()
+
+	parser := gophermap.NewMenuParser(body, gophermap.PARSE_LENIENT)
+	var readErr error
+	for {
+		it, err := parser.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		var html strings.Builder
+		m.item(&html, it)
+		io.WriteString(w, html.String())
+		flusher.Flush()
+	}
+
+	if readErr != nil {
+		// the page is already on its way; report the failure as a final type-3 line
+		var html strings.Builder
+		m.item(&html, &gophermap.Item{Type: '3', Display: "Transfer interrupted: " + readErr.Error()})
+		io.WriteString(w, html.String())
+	}
+
+	io.WriteString(w, m.footer())
+	flusher.Flush()
+	return readErr
+}
+
 // --- HTTP Server Handlers ---
 
 // serveGopher handles the primary Gopher requests (e.g., /?host=... or just /).
 func serveGopher(w http.ResponseWriter, r *http.Request) {
 	updateActivity() // Reset the inactivity timer
 
-	var (
-		err         error
-		u           *url.URL
-		rawResponse string
-		rawBytes    []byte
-	)
-
 	query := r.URL.Query()
 
-	// 1. Check for submission from the new single-field URI bar
-	gopherURI := query.Get("uri")
-
-	// 2. Initialize variables (or use values from old menu link clicks)
-	gopherTypeQuery := query.Get("type")
-	host := query.Get("host")
-	port := query.Get("port")
-	selector := query.Get("selector")
-
-	if gopherURI != "" {
+	var g *GopherURL
 
-		raw := gopherURI
-		if !strings.Contains(raw, "://") {
-			raw = "gopher://" + raw
+	// 1. Check for submission from the single-field URI bar
+	if gopherURI := strings.TrimSpace(query.Get("uri")); gopherURI != "" {
+		if !strings.Contains(gopherURI, "://") {
+			gopherURI = "gopher://" + gopherURI
 		}
 
-		u, err = url.Parse(raw)
+		parsed, err := ParseGopherURL(gopherURI)
+		if err != nil {
+			synthetic := fmt.Sprintf("3%s\t\t%s\t%s\n.\n", err.Error(), DEFAULT_GOPHER_HOST, DEFAULT_GOPHER_PORT)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(formatMenuHTML(synthetic, DEFAULT_GOPHER_HOST, DEFAULT_GOPHER_PORT, "", false)))
+			return
+		}
+		g = parsed
+	} else {
+		// 2. Menu links (and direct "/" loads) carry the item as query parameters
+		g = gopherURLFromQuery(query)
+	}
+	mirrors := mirrorsFromQuery(query, g)
 
-		if err == nil && (u.Scheme == "gopher" || u.Scheme == "") {
-			// Overwrite host, port, and selector from the parsed URI
+	// "URL:" selectors are links, not requests; send them where they point
+	if gopher, external := urlSelector(g.Selector); gopher != nil {
+		http.Redirect(w, r, gopher.LocalPath(), http.StatusSeeOther)
+		return
+	} else if external != nil {
+		http.Redirect(w, r, externalLink(external, "/"), http.StatusSeeOther)
+		return
+	}
 
-			host = u.Hostname()
+	// The item type decides up front which handler runs; only then is the selector fetched, once.
+	kind := kindOf(g.Type)
+	raw := query.Get("raw") == "1"
 
-			port = u.Port()
-			if port == "" {
-				port = DEFAULT_GOPHER_PORT
+	// searches, Ph servers, and terminal sessions have routes of their own
+	if kind.Route != nil {
+		if route := kind.Route(g, "/"); route != "" {
+			if kind.Mirrors {
+				route += mirrorQuery(mirrors)
 			}
-
-			selector = strings.TrimPrefix(u.Path, "/")
-		}
-	} else {
-		// Only apply defaults if navigating via an old-style link or direct "/" load
-		if host == "" {
-			host = DEFAULT_GOPHER_HOST
-		}
-		if port == "" {
-			port = DEFAULT_GOPHER_PORT
-		}
-		if selector == "" {
-			selector = "/"
+			http.Redirect(w, r, route, http.StatusSeeOther)
+			return
 		}
 	}
 
-	rawResponse, err = gopherRequest(host, port, selector)
-
-	if err != nil {
-		// Connection Error - a synthetic type-3 line for the formatter
-		synthetic := fmt.Sprintf("3Connection failed: %s\t/\t%s\t%s\n.\n",
-			err.Error(), host, port)
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		htmlContent := formatMenuHTML(synthetic, host, port, selector, false)
-		w.Write([]byte(htmlContent))
+	// video and documents get a player or viewer page, which loads the item with raw=1
+	if kind.Page != "" && !raw {
+		serveMediaPage(w, r, g, mirrors)
 		return
 	}
 
-	// Determine the Gopher type requested.
-	var gopherType byte
-	if len(gopherTypeQuery) > 0 {
-		gopherType = gopherTypeQuery[0]
-	} else {
-		// Fallback for direct browser entry or older links (assume menu)
-		gopherType = '1'
+	handler := itemHandlers[kind.Serve]
+	if raw {
+		handler = serveBinary
 	}
 
-	isTransparent := isTransparentType(gopherType)
-
-	// Fetch content based on pipeline
-	if isTransparent {
-		rawResponse, err = gopherRequest(host, port, selector)
-	} else {
-		rawBytes, err = gopherRequestBytes(host, port, selector)
+	// type + mirrors, if the link carried any, are tried in order after the primary.
+	// A view page's own requests find the item it just decoded in the cache.
+	targets := append([]*GopherURL{g}, mirrors...)
+	conn := cachedGopherConn(targets)
+	if conn == nil {
+		var err error
+		if conn, err = openGopherMirrors(targets); err != nil {
+			serveFetchError(w, r, err, g.Host, g.Port, g.Selector)
+			return
+		}
 	}
+	defer conn.Close()
 
-	if err != nil {
-		synthetic := fmt.Sprintf("3Connection failed: %s\t/\t%s\t%s\n.\n",
-			err.Error(), host, port)
+	if len(conn.Tried) > 0 {
+		w.Header().Set("X-Gofer-Mirror", conn.Address())
+	}
 
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		htmlContent := formatMenuHTML(synthetic, host, port, selector, false)
-		w.Write([]byte(htmlContent))
-		return
+	if err := handler(w, r, g, conn); err != nil {
+		// headers are already sent, so all we can do is note it
+		fmt.Printf("Transfer of %s interrupted: %v\n", g, err)
 	}
+}
 
-	// Handle content based on Gopher Type
-	switch gopherType {
+// --- Item Handlers ---
+
+// itemHandler serves one gopher reply to the browser as it arrives.
+type itemHandler func(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error
+
+// itemHandlers maps the registry's serving strategies to their handlers.
+var itemHandlers = map[serveStrategy]itemHandler{
+	SERVE_RAW:      serveBinary,
+	SERVE_TEXT:     serveText,
+	SERVE_MENU:     serveMenu,
+	SERVE_BINHEX:   serveBinHex,
+	SERVE_UUDECODE: serveUUDecode,
+	SERVE_ARCHIVE:  serveArchive,
+	SERVE_IMAGE:    serveImage,
+	SERVE_SOUND:    serveSound,
+}
 
-	case '0', 'i': // Text File (Type 0) or Informational Text (Type i)
-		// Type 0 is sent to the browser as raw text with the correct HTTP header.
-		// Type 'i' is only used in a menu and should not be requested directly, but treat it as text/plain if it is.
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Write([]byte(rawResponse))
+// serveText sends a Text File (Type 0) as raw text with the correct HTTP header.
+// Type 'i' is only used in a menu and should not be requested directly, but treat it as text/plain if it is.
+func serveText(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	setRemoteHeaders(w, "text/plain; charset=utf-8")
+	return copyStream(w, conn)
+}
 
-	case '1': // Menu (Type 1)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		htmlContent := formatMenuHTML(rawResponse, host, port, selector, false)
-		w.Write([]byte(htmlContent))
+// serveMenu renders a Menu (Type 1) progressively.
+func serveMenu(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	return streamMenuHTML(w, conn, conn.Host, conn.Port, conn.Selector, mirrorNote(conn.Address(), conn.Tried))
+}
 
-	default:
-		// Unknown types are treated as opaque bytes.
-		// We provide no strong opinion; the browser decides.
-		w.Header().Set("Content-Type", http.DetectContentType(rawBytes))
-		w.Write(rawBytes)
+// serveBinary sends the bytes as they are, under the type's registered MIME
+// type or one guessed from the selector or the first bytes. Types marked for
+// download (and links with download=1) are saved rather than shown.
+func serveBinary(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	setRemoteHeaders(w, contentType(g, conn))
+	if kindOf(g.Type).Download || r.URL.Query().Get("download") == "1" {
+		setDownload(w, itemFileName(g))
 	}
+	return copyStream(w, conn)
 }
 
 // handleFocus is called by a newly launched 'gofer' process (PID 2) to signal
@@ -525,36 +642,15 @@ func handleFocus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Convert the gopher URI into the local HTTP link
-	u, err := url.Parse(gopherURI)
-	if err != nil || u.Scheme != "gopher" {
+	// Example: gopher://freeshell.org:70/1/users becomes /?type=1&host=freeshell.org&port=70&selector=%2Fusers
+	g, err := ParseGopherURL(gopherURI)
+	if err != nil {
 		http.Error(w, "Invalid gopher URI.", http.StatusBadRequest)
 		return
 	}
 
-	// Reconstruct the URL for our local server
-	// Example: gopher://freeshell.org:70/1/users becomes /?host=freeshell.org&port=70&selector=1/users
-
-	// u.Path contains the item type and selector (e.g., /1/users)
-	// u.Host contains host:port (e.g., freeshell.org:70)
-
-	// We need to pass the raw path, without the leading slash for the selector
-	// But since our serveGopher handler handles the parsing of the selector from the path correctly,
-	// we just need to reconstruct the full local URL.
-
-	// Use our existing serveGopher logic (which uses the query params)
-	// We must separate host and port from u.Host
-
-	host := u.Hostname()
-	port := u.Port()
-	if port == "" {
-		port = DEFAULT_GOPHER_PORT
-	}
-
-	// The selector is the path without the leading slash
-	selector := strings.TrimPrefix(u.Path, "/")
-
 	// Construct the local URL to load
-	localURL := fmt.Sprintf("http://localhost:%s/?host=%s&port=%s&selector=%s", LOCAL_SERVER_PORT, host, port, selector)
+	localURL := fmt.Sprintf("http://localhost:%s%s", LOCAL_SERVER_PORT, g.LocalPath())
 
 	// 3. Launch the browser to the new URL
 	// The browser will typically focus on the existing tab or open a new one.
@@ -575,27 +671,43 @@ func handlePHEntry(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 
-	// --- STEP 1: Parse Command-Line Arguments (Gopher URI) ---
+	// "gofer ph-serve" runs a Ph server instead of the browser helper
+	if len(os.Args) > 1 && os.Args[1] == "ph-serve" {
+		if err := phServe(os.Args[2:]); err != nil {
+			fmt.Println("ph-serve:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// --- STEP 1: Parse Command-Line Arguments (flags, then the Gopher URI) ---
+
+	flag.BoolVar(&opportunisticTLS, "tls-upgrade", false, "try TLS first for plain gopher:// hosts")
+	flag.BoolVar(&allowLAN, "lan", false, "accept connections from the local network, not just this machine")
+	flag.Func("allow-host", "always allow connections to `host[:port]` (repeatable, or comma-separated)", func(v string) error {
+		policyAllowHosts = append(policyAllowHosts, strings.Split(v, ",")...)
+		return nil
+	})
+	flag.Func("deny-host", "never connect to `host[:port]` (repeatable, or comma-separated)", func(v string) error {
+		policyDenyHosts = append(policyDenyHosts, strings.Split(v, ",")...)
+		return nil
+	})
+	flag.BoolVar(&policyAllowPrivate, "allow-private", false, "allow connections to loopback and private networks")
+	flag.Parse()
 
 	// Determine the initial Gopher URL to load.
 	// This will be used in the first instance (PID 1) to open the browser.
-	initialGopherURL := fmt.Sprintf("http://localhost:%s/?host=%s&port=%s&selector=/", LOCAL_SERVER_PORT, DEFAULT_GOPHER_HOST, DEFAULT_GOPHER_PORT)
+	home := &GopherURL{Host: DEFAULT_GOPHER_HOST, Port: DEFAULT_GOPHER_PORT, Type: '1'}
+	initialGopherURL := fmt.Sprintf("http://localhost:%s%s", LOCAL_SERVER_PORT, home.LocalPath())
 
 	// If a command-line argument is passed (likely a gopher:// URI from the OS handler)
-	if len(os.Args) > 1 {
-		// The argument is the gopher URI
-		gopherURI := os.Args[1]
+	if flag.NArg() > 0 {
+		// The argument is the gopher (or gophers) URI
+		gopherURI := flag.Arg(0)
 		// Convert it to our local HTTP URL for the browser
 		// We use the Focus endpoint logic to convert the gopher URI to local URL
-		u, err := url.Parse(gopherURI)
-		if err == nil && u.Scheme == "gopher" {
-			host := u.Hostname()
-			port := u.Port()
-			if port == "" {
-				port = DEFAULT_GOPHER_PORT
-			}
-			selector := strings.TrimPrefix(u.Path, "/")
-			initialGopherURL = fmt.Sprintf("http://localhost:%s/?host=%s&port=%s&selector=%s", LOCAL_SERVER_PORT, host, port, selector)
+		if g, err := ParseGopherURL(gopherURI); err == nil {
+			initialGopherURL = fmt.Sprintf("http://localhost:%s%s", LOCAL_SERVER_PORT, g.LocalPath())
 		} else {
 			fmt.Printf("Warning: Invalid URI received: %s. Loading default page.\n", gopherURI)
 		}
@@ -603,7 +715,7 @@ func main() {
 
 	// --- STEP 2: Singleton Check (Attempt to bind to the port) ---
 
-	listener, err := net.Listen("tcp", ":"+LOCAL_SERVER_PORT)
+	listener, err := net.Listen("tcp", localListenAddress())
 	if err != nil {
 		// Port is already in use (PID 1 is running) -> This is PID 2
 		fmt.Printf("gofer (PID %d) is already running on port %s. Sending Re-Focus signal.\n", os.Getpid(), LOCAL_SERVER_PORT)
@@ -611,12 +723,12 @@ func main() {
 		// Send a request to PID 1 to handle the new Gopher URI
 		// If we were launched with a Gopher URL, forward it. Otherwise request a generic focus.
 		var targetURL string
-		if len(os.Args) > 1 {
+		if flag.NArg() > 0 {
 			targetURL = fmt.Sprintf(
 				"http://localhost:%s%s?uri=%s",
 				LOCAL_SERVER_PORT,
 				FOCUS_ENDPOINT,
-				url.QueryEscape(os.Args[1]),
+				url.QueryEscape(flag.Arg(0)),
 			)
 		} else {
 			// No arg provided; request focus without a URI.
@@ -627,7 +739,20 @@ func main() {
 			)
 		}
 
-		resp, err := http.Get(targetURL)
+		// the running instance only accepts /focus with its session token
+		token, err := readSessionToken()
+		if err != nil {
+			fmt.Printf("Error reading session token: %v\n", err)
+			os.Exit(1)
+		}
+		req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+		if err != nil {
+			fmt.Printf("Error sending re-focus signal: %v\n", err)
+			os.Exit(1)
+		}
+		req.Header.Set(SESSION_TOKEN_HEADER, token)
+
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			fmt.Printf("Error sending re-focus signal: %v\n", err)
 			os.Exit(1)
@@ -641,18 +766,30 @@ func main() {
 
 	// --- STEP 3: Primary Instance (PID 1) Initialization ---
 
-	fmt.Printf("gofer (PID %d) starting server on port %s...\n", os.Getpid(), LOCAL_SERVER_PORT)
+	fmt.Printf("gofer (PID %d) starting server on %s...\n", os.Getpid(), localListenAddress())
+
+	if err := startSession(); err != nil {
+		fmt.Printf("Warning: %v (gofer can't be re-focused by a second instance)\n", err)
+	}
 
 	// 1. Start the inactivity monitor in a separate goroutine
 	go monitorInactivity()
 
 	// 2. Set up the HTTP handlers
 	http.HandleFunc("/", serveGopher)
-	http.HandleFunc(FOCUS_ENDPOINT, handleFocus)   // handler for PID 2 signals
-	http.HandleFunc("/heartbeat", handleHeartbeat) // handler for keep-alive ping
-	http.HandleFunc("/heartmon", serveHeartMon)    // heartbeat monitor window
-	http.HandleFunc("/ph/", handlePHEntry)         // handler for type 2 ph_client and cso directorys
-	http.HandleFunc("/search", HandleSearch)       // handler for type 7 searches
+	http.HandleFunc(FOCUS_ENDPOINT, handleFocus)                // handler for PID 2 signals
+	http.HandleFunc("/heartbeat", handleHeartbeat)              // handler for keep-alive ping
+	http.HandleFunc("/heartmon", serveHeartMon)                 // heartbeat monitor window
+	http.HandleFunc("/ph/", handlePHEntry)                      // handler for type 2 ph_client and cso directorys
+	http.HandleFunc("/search", HandleSearch)                    // handler for type 7 searches
+	http.HandleFunc(VIEW_ENDPOINT, handleView)                  // handler for gopher+ alternate views
+	http.HandleFunc(ASK_ENDPOINT, HandleAsk)                    // handler for gopher+ +ASK forms
+	http.HandleFunc(TRUST_ENDPOINT, handleTrust)                // handler for accepting changed TLS certificates
+	http.HandleFunc(TELNET_ENDPOINT, handleTelnet)              // handler for type 8 terminal pages
+	http.HandleFunc(TELNET_SOCKET_ENDPOINT, handleTelnetSocket) // websocket bridge for telnet sessions
+	http.HandleFunc(TN3270_ENDPOINT, handleTN3270)              // handler for type T 3270 screens
+	http.HandleFunc(TN3270_SOCKET_ENDPOINT, handleTN3270Socket) // websocket bridge for tn3270 sessions
+	http.HandleFunc(EXTERNAL_ENDPOINT, handleExternal)          // warning page for URL: links out of gopherspace
 
 	// 3. Launch the browser to the initial URL (parsed from CLI or default)
 	launchBrowser(initialGopherURL)
@@ -660,7 +797,7 @@ func main() {
 
 	// 4. Start the server using the listener we successfully created
 	// This blocks the main goroutine until termination (by the monitor or Ctrl+C)
-	server := &http.Server{Handler: nil}
+	server := &http.Server{Handler: guardLocal(http.DefaultServeMux)}
 	err = server.Serve(listener)
 
 	if err != nil && err != http.ErrServerClosed {
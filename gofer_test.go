@@ -0,0 +1,102 @@
+// menu rendering tests for gofer 0.9
+// hostile menu lines must come out escaped
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gofer/gophermap"
+)
+
+func TestFormatItemsHTMLEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   []string // substrings of the rendered item
+		forbid []string
+	}{
+		{
+			name:   "script in display",
+			line:   "0<script>alert(1)</script>\t/x\texample.org\t70",
+			want:   []string{"&lt;script&gt;alert(1)&lt;/script&gt;</a>"},
+			forbid: []string{"<script>"},
+		},
+		{
+			name:   "markup in info line",
+			line:   "i<b>info</b> & co\t\terror.host\t1",
+			want:   []string{"&lt;b&gt;info&lt;/b&gt; &amp; co"},
+			forbid: []string{"<b>", "<a "},
+		},
+		{
+			name:   "selector breaking out of the attribute",
+			line:   "0quote\t\"><img src=x onerror=alert(1)>\texample.org\t70",
+			want:   []string{`selector=%22%3E%3Cimg&#43;src%3Dx&#43;onerror%3Dalert%281%29%3E">quote</a>`},
+			forbid: []string{`"><img`, "<img"},
+		},
+		{
+			name:   "selector breaking out of a telnet route",
+			line:   "8tel\t\"><x\texample.org\t23",
+			want:   []string{`href="/telnet?host=example.org&amp;port=23&amp;selector=%22%3E%3Cx&amp;`},
+			forbid: []string{`"><x`},
+		},
+		{
+			name:   "javascript host",
+			line:   "1js host\t/x\tjavascript:alert(1)\t70",
+			want:   []string{`href="/?type=1&amp;host=javascript%3Aalert%281%29&amp;port=70&amp;`},
+			forbid: []string{`href="javascript:`},
+		},
+		{
+			name:   "javascript URL: selector",
+			line:   "hscript\tURL:javascript:alert(1)\texample.org\t70",
+			want:   []string{`href="/?type=h&amp;host=example.org&amp;port=70&amp;selector=URL%3Ajavascript%3Aalert%281%29"`},
+			forbid: []string{`href="javascript:`, "[URL]"},
+		},
+		{
+			name:   "markup in an external URL",
+			line:   "hweb\tURL:http://example.com/\"><script>\texample.org\t70",
+			want:   []string{`href="/external?url=http%3A%2F%2Fexample.com%2F%2522%253E%253Cscript%253E&amp;`, `title="http://example.com/%22%3E%3Cscript%3E"`},
+			forbid: []string{"<script>"},
+		},
+		{
+			name: "letters in the port",
+			line: "1bad port\t/x\texample.org\t70abc",
+			want: []string{`port=70abc&amp;`},
+		},
+		{
+			name:   "markup in the port",
+			line:   "1bad port\t/x\texample.org\t\"><b>",
+			want:   []string{`port=%22%3E%3Cb%3E&amp;`},
+			forbid: []string{`"><b>`, "<b>"},
+		},
+	}
+
+	for _, tt := range tests {
+		items, err := gophermap.ParseMenuString(tt.line+"\n.\n", gophermap.PARSE_LENIENT)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("%s: ParseMenuString = %d items, %v", tt.name, len(items), err)
+		}
+		html := formatItemsHTML(items, "current.example.org", "70", "/", true)
+
+		for _, want := range tt.want {
+			if !strings.Contains(html, want) {
+				t.Errorf("%s: output lacks %q:\n%s", tt.name, want, html)
+			}
+		}
+		for _, forbid := range tt.forbid {
+			if strings.Contains(html, forbid) {
+				t.Errorf("%s: output contains %q:\n%s", tt.name, forbid, html)
+			}
+		}
+	}
+}
+
+func TestFormatMenuHTMLHeaderEscaping(t *testing.T) {
+	html := formatMenuHTML("iok\t\terror.host\t1\n.\n", `evil"host`, "70", "/<script>", false)
+	if strings.Contains(html, "<script>") || strings.Contains(html, `evil"host`) {
+		t.Errorf("unescaped current location in page:\n%s", html)
+	}
+}
@@ -0,0 +1,291 @@
+// gopher url module for gofer 0.9
+// parses and serializes gopher:// URLs per RFC 4266
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// GopherURL is the canonical model of a gopher item address:
+//
+//	gopher://<host>:<port>/<type><selector>%09<search>%09<gopher+_string>
+//
+// Selector, Search, and Plus are held unescaped, exactly as sent on the wire.
+type GopherURL struct {
+	TLS      bool   // gophers://
+	Host     string // hostname or IP literal, without brackets
+	Port     string
+	Type     byte
+	Selector string
+	Search   string // search string for type 7 items
+	Plus     string // Gopher+ string, e.g. "+" or "+text/plain"
+}
+
+// ParseGopherURL parses a gopher:// or gophers:// URL. An empty path is the
+// root menu (type 1, empty selector) and a missing port is the default port.
+func ParseGopherURL(raw string) (*GopherURL, error) {
+	raw = strings.TrimSpace(raw)
+
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid gopher URL %q: missing scheme", raw)
+	}
+
+	g := &GopherURL{Type: '1'}
+	switch strings.ToLower(scheme) {
+	case "gopher":
+	case "gophers":
+		g.TLS = true
+	default:
+		return nil, fmt.Errorf("invalid gopher URL %q: unsupported scheme %q", raw, scheme)
+	}
+
+	// The path is everything after the authority. Selectors may legitimately
+	// contain "?" and "#", so they are not treated as query or fragment.
+	authority, path, _ := strings.Cut(rest, "/")
+	if err := g.setAuthority(authority); err != nil {
+		return nil, fmt.Errorf("invalid gopher URL %q: %w", raw, err)
+	}
+
+	if path == "" {
+		return g, nil
+	}
+	g.Type = path[0]
+
+	// Split on %09 before unescaping, so encoded tabs separate the parts while
+	// everything else (including %25) decodes into the selector.
+	parts := splitEncodedTabs(path[1:])
+
+	g.Selector = unescapeGopher(parts[0])
+	if len(parts) > 1 {
+		g.Search = unescapeGopher(parts[1])
+	}
+	if len(parts) > 2 {
+		g.Plus = unescapeGopher(strings.Join(parts[2:], "\t"))
+	}
+
+	return g, nil
+}
+
+// setAuthority fills Host and Port from "host", "host:port", or "[v6]:port".
+func (g *GopherURL) setAuthority(authority string) error {
+	// userinfo has no meaning for gopher; drop it like browsers do
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		authority = authority[i+1:]
+	}
+	if authority == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	host, port := authority, ""
+	if strings.HasPrefix(authority, "[") {
+		end := strings.Index(authority, "]")
+		if end < 0 {
+			return fmt.Errorf("unterminated IPv6 literal")
+		}
+		host = authority[1:end]
+		port = strings.TrimPrefix(authority[end+1:], ":")
+	} else if i := strings.LastIndex(authority, ":"); i >= 0 {
+		host, port = authority[:i], authority[i+1:]
+	}
+
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+
+	g.Host = host
+	g.Port = port
+	if g.Port == "" {
+		g.Port = g.defaultPort()
+	}
+	return nil
+}
+
+func (g *GopherURL) defaultPort() string {
+	if g.TLS {
+		return DEFAULT_GOPHERS_PORT
+	}
+	return DEFAULT_GOPHER_PORT
+}
+
+// splitEncodedTabs splits on %09 (either case) and on literal tabs that a
+// browser may already have decoded.
+func splitEncodedTabs(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\t':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		case s[i] == '%' && i+2 < len(s) && s[i+1] == '0' && s[i+2] == '9':
+			parts = append(parts, s[start:i])
+			start = i + 3
+			i += 2
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeGopher percent-decodes a path part. Unlike url.PathUnescape it
+// tolerates stray "%" signs, which real-world selectors are full of.
+func unescapeGopher(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// escapeGopher percent-encodes everything but unreserved characters, "/",
+// and the sub-delimiters, so that "?", "#", "%", spaces, and tabs survive.
+func escapeGopher(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("-._~/!$&'()*+,;=:@", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
+
+// Address returns host:port for dialing.
+func (g *GopherURL) Address() string {
+	return net.JoinHostPort(g.Host, g.Port)
+}
+
+// Request returns the line sent to the server (without the CRLF terminator).
+func (g *GopherURL) Request() string {
+	request := g.Selector
+	if g.Search != "" {
+		request += "\t" + g.Search
+	}
+	if g.Plus != "" {
+		request += "\t" + g.Plus
+	}
+	return request
+}
+
+// Authority returns host[:port] with the port omitted when it is the default.
+func (g *GopherURL) Authority() string {
+	host := g.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if g.Port == "" || g.Port == g.defaultPort() {
+		return host
+	}
+	return host + ":" + g.Port
+}
+
+// Path returns the escaped /<type><selector>[%09<search>[%09<plus>]] part.
+// The root menu serializes as "/".
+func (g *GopherURL) Path() string {
+	if g.Type == '1' && g.Selector == "" && g.Search == "" && g.Plus == "" {
+		return "/"
+	}
+
+	path := "/" + string(g.Type) + escapeGopher(g.Selector)
+	if g.Search != "" || g.Plus != "" {
+		path += "%09" + escapeGopher(g.Search)
+	}
+	if g.Plus != "" {
+		path += "%09" + escapeGopher(g.Plus)
+	}
+	return path
+}
+
+// String returns the canonical RFC 4266 form of the URL.
+func (g *GopherURL) String() string {
+	scheme := "gopher://"
+	if g.TLS {
+		scheme = "gophers://"
+	}
+	return scheme + g.Authority() + g.Path()
+}
+
+// LocalPath returns the link into the local gofer server that loads this item.
+func (g *GopherURL) LocalPath() string {
+	path := fmt.Sprintf("/?type=%s&host=%s&port=%s&selector=%s",
+		url.QueryEscape(string(g.Type)),
+		url.QueryEscape(g.Host),
+		url.QueryEscape(g.Port),
+		url.QueryEscape(g.Selector),
+	)
+	if g.Search != "" {
+		path += "&search=" + url.QueryEscape(g.Search)
+	}
+	if g.Plus != "" {
+		path += "&plus=" + url.QueryEscape(g.Plus)
+	}
+	if g.TLS {
+		path += "&tls=1"
+	}
+	return path
+}
+
+// gopherURLFromQuery reads the parameters written by LocalPath. Missing
+// fields fall back to the default server and the root menu.
+func gopherURLFromQuery(query url.Values) *GopherURL {
+	g := &GopherURL{
+		TLS:      query.Get("tls") == "1",
+		Host:     query.Get("host"),
+		Port:     query.Get("port"),
+		Type:     '1',
+		Selector: query.Get("selector"),
+		Search:   query.Get("search"),
+		Plus:     query.Get("plus"),
+	}
+
+	if t := query.Get("type"); t != "" {
+		g.Type = t[0]
+	}
+	if g.Host == "" {
+		g.Host = DEFAULT_GOPHER_HOST
+	}
+	if g.Port == "" {
+		g.Port = g.defaultPort()
+	}
+	return g
+}
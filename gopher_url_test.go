@@ -0,0 +1,132 @@
+// gopher url tests for gofer 0.9
+// round trips of the RFC 4266 URL forms
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseGopherURL(t *testing.T) {
+	tests := []struct {
+		raw       string
+		want      GopherURL
+		canonical string
+	}{
+		{
+			raw:       "gopher://gopher.example.org",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '1'},
+			canonical: "gopher://gopher.example.org/",
+		},
+		{
+			raw:       "gopher://gopher.example.org:70/",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '1'},
+			canonical: "gopher://gopher.example.org/",
+		},
+		{
+			raw:       "gopher://gopher.example.org:7070/0/docs/readme.txt",
+			want:      GopherURL{Host: "gopher.example.org", Port: "7070", Type: '0', Selector: "/docs/readme.txt"},
+			canonical: "gopher://gopher.example.org:7070/0/docs/readme.txt",
+		},
+		{
+			raw:       "gopher://gopher.example.org/1",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '1'},
+			canonical: "gopher://gopher.example.org/",
+		},
+		{
+			raw:       "gopher://gopher.example.org/7/search%09gopher%20holes",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '7', Selector: "/search", Search: "gopher holes"},
+			canonical: "gopher://gopher.example.org/7/search%09gopher%20holes",
+		},
+		{
+			raw:       "gopher://gopher.example.org/1/dir%09%09+",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '1', Selector: "/dir", Plus: "+"},
+			canonical: "gopher://gopher.example.org/1/dir%09%09+",
+		},
+		{
+			raw:       "gopher://gopher.example.org/0/paper%09%09+application/postscript",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '0', Selector: "/paper", Plus: "+application/postscript"},
+			canonical: "gopher://gopher.example.org/0/paper%09%09+application/postscript",
+		},
+		{
+			raw:       "gopher://[2001:db8::1]/0/file",
+			want:      GopherURL{Host: "2001:db8::1", Port: "70", Type: '0', Selector: "/file"},
+			canonical: "gopher://[2001:db8::1]/0/file",
+		},
+		{
+			raw:       "gophers://[::1]:7443/0a?b#c%25",
+			want:      GopherURL{TLS: true, Host: "::1", Port: "7443", Type: '0', Selector: "a?b#c%"},
+			canonical: "gophers://[::1]:7443/0a%3Fb%23c%25",
+		},
+		{
+			raw:       "gopher://gopher.example.org/0100%25%20done",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '0', Selector: "100% done"},
+			canonical: "gopher://gopher.example.org/0100%25%20done",
+		},
+		{
+			// a stray "%" is kept as it is, as real selectors have them
+			raw:       "gopher://gopher.example.org/0/50%off",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '0', Selector: "/50%off"},
+			canonical: "gopher://gopher.example.org/0/50%25off",
+		},
+		{
+			raw:       "GOPHER://user@gopher.example.org:70/0/x",
+			want:      GopherURL{Host: "gopher.example.org", Port: "70", Type: '0', Selector: "/x"},
+			canonical: "gopher://gopher.example.org/0/x",
+		},
+	}
+
+	for _, tt := range tests {
+		g, err := ParseGopherURL(tt.raw)
+		if err != nil {
+			t.Errorf("ParseGopherURL(%q): %v", tt.raw, err)
+			continue
+		}
+		if *g != tt.want {
+			t.Errorf("ParseGopherURL(%q) = %+v, want %+v", tt.raw, *g, tt.want)
+		}
+		if got := g.String(); got != tt.canonical {
+			t.Errorf("ParseGopherURL(%q).String() = %q, want %q", tt.raw, got, tt.canonical)
+		}
+
+		again, err := ParseGopherURL(g.String())
+		if err != nil || *again != *g {
+			t.Errorf("round trip of %q: got %+v, %v", tt.raw, again, err)
+		}
+	}
+}
+
+func TestParseGopherURLErrors(t *testing.T) {
+	for _, raw := range []string{
+		"gopher.example.org/0/x",
+		"http://gopher.example.org/",
+		"gopher:///0/x",
+		"gopher://[::1/0/x",
+		"gopher://gopher.example.org:7o/",
+	} {
+		if g, err := ParseGopherURL(raw); err == nil {
+			t.Errorf("ParseGopherURL(%q) = %+v, want an error", raw, *g)
+		}
+	}
+}
+
+func TestGopherURLRequest(t *testing.T) {
+	g := &GopherURL{Type: '7', Selector: "/search", Search: "a b", Plus: "+"}
+	if got, want := g.Request(), "/search\ta b\t+"; got != want {
+		t.Errorf("Request() = %q, want %q", got, want)
+	}
+}
+
+func TestGopherURLLocalPath(t *testing.T) {
+	g := &GopherURL{TLS: true, Host: "::1", Port: "7443", Type: '7', Selector: "/a&b", Search: "x y", Plus: "+"}
+	u, err := url.Parse(g.LocalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back := gopherURLFromQuery(u.Query()); *back != *g {
+		t.Errorf("gopherURLFromQuery(LocalPath()) = %+v, want %+v", *back, *g)
+	}
+}
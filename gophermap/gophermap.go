@@ -0,0 +1,319 @@
+// gophermap module for gofer 0.9
+// parses gopher menus into typed items for rendering, search, and export
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+// Package gophermap parses gopher menus (RFC 1436, with the Gopher+ and
+// type + extensions) into typed items, for gofer and for any other tool
+// that reads gopherspace.
+package gophermap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseMode selects how the menu parser treats lines that break RFC 1436.
+type ParseMode int
+
+const (
+	PARSE_LENIENT ParseMode = iota // repair what can be repaired and record a warning
+	PARSE_STRICT                   // stop at the first malformed line
+)
+
+// Item is one parsed line of a gopher menu.
+type Item struct {
+	Type     byte
+	Display  string
+	Selector string
+	Host     string
+	Port     string
+	Plus     string   // the Gopher+ fifth field ("+" or "?"), empty for plain items
+	Raw      string   // the line as received, without its line ending
+	Line     int      // 1-based line number in the menu
+	Warnings []string // what the lenient parser had to repair
+	Mirrors  []*Item  // type + lines that followed this item: redundant servers for it
+}
+
+// IsPlus reports whether the item carries the Gopher+ fifth field.
+func (it *Item) IsPlus() bool {
+	return strings.HasPrefix(it.Plus, "+") || strings.HasPrefix(it.Plus, "?")
+}
+
+// IsAsk reports whether the item is a Gopher+ item with an +ASK block ("?").
+func (it *Item) IsAsk() bool {
+	return strings.HasPrefix(it.Plus, "?")
+}
+
+// ParseError reports a line the strict parser refused.
+type ParseError struct {
+	Line   int
+	Raw    string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("menu line %d: %s: %q", e.Line, e.Reason, e.Raw)
+}
+
+// ErrMissingTerminator is returned by the strict parser when the menu ends without ".".
+var ErrMissingTerminator = errors.New("menu ended without the \".\" terminator")
+
+// -----------------------------------------------------------
+// MenuParser reads a menu one item at a time, so a renderer can
+// show items while the rest of the menu is still arriving.
+// -----------------------------------------------------------
+type MenuParser struct {
+	reader  *bufio.Reader
+	mode    ParseMode
+	line    int
+	eof     bool  // the reader is exhausted
+	done    bool  // the menu is finished (terminator, EOF, or strict error)
+	pending *Item // the last item read, held back until its type + lines are collected
+	err     error // a strict error to report once the pending item is out
+
+	// Warnings collects menu-level repairs (e.g. a missing terminator).
+	Warnings []string
+}
+
+// NewMenuParser returns a parser reading from r.
+func NewMenuParser(r io.Reader, mode ParseMode) *MenuParser {
+	return &MenuParser{reader: bufio.NewReader(r), mode: mode}
+}
+
+// Next returns the next item, or io.EOF once the menu is finished.
+// Blank lines are skipped; CRLF and bare LF line endings are both accepted.
+// Type + lines are attached to the item before them as Mirrors, so each item
+// is returned once the line after it has arrived.
+func (p *MenuParser) Next() (*Item, error) {
+	for {
+		if p.err != nil || p.done {
+			return p.flush()
+		}
+
+		item, err := p.nextLine()
+		if err != nil {
+			if err != io.EOF {
+				p.err = err
+			}
+			return p.flush()
+		}
+
+		if item.Type == '+' {
+			if p.pending != nil {
+				p.pending.Mirrors = append(p.pending.Mirrors, item)
+				continue
+			}
+			if p.mode == PARSE_STRICT {
+				p.done = true
+				return nil, &ParseError{Line: item.Line, Raw: item.Raw, Reason: "redundant server with no item before it"}
+			}
+			item.Warnings = append(item.Warnings, "redundant server with no item before it")
+		}
+
+		previous := p.pending
+		p.pending = item
+		if previous != nil {
+			return previous, nil
+		}
+	}
+}
+
+// flush hands out the held-back item, then the error that ended the menu.
+func (p *MenuParser) flush() (*Item, error) {
+	if item := p.pending; item != nil {
+		p.pending = nil
+		return item, nil
+	}
+	if p.err != nil {
+		err := p.err
+		p.err = nil
+		p.done = true
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// nextLine reads and parses one menu line, or returns io.EOF at the end of the menu.
+func (p *MenuParser) nextLine() (*Item, error) {
+	for {
+		if p.done {
+			return nil, io.EOF
+		}
+
+		// the connection closed without a "." line
+		if p.eof {
+			p.done = true
+			if p.mode == PARSE_STRICT {
+				return nil, ErrMissingTerminator
+			}
+			p.Warnings = append(p.Warnings, ErrMissingTerminator.Error())
+			return nil, io.EOF
+		}
+
+		raw, err := p.reader.ReadString('\n')
+		if err == io.EOF {
+			p.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		if raw == "" {
+			continue
+		}
+		p.line++
+
+		raw = strings.TrimRight(raw, "\r\n")
+
+		if raw == "." {
+			p.done = true
+			return nil, io.EOF
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		item, err := parseMenuLine(raw, p.line, p.mode)
+		if err != nil {
+			p.done = true
+			return nil, err
+		}
+		return item, nil
+	}
+}
+
+// ParseMenu reads a whole menu. In lenient mode it never fails on content,
+// only on read errors.
+func ParseMenu(r io.Reader, mode ParseMode) ([]*Item, error) {
+	p := NewMenuParser(r, mode)
+
+	var items []*Item
+	for {
+		item, err := p.Next()
+		if item != nil {
+			items = append(items, item)
+		}
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return items, err
+		}
+	}
+}
+
+// ParseMenuString is ParseMenu for a menu already held in memory.
+func ParseMenuString(menu string, mode ParseMode) ([]*Item, error) {
+	return ParseMenu(strings.NewReader(menu), mode)
+}
+
+// -----------------------------------------------------------
+// parseMenuLine(raw) -> item
+//
+// TypeDisplayString<TAB>Selector<TAB>Host<TAB>Port[<TAB>+]
+//
+// The lenient parser also copes with dot-stuffed lines, tabs inside
+// the display string, missing trailing fields, and lines with no tabs.
+// -----------------------------------------------------------
+func parseMenuLine(raw string, lineNo int, mode ParseMode) (*Item, error) {
+	item := &Item{Raw: raw, Line: lineNo}
+
+	problem := func(reason string) error {
+		if mode == PARSE_STRICT {
+			return &ParseError{Line: lineNo, Raw: raw, Reason: reason}
+		}
+		item.Warnings = append(item.Warnings, reason)
+		return nil
+	}
+
+	line := raw
+	if strings.HasPrefix(line, "..") {
+		line = line[1:] // dot-stuffing
+	}
+
+	fields := strings.Split(line, "\t")
+
+	// no tabs at all: not a menu line, most likely stray text
+	if len(fields) == 1 {
+		if err := problem("no tab-separated fields"); err != nil {
+			return nil, err
+		}
+		item.Type = '3'
+		item.Display = strings.TrimSpace(line)
+		return item, nil
+	}
+
+	if fields[0] == "" {
+		if err := problem("missing item type"); err != nil {
+			return nil, err
+		}
+		item.Type = '3'
+		item.Display = strings.TrimSpace(line)
+		return item, nil
+	}
+
+	// tabs inside the display string push the real fields to the right;
+	// the port is the last numeric field that still leaves room for selector and host
+	if len(fields) > 5 || (len(fields) == 5 && !isPort(fields[3]) && isPort(fields[4])) {
+		portAt := -1
+		for i := len(fields) - 1; i >= 3; i-- {
+			if isPort(fields[i]) {
+				portAt = i
+				break
+			}
+		}
+		if portAt > 3 {
+			if err := problem("tab inside display string"); err != nil {
+				return nil, err
+			}
+			display := strings.Join(fields[:portAt-2], " ")
+			fields = append([]string{display}, fields[portAt-2:]...)
+		}
+	}
+
+	item.Type = fields[0][0]
+	item.Display = strings.TrimRight(fields[0][1:], " \t\r")
+
+	if len(fields) > 1 {
+		item.Selector = fields[1]
+	}
+	if len(fields) > 2 {
+		item.Host = strings.TrimSpace(fields[2])
+	}
+	if len(fields) > 3 {
+		item.Port = strings.TrimSpace(fields[3])
+	}
+	if len(fields) > 4 {
+		item.Plus = strings.TrimSpace(fields[4])
+	}
+
+	// info and error lines often skip their dummy fields; only links need them
+	if len(fields) < 4 && (mode == PARSE_STRICT || (item.Type != 'i' && item.Type != '3')) {
+		if err := problem("missing host or port"); err != nil {
+			return nil, err
+		}
+	}
+	if item.Port != "" && !isPort(item.Port) {
+		if err := problem("non-numeric port"); err != nil {
+			return nil, err
+		}
+	}
+
+	return item, nil
+}
+
+// isPort reports whether s looks like a TCP port number.
+func isPort(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" || len(s) > 5 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+// gophermap tests for gofer 0.9
+// lenient repairs and strict refusals of the menu parser
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package gophermap
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseMenuLenient(t *testing.T) {
+	menu := "1Docs\t/docs\texample.org\t70\r\n" +
+		"0Plus item\t/p\texample.org\t70\t+\n" +
+		"+\t/docs\tmirror.example.org\t7070\n" +
+		"iinfo without fields\t\n" +
+		"..dotted\t/d\texample.org\t70\n" +
+		"0tab\tinside\t/t\texample.org\t70\n" +
+		"\n" +
+		"stray text\n" +
+		"7Search\t/s\texample.org\t7o\n"
+
+	items, err := ParseMenuString(menu, PARSE_LENIENT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		typ                     byte
+		display, selector, host string
+		warned                  bool
+	}{
+		{'1', "Docs", "/docs", "example.org", false},
+		{'0', "Plus item", "/p", "example.org", false},
+		{'i', "info without fields", "", "", false},
+		{'.', "dotted", "/d", "example.org", false},
+		{'0', "tab inside", "/t", "example.org", true},
+		{'3', "stray text", "", "", true},
+		{'7', "Search", "/s", "example.org", true},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("%d items, want %d", len(items), len(want))
+	}
+	for i, w := range want {
+		it := items[i]
+		if it.Type != w.typ || it.Display != w.display || it.Selector != w.selector || it.Host != w.host || (len(it.Warnings) > 0) != w.warned {
+			t.Errorf("item %d = %c %q %q %q %q", i, it.Type, it.Display, it.Selector, it.Host, it.Warnings)
+		}
+	}
+
+	if !items[1].IsPlus() || items[1].IsAsk() {
+		t.Error("the fifth field \"+\" isn't a plain Gopher+ item")
+	}
+	if len(items[1].Mirrors) != 1 || items[1].Mirrors[0].Host != "mirror.example.org" {
+		t.Errorf("the type + line wasn't attached as a mirror: %+v", items[1].Mirrors)
+	}
+	if items[2].Line != 4 || items[6].Line != 9 {
+		t.Errorf("line numbers %d and %d, want 4 and 9", items[2].Line, items[6].Line)
+	}
+}
+
+func TestMenuParserMissingTerminator(t *testing.T) {
+	p := NewMenuParser(strings.NewReader("iok\t\terror.host\t1\n"), PARSE_LENIENT)
+	if it, err := p.Next(); err != nil || it.Display != "ok" {
+		t.Fatalf("Next = %+v, %v", it, err)
+	}
+	if _, err := p.Next(); err != io.EOF {
+		t.Fatalf("Next at the end = %v, want io.EOF", err)
+	}
+	if len(p.Warnings) != 1 {
+		t.Errorf("warnings = %q, want the missing terminator", p.Warnings)
+	}
+
+	_, err := ParseMenuString("iok\t\terror.host\t1\n", PARSE_STRICT)
+	if !errors.Is(err, ErrMissingTerminator) {
+		t.Errorf("strict parse without \".\" = %v", err)
+	}
+}
+
+func TestParseMenuStrict(t *testing.T) {
+	for _, line := range []string{
+		"stray text",
+		"\t/x\texample.org\t70",
+		"1Docs\t/docs",
+		"1Docs\t/docs\texample.org\tseventy",
+	} {
+		items, err := ParseMenuString("1Before\t/b\texample.org\t70\n"+line+"\n.\n", PARSE_STRICT)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: err = %v, want a ParseError", line, err)
+			continue
+		}
+		if parseErr.Line != 2 || parseErr.Raw != line {
+			t.Errorf("%q: error at line %d for %q", line, parseErr.Line, parseErr.Raw)
+		}
+		if len(items) != 1 {
+			t.Errorf("%q: %d items before the error, want 1", line, len(items))
+		}
+	}
+}
+
+func TestParseMenuStrayMirror(t *testing.T) {
+	menu := "+\t/docs\tmirror.example.org\t70\n.\n"
+
+	var parseErr *ParseError
+	if _, err := ParseMenuString(menu, PARSE_STRICT); !errors.As(err, &parseErr) {
+		t.Errorf("strict: err = %v, want a ParseError", err)
+	}
+	items, err := ParseMenuString(menu, PARSE_LENIENT)
+	if err != nil || len(items) != 1 || len(items[0].Warnings) != 1 {
+		t.Errorf("lenient: %+v, %v, want the line kept with a warning", items, err)
+	}
+}
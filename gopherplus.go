@@ -0,0 +1,494 @@
+// gopher+ module for gofer 0.9
+// item attributes (+INFO, +ADMIN, +VIEWS, +ABSTRACT) and alternate views
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GOPHERPLUS_ITEM_ATTRIBUTES = "\t!"           // attribute block for a single item
+	GOPHERPLUS_DIR_ATTRIBUTES  = "\t$"           // attribute blocks for every item in a directory
+	GOPHERPLUS_MAX_ITEM_FETCH  = 4               // per-menu cap on individual "!" requests
+	GOPHERPLUS_ITEM_FETCH_TIME = 2 * time.Second // per-menu time allowed for them
+	GOPHERPLUS_DIR_FETCH_TIME  = 3 * time.Second // time allowed for a menu's "$" request
+	GOPHERPLUS_ATTRIBUTES_SIZE = 1 << 20         // largest attribute listing read while a menu streams
+	GOPHERPLUS_VIEWS_KEPT      = 4096            // offered views remembered for /view
+	VIEW_ENDPOINT              = "/view"
+)
+
+// PlusView is one alternate representation offered in a +VIEWS block.
+type PlusView struct {
+	MIME     string // e.g. text/plain
+	Language string // e.g. En_US (optional)
+	Size     string // e.g. 10k (optional)
+}
+
+// Spec returns the view as it is sent back to the server after "selector\t+".
+func (v PlusView) Spec() string {
+	if v.Language != "" {
+		return v.MIME + " " + v.Language
+	}
+	return v.MIME
+}
+
+// PlusAttributes holds the parsed attribute blocks of one Gopher+ item.
+type PlusAttributes struct {
+	Info     string // the raw +INFO menu line
+	Admin    string
+	ModDate  string
+	Views    []PlusView
+	Abstract string
+	Blocks   map[string][]string // every block by name, including the ones above
+}
+
+// errNotGopherPlus is returned for a reply without a Gopher+ length line:
+// the server is a plain gopher server, and the reply is the document.
+var errNotGopherPlus = errors.New("not a gopher+ response")
+
+// -----------------------------------------------------------
+// stripPlusHeader(data) -> payload
+//
+// Gopher+ replies start with a length line: "+-1" (terminated by "."),
+// "+-2" (read until close), "+N" (N bytes), or "--1" and "--2" for
+// errors. Anything else is errNotGopherPlus.
+// -----------------------------------------------------------
+func stripPlusHeader(data []byte) ([]byte, error) {
+	nl := bytes.IndexByte(data, '\n')
+	if nl < 0 || len(data) == 0 {
+		return nil, fmt.Errorf("empty or malformed gopher+ response")
+	}
+
+	header := strings.TrimSpace(string(data[:nl]))
+	body := data[nl+1:]
+
+	if len(header) < 2 || header[0] != '+' && header[0] != '-' {
+		return nil, errNotGopherPlus
+	}
+	if _, err := strconv.Atoi(header[1:]); err != nil {
+		return nil, errNotGopherPlus
+	}
+
+	switch header[0] {
+	case '-':
+		// error: the body carries an error code, message, and admin contact
+		msg := strings.TrimSpace(string(body))
+		msg = strings.TrimSuffix(msg, "\n.")
+		msg = strings.TrimSuffix(msg, ".")
+		return nil, fmt.Errorf("gopher+ server error: %s", strings.TrimSpace(msg))
+
+	case '+':
+		n, _ := strconv.Atoi(header[1:])
+		switch {
+		case n == -1:
+			return trimGopherTerminator(body), nil
+		case n == -2:
+			return body, nil
+		case n >= 0 && n <= len(body):
+			return body[:n], nil
+		default:
+			return body, nil // short read; hand back what arrived
+		}
+	}
+	return nil, errNotGopherPlus
+}
+
+// trimGopherTerminator drops the final "." line from period-terminated data.
+func trimGopherTerminator(b []byte) []byte {
+	trimmed := bytes.TrimRight(b, "\r\n")
+	if bytes.HasSuffix(trimmed, []byte("\n.")) {
+		return trimmed[:len(trimmed)-1]
+	}
+	if bytes.Equal(trimmed, []byte(".")) {
+		return nil
+	}
+	return b
+}
+
+// parsePlusAttributes splits an attribute response into one PlusAttributes per +INFO block.
+func parsePlusAttributes(data string) []*PlusAttributes {
+	var items []*PlusAttributes
+	var current *PlusAttributes
+	var block string
+
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.HasPrefix(line, "+") {
+			name, rest, _ := strings.Cut(line[1:], ":")
+			block = strings.ToUpper(strings.TrimSpace(name))
+			rest = strings.TrimLeft(rest, " ")
+
+			if block == "INFO" {
+				current = &PlusAttributes{Info: rest, Blocks: map[string][]string{}}
+				items = append(items, current)
+			}
+			if current != nil && block != "INFO" && rest != "" {
+				current.Blocks[block] = append(current.Blocks[block], rest)
+			}
+			continue
+		}
+
+		if current == nil || block == "" {
+			continue
+		}
+		// block content lines are indented by a single space
+		current.Blocks[block] = append(current.Blocks[block], strings.TrimPrefix(line, " "))
+	}
+
+	for _, a := range items {
+		a.fill()
+	}
+	return items
+}
+
+// fill derives the well-known fields from the raw blocks.
+func (a *PlusAttributes) fill() {
+	for _, line := range a.Blocks["ADMIN"] {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "Admin":
+			a.Admin = val
+		case "Mod-Date":
+			a.ModDate = formatPlusDate(val)
+		}
+	}
+
+	for _, line := range a.Blocks["VIEWS"] {
+		if v, ok := parsePlusView(line); ok {
+			a.Views = append(a.Views, v)
+		}
+	}
+
+	abstract := strings.TrimSpace(strings.Join(a.Blocks["ABSTRACT"], "\n"))
+	a.Abstract = abstract
+}
+
+// parsePlusView reads a +VIEWS line such as "application/postscript En_US: <30k>".
+func parsePlusView(line string) (PlusView, bool) {
+	spec, size, _ := strings.Cut(strings.TrimSpace(line), ":")
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return PlusView{}, false
+	}
+
+	v := PlusView{MIME: fields[0]}
+	if len(fields) > 1 {
+		v.Language = fields[1]
+	}
+	size = strings.TrimSpace(size)
+	size = strings.TrimSuffix(strings.TrimPrefix(size, "<"), ">")
+	v.Size = size
+	return v, true
+}
+
+// formatPlusDate turns "Some Text <19930303123456>" into a readable timestamp.
+func formatPlusDate(val string) string {
+	start := strings.LastIndex(val, "<")
+	end := strings.LastIndex(val, ">")
+	if start < 0 || end <= start {
+		return val
+	}
+	t, err := time.Parse("20060102150405", val[start+1:end])
+	if err != nil {
+		return val
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// plusKey identifies an item by the selector/host/port triple of its menu line.
+func plusKey(selector, host, port string) string {
+	return selector + "\t" + host + "\t" + port
+}
+
+// infoKey derives the plusKey from the +INFO line of an attribute block.
+func (a *PlusAttributes) infoKey() string {
+	fields := strings.Split(a.Info, "\t")
+	if len(fields) < 4 {
+		return ""
+	}
+	return plusKey(fields[1], fields[2], fields[3])
+}
+
+// -----------------------------------------------------------
+// plusMenuAttributes lazily collects attributes for the Gopher+
+// items of one menu: a single "$" request for the whole directory,
+// then individual "!" requests for anything it didn't cover.
+// The menu streams while they run, so the "!" requests are few
+// (GOPHERPLUS_MAX_ITEM_FETCH) and share GOPHERPLUS_ITEM_FETCH_TIME;
+// items past either limit are shown without attributes.
+// -----------------------------------------------------------
+type plusMenuAttributes struct {
+	host, port, selector string
+	loaded               bool
+	fetched              int
+	spent                time.Duration
+	byKey                map[string]*PlusAttributes
+}
+
+func newPlusMenuAttributes(host, port, selector string) *plusMenuAttributes {
+	return &plusMenuAttributes{host: host, port: port, selector: selector, byKey: map[string]*PlusAttributes{}}
+}
+
+func (m *plusMenuAttributes) lookup(selector, host, port string) *PlusAttributes {
+	if !m.loaded {
+		m.loaded = true
+		for _, a := range fetchPlusAttributesWithin(GOPHERPLUS_DIR_FETCH_TIME, m.host, m.port, m.selector+GOPHERPLUS_DIR_ATTRIBUTES) {
+			m.byKey[a.infoKey()] = a
+		}
+	}
+
+	key := plusKey(selector, host, port)
+	if a, ok := m.byKey[key]; ok {
+		return a
+	}
+
+	if m.fetched >= GOPHERPLUS_MAX_ITEM_FETCH || m.spent >= GOPHERPLUS_ITEM_FETCH_TIME {
+		return nil
+	}
+	m.fetched++
+
+	start := time.Now()
+	attrs := fetchPlusAttributesWithin(GOPHERPLUS_ITEM_FETCH_TIME-m.spent, host, port, selector+GOPHERPLUS_ITEM_ATTRIBUTES)
+	m.spent += time.Since(start)
+	var found *PlusAttributes
+	if len(attrs) > 0 {
+		found = attrs[0]
+	}
+	m.byKey[key] = found // remember misses too
+	return found
+}
+
+// fetchPlusAttributes requests an attribute listing; errors just mean "no attributes".
+func fetchPlusAttributes(host, port, request string) []*PlusAttributes {
+	raw, err := gopherRequestBytes(host, port, request)
+	if err != nil {
+		return nil
+	}
+	return parsePlusReply(raw)
+}
+
+// parsePlusReply reads the attribute blocks of a reply, if it has any.
+func parsePlusReply(raw []byte) []*PlusAttributes {
+	body, err := stripPlusHeader(raw)
+	if err != nil {
+		return nil
+	}
+	return parsePlusAttributes(string(body))
+}
+
+// -----------------------------------------------------------
+// fetchPlusAttributesWithin(d, host, port, request) -> attrs
+//
+// fetchPlusAttributes for the requests made while a menu
+// streams. The whole reply has to arrive within d and fit in
+// GOPHERPLUS_ATTRIBUTES_SIZE. The connection carries the
+// deadline and is closed when the time runs out, so a server
+// that trickles its reply holds neither the menu nor a socket.
+// -----------------------------------------------------------
+func fetchPlusAttributesWithin(d time.Duration, host, port, request string) []*PlusAttributes {
+	deadline := time.Now().Add(d)
+
+	var mux sync.Mutex
+	var conn *gopherConn
+	expired := false
+
+	done := make(chan []byte, 1)
+	go func() {
+		c, err := openGopher(host, port, request)
+		if err != nil {
+			done <- nil
+			return
+		}
+		defer c.Close()
+
+		mux.Lock()
+		conn = c
+		late := expired
+		mux.Unlock()
+		if late {
+			done <- nil
+			return
+		}
+
+		c.SetDeadline(deadline)
+		raw, err := io.ReadAll(io.LimitReader(c, GOPHERPLUS_ATTRIBUTES_SIZE+1))
+		if err != nil || len(raw) > GOPHERPLUS_ATTRIBUTES_SIZE {
+			raw = nil
+		}
+		done <- raw
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case raw := <-done:
+		return parsePlusReply(raw)
+	case <-timer.C:
+		mux.Lock()
+		expired = true
+		if conn != nil {
+			conn.Close()
+		}
+		mux.Unlock()
+		return nil
+	}
+}
+
+// plusDetailsView is the data for the "plus-details" template.
+type plusDetailsView struct {
+	Abstract string
+	Meta     string // admin contact and modification date
+	Views    []plusViewLink
+}
+
+type plusViewLink struct {
+	Href  string
+	Label string
+	Size  string
+}
+
+// plusDetails prepares the attribute summary shown under a Gopher+ link.
+func plusDetails(a *PlusAttributes, itemType byte, host, port, selector string) *plusDetailsView {
+	if a == nil {
+		return nil
+	}
+
+	view := &plusDetailsView{Abstract: a.Abstract}
+
+	var meta []string
+	if a.Admin != "" {
+		meta = append(meta, "Admin: "+a.Admin)
+	}
+	if a.ModDate != "" {
+		meta = append(meta, "Modified: "+a.ModDate)
+	}
+	view.Meta = strings.Join(meta, "  ")
+
+	rememberPlusViews(a, host, port, selector)
+	for _, v := range a.Views {
+		view.Views = append(view.Views, plusViewLink{
+			Href: fmt.Sprintf("%s?type=%c&host=%s&port=%s&selector=%s&view=%s",
+				VIEW_ENDPOINT, itemType,
+				url.QueryEscape(host), url.QueryEscape(port),
+				url.QueryEscape(selector), url.QueryEscape(v.Spec()),
+			),
+			Label: v.Spec(),
+			Size:  v.Size,
+		})
+	}
+
+	if view.Abstract == "" && view.Meta == "" && len(view.Views) == 0 {
+		return nil
+	}
+	return view
+}
+
+// the views gofer has offered, by item; /view serves nothing else
+var plusViewsMux sync.Mutex
+var plusViewsOffered = map[string]bool{}
+
+// rememberPlusViews records the views an item's +VIEWS block offers.
+func rememberPlusViews(a *PlusAttributes, host, port, selector string) {
+	plusViewsMux.Lock()
+	defer plusViewsMux.Unlock()
+	if len(plusViewsOffered) >= GOPHERPLUS_VIEWS_KEPT {
+		plusViewsOffered = map[string]bool{}
+	}
+	for _, v := range a.Views {
+		plusViewsOffered[plusKey(selector, host, port)+"\t"+v.Spec()] = true
+	}
+}
+
+// -----------------------------------------------------------
+// plusViewOffered(host, port, selector, spec) -> bool
+//
+// Whether the item offers the view in its +VIEWS block. Views
+// gofer listed on a menu are remembered; anything else (a link
+// from before a restart, say) costs a "!" request to check.
+// Without this, the view= parameter would pick the Content-Type
+// of whatever the server sends back, text/html included.
+// -----------------------------------------------------------
+func plusViewOffered(host, port, selector, spec string) bool {
+	key := plusKey(selector, host, port) + "\t" + spec
+	plusViewsMux.Lock()
+	offered := plusViewsOffered[key]
+	plusViewsMux.Unlock()
+	if offered {
+		return true
+	}
+
+	for _, a := range fetchPlusAttributes(host, port, selector+GOPHERPLUS_ITEM_ATTRIBUTES) {
+		rememberPlusViews(a, host, port, selector)
+	}
+	plusViewsMux.Lock()
+	defer plusViewsMux.Unlock()
+	return plusViewsOffered[key]
+}
+
+// --- HTTP Handler ---
+
+// handleView fetches one +VIEWS representation of an item ("selector\t+mime lang").
+func handleView(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	selector := query.Get("selector")
+	view := strings.TrimSpace(query.Get("view"))
+
+	if host == "" || port == "" || view == "" {
+		http.Error(w, "Missing host, port, or view", http.StatusBadRequest)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(strings.Fields(view)[0])
+	if err != nil || !plusViewOffered(host, port, selector, view) {
+		http.Error(w, "The item doesn't offer that view", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := gopherRequestBytes(host, port, selector+"\t+"+view)
+	if err == nil {
+		raw, err = stripPlusHeader(raw)
+	}
+	if servePinWarning(w, r, err) || servePolicyBlock(w, r, err) {
+		return
+	}
+	if err != nil {
+		synthetic := fmt.Sprintf("3View failed: %s\t/\t%s\t%s\n.\n", err.Error(), host, port)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(formatMenuHTML(synthetic, host, port, selector, false)))
+		return
+	}
+
+	switch mediaType {
+	case "application/gopher-menu", "application/gopher+-menu":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(formatMenuHTML(string(raw), host, port, selector, false)))
+	default:
+		if strings.HasPrefix(mediaType, "text/") {
+			mediaType += "; charset=utf-8"
+		}
+		setRemoteHeaders(w, mediaType)
+		w.Write(raw)
+	}
+}
@@ -0,0 +1,155 @@
+// gopherplus tests for gofer 0.9
+// menu attributes: one "$" request, then a few bounded "!" requests
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenLoopback serves each connection on a loopback port, which the
+// policy has to be told to allow, and returns its address.
+func listenLoopback(t *testing.T, serve func(net.Conn)) (string, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowPrivate := policyAllowPrivate
+	policyAllowPrivate = true
+	t.Cleanup(func() {
+		ln.Close()
+		policyAllowPrivate = allowPrivate
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+// listenPlus answers "$" with attributes for the covered selectors and
+// counts the "!" requests, which hang until the test ends if stall is set.
+func listenPlus(t *testing.T, covered []string, stall bool) (string, string, *atomic.Int32) {
+	t.Helper()
+	items := new(atomic.Int32)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	host, port := listenLoopback(t, func(c net.Conn) {
+		defer c.Close()
+		request, _ := bufio.NewReader(c).ReadString('\n')
+		switch {
+		case strings.HasSuffix(request, GOPHERPLUS_DIR_ATTRIBUTES+"\r\n"):
+			host, port, _ := net.SplitHostPort(c.LocalAddr().String())
+			reply := "+-2\r\n"
+			for _, sel := range covered {
+				reply += "+INFO: 0Doc\t" + sel + "\t" + host + "\t" + port + "\t+\r\n+ABSTRACT:\r\n About " + sel + "\r\n"
+			}
+			c.Write([]byte(reply))
+		case strings.HasSuffix(request, GOPHERPLUS_ITEM_ATTRIBUTES+"\r\n"):
+			items.Add(1)
+			if stall {
+				<-release
+			}
+			c.Write([]byte("--1\r\n1 Item is not available.\r\n"))
+		}
+	})
+	return host, port, items
+}
+
+func TestPlusMenuAttributesDirectory(t *testing.T) {
+	host, port, items := listenPlus(t, []string{"/a", "/b", "/c"}, false)
+	m := newPlusMenuAttributes(host, port, "/")
+
+	for _, sel := range []string{"/a", "/b", "/c"} {
+		if a := m.lookup(sel, host, port); a == nil || a.Abstract != "About "+sel {
+			t.Errorf("%s: attributes %+v", sel, a)
+		}
+	}
+	if n := items.Load(); n != 0 {
+		t.Errorf("%d \"!\" requests for items the \"$\" reply covered", n)
+	}
+}
+
+func TestPlusMenuAttributesItemLimit(t *testing.T) {
+	host, port, items := listenPlus(t, nil, false)
+	m := newPlusMenuAttributes(host, port, "/")
+
+	for _, sel := range []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8"} {
+		if a := m.lookup(sel, host, port); a != nil {
+			t.Errorf("%s: attributes %+v from an error reply", sel, a)
+		}
+	}
+	m.lookup("/1", host, port) // misses are remembered
+	if n := items.Load(); n != GOPHERPLUS_MAX_ITEM_FETCH {
+		t.Errorf("%d \"!\" requests, want %d", n, GOPHERPLUS_MAX_ITEM_FETCH)
+	}
+}
+
+func TestPlusMenuAttributesTimeLimit(t *testing.T) {
+	host, port, items := listenPlus(t, nil, true)
+	m := newPlusMenuAttributes(host, port, "/")
+
+	start := time.Now()
+	for _, sel := range []string{"/1", "/2", "/3"} {
+		m.lookup(sel, host, port)
+	}
+	if elapsed := time.Since(start); elapsed > GOPHERPLUS_ITEM_FETCH_TIME+time.Second {
+		t.Errorf("stalled server held the menu for %s", elapsed)
+	}
+	if n := items.Load(); n != 1 {
+		t.Errorf("%d \"!\" requests after the time ran out, want 1", n)
+	}
+}
+
+func TestPlusMenuAttributesTrickle(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	t.Cleanup(func() { close(release) })
+
+	host, port := listenLoopback(t, func(c net.Conn) {
+		defer c.Close()
+		bufio.NewReader(c).ReadString('\n')
+		c.Write([]byte("+-2\r\n"))
+		for {
+			select {
+			case <-release:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if _, err := c.Write([]byte(" ")); err != nil {
+				closeOnce.Do(func() { close(closed) })
+				return
+			}
+		}
+	})
+	m := newPlusMenuAttributes(host, port, "/")
+
+	start := time.Now()
+	m.lookup("/doc", host, port)
+	if elapsed := time.Since(start); elapsed > GOPHERPLUS_DIR_FETCH_TIME+GOPHERPLUS_ITEM_FETCH_TIME+time.Second {
+		t.Errorf("a trickling \"$\" reply held the menu for %s", elapsed)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the trickling connection was left open")
+	}
+}
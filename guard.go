@@ -0,0 +1,187 @@
+// local server guard for gofer 0.9
+// keeps web pages from driving gofer: loopback binding, Host header checks,
+// same-origin enforcement, and a per-session token for state-changing requests
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SESSION_TOKEN_FILE   = "session.token"
+	SESSION_TOKEN_FIELD  = "token"         // hidden form field on every POST form
+	SESSION_TOKEN_HEADER = "X-Gofer-Token" // used by a second instance calling /focus
+)
+
+// allowLAN serves gofer on every interface instead of loopback only (-lan).
+var allowLAN bool
+
+// sessionToken is generated by the primary instance at startup.
+var sessionToken string
+
+// localListenAddress is where the primary instance binds.
+func localListenAddress() string {
+	if allowLAN {
+		return ":" + LOCAL_SERVER_PORT
+	}
+	return net.JoinHostPort("127.0.0.1", LOCAL_SERVER_PORT)
+}
+
+// -----------------------------------------------------------
+// guardLocal(next) -> handler
+//
+// Wraps every route. A page on another site can still make the
+// browser send requests to localhost, so gofer refuses Host headers
+// that aren't its own (DNS rebinding), requests the browser marks as
+// coming from another origin, and POSTs or /focus calls without the
+// session token.
+// -----------------------------------------------------------
+func guardLocal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host) {
+			http.Error(w, "Unrecognized Host header", http.StatusForbidden)
+			return
+		}
+		if crossOrigin(r) {
+			http.Error(w, "Cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		if needsToken(r) && !validToken(r) {
+			http.Error(w, "Missing or invalid session token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost accepts loopback names on gofer's port; with -lan, any IP
+// literal or this machine's own name as well. Other names are refused,
+// since a rebound DNS name is how a remote page would reach us.
+func allowedHost(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	if port != "" && port != LOCAL_SERVER_PORT {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	if !allowLAN {
+		return false
+	}
+	if ip != nil {
+		return true
+	}
+	if name, err := os.Hostname(); err == nil {
+		name = strings.ToLower(name)
+		return host == name || host == name+".local"
+	}
+	return false
+}
+
+// crossOrigin reports whether the browser says the request came from another site.
+// Requests without these headers (typed URLs, the second instance) are allowed.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
+
+// needsToken lists the requests that change state or start processes.
+// WebSocket handshakes count, since they open a live connection.
+func needsToken(r *http.Request) bool {
+	if r.URL.Path == FOCUS_ENDPOINT || headerHasToken(r.Header, "Connection", "upgrade") {
+		return true
+	}
+	return r.Method != http.MethodGet && r.Method != http.MethodHead
+}
+
+// validToken checks the token header or form field against this session's token.
+func validToken(r *http.Request) bool {
+	token := r.Header.Get(SESSION_TOKEN_HEADER)
+	if token == "" && r.Method == http.MethodGet {
+		token = r.URL.Query().Get(SESSION_TOKEN_FIELD) // browsers can't set headers on a WebSocket
+	}
+	if token == "" {
+		token = r.PostFormValue(SESSION_TOKEN_FIELD)
+	}
+	if sessionToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(sessionToken)) == 1
+}
+
+// --- Session Token ---
+// the primary instance writes the token to the user config directory, so a
+// second instance started by the OS can authenticate its /focus request
+
+func sessionTokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofer", SESSION_TOKEN_FILE), nil
+}
+
+// startSession generates a fresh token and saves it for later instances.
+func startSession() error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("failed to generate session token: %w", err)
+	}
+	sessionToken = hex.EncodeToString(buf)
+
+	path, err := sessionTokenPath()
+	if err != nil {
+		return fmt.Errorf("no config directory for the session token: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create session token directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(sessionToken), 0o600); err != nil {
+		return fmt.Errorf("failed to write session token: %w", err)
+	}
+	return nil
+}
+
+// readSessionToken returns the token saved by the running primary instance.
+func readSessionToken() (string, error) {
+	path, err := sessionTokenPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,106 @@
+// local server guard tests for gofer 0.9
+// foreign Host headers, cross-site requests, and missing session tokens
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host string
+		lan  bool
+		want bool
+	}{
+		{"localhost:8000", false, true},
+		{"LOCALHOST.:8000", false, true},
+		{"127.0.0.1:8000", false, true},
+		{"[::1]:8000", false, true},
+		{"localhost", false, true},
+		{"localhost:8001", false, false},
+		{"evil.example:8000", false, false}, // a rebound DNS name
+		{"localhost.evil.example:8000", false, false},
+		{"192.168.1.20:8000", false, false},
+		{"192.168.1.20:8000", true, true},
+		{"evil.example:8000", true, false},
+	}
+
+	defer func(lan bool) { allowLAN = lan }(allowLAN)
+	for _, tt := range tests {
+		allowLAN = tt.lan
+		if got := allowedHost(tt.host); got != tt.want {
+			t.Errorf("allowedHost(%q) with -lan=%v = %v, want %v", tt.host, tt.lan, got, tt.want)
+		}
+	}
+}
+
+func TestGuardLocal(t *testing.T) {
+	defer func(token string) { sessionToken = token }(sessionToken)
+	sessionToken = "secret"
+
+	reached := false
+	guarded := guardLocal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	form := func(values url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	upgrade := func(target string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		host   string
+		header map[string]string
+		want   bool
+	}{
+		{name: "menu page", req: httptest.NewRequest(http.MethodGet, "/", nil), want: true},
+		{name: "foreign Host header", req: httptest.NewRequest(http.MethodGet, "/", nil), host: "rebound.example:8000"},
+		{name: "cross-site fetch", req: httptest.NewRequest(http.MethodGet, "/", nil), header: map[string]string{"Sec-Fetch-Site": "cross-site"}},
+		{name: "same-site fetch", req: httptest.NewRequest(http.MethodGet, "/", nil), header: map[string]string{"Sec-Fetch-Site": "same-site"}},
+		{name: "same-origin fetch", req: httptest.NewRequest(http.MethodGet, "/", nil), header: map[string]string{"Sec-Fetch-Site": "same-origin"}, want: true},
+		{name: "foreign Origin", req: form(url.Values{"token": {"secret"}}), header: map[string]string{"Origin": "http://evil.example"}},
+		{name: "POST without token", req: form(url.Values{"q0": {"x"}})},
+		{name: "POST with wrong token", req: form(url.Values{"token": {"guess"}})},
+		{name: "POST with token", req: form(url.Values{"token": {"secret"}}), want: true},
+		{name: "focus without token", req: httptest.NewRequest(http.MethodGet, FOCUS_ENDPOINT+"?uri=gopher://example.org", nil)},
+		{name: "focus with token", req: httptest.NewRequest(http.MethodGet, FOCUS_ENDPOINT, nil), header: map[string]string{SESSION_TOKEN_HEADER: "secret"}, want: true},
+		{name: "WebSocket without token", req: upgrade("/telnet/ws?host=example.org&port=23")},
+		{name: "WebSocket with token", req: upgrade("/telnet/ws?host=example.org&port=23&token=secret"), want: true},
+	}
+
+	for _, tt := range tests {
+		tt.req.Host = "localhost:" + LOCAL_SERVER_PORT
+		if tt.host != "" {
+			tt.req.Host = tt.host
+		}
+		for k, v := range tt.header {
+			tt.req.Header.Set(k, v)
+		}
+
+		reached = false
+		rec := httptest.NewRecorder()
+		guarded.ServeHTTP(rec, tt.req)
+		if reached != tt.want {
+			t.Errorf("%s: reached the handler = %v, want %v", tt.name, reached, tt.want)
+		}
+		if !tt.want && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", tt.name, rec.Code)
+		}
+	}
+}
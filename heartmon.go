@@ -9,7 +9,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 )
 
@@ -24,56 +23,7 @@ func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 func serveHeartMon(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	fmt.Fprintf(w, `
-<!DOCTYPE html>
-<html>
-<head>
-	<title>gofer — running</title>
-	<style>
-		body {
-			font-family: monospace;
-			text-align: center;
-			margin-top: 2em;
-		}
-		button {
-			margin-top: 1em;
-			font-family: monospace;
-			cursor: pointer;
-		}
-	</style>
-</head>
-<body>
-
-	<p>close this tab or window to exit gofer</p>
-
-	<button onclick="popout()">pop out</button>
-
-	<script>
-		function ping() {
-			fetch('http://localhost:%s/heartbeat')
-				.catch(() => {
-					window.close();
-				});
-		}
-
-		function popout() {
-			const w = window.open(
-				"/heartmon",
-				"gofer-heartmon",
-				"width=240,height=240,resizable=yes"
-			);
-
-			// If popup succeeded, close this tab
-			if (w) {
-				window.close();
-			}
-		}
-
-		ping();
-		setInterval(ping, 30000);
-	</script>
-
-</body>
-</html>
-`, LOCAL_SERVER_PORT)
+	renderTemplate(w, "heartmon", struct{ HeartbeatURL string }{
+		HeartbeatURL: "/heartbeat", // same origin, whichever loopback name the page was opened with
+	})
 }
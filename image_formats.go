@@ -0,0 +1,1092 @@
+// legacy image decoders for gofer 0.9
+// PCX, XBM, XPM, TIFF, BMP, and IFF/ILBM, which browsers can't show
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const IMAGE_MAX_PIXELS = 1 << 24 // largest image gofer will convert
+
+// checkImageSize rejects empty images and ones too large to hold in memory.
+func checkImageSize(w, h int) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("bad image size %dx%d", w, h)
+	}
+	if w > IMAGE_MAX_PIXELS/h {
+		return fmt.Errorf("image is too large to convert (%dx%d)", w, h)
+	}
+	return nil
+}
+
+var errImageTruncated = errors.New("image data is truncated")
+
+// unpackBits expands PackBits (TIFF) or ByteRun1 (IFF) data until n bytes
+// are out or the input ends.
+func unpackBits(in []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in) && len(out) < n; {
+		c := int8(in[i])
+		i++
+		switch {
+		case c >= 0:
+			end := min(i+int(c)+1, len(in))
+			out = append(out, in[i:end]...)
+			i = end
+		case c != -128:
+			if i < len(in) {
+				for k := 0; k < 1-int(c); k++ {
+					out = append(out, in[i])
+				}
+				i++
+			}
+		}
+	}
+	return out
+}
+
+// --- PCX ---
+
+// the 16 EGA colors, for PCX files whose header palette is empty
+var egaPalette = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, color.RGBA{0x00, 0x00, 0xAA, 0xFF},
+	color.RGBA{0x00, 0xAA, 0x00, 0xFF}, color.RGBA{0x00, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x00, 0x00, 0xFF}, color.RGBA{0xAA, 0x00, 0xAA, 0xFF},
+	color.RGBA{0xAA, 0x55, 0x00, 0xFF}, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF},
+	color.RGBA{0x55, 0x55, 0x55, 0xFF}, color.RGBA{0x55, 0x55, 0xFF, 0xFF},
+	color.RGBA{0x55, 0xFF, 0x55, 0xFF}, color.RGBA{0x55, 0xFF, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0x55, 0x55, 0xFF}, color.RGBA{0xFF, 0x55, 0xFF, 0xFF},
+	color.RGBA{0xFF, 0xFF, 0x55, 0xFF}, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+func isPCX(b []byte) bool {
+	return len(b) >= 128 && b[0] == 0x0A && b[1] <= 5 && b[2] <= 1 &&
+		(b[3] == 1 || b[3] == 2 || b[3] == 4 || b[3] == 8)
+}
+
+// -----------------------------------------------------------
+// decodePCX(data) -> image, details, error
+//
+// A 128-byte header, then run-length encoded scanlines: a
+// byte with the top two bits set repeats the next byte. Each
+// scanline holds every plane in turn. Images of up to 16
+// colors carry their palette in the header; 256-color images
+// keep it in the last 769 bytes, after a 0x0C marker.
+// -----------------------------------------------------------
+func decodePCX(data []byte) (image.Image, string, error) {
+	bpp := int(data[3])
+	w := int(binary.LittleEndian.Uint16(data[8:])) - int(binary.LittleEndian.Uint16(data[4:])) + 1
+	h := int(binary.LittleEndian.Uint16(data[10:])) - int(binary.LittleEndian.Uint16(data[6:])) + 1
+	planes := int(data[65])
+	bpl := int(binary.LittleEndian.Uint16(data[66:]))
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+	if planes < 1 || planes > 4 || bpl < (w*bpp+7)/8 {
+		return nil, "", errors.New("bad PCX header")
+	}
+
+	// the scanlines, decoded
+	size := h * planes * bpl
+	raw := make([]byte, 0, size)
+	body := data[128:]
+	for i := 0; i < len(body) && len(raw) < size; i++ {
+		c := body[i]
+		if c&0xC0 == 0xC0 && data[2] == 1 {
+			i++
+			if i == len(body) {
+				break
+			}
+			for k := 0; k < int(c&0x3F); k++ {
+				raw = append(raw, body[i])
+			}
+		} else {
+			raw = append(raw, c)
+		}
+	}
+	if len(raw) < size {
+		return nil, "", errImageTruncated
+	}
+	raw = raw[:size]
+	details := fmt.Sprintf("version %d, %d bit(s) per pixel, %d plane(s)", data[1], bpp, planes)
+
+	// 24-bit: red, green, and blue planes
+	if bpp == 8 && planes >= 3 {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			line := raw[y*planes*bpl:]
+			for x := 0; x < w; x++ {
+				a := byte(0xFF)
+				if planes == 4 {
+					a = line[3*bpl+x]
+				}
+				img.SetNRGBA(x, y, color.NRGBA{line[x], line[bpl+x], line[2*bpl+x], a})
+			}
+		}
+		return img, details, nil
+	}
+
+	// everything else is indexed
+	var palette color.Palette
+	switch {
+	case bpp == 8 && len(data) >= 128+769 && data[len(data)-769] == 0x0C:
+		pal := data[len(data)-768:]
+		for i := 0; i < 256; i++ {
+			palette = append(palette, color.RGBA{pal[3*i], pal[3*i+1], pal[3*i+2], 0xFF})
+		}
+	case bpp == 8:
+		for i := 0; i < 256; i++ {
+			palette = append(palette, color.Gray{uint8(i)})
+		}
+	case bpp == 1 && planes == 1:
+		palette = color.Palette{color.Black, color.White}
+	default:
+		header := data[16:64]
+		if bytes.Count(header, []byte{0}) == len(header) || data[1] == 3 {
+			palette = egaPalette
+		} else {
+			for i := 0; i < 16; i++ {
+				palette = append(palette, color.RGBA{header[3*i], header[3*i+1], header[3*i+2], 0xFF})
+			}
+		}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	for y := 0; y < h; y++ {
+		line := raw[y*planes*bpl:]
+		for x := 0; x < w; x++ {
+			index := 0
+			for p := 0; p < planes; p++ {
+				index |= int(bitsAt(line[p*bpl:], x, bpp)) << (p * bpp)
+			}
+			img.SetColorIndex(x, y, uint8(index%len(palette)))
+		}
+	}
+	return img, details, nil
+}
+
+// bitsAt reads pixel x from a packed scanline, most significant bits first.
+func bitsAt(line []byte, x int, bpp int) byte {
+	bit := x * bpp
+	v := line[bit/8] >> (8 - bpp - bit%8)
+	return v & (1<<bpp - 1)
+}
+
+// --- XBM ---
+
+var (
+	xbmDefine = regexp.MustCompile(`#define\s+\S*?(width|height)\s+(\d+)`)
+	xbmNumber = regexp.MustCompile(`0[xX][0-9a-fA-F]+|\d+`)
+)
+
+func isXBM(b []byte) bool {
+	head := b[:min(len(b), 512)]
+	return bytes.Contains(head, []byte("#define")) && bytes.Contains(head, []byte("_width")) && bytes.Contains(b, []byte("{"))
+}
+
+// decodeXBM reads an X bitmap: C source with the size in #defines and the
+// bits in an array, least significant bit first, 1 for black. X10 bitmaps
+// use an array of shorts instead of chars.
+func decodeXBM(data []byte) (image.Image, string, error) {
+	var w, h int
+	for _, m := range xbmDefine.FindAllSubmatch(data, -1) {
+		n, _ := strconv.Atoi(string(m[2]))
+		if string(m[1]) == "width" {
+			w = n
+		} else {
+			h = n
+		}
+	}
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+
+	open := bytes.IndexByte(data, '{')
+	end := bytes.IndexByte(data[open:], '}')
+	if end < 0 {
+		return nil, "", errImageTruncated
+	}
+	unit, version := 8, "X11"
+	if bytes.Contains(data[:open], []byte("short")) {
+		unit, version = 16, "X10"
+	}
+
+	var bits []uint16
+	for _, n := range xbmNumber.FindAll(data[open:open+end], -1) {
+		v, err := strconv.ParseUint(string(n), 0, 16)
+		if err != nil {
+			return nil, "", fmt.Errorf("bad XBM value %q", n)
+		}
+		bits = append(bits, uint16(v))
+	}
+	perRow := (w + unit - 1) / unit
+	if len(bits) < perRow*h {
+		return nil, "", errImageTruncated
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.White, color.Black})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetColorIndex(x, y, uint8(bits[y*perRow+x/unit]>>(x%unit)&1))
+		}
+	}
+	return img, version + " bitmap", nil
+}
+
+// --- XPM ---
+
+// the X11 color names XPM files use most; grayN and greyN are worked out
+var xpmColorNames = map[string]color.NRGBA{
+	"black": {0, 0, 0, 255}, "white": {255, 255, 255, 255}, "red": {255, 0, 0, 255},
+	"green": {0, 255, 0, 255}, "blue": {0, 0, 255, 255}, "yellow": {255, 255, 0, 255},
+	"cyan": {0, 255, 255, 255}, "magenta": {255, 0, 255, 255}, "gray": {190, 190, 190, 255},
+	"grey": {190, 190, 190, 255}, "lightgray": {211, 211, 211, 255}, "lightgrey": {211, 211, 211, 255},
+	"darkgray": {169, 169, 169, 255}, "darkgrey": {169, 169, 169, 255}, "dimgray": {105, 105, 105, 255},
+	"orange": {255, 165, 0, 255}, "brown": {165, 42, 42, 255}, "purple": {160, 32, 240, 255},
+	"pink": {255, 192, 203, 255}, "navy": {0, 0, 128, 255}, "navyblue": {0, 0, 128, 255},
+	"maroon": {176, 48, 96, 255}, "darkgreen": {0, 100, 0, 255}, "darkblue": {0, 0, 139, 255},
+	"darkred": {139, 0, 0, 255}, "gold": {255, 215, 0, 255}, "violet": {238, 130, 238, 255},
+	"tan": {210, 180, 140, 255}, "khaki": {240, 230, 140, 255}, "salmon": {250, 128, 114, 255},
+	"lightblue": {173, 216, 230, 255}, "skyblue": {135, 206, 235, 255}, "steelblue": {70, 130, 180, 255},
+	"forestgreen": {34, 139, 34, 255}, "seagreen": {46, 139, 87, 255}, "wheat": {245, 222, 179, 255},
+	"beige": {245, 245, 220, 255}, "ivory": {255, 255, 240, 255}, "gray50": {127, 127, 127, 255},
+}
+
+var xpmKeys = map[string]bool{"c": true, "m": true, "g": true, "g4": true, "s": true}
+
+func isXPM(b []byte) bool {
+	head := b[:min(len(b), 64)]
+	return bytes.Contains(head, []byte("/* XPM */")) || bytes.HasPrefix(head, []byte("! XPM2"))
+}
+
+// xpmStrings returns the quoted strings of an XPM3 file, or the lines of an XPM2 file.
+func xpmStrings(data []byte) []string {
+	if bytes.HasPrefix(data, []byte("! XPM2")) {
+		lines := strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n")
+		return lines[1:]
+	}
+
+	var out []string
+	s := string(data)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case s[i] == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return out
+			}
+			out = append(out, s[i+1:i+1+end])
+			i += end + 1
+		}
+	}
+	return out
+}
+
+// -----------------------------------------------------------
+// decodeXPM(data) -> image, details, error
+//
+// The first string is "width height colors chars-per-pixel".
+// Each color string is the pixel's characters followed by
+// key and value pairs: c for color, g and g4 for grayscale,
+// m for mono. Values can be #RGB-style, None (transparent),
+// or an X11 name of one or more words.
+// -----------------------------------------------------------
+func decodeXPM(data []byte) (image.Image, string, error) {
+	strs := xpmStrings(data)
+	if len(strs) == 0 {
+		return nil, "", errors.New("no XPM values found")
+	}
+	var w, h, ncolors, cpp int
+	if n, _ := fmt.Sscan(strs[0], &w, &h, &ncolors, &cpp); n < 4 || cpp < 1 || ncolors < 1 {
+		return nil, "", errors.New("bad XPM header")
+	}
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+	if len(strs) < 1+ncolors+h {
+		return nil, "", errImageTruncated
+	}
+
+	colors := make(map[string]color.NRGBA, ncolors)
+	for _, line := range strs[1 : 1+ncolors] {
+		if len(line) < cpp {
+			return nil, "", errors.New("bad XPM color line")
+		}
+		colors[line[:cpp]] = xpmColor(strings.Fields(line[cpp:]))
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y, row := range strs[1+ncolors : 1+ncolors+h] {
+		if len(row) < w*cpp {
+			return nil, "", errImageTruncated
+		}
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, colors[row[x*cpp:(x+1)*cpp]])
+		}
+	}
+
+	version := "XPM3"
+	if bytes.HasPrefix(data, []byte("! XPM2")) {
+		version = "XPM2"
+	}
+	return img, fmt.Sprintf("%s, %d colors", version, ncolors), nil
+}
+
+// xpmColor picks the color, grayscale, or mono value from a color line.
+func xpmColor(fields []string) color.NRGBA {
+	values := map[string]string{}
+	key := ""
+	for _, f := range fields {
+		if xpmKeys[f] {
+			key = f
+			continue
+		}
+		if key != "" {
+			values[key] = strings.TrimSpace(values[key] + " " + f)
+		}
+	}
+	for _, k := range []string{"c", "g", "g4", "m"} {
+		if v, ok := values[k]; ok {
+			return parseXColor(v)
+		}
+	}
+	return color.NRGBA{}
+}
+
+// parseXColor reads #RGB, #RRGGBB, #RRRRGGGGBBBB, None, or a color name.
+func parseXColor(v string) color.NRGBA {
+	if strings.EqualFold(v, "none") {
+		return color.NRGBA{}
+	}
+	if hex, ok := strings.CutPrefix(v, "#"); ok && len(hex)%3 == 0 && len(hex) > 0 {
+		n := len(hex) / 3
+		channel := func(i int) uint8 {
+			c, _ := strconv.ParseUint(hex[i*n:(i+1)*n], 16, 64)
+			switch {
+			case n == 1:
+				return uint8(c * 17)
+			case n > 2:
+				return uint8(c >> (4 * (n - 2)))
+			}
+			return uint8(c)
+		}
+		return color.NRGBA{channel(0), channel(1), channel(2), 255}
+	}
+
+	name := strings.ToLower(strings.ReplaceAll(v, " ", ""))
+	if c, ok := xpmColorNames[name]; ok {
+		return c
+	}
+	for _, prefix := range []string{"gray", "grey"} {
+		if pct, err := strconv.Atoi(strings.TrimPrefix(name, prefix)); err == nil && strings.HasPrefix(name, prefix) && pct >= 0 && pct <= 100 {
+			g := uint8(pct * 255 / 100)
+			return color.NRGBA{g, g, g, 255}
+		}
+	}
+	return color.NRGBA{128, 128, 128, 255} // unknown names show as gray rather than failing
+}
+
+// --- TIFF ---
+
+const (
+	TIFF_WIDTH          = 256
+	TIFF_HEIGHT         = 257
+	TIFF_BITS           = 258
+	TIFF_COMPRESSION    = 259
+	TIFF_PHOTOMETRIC    = 262
+	TIFF_STRIP_OFFSETS  = 273
+	TIFF_SAMPLES        = 277
+	TIFF_ROWS_PER_STRIP = 278
+	TIFF_STRIP_COUNTS   = 279
+	TIFF_PLANAR         = 284
+	TIFF_PREDICTOR      = 317
+	TIFF_COLORMAP       = 320
+	TIFF_TILE_WIDTH     = 322
+)
+
+func isTIFF(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*"))
+}
+
+// -----------------------------------------------------------
+// decodeTIFF(data) -> image, details, error
+//
+// Reads the first image of a baseline TIFF: bilevel, gray,
+// palette, or RGB(A) in strips, uncompressed, PackBits, or
+// LZW, with or without the horizontal predictor. Tiles,
+// separate planes, and fax or JPEG compression are refused.
+// -----------------------------------------------------------
+func decodeTIFF(data []byte) (image.Image, string, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if len(data) < 8 {
+		return nil, "", errImageTruncated
+	}
+
+	ifd := int(order.Uint32(data[4:]))
+	if ifd+2 > len(data) || ifd < 8 {
+		return nil, "", errImageTruncated
+	}
+	tags := map[uint16][]uint32{}
+	count := int(order.Uint16(data[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(data) {
+			return nil, "", errImageTruncated
+		}
+		tag, typ, n := order.Uint16(data[e:]), order.Uint16(data[e+2:]), int(order.Uint32(data[e+4:]))
+		size := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4}[typ]
+		if size == 0 || n <= 0 || n > len(data) {
+			continue // rationals and the like aren't needed
+		}
+		at := e + 8
+		if size*n > 4 {
+			at = int(order.Uint32(data[e+8:]))
+			if at < 0 || at+size*n > len(data) {
+				return nil, "", errImageTruncated
+			}
+		}
+		values := make([]uint32, n)
+		for k := range values {
+			switch size {
+			case 1:
+				values[k] = uint32(data[at+k])
+			case 2:
+				values[k] = uint32(order.Uint16(data[at+2*k:]))
+			case 4:
+				values[k] = order.Uint32(data[at+4*k:])
+			}
+		}
+		tags[tag] = values
+	}
+	tagValue := func(tag uint16, def uint32) uint32 {
+		if v := tags[tag]; len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+
+	w, h := int(tagValue(TIFF_WIDTH, 0)), int(tagValue(TIFF_HEIGHT, 0))
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+	bps := int(tagValue(TIFF_BITS, 1))
+	spp := int(tagValue(TIFF_SAMPLES, 1))
+	compression := tagValue(TIFF_COMPRESSION, 1)
+	photometric := tagValue(TIFF_PHOTOMETRIC, 1)
+	predictor := tagValue(TIFF_PREDICTOR, 1)
+	rowsPerStrip := int(tagValue(TIFF_ROWS_PER_STRIP, uint32(h)))
+
+	switch {
+	case tags[TIFF_TILE_WIDTH] != nil:
+		return nil, "", errors.New("tiled TIFF files aren't supported")
+	case tagValue(TIFF_PLANAR, 1) != 1:
+		return nil, "", errors.New("TIFF files with separate color planes aren't supported")
+	case bps != 1 && bps != 2 && bps != 4 && bps != 8 && bps != 16:
+		return nil, "", fmt.Errorf("TIFF files with %d bits per sample aren't supported", bps)
+	case spp < 1 || spp > 4:
+		return nil, "", fmt.Errorf("TIFF files with %d samples per pixel aren't supported", spp)
+	case predictor == 2 && bps != 8:
+		return nil, "", errors.New("the TIFF predictor is only supported for 8-bit samples")
+	}
+	if rowsPerStrip <= 0 || rowsPerStrip > h {
+		rowsPerStrip = h
+	}
+
+	// the strips, decompressed into one buffer of rows
+	rowBytes := (w*spp*bps + 7) / 8
+	offsets, counts := tags[TIFF_STRIP_OFFSETS], tags[TIFF_STRIP_COUNTS]
+	if len(offsets) == 0 || len(counts) < len(offsets) {
+		return nil, "", errors.New("TIFF file has no image strips")
+	}
+	var pixels []byte
+	for i, off := range offsets {
+		start, end := int(off), int(off)+int(counts[i])
+		if start < 0 || end > len(data) || start > end {
+			return nil, "", errImageTruncated
+		}
+		want := min(rowsPerStrip, h-i*rowsPerStrip) * rowBytes
+		strip := data[start:end]
+		switch compression {
+		case 1:
+		case 5:
+			var err error
+			if strip, err = decodeTIFFLZW(strip, want); err != nil {
+				return nil, "", err
+			}
+		case 32773:
+			strip = unpackBits(strip, want)
+		default:
+			return nil, "", fmt.Errorf("TIFF compression %d isn't supported", compression)
+		}
+		pixels = append(pixels, strip[:min(want, len(strip))]...)
+	}
+	if len(pixels) < h*rowBytes {
+		return nil, "", errImageTruncated
+	}
+
+	if predictor == 2 {
+		for y := 0; y < h; y++ {
+			row := pixels[y*rowBytes : (y+1)*rowBytes]
+			for i := spp; i < len(row); i++ {
+				row[i] += row[i-spp]
+			}
+		}
+	}
+
+	// sample reads sample s of pixel x in row y, scaled to 8 bits
+	sample := func(x, y, s int) uint8 {
+		row := pixels[y*rowBytes:]
+		i := x*spp + s
+		switch bps {
+		case 8:
+			return row[i]
+		case 16:
+			return uint8(order.Uint16(row[2*i:]) >> 8)
+		}
+		return bitsAt(row, i, bps) * uint8(255/(1<<bps-1))
+	}
+
+	details := fmt.Sprintf("%d bit(s) per sample, %d sample(s) per pixel, %s",
+		bps, spp, map[uint32]string{1: "uncompressed", 5: "LZW", 32773: "PackBits"}[compression])
+
+	switch {
+	case photometric == 3 && spp == 1 && bps <= 8:
+		cmap := tags[TIFF_COLORMAP]
+		n := 1 << bps
+		if len(cmap) < 3*n {
+			return nil, "", errors.New("TIFF palette is missing")
+		}
+		palette := make(color.Palette, n)
+		for i := range palette {
+			palette[i] = color.RGBA{uint8(cmap[i] >> 8), uint8(cmap[n+i] >> 8), uint8(cmap[2*n+i] >> 8), 0xFF}
+		}
+		img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				row := pixels[y*rowBytes:]
+				if bps == 8 {
+					img.SetColorIndex(x, y, row[x])
+				} else {
+					img.SetColorIndex(x, y, bitsAt(row, x, bps))
+				}
+			}
+		}
+		return img, "palette, " + details, nil
+
+	case (photometric == 0 || photometric == 1) && spp <= 2:
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v := sample(x, y, 0)
+				if photometric == 0 {
+					v = 255 - v // white is zero
+				}
+				a := uint8(255)
+				if spp == 2 {
+					a = sample(x, y, 1)
+				}
+				img.SetNRGBA(x, y, color.NRGBA{v, v, v, a})
+			}
+		}
+		return img, "grayscale, " + details, nil
+
+	case photometric == 2 && spp >= 3:
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				a := uint8(255)
+				if spp == 4 {
+					a = sample(x, y, 3)
+				}
+				img.SetNRGBA(x, y, color.NRGBA{sample(x, y, 0), sample(x, y, 1), sample(x, y, 2), a})
+			}
+		}
+		return img, "RGB, " + details, nil
+	}
+	return nil, "", fmt.Errorf("TIFF photometric interpretation %d isn't supported", photometric)
+}
+
+// -----------------------------------------------------------
+// decodeTIFFLZW(in, want) -> bytes, error
+//
+// TIFF's LZW: codes most significant bit first, 9 to 12 bits
+// wide, 256 to clear the table and 257 to end, and the code
+// width grows one code early ("early change").
+// -----------------------------------------------------------
+func decodeTIFFLZW(in []byte, want int) ([]byte, error) {
+	const clear, eoi, first = 256, 257, 258
+
+	table := make([][]byte, 4096)
+	for i := 0; i < 256; i++ {
+		table[i] = []byte{byte(i)}
+	}
+	out := make([]byte, 0, want)
+	width, free := 9, first
+	var prev []byte
+	bitPos := 0
+
+	for bitPos+width <= len(in)*8 && len(out) < want {
+		code := 0
+		for i := 0; i < width; i++ {
+			p := bitPos + i
+			code = code<<1 | int(in[p>>3]>>(7-p&7)&1)
+		}
+		bitPos += width
+
+		switch {
+		case code == eoi:
+			return out, nil
+		case code == clear:
+			width, free, prev = 9, first, nil
+			continue
+		}
+
+		var entry []byte
+		switch {
+		case code < free && table[code] != nil:
+			entry = table[code]
+		case code == free && prev != nil:
+			entry = append(append([]byte{}, prev...), prev[0])
+		default:
+			return nil, errors.New("TIFF LZW data is corrupt")
+		}
+		out = append(out, entry...)
+
+		if prev != nil && free < len(table) {
+			table[free] = append(append([]byte{}, prev...), entry[0])
+			free++
+		}
+		prev = entry
+		if free >= 1<<width-1 && width < 12 {
+			width++
+		}
+	}
+	return out, nil
+}
+
+// --- BMP ---
+
+func isBMP(b []byte) bool {
+	return len(b) >= 26 && b[0] == 'B' && b[1] == 'M'
+}
+
+// -----------------------------------------------------------
+// decodeBMP(data) -> image, details, error
+//
+// Windows and OS/2 bitmaps: 1, 4, and 8 bits per pixel with a
+// palette (uncompressed or RLE), and 16, 24, and 32 bits per
+// pixel (with or without bitfield masks). Rows are stored
+// bottom up unless the height is negative, each padded to
+// four bytes.
+// -----------------------------------------------------------
+func decodeBMP(data []byte) (image.Image, string, error) {
+	le := binary.LittleEndian
+	pixelOffset := int(le.Uint32(data[10:]))
+	headerSize := int(le.Uint32(data[14:]))
+	if 14+headerSize > len(data) || headerSize < 12 {
+		return nil, "", errImageTruncated
+	}
+
+	var w, h, bpp, compression int
+	paletteEntry := 4
+	version := "Windows"
+	if headerSize == 12 {
+		w, h = int(le.Uint16(data[18:])), int(le.Uint16(data[20:]))
+		bpp = int(le.Uint16(data[24:]))
+		paletteEntry, version = 3, "OS/2"
+	} else {
+		if headerSize < 40 {
+			return nil, "", errors.New("bad BMP header")
+		}
+		w, h = int(int32(le.Uint32(data[18:]))), int(int32(le.Uint32(data[22:])))
+		bpp = int(le.Uint16(data[28:]))
+		compression = int(le.Uint32(data[30:]))
+	}
+
+	topDown := h < 0
+	if topDown {
+		h = -h
+	}
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+	if pixelOffset > len(data) {
+		return nil, "", errImageTruncated
+	}
+	details := fmt.Sprintf("%s, %d bits per pixel", version, bpp)
+	if compression == 1 || compression == 2 {
+		details += ", RLE"
+	}
+
+	// row y of the image is row rowAt(y) of the file
+	rowAt := func(y int) int {
+		if topDown {
+			return y
+		}
+		return h - 1 - y
+	}
+
+	if bpp <= 8 {
+		if bpp != 1 && bpp != 4 && bpp != 8 {
+			return nil, "", fmt.Errorf("BMP files with %d bits per pixel aren't supported", bpp)
+		}
+		n := 1 << bpp
+		if headerSize >= 40 {
+			if used := int(le.Uint32(data[46:])); used > 0 && used < n {
+				n = used
+			}
+		}
+		palStart := 14 + headerSize
+		if palStart+n*paletteEntry > len(data) {
+			return nil, "", errImageTruncated
+		}
+		palette := make(color.Palette, n)
+		for i := range palette {
+			p := data[palStart+i*paletteEntry:]
+			palette[i] = color.RGBA{p[2], p[1], p[0], 0xFF}
+		}
+		img := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		pixels := data[pixelOffset:]
+
+		if compression == 1 || compression == 2 {
+			decodeBMPRLE(img, pixels, compression == 2, rowAt)
+			return img, details, nil
+		}
+		stride := (w*bpp + 31) / 32 * 4
+		if len(pixels) < stride*h {
+			return nil, "", errImageTruncated
+		}
+		for y := 0; y < h; y++ {
+			row := pixels[rowAt(y)*stride:]
+			for x := 0; x < w; x++ {
+				img.SetColorIndex(x, y, bitsAt(row, x, bpp)%uint8(n))
+			}
+		}
+		return img, details, nil
+	}
+
+	// true color: channel masks, from the header or the defaults
+	var masks [4]uint32
+	switch bpp {
+	case 16:
+		masks = [4]uint32{0x7C00, 0x03E0, 0x001F, 0}
+	case 24, 32:
+		masks = [4]uint32{0xFF0000, 0x00FF00, 0x0000FF, 0}
+	default:
+		return nil, "", fmt.Errorf("BMP files with %d bits per pixel aren't supported", bpp)
+	}
+	if compression == 3 || compression == 6 {
+		if 14+40+12 > len(data) {
+			return nil, "", errImageTruncated
+		}
+		for i := 0; i < 3; i++ {
+			masks[i] = le.Uint32(data[14+40+4*i:])
+		}
+		if headerSize >= 56 || compression == 6 {
+			masks[3] = le.Uint32(data[14+40+12:])
+		}
+	} else if compression != 0 {
+		return nil, "", fmt.Errorf("BMP compression %d isn't supported", compression)
+	}
+
+	stride := (w*bpp + 31) / 32 * 4
+	pixels := data[pixelOffset:]
+	if len(pixels) < stride*h {
+		return nil, "", errImageTruncated
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := pixels[rowAt(y)*stride:]
+		for x := 0; x < w; x++ {
+			var v uint32
+			switch bpp {
+			case 16:
+				v = uint32(le.Uint16(row[2*x:]))
+			case 24:
+				v = uint32(row[3*x]) | uint32(row[3*x+1])<<8 | uint32(row[3*x+2])<<16
+			case 32:
+				v = le.Uint32(row[4*x:])
+			}
+			a := uint8(255)
+			if masks[3] != 0 {
+				a = maskChannel(v, masks[3])
+			}
+			img.SetNRGBA(x, y, color.NRGBA{maskChannel(v, masks[0]), maskChannel(v, masks[1]), maskChannel(v, masks[2]), a})
+		}
+	}
+	return img, details, nil
+}
+
+// maskChannel extracts a bitfield and scales it to 8 bits.
+func maskChannel(v, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+	shift := 0
+	for mask&1 == 0 {
+		mask >>= 1
+		shift++
+	}
+	return uint8((v >> shift & mask) * 255 / mask)
+}
+
+// decodeBMPRLE fills a paletted image from RLE8 or RLE4 data. Pixels the
+// data skips over keep color 0.
+func decodeBMPRLE(img *image.Paletted, in []byte, four bool, rowAt func(int) int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x, row := 0, 0 // the row in the file; rowAt maps it either way
+	set := func(c byte) {
+		if x < w && row < h {
+			img.SetColorIndex(x, rowAt(row), c)
+		}
+		x++
+	}
+
+	for i := 0; i+1 < len(in); {
+		n, c := int(in[i]), in[i+1]
+		i += 2
+		if n > 0 {
+			for k := 0; k < n; k++ {
+				if four {
+					set(c >> (4 * (1 - k%2)) & 0x0F)
+				} else {
+					set(c)
+				}
+			}
+			continue
+		}
+		switch c {
+		case 0: // end of line
+			x, row = 0, row+1
+		case 1: // end of bitmap
+			return
+		case 2: // move right and up
+			if i+1 >= len(in) {
+				return
+			}
+			x, row = x+int(in[i]), row+int(in[i+1])
+			i += 2
+		default: // c pixels as they are
+			count := int(c)
+			size := count
+			if four {
+				size = (count + 1) / 2
+			}
+			if i+size > len(in) {
+				return
+			}
+			for k := 0; k < count; k++ {
+				if four {
+					set(in[i+k/2] >> (4 * (1 - k%2)) & 0x0F)
+				} else {
+					set(in[i+k])
+				}
+			}
+			i += size + size%2 // padded to a word
+		}
+	}
+}
+
+// --- IFF ---
+
+const (
+	IFF_CAMG_HAM = 0x800
+	IFF_CAMG_EHB = 0x80
+)
+
+func isILBM(b []byte) bool {
+	return len(b) >= 12 && string(b[0:4]) == "FORM" && (string(b[8:12]) == "ILBM" || string(b[8:12]) == "PBM ")
+}
+
+// -----------------------------------------------------------
+// decodeILBM(data) -> image, details, error
+//
+// Amiga IFF pictures: ILBM keeps each row as bit planes, PBM
+// (from Deluxe Paint) as one byte per pixel. BMHD gives the
+// size and compression, CMAP the palette, and CAMG the
+// display mode; EHB doubles the palette with half-bright
+// copies and HAM modifies one channel of the previous pixel.
+// -----------------------------------------------------------
+func decodeILBM(data []byte) (image.Image, string, error) {
+	chunky := string(data[8:12]) == "PBM "
+	var bmhd, cmap, body []byte
+	var camg uint32
+
+	for i := 12; i+8 <= len(data); {
+		id, size := string(data[i:i+4]), int(binary.BigEndian.Uint32(data[i+4:]))
+		i += 8
+		if size < 0 || i+size > len(data) {
+			size = len(data) - i // a truncated BODY still shows what it has
+		}
+		chunk := data[i : i+size]
+		switch id {
+		case "BMHD":
+			bmhd = chunk
+		case "CMAP":
+			cmap = chunk
+		case "CAMG":
+			if len(chunk) >= 4 {
+				camg = binary.BigEndian.Uint32(chunk)
+			}
+		case "BODY":
+			body = chunk
+		}
+		i += size + size%2
+	}
+	if len(bmhd) < 20 || body == nil {
+		return nil, "", errors.New("IFF picture has no BMHD or BODY")
+	}
+
+	w, h := int(binary.BigEndian.Uint16(bmhd[0:])), int(binary.BigEndian.Uint16(bmhd[2:]))
+	planes, masking, compression := int(bmhd[8]), bmhd[9], bmhd[10]
+	transparent := int(binary.BigEndian.Uint16(bmhd[12:]))
+	if err := checkImageSize(w, h); err != nil {
+		return nil, "", err
+	}
+	if planes < 1 || planes > 24 || (planes > 8 && planes != 24) {
+		return nil, "", fmt.Errorf("IFF pictures with %d planes aren't supported", planes)
+	}
+
+	// the palette; old files keep 4-bit values in the high nibble
+	var palette []color.NRGBA
+	lowNibbles := false
+	for _, c := range cmap {
+		lowNibbles = lowNibbles || c&0x0F != 0
+	}
+	for i := 0; i+2 < len(cmap); i += 3 {
+		c := color.NRGBA{cmap[i], cmap[i+1], cmap[i+2], 255}
+		if !lowNibbles {
+			c.R, c.G, c.B = c.R|c.R>>4, c.G|c.G>>4, c.B|c.B>>4
+		}
+		palette = append(palette, c)
+	}
+	if camg&IFF_CAMG_EHB != 0 && len(palette) >= 32 {
+		for _, c := range palette[:32] {
+			palette = append(palette, color.NRGBA{c.R / 2, c.G / 2, c.B / 2, 255})
+		}
+	}
+	ham := camg&IFF_CAMG_HAM != 0 && (planes == 6 || planes == 8)
+
+	// the rows, decompressed
+	rowBytes := (w + 15) / 16 * 2
+	rowPlanes := planes
+	if masking == 1 {
+		rowPlanes++ // a mask plane follows the image planes
+	}
+	lineSize := rowBytes * rowPlanes
+	if chunky {
+		lineSize = w + w%2
+	}
+	switch compression {
+	case 0:
+	case 1:
+		body = unpackBits(body, lineSize*h)
+	default:
+		return nil, "", fmt.Errorf("IFF compression %d isn't supported", compression)
+	}
+	if len(body) < lineSize*h {
+		return nil, "", errImageTruncated
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	lookup := func(i int) color.NRGBA {
+		if i < len(palette) {
+			return palette[i]
+		}
+		g := uint8(i * 255 / (1<<min(planes, 8) - 1)) // no CMAP: grayscale
+		return color.NRGBA{g, g, g, 255}
+	}
+
+	for y := 0; y < h; y++ {
+		line := body[y*lineSize:]
+		var prev color.NRGBA
+		if ham {
+			prev = lookup(0)
+		}
+		for x := 0; x < w; x++ {
+			v := 0
+			if chunky {
+				v = int(line[x])
+			} else {
+				for p := 0; p < planes; p++ {
+					v |= int(line[p*rowBytes+x/8]>>(7-x%8)&1) << p
+				}
+			}
+
+			var c color.NRGBA
+			switch {
+			case planes == 24:
+				c = color.NRGBA{uint8(v), uint8(v >> 8), uint8(v >> 16), 255}
+			case ham:
+				c = hamPixel(v, planes, prev, lookup)
+			default:
+				c = lookup(v)
+				if masking == 2 && v == transparent {
+					c.A = 0
+				}
+			}
+			if masking == 1 && !chunky && line[planes*rowBytes+x/8]>>(7-x%8)&1 == 0 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+			prev = c
+		}
+	}
+
+	details := fmt.Sprintf("%s, %d plane(s)", strings.TrimSpace(string(data[8:12])), planes)
+	switch {
+	case ham:
+		details += ", HAM"
+	case camg&IFF_CAMG_EHB != 0:
+		details += ", extra half-brite"
+	}
+	if compression == 1 {
+		details += ", ByteRun1"
+	}
+	return img, details, nil
+}
+
+// hamPixel applies one hold-and-modify pixel: the top two bits choose a
+// palette color or which channel of the previous pixel to replace.
+func hamPixel(v, planes int, prev color.NRGBA, lookup func(int) color.NRGBA) color.NRGBA {
+	bits := planes - 2
+	value := v & (1<<bits - 1)
+	var level uint8
+	if bits == 4 {
+		level = uint8(value<<4 | value)
+	} else {
+		level = uint8(value<<2 | value>>4)
+	}
+
+	switch v >> bits {
+	case 0:
+		return lookup(value)
+	case 1:
+		prev.B = level
+	case 2:
+		prev.R = level
+	case 3:
+		prev.G = level
+	}
+	return prev
+}
@@ -0,0 +1,150 @@
+// images module for gofer 0.9
+// image items (types g, I, and :): a view page, and PNG conversion of
+// formats browsers can't show
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// imageFormat is one picture format gofer recognizes by its first bytes.
+type imageFormat struct {
+	Name   string
+	MIME   string                                    // for formats the browser shows itself
+	match  func([]byte) bool                         // recognizes the format
+	decode func([]byte) (image.Image, string, error) // converts it, with details for the view page
+}
+
+// browsers show these as they are; the rest are converted to PNG
+var nativeImageFormats = []*imageFormat{
+	{Name: "PNG", MIME: "image/png", match: prefixMatch("\x89PNG\r\n\x1a\n")},
+	{Name: "GIF", MIME: "image/gif", match: prefixMatch("GIF8")},
+	{Name: "JPEG", MIME: "image/jpeg", match: prefixMatch("\xFF\xD8\xFF")},
+	{Name: "WebP", MIME: "image/webp", match: func(b []byte) bool {
+		return len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WEBP"
+	}},
+}
+
+var legacyImageFormats = []*imageFormat{
+	{Name: "BMP", match: isBMP, decode: decodeBMP},
+	{Name: "TIFF", match: isTIFF, decode: decodeTIFF},
+	{Name: "IFF", match: isILBM, decode: decodeILBM},
+	{Name: "XPM", match: isXPM, decode: decodeXPM},
+	{Name: "XBM", match: isXBM, decode: decodeXBM},
+	{Name: "PCX", match: isPCX, decode: decodePCX}, // last: its magic is one byte
+}
+
+func prefixMatch(magic string) func([]byte) bool {
+	return func(b []byte) bool { return bytes.HasPrefix(b, []byte(magic)) }
+}
+
+// identifyImage finds the format of an image, or returns nil.
+func identifyImage(data []byte) *imageFormat {
+	for _, f := range nativeImageFormats {
+		if f.match(data) {
+			return f
+		}
+	}
+	for _, f := range legacyImageFormats {
+		if f.match(data) {
+			return f
+		}
+	}
+	return nil
+}
+
+// --- HTTP Handler ---
+
+// imageView is the data for the "image" template.
+type imageView struct {
+	pageHead
+	Name     string
+	Format   string
+	Details  string
+	Width    int
+	Height   int
+	Src      string // the image as the browser can show it, if it can
+	Note     string
+	Error    string
+	Download string
+	Return   returnLink
+}
+
+// -----------------------------------------------------------
+// serveImage(w, r, g, conn) -> error
+//
+// Image items open a view page with the format and size and
+// the picture itself. image=png sends the picture converted
+// to PNG, falling back to the original bytes if it can't be
+// converted, and raw=1 (handled by serveGopher) the original.
+// -----------------------------------------------------------
+func serveImage(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	data, err := readDecodable(conn)
+
+	var format *imageFormat
+	var img image.Image
+	var details string
+	if err == nil {
+		if format = identifyImage(data); format != nil && format.decode != nil {
+			img, details, err = format.decode(data)
+		}
+	}
+
+	if r.URL.Query().Get("image") == "png" && data != nil {
+		if img != nil {
+			var b bytes.Buffer
+			if png.Encode(&b, img) == nil {
+				setRemoteHeaders(w, "image/png")
+				_, err := w.Write(b.Bytes())
+				return err
+			}
+		}
+		ct := declaredType(g)
+		if format != nil && format.MIME != "" {
+			ct = format.MIME
+		} else if ct == "" {
+			ct = http.DetectContentType(data)
+		}
+		setRemoteHeaders(w, ct)
+		_, err := w.Write(data)
+		return err
+	}
+
+	view := imageView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Name:     itemFileName(g),
+		Details:  details,
+		Download: withParams(r, "raw", "1", "download", "1"),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	}
+	switch {
+	case err != nil:
+		view.Error = err.Error()
+	case format == nil:
+		view.Src = withParams(r, "raw", "1")
+		view.Note = "gofer doesn't recognize this format; your browser may still show it."
+	case img != nil:
+		view.Width, view.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		view.Src = withParams(r, "image", "png")
+		view.Note = "Converted to PNG for display."
+	default:
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			view.Width, view.Height = cfg.Width, cfg.Height
+		}
+		view.Src = withParams(r, "raw", "1")
+	}
+	if format != nil {
+		view.Format = format.Name
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return renderTemplate(w, "image", view)
+}
@@ -0,0 +1,62 @@
+// images tests for gofer 0.9
+// a view page and the requests it makes back cost one fetch
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testXBM = "#define dot_width 8\n#define dot_height 2\nstatic char dot_bits[] = { 0x01, 0x80 };\n"
+
+// listenGopher serves reply to every request and counts the fetches.
+func listenGopher(t *testing.T, reply string) (string, string, *atomic.Int32) {
+	t.Helper()
+	fetches := new(atomic.Int32)
+	host, port := listenLoopback(t, func(c net.Conn) {
+		defer c.Close()
+		bufio.NewReader(c).ReadString('\n')
+		fetches.Add(1)
+		c.Write([]byte(reply))
+	})
+	return host, port, fetches
+}
+
+// getGopher sends one request through serveGopher and wants a 200.
+func getGopher(t *testing.T, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	serveGopher(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", path, rec.Code)
+	}
+	return rec
+}
+
+func TestServeImageFetchesOnce(t *testing.T) {
+	host, port, fetches := listenGopher(t, testXBM)
+	item := "/?type=I&host=" + host + "&port=" + port + "&selector=%2Fdot.xbm"
+
+	page := getGopher(t, item).Body.String()
+	if !strings.Contains(page, "XBM") || !strings.Contains(page, "image=png") {
+		t.Errorf("view page lacks the format or the converted image:\n%s", page)
+	}
+	if ct := getGopher(t, item+"&image=png").Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("image=png sent %q", ct)
+	}
+	if body := getGopher(t, item+"&raw=1").Body.String(); body != testXBM {
+		t.Errorf("raw=1 sent %q", body)
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches for one view, want 1", n)
+	}
+}
@@ -0,0 +1,143 @@
+// compress module for gofer 0.9
+// decodes Unix compress (.Z) files, whose LZW variant compress/lzw can't read
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import "errors"
+
+const (
+	LZW_MAGIC_1    = 0x1F
+	LZW_MAGIC_2    = 0x9D
+	LZW_INIT_BITS  = 9
+	LZW_MAX_BITS   = 16
+	LZW_CLEAR      = 256  // in block mode, starts a new table
+	LZW_BLOCK_MODE = 0x80 // header flag: the clear code is in use
+	LZW_BITS_MASK  = 0x1F // header bits: the widest code
+)
+
+// -----------------------------------------------------------
+// uncompressLZW(data) -> bytes, error
+//
+// compress(1) writes codes least significant bit first,
+// starting 9 bits wide and growing to the width in the header
+// whenever the table fills. Codes go out in groups of eight,
+// and a group is padded to its full size when the width
+// changes or the table is cleared, so the reader skips to
+// the end of the group at those points, as ncompress does.
+// -----------------------------------------------------------
+func uncompressLZW(data []byte) ([]byte, error) {
+	if len(data) < 3 || data[0] != LZW_MAGIC_1 || data[1] != LZW_MAGIC_2 {
+		return nil, errors.New("not a compress (.Z) file")
+	}
+	maxBits := int(data[2] & LZW_BITS_MASK)
+	blockMode := data[2]&LZW_BLOCK_MODE != 0
+	if maxBits < LZW_INIT_BITS || maxBits > LZW_MAX_BITS {
+		return nil, errors.New("compress (.Z) file uses an unsupported code width")
+	}
+
+	in := data[3:]
+	totalBits := len(in) * 8
+	tableSize := 1 << maxBits
+
+	prefix := make([]uint16, tableSize)
+	suffix := make([]byte, tableSize)
+	for i := 0; i < 256; i++ {
+		suffix[i] = byte(i)
+	}
+	first := 256
+	if blockMode {
+		first = LZW_CLEAR + 1
+	}
+
+	width := LZW_INIT_BITS
+	maxCode := 1<<width - 1
+	free := first
+	bitPos, groupStart := 0, 0
+	oldCode := -1
+	var finChar byte
+
+	out := make([]byte, 0, len(in)*3)
+	stack := make([]byte, 0, tableSize)
+
+	// skip to the end of the current group of eight codes
+	align := func() {
+		group := width * 8
+		if rel := (bitPos - groupStart) % group; rel != 0 {
+			bitPos += group - rel
+		}
+		groupStart = bitPos
+	}
+
+	for {
+		if free > maxCode {
+			align()
+			width++
+			maxCode = 1<<width - 1
+			if width == maxBits {
+				maxCode = tableSize // full width: the table just stops growing
+			}
+		}
+		if bitPos+width > totalBits {
+			break
+		}
+
+		code := 0
+		for i := 0; i < width; i++ {
+			p := bitPos + i
+			code |= int(in[p>>3]>>(p&7)&1) << i
+		}
+		bitPos += width
+
+		if oldCode == -1 {
+			if code >= 256 {
+				return nil, errors.New("compress (.Z) data is corrupt (bad first code)")
+			}
+			oldCode, finChar = code, byte(code)
+			out = append(out, finChar)
+			continue
+		}
+
+		if code == LZW_CLEAR && blockMode {
+			// ncompress restarts at one below the first free entry;
+			// the next code's table entry then lands on the clear code
+			free = first - 1
+			align()
+			width = LZW_INIT_BITS
+			maxCode = 1<<width - 1
+			continue
+		}
+
+		inCode := code
+		stack = stack[:0]
+		if code >= free {
+			if code > free {
+				return nil, errors.New("compress (.Z) data is corrupt (code out of range)")
+			}
+			stack = append(stack, finChar) // the KwKwK case
+			code = oldCode
+		}
+		for code >= 256 {
+			stack = append(stack, suffix[code])
+			code = int(prefix[code])
+		}
+		finChar = suffix[code]
+		stack = append(stack, finChar)
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			out = append(out, stack[i])
+		}
+		if len(out) > DECODE_MAX_SIZE {
+			return nil, errors.New("compress (.Z) data expands past the size limit")
+		}
+
+		if free < tableSize {
+			prefix[free] = uint16(oldCode)
+			suffix[free] = finChar
+			free++
+		}
+		oldCode = inCode
+	}
+	return out, nil
+}
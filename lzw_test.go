@@ -0,0 +1,163 @@
+// compress tests for gofer 0.9
+// .Z files from an encoder that follows compress(1) 4.0, and corrupt ones
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// -----------------------------------------------------------
+// compressLZW(data, maxBits, clearWhenFull) -> .Z file
+//
+// A test encoder written from compress.c 4.0, not from the
+// decoder: codes least significant bit first in groups of
+// eight, a group padded to its full size when the width grows
+// or the table is cleared. With clearWhenFull it sends a clear
+// code whenever the table fills, as compress does once the
+// ratio drops.
+// -----------------------------------------------------------
+func compressLZW(data []byte, maxBits int, clearWhenFull bool) []byte {
+	out := []byte{LZW_MAGIC_1, LZW_MAGIC_2, byte(maxBits) | LZW_BLOCK_MODE}
+	if len(data) == 0 {
+		return out
+	}
+
+	maxMaxCode := 1 << maxBits
+	width, maxCode := LZW_INIT_BITS, 1<<LZW_INIT_BITS-1
+	free := LZW_CLEAR + 1
+	var bits []byte // the code stream, one bit per byte
+	offset := 0     // bits into the current group
+	clearing := false
+
+	output := func(code int) {
+		for i := 0; i < width; i++ {
+			bits = append(bits, byte(code>>i&1))
+		}
+		offset += width
+		if offset == width*8 {
+			offset = 0
+		}
+		if free > maxCode || clearing {
+			if offset > 0 {
+				bits = append(bits, make([]byte, width*8-offset)...)
+			}
+			offset = 0
+			if clearing {
+				width, maxCode = LZW_INIT_BITS, 1<<LZW_INIT_BITS-1
+				clearing = false
+			} else if width++; width == maxBits {
+				maxCode = maxMaxCode
+			} else {
+				maxCode = 1<<width - 1
+			}
+		}
+	}
+
+	table := map[[2]int]int{}
+	ent := int(data[0])
+	for _, c := range data[1:] {
+		if code, ok := table[[2]int{ent, int(c)}]; ok {
+			ent = code
+			continue
+		}
+		output(ent)
+		if free < maxMaxCode {
+			table[[2]int{ent, int(c)}] = free
+			free++
+		} else if clearWhenFull {
+			table = map[[2]int]int{}
+			free = LZW_CLEAR + 1
+			clearing = true
+			output(LZW_CLEAR)
+		}
+		ent = int(c)
+	}
+	output(ent)
+
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8 && i+j < len(bits); j++ {
+			b |= bits[i+j] << j
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// lzwSample is text with enough variety to fill a 12-bit table several times.
+func lzwSample() []byte {
+	var b bytes.Buffer
+	words := strings.Fields("gopher menu selector item type host port server client veronica jughead archie")
+	for i := 0; b.Len() < 200_000; i++ {
+		b.WriteString(words[i*7%len(words)])
+		b.WriteByte(" \n,."[i*13%4])
+		b.WriteByte(byte('0' + i*31%10))
+	}
+	return b.Bytes()
+}
+
+func TestUncompressLZW(t *testing.T) {
+	sample := lzwSample()
+	tests := []struct {
+		name          string
+		data          []byte
+		maxBits       int
+		clearWhenFull bool
+	}{
+		{"one byte", []byte("x"), 16, false},
+		{"classic", []byte("TOBEORNOTTOBEORTOBEORNOT#"), 16, false},
+		{"KwKwK", []byte(strings.Repeat("a", 1000)), 16, false},
+		{"every byte value", func() []byte {
+			b := make([]byte, 1024)
+			for i := range b {
+				b[i] = byte(i * 7)
+			}
+			return b
+		}(), 16, false},
+		{"width growing to 16 bits", sample, 16, false},
+		{"full 12-bit table kept", sample, 12, false},
+		{"full 12-bit table cleared", sample, 12, true},
+		{"full 10-bit table cleared", sample, 10, true},
+	}
+	for _, tt := range tests {
+		got, err := uncompressLZW(compressLZW(tt.data, tt.maxBits, tt.clearWhenFull))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.data) {
+			n := 0
+			for n < len(got) && n < len(tt.data) && got[n] == tt.data[n] {
+				n++
+			}
+			t.Errorf("%s: %d bytes, want %d; first difference at %d", tt.name, len(got), len(tt.data), n)
+		}
+	}
+}
+
+func TestUncompressLZWCorrupt(t *testing.T) {
+	good := compressLZW([]byte("TOBEORNOTTOBEORTOBEORNOT#"), 16, false)
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"gzip magic", []byte{0x1F, 0x8B, 0x08}, "not a compress"},
+		{"too short", []byte{LZW_MAGIC_1, LZW_MAGIC_2}, "not a compress"},
+		{"8-bit codes", []byte{LZW_MAGIC_1, LZW_MAGIC_2, 8 | LZW_BLOCK_MODE, 0}, "unsupported code width"},
+		{"17-bit codes", []byte{LZW_MAGIC_1, LZW_MAGIC_2, 17 | LZW_BLOCK_MODE, 0}, "unsupported code width"},
+		{"first code not a byte", []byte{LZW_MAGIC_1, LZW_MAGIC_2, 16 | LZW_BLOCK_MODE, 0x01, 0x03}, "bad first code"},
+		{"code past the table", append(good[:5:5], 0xFF, 0xFF, 0xFF), "code out of range"},
+	}
+	for _, tt := range tests {
+		_, err := uncompressLZW(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
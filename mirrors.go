@@ -0,0 +1,148 @@
+// mirrors module for gofer 0.9
+// type + redundant servers: mirror links, and failover when a server is down
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"gofer/gophermap"
+)
+
+const MIRROR_PARAM = "mirror" // repeated query parameter carrying gopher:// URLs of mirrors
+
+// mirrorURLs returns the addresses of a menu item's redundant servers. A
+// type + line carries no type of its own; the mirror serves the same item type.
+func mirrorURLs(it *gophermap.Item) []*GopherURL {
+	var urls []*GopherURL
+	for _, m := range it.Mirrors {
+		urls = append(urls, &GopherURL{Host: m.Host, Port: m.Port, Type: it.Type, Selector: m.Selector})
+	}
+	return urls
+}
+
+// mirrorQuery appends the mirrors to a local link, so the fetch can fail over.
+func mirrorQuery(mirrors []*GopherURL) string {
+	var q strings.Builder
+	for _, m := range mirrors {
+		q.WriteString("&" + MIRROR_PARAM + "=" + url.QueryEscape(m.String()))
+	}
+	return q.String()
+}
+
+// mirrorsFromQuery reads the mirrors written by mirrorQuery. They serve the
+// same item as g, so they take its type, search string, and Gopher+ string.
+func mirrorsFromQuery(query url.Values, g *GopherURL) []*GopherURL {
+	var mirrors []*GopherURL
+	for _, raw := range query[MIRROR_PARAM] {
+		m, err := ParseGopherURL(raw)
+		if err != nil {
+			continue
+		}
+		m.Type, m.Search, m.Plus = g.Type, g.Search, g.Plus
+		mirrors = append(mirrors, m)
+	}
+	return mirrors
+}
+
+// -----------------------------------------------------------
+// openGopherMirrors(targets) -> conn, error
+//
+// Tries each server in turn until one answers. A server counts
+// as answering once the first byte of its reply arrives, so a
+// host that accepts the connection and then stalls is skipped
+// too. A changed certificate is reported at once rather than
+// hidden behind a mirror. The connection's Tried field lists
+// the servers that failed first.
+// -----------------------------------------------------------
+func openGopherMirrors(targets []*GopherURL) (*gopherConn, error) {
+	var firstErr error
+	var tried []string
+
+	for _, g := range targets {
+		conn, err := openGopherTarget(g)
+		if err == nil {
+			conn.Tried = tried
+			return conn, nil
+		}
+
+		var pinErr *PinMismatchError
+		if errors.As(err, &pinErr) {
+			return nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		tried = append(tried, g.Address())
+	}
+
+	if len(targets) > 1 {
+		return nil, fmt.Errorf("%w; %d mirror(s) also failed", firstErr, len(targets)-1)
+	}
+	return nil, firstErr
+}
+
+// openGopherTarget opens one server and waits for the start of its reply.
+func openGopherTarget(g *GopherURL) (*gopherConn, error) {
+	if err := checkRequestLine(g.Host, g.Port, g.Request()); err != nil {
+		return nil, err
+	}
+
+	// a gophers:// link asks for TLS on this request; the host is only
+	// remembered as secure once the handshake succeeds
+	conn, err := openGopherData(g.Host, g.Port, g.Request(), "", g.TLS)
+	if err != nil {
+		return nil, err
+	}
+	conn.Selector = g.Selector // without the search and Gopher+ strings
+	if _, err := conn.reader.Peek(1); err != nil && err != io.EOF {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// gopherRequestMirrors reads a whole reply from the first server that
+// delivers one completely, and reports which server that was.
+func gopherRequestMirrors(targets []*GopherURL) ([]byte, *GopherURL, error) {
+	var firstErr error
+
+	for i, g := range targets {
+		conn, err := openGopherMirrors(targets[i : i+1])
+		if err == nil {
+			var reply []byte
+			reply, err = conn.ReadAll()
+			conn.Close()
+			if err == nil {
+				return reply, g, nil
+			}
+		}
+
+		var pinErr *PinMismatchError
+		if errors.As(err, &pinErr) {
+			return nil, nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(targets) > 1 {
+		return nil, nil, fmt.Errorf("%w; %d mirror(s) also failed", firstErr, len(targets)-1)
+	}
+	return nil, nil, firstErr
+}
+
+// mirrorNote describes a failover for the page that shows the reply.
+func mirrorNote(answered string, tried []string) string {
+	if len(tried) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Answered by mirror %s; %s did not respond.", answered, strings.Join(tried, ", "))
+}
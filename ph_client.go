@@ -1,5 +1,5 @@
 // Ph Client for gofer 0.5
-// hewing as close to RFC 1436 (1998) as practical
+// hewing as close to RFC 2378 (1998) as practical
 // (C) 2025 Isaac Roll
 // See github.com/iroll/gofer for license
 
@@ -7,15 +7,42 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const PH_DEFAULT_PORT = "105"
 const PH_TIMEOUT = 5 * time.Second
+const PH_GREETING_WAIT = 300 * time.Millisecond // the few servers that greet do so at once
+const PH_MAX_LINES = 100000                     // longest response gofer will read
+
+// CSO result codes gofer acts on (RFC 2378, appendix B)
+const (
+	PH_OK         = 200 // and anything above it ends a response
+	PH_TEMP_ERROR = 400 // and anything above it is an error
+	PH_CHALLENGE  = 301 // login wants an answer
+	PH_NO_MATCHES = 501
+)
+
+// what the common result codes mean, for servers that send no text
+var phResultCodes = map[int]string{
+	400: "temporary error", 401: "internal database error", 402: "lock not obtained",
+	475: "database unavailable; try later", 500: "permanent error", 501: "no matches to query",
+	502: "too many matches to query", 503: "not authorized for requested information",
+	504: "not authorized for requested search criteria", 506: "must be logged in",
+	507: "field does not exist", 512: "illegal value", 514: "unknown command",
+	515: "no indexed field in query", 520: "CPU usage limit exceeded", 598: "command unknown",
+	599: "syntax error",
+}
+
+// a field name in a response line
+var phAttribute = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
 
 // -----------------------------------------------------------
 // ParsePHRoute("/ph:hostname:port") -> host, port
@@ -39,199 +66,525 @@ func ParsePHRoute(path string) (string, string, error) {
 	return host, port, nil
 }
 
+// --- Responses ---
+
+// PHLine is one response line: "code:[index:][field:]text".
+type PHLine struct {
+	Code  int // negative on every line but the last
+	Index int // entry index, or 0
+	Field string
+	Text  string
+}
+
+// PHError is a response that ended with a code of 400 or more.
+type PHError struct {
+	Code int
+	Text string
+}
+
+func (e *PHError) Error() string {
+	text := e.Text
+	if text == "" {
+		text = phResultCodes[e.Code]
+	}
+	return fmt.Sprintf("Ph server error %d: %s", e.Code, text)
+}
+
+// phErrorCode returns the result code of a PHError, or 0.
+func phErrorCode(err error) int {
+	var phErr *PHError
+	if errors.As(err, &phErr) {
+		return phErr.Code
+	}
+	return 0
+}
+
 // -----------------------------------------------------------
-// PHInitialGreeting(host, port) -> string
+// parsePHLine(line) -> PHLine, error
 //
-// Connects to PH server, reads greeting line, closes socket.
+// The entry index and field name are both optional, so a part
+// is only taken as an index if it's a number, and as a field
+// name if an index came before it and it looks like one. The
+// field name and the text may be padded with spaces.
 // -----------------------------------------------------------
-func PHInitialGreeting(host, port string) (string, error) {
-	address := net.JoinHostPort(host, port)
+func parsePHLine(line string) (PHLine, error) {
+	line = strings.TrimRight(line, "\r\n")
+	codeText, rest, ok := strings.Cut(line, ":")
+	code, err := strconv.Atoi(strings.TrimSpace(codeText))
+	if !ok || err != nil {
+		return PHLine{}, fmt.Errorf("unexpected reply from Ph server: %q", line)
+	}
 
-	conn, err := net.DialTimeout("tcp", address, PH_TIMEOUT)
-	if err != nil {
-		return "", fmt.Errorf("PH connect failed: %w", err)
+	parsed := PHLine{Code: code, Text: rest}
+	if indexText, after, ok := strings.Cut(rest, ":"); ok {
+		if index, err := strconv.Atoi(strings.TrimSpace(indexText)); err == nil {
+			parsed.Index, parsed.Text = index, after
+			if name, value, ok := strings.Cut(after, ":"); ok && phAttribute.MatchString(strings.TrimSpace(name)) {
+				parsed.Field, parsed.Text = strings.TrimSpace(name), value
+			}
+		}
 	}
-	defer conn.Close()
+	parsed.Text = strings.TrimSpace(parsed.Text)
+	return parsed, nil
+}
 
-	conn.SetDeadline(time.Now().Add(PH_TIMEOUT))
+// PHField is one field of an entry or of the site information.
+type PHField struct {
+	Name  string
+	Value string
+}
 
-	reader := bufio.NewReader(conn)
+// PHEntry is one entry from a query, its fields in the order they came.
+type PHEntry struct {
+	Index  int
+	Fields []PHField
+}
 
-	greeting, err := reader.ReadString('\n')
-	if err != nil {
-		return "", fmt.Errorf("PH read failed: %w", err)
+// Get returns the value of a field, or "".
+func (e *PHEntry) Get(name string) string {
+	for _, f := range e.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
 	}
+	return ""
+}
 
-	// PH greeting lines may have trailing CRLF → trim it
-	return strings.TrimSpace(greeting), nil
+// PHFieldInfo describes one field, from the "fields" command.
+type PHFieldInfo struct {
+	ID          int
+	Name        string
+	Max         int      // longest value, or 0
+	Keywords    []string // Indexed, Lookup, Public, Default, Change, ...
+	Description string
 }
 
-func HandlePH(w http.ResponseWriter, r *http.Request) {
-	host, port, err := ParsePHRoute(r.URL.Path)
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
+// Has reports whether the field carries a keyword, ignoring case.
+func (f *PHFieldInfo) Has(keyword string) bool {
+	for _, k := range f.Keywords {
+		if strings.EqualFold(k, keyword) {
+			return true
+		}
 	}
+	return false
+}
 
-	returnURL := r.URL.Query().Get("return")
-	if returnURL == "" {
-		returnURL = "/"
-	}
+// --- Client ---
 
-	var content string
+// PHClient is one connection to a Ph server.
+type PHClient struct {
+	Host, Port string
+	Greeting   []string // what the server said before being asked, if anything
+	Alias      string   // who we're logged in as, if anyone
 
-	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Invalid form data", http.StatusBadRequest)
-			return
-		}
+	conn   net.Conn
+	reader *bufio.Reader
+	broken bool // the connection failed or lost its place in a response
+}
 
-		query := strings.TrimSpace(r.FormValue("query"))
-		if query == "" {
-			http.Error(w, "Empty query", http.StatusBadRequest)
-			return
+// -----------------------------------------------------------
+// DialPH(host, port) -> client, error
+//
+// Most Ph servers say nothing until they get a command, but a
+// few send a greeting first. Waiting for one used to hang the
+// page until PH_TIMEOUT; now anything the server sends within
+// PH_GREETING_WAIT is kept as the greeting, and otherwise the
+// client goes straight on.
+// -----------------------------------------------------------
+func DialPH(host, port string) (*PHClient, error) {
+	conn, err := dialRemote(host, port, PH_TIMEOUT, false)
+	if err != nil {
+		return nil, fmt.Errorf("PH connect to %s failed: %w", net.JoinHostPort(host, port), err)
+	}
+	c := &PHClient{Host: host, Port: port, conn: conn, reader: bufio.NewReader(conn)}
+
+	conn.SetReadDeadline(time.Now().Add(PH_GREETING_WAIT))
+	for len(c.Greeting) < PH_MAX_LINES {
+		text, err := c.reader.ReadString('\n')
+		if text = strings.TrimSpace(text); text != "" {
+			line, parseErr := parsePHLine(text)
+			if parseErr == nil {
+				text = line.Text
+			}
+			c.Greeting = append(c.Greeting, text)
+			if parseErr == nil && line.Code >= PH_OK {
+				break
+			}
 		}
-
-		result, err := PHQuery(host, port, query)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+			break
 		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return c, nil
+}
 
-		content = result
+// Close says goodbye to the server and closes the connection.
+func (c *PHClient) Close() error {
+	c.conn.SetWriteDeadline(time.Now().Add(PH_TIMEOUT))
+	fmt.Fprint(c.conn, "quit\r\n")
+	return c.conn.Close()
+}
+
+// Command sends one command and reads its response up to the final line. A
+// final code of 400 or more comes back as a *PHError, with the lines before it.
+func (c *PHClient) Command(command string) ([]PHLine, error) {
+	if err := checkRequestLine(c.Host, c.Port, command); err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(PH_TIMEOUT))
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", command); err != nil {
+		c.broken = true
+		return nil, fmt.Errorf("PH write failed: %w", err)
+	}
 
-	} else {
-		greeting, err := PHInitialGreeting(host, port)
+	var lines []PHLine
+	for len(lines) < PH_MAX_LINES {
+		text, err := c.reader.ReadString('\n')
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			return
+			c.broken = true
+			return lines, fmt.Errorf("PH read failed: %w", err)
+		}
+		line, err := parsePHLine(text)
+		if err != nil {
+			c.broken = true
+			return lines, err
+		}
+		lines = append(lines, line)
+
+		// a response ends with the first line whose code is 200 or more
+		if line.Code >= PH_OK {
+			if line.Code >= PH_TEMP_ERROR {
+				return lines, &PHError{Code: line.Code, Text: line.Text}
+			}
+			return lines, nil
 		}
-
-		content = greeting
 	}
+	return lines, errors.New("PH response is too long")
+}
 
-	html := formatPHPage(host, port, content, returnURL)
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
+// Status returns the server's message of the day and status.
+func (c *PHClient) Status() ([]string, error) {
+	lines, err := c.Command("status")
+	var messages []string
+	for _, line := range lines {
+		messages = append(messages, line.Text)
+	}
+	return messages, err
 }
 
-func PHQuery(host, port, query string) (string, error) {
-	address := net.JoinHostPort(host, port)
+// SiteInfo returns the server's site information: version, mail domain,
+// administrator, and so on. Older servers don't know the command.
+func (c *PHClient) SiteInfo() ([]PHField, error) {
+	lines, err := c.Command("siteinfo")
+	if err != nil {
+		return nil, err
+	}
+	var info []PHField
+	for _, line := range lines {
+		if line.Field != "" {
+			info = append(info, PHField{Name: line.Field, Value: line.Text})
+		}
+	}
+	return info, nil
+}
 
-	conn, err := net.DialTimeout("tcp", address, PH_TIMEOUT)
+// -----------------------------------------------------------
+// (c) Fields() -> field descriptions, error
+//
+// Each field comes as two lines with the field's id as the
+// index: "max 32 Indexed Lookup Public Default", then a line
+// of description. Some servers continue the description over
+// more lines.
+// -----------------------------------------------------------
+func (c *PHClient) Fields() ([]*PHFieldInfo, error) {
+	lines, err := c.Command("fields")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer conn.Close()
 
-	conn.SetDeadline(time.Now().Add(PH_TIMEOUT))
-	reader := bufio.NewReader(conn)
+	var fields []*PHFieldInfo
+	byName := map[string]*PHFieldInfo{}
+	for _, line := range lines {
+		if line.Field == "" || line.Code != -PH_OK {
+			continue
+		}
+		f := byName[line.Field]
+		if f == nil {
+			f = &PHFieldInfo{ID: line.Index, Name: line.Field}
+			words := strings.Fields(line.Text)
+			for i := 0; i < len(words); i++ {
+				if words[i] == "max" && i+1 < len(words) {
+					f.Max, _ = strconv.Atoi(words[i+1])
+					i++
+					continue
+				}
+				f.Keywords = append(f.Keywords, words[i])
+			}
+			byName[line.Field] = f
+			fields = append(fields, f)
+			continue
+		}
+		f.Description = strings.TrimSpace(f.Description + " " + line.Text)
+	}
+	return fields, nil
+}
 
-	// Read greeting (and ignore content)
-	_, err = reader.ReadString('\n')
+// -----------------------------------------------------------
+// (c) Query(query, returnFields) -> entries, error
+//
+// Sends "query ... [return field ...]" and groups the result
+// lines by entry index. Lines with an empty field name carry
+// on the value before them, as multi-line fields do. Lines
+// with an error code (a field the entry doesn't have) are
+// left out. No matches comes back as a PHError with code
+// PH_NO_MATCHES.
+// -----------------------------------------------------------
+func (c *PHClient) Query(query string, returnFields []string) ([]*PHEntry, error) {
+	command := "query " + query
+	if len(returnFields) > 0 {
+		command += " return " + strings.Join(returnFields, " ")
+	}
+	lines, err := c.Command(command)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Send query
-	fmt.Fprintf(conn, "query %s\r\n", query)
-
-	// Read response
-	var out strings.Builder
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) > 0 {
-			out.WriteString(line)
+	var entries []*PHEntry
+	for _, line := range lines {
+		if line.Index == 0 || line.Code != -PH_OK {
+			continue
 		}
-		if err != nil {
-			break
+		if len(entries) == 0 || entries[len(entries)-1].Index != line.Index {
+			entries = append(entries, &PHEntry{Index: line.Index})
+		}
+		entry := entries[len(entries)-1]
+
+		if line.Field == "" && len(entry.Fields) > 0 {
+			last := &entry.Fields[len(entry.Fields)-1]
+			last.Value += "\n" + line.Text
+			continue
 		}
+		entry.Fields = append(entry.Fields, PHField{Name: line.Field, Value: line.Text})
 	}
+	return entries, nil
+}
 
-	return strings.TrimSpace(out.String()), nil
+// -----------------------------------------------------------
+// Login(alias, password) -> error
+//
+// "login alias" gets a 301 challenge, which the server
+// expects back either encrypted with the password ("answer")
+// or not at all, with the password sent as is ("clear"). The
+// encryption is qi's own, keyed with crypt(3) of the password,
+// so gofer uses "clear"; servers that refuse it answer with
+// an error, which comes back as a PHError.
+// -----------------------------------------------------------
+func (c *PHClient) Login(alias, password string) error {
+	lines, err := c.Command("login " + phQuote(alias))
+	if err != nil {
+		return err
+	}
+	if last := lines[len(lines)-1]; last.Code != PH_CHALLENGE {
+		return fmt.Errorf("PH login got %d instead of a challenge: %s", last.Code, last.Text)
+	}
+	if _, err := c.Command("clear " + phQuote(password)); err != nil {
+		return err
+	}
+	c.Alias = alias
+	return nil
 }
 
-// HTML UI formatting function
-func formatPHPage(host, port, content, returnURL string) string {
-	var html strings.Builder
-
-	html.WriteString(fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>gofer PhClient - %s:%s</title>
-			<style>
-
-				:root { color-scheme: light dark; }
-
-				body {
-					font-family: monospace;
-					line-height: 1.4;
-					width: 100ch;
-					margin: 0 auto;
-					padding-bottom: 1ch;
-				}
-					
-				.return { 
-					margin-top: 1ch;
-				} 
-
-				.query-bar {
-					width: 100%%;
-					margin: 1ch 0 1ch 0;		
-				}
-				
-				.query-bar form {
-        			display: flex; /* Activate Flexbox */
-        			width: 100%%; /* Ensure the form uses the full 100ch of .query-bar */
-        			align-items: center; /* Vertically center the text and input */
-    			}
-
-				.query-label {
-					font-size: 1.5em;
-					font-weight: bold;
-					padding: 0 1ch 0 0;
-					flex-shrink: 0;
-				}
+// Logout ends the login; the connection carries on anonymously.
+func (c *PHClient) Logout() error {
+	_, err := c.Command("logout")
+	if err == nil {
+		c.Alias = ""
+	}
+	return err
+}
 
-				input[type="text"] {
-					font-family: monospace;
-					font-size: 1.5em;
-					font-weight: bold;
+// Change sets one field of the entry with the given alias, which has to be
+// our own unless the login has the server's hero privileges.
+func (c *PHClient) Change(alias, field, value string) error {
+	_, err := c.Command(fmt.Sprintf("change alias=%s make %s=%s", phQuote(alias), field, phQuote(value)))
+	return err
+}
 
-					flex-grow: 1;
-					min-width: 0; 
+// --- HTTP Handler ---
 
-					outline: 0;
-					caret-style: underscore;
-				}
+// -----------------------------------------------------------
+// HandlePH(w, r)
+//
+// Opens a Ph server: a GET shows its status and site
+// information, and a POST runs a query and shows the entries
+// found. Queries come from a form with an input per field the
+// server lets us search on, built from its "fields" answer,
+// or with mode=raw (or when the server won't describe its
+// fields) from a box that takes CSO syntax as typed. The
+// connection is kept in a session between requests, so a POST
+// can also log in or out, and once logged in, mode=edit shows
+// your own entry with a form for changing it. A GET with
+// export= runs the query in q= again and sends the entries as
+// a vCard, CSV, or JSON file.
+// -----------------------------------------------------------
+func HandlePH(w http.ResponseWriter, r *http.Request) {
+	host, port, err := ParsePHRoute(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 
-				pre {
-					width: 100%%;
-					padding: 0 0 1ch 0;
-					white-space: pre;
-				}
-				
-			</style>
-		</head>
-		<body>
+	returnURL := r.URL.Query().Get("return")
+	if returnURL == "" {
+		returnURL = "/"
+	}
 
-		<div class="query-bar">
-			<form method="POST">
-				<span class="query-label">query</span>
-				<input type="text" name="query" autofocus>
-			</form>
-		</div>
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+	}
+
+	session, err := openPHSession(w, r, host, port)
+	if servePinWarning(w, r, err) || servePolicyBlock(w, r, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer session.release()
+	client := session.client
 
-		<pre>%s</pre>
+	if format := r.URL.Query().Get("export"); format != "" {
+		servePHExport(w, client, format, r.URL.Query().Get("q"), strings.Fields(r.URL.Query().Get("show")))
+		return
+	}
 
-		<div class="return">
-			<a href="%s">Exit PhClient</a>
-		</div>
+	mode := r.FormValue("mode")
+	view := phView{
+		Greeting:   client.Greeting,
+		Raw:        mode == PH_MODE_RAW,
+		RawHref:    withParams(r, "mode", PH_MODE_RAW),
+		FieldsHref: withParams(r, "mode", PH_MODE_FIELDS),
+		EditHref:   withParams(r, "mode", PH_MODE_EDIT),
+	}
 
-		</body>
-		</html>
-`, host, port, content, returnURL))
+	fields, err := phFields(client)
+	if err != nil || len(phSearchable(fields)) == 0 {
+		view.Raw = true
+		view.Note = "This server doesn't describe its fields; type queries in CSO syntax."
+	} else if !view.Raw {
+		view.Form = newPHSearchForm(fields, r.PostForm)
+	}
+	err = nil
 
-	return html.String()
+	switch r.PostForm.Get("action") {
+	case PH_ACTION_LOGIN:
+		err = client.Login(strings.TrimSpace(r.PostForm.Get("alias")), r.PostForm.Get("password"))
+		if err == nil {
+			mode = PH_MODE_EDIT
+		}
+	case PH_ACTION_LOGOUT:
+		err = client.Logout()
+		mode = ""
+	case PH_ACTION_CHANGE:
+		var changed []string
+		changed, err = changePHEntry(client, fields, r.PostForm)
+		if len(changed) > 0 {
+			view.Saved = "Changed " + strings.Join(changed, ", ") + "."
+		} else if err == nil {
+			view.Saved = "Nothing to change."
+		}
+	case "":
+		if r.Method != http.MethodPost {
+			break
+		}
+		var returnFields []string
+		if view.Raw {
+			view.Query = strings.TrimSpace(r.PostForm.Get("query"))
+			if view.Query == "" {
+				err = errors.New("empty query")
+			}
+		} else {
+			view.Query, err = buildPHQuery(fields, phFormValues(r.PostForm))
+			returnFields = phReturnList(fields, r.PostForm["show"])
+		}
+		if err == nil {
+			view.Searched = true
+			view.Entries, err = client.Query(view.Query, returnFields)
+			if phErrorCode(err) == PH_NO_MATCHES {
+				err = nil
+			}
+			if len(view.Entries) > 0 {
+				view.Exports = phExportLinks(r, view.Query, returnFields)
+			}
+		}
+	}
+
+	if mode == PH_MODE_EDIT {
+		var editErr error
+		if client.Alias == "" {
+			editErr = errors.New("log in to change your entry")
+		} else {
+			view.Edit, editErr = phOwnEntry(client, fields)
+		}
+		if err != nil {
+			// keep what was typed, to fix and send again
+			for i, f := range view.Edit {
+				if values, ok := r.PostForm["field."+f.Name]; ok {
+					view.Edit[i].Value = values[0]
+				}
+			}
+		} else {
+			err = editErr
+		}
+	}
+	if view.Edit == nil && !view.Searched && err == nil {
+		view.Status, err = client.Status()
+		if err == nil {
+			// servers too old for siteinfo answer with an unknown command error
+			view.SiteInfo, _ = client.SiteInfo()
+		}
+	}
+	view.Alias = client.Alias
+	if err != nil {
+		view.Error = err.Error()
+	}
+
+	html := formatPHPage(host, port, view, returnURL)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+// phView is the data for the "ph" template.
+type phView struct {
+	pageHead
+	Greeting   []string
+	Status     []string
+	SiteInfo   []PHField
+	Raw        bool          // searching with the raw query box
+	Form       *phSearchForm // the field-by-field form otherwise
+	RawHref    string
+	FieldsHref string
+	EditHref   string
+	Note       string
+	Alias      string        // who the session is logged in as
+	Edit       []phEditField // your own entry, on the edit page
+	Saved      string        // what the last change did
+	Query      string
+	Searched   bool
+	Entries    []*PHEntry
+	Exports    []phExport
+	Error      string
+	Return     returnLink
+}
+
+// HTML UI formatting function
+func formatPHPage(host, port string, view phView, returnURL string) string {
+	view.pageHead = pageHead{Title: fmt.Sprintf("gofer PhClient - %s:%s", host, port)}
+	view.Return = returnLink{Href: returnURL, Label: "Exit PhClient"}
+	return renderString("ph", view)
 }
@@ -0,0 +1,381 @@
+// Ph export module for gofer 0.9
+// query results as vCards, CSV, and JSON, and links for the fields
+// that hold addresses and numbers
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// the formats of the export links on the results page
+const (
+	PH_EXPORT_VCARD3 = "vcard3"
+	PH_EXPORT_VCARD4 = "vcard4"
+	PH_EXPORT_CSV    = "csv"
+	PH_EXPORT_JSON   = "json"
+)
+
+const VCARD_LINE_OCTETS = 75 // longer lines are folded (RFC 6350, section 3.2)
+
+type phExportFormat struct {
+	contentType string
+	ext         string
+	encode      func([]*PHEntry) ([]byte, error)
+}
+
+var phExportFormats = map[string]phExportFormat{
+	PH_EXPORT_VCARD3: {"text/vcard; charset=utf-8", "vcf", func(e []*PHEntry) ([]byte, error) { return phVCards(e, "3.0"), nil }},
+	PH_EXPORT_VCARD4: {"text/vcard; charset=utf-8", "vcf", func(e []*PHEntry) ([]byte, error) { return phVCards(e, "4.0"), nil }},
+	PH_EXPORT_CSV:    {"text/csv; charset=utf-8", "csv", phCSV},
+	PH_EXPORT_JSON:   {"application/json", "json", phJSON},
+}
+
+// phExport is one export link.
+type phExport struct {
+	Label string
+	Href  string
+}
+
+// phExportLinks offers the results of a query in each export format. The
+// links run the query again, on the same session.
+func phExportLinks(r *http.Request, query string, returnFields []string) []phExport {
+	show := strings.Join(returnFields, " ")
+	link := func(format string) string {
+		return withParams(r, "export", format, "q", query, "show", show)
+	}
+	return []phExport{
+		{Label: "vCard 3.0", Href: link(PH_EXPORT_VCARD3)},
+		{Label: "vCard 4.0", Href: link(PH_EXPORT_VCARD4)},
+		{Label: "CSV", Href: link(PH_EXPORT_CSV)},
+		{Label: "JSON", Href: link(PH_EXPORT_JSON)},
+	}
+}
+
+// -----------------------------------------------------------
+// servePHExport(w, c, format, query, returnFields)
+//
+// Runs the query and sends the entries as a download in the
+// format asked for. No matches is a 404, and other errors
+// from the server a 502, as they would be for a gopher item.
+// -----------------------------------------------------------
+func servePHExport(w http.ResponseWriter, c *PHClient, format, query string, returnFields []string) {
+	export, ok := phExportFormats[format]
+	if !ok {
+		http.Error(w, "Unknown export format: "+format, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(query) == "" {
+		http.Error(w, "Empty query", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := c.Query(query, returnFields)
+	if phErrorCode(err) == PH_NO_MATCHES {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	data, err := export.encode(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setRemoteHeaders(w, export.contentType)
+	setDownload(w, fmt.Sprintf("ph-%s.%s", c.Host, export.ext))
+	w.Write(data)
+}
+
+// phColumns lists every field name found in the entries, in the order they
+// first turn up, since entries needn't all have the same fields.
+func phColumns(entries []*PHEntry) []string {
+	var columns []string
+	seen := map[string]bool{}
+	for _, e := range entries {
+		for _, f := range e.Fields {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				columns = append(columns, f.Name)
+			}
+		}
+	}
+	return columns
+}
+
+// phCSV writes a header row of field names and a row per entry.
+func phCSV(entries []*PHEntry) ([]byte, error) {
+	var out bytes.Buffer
+	cw := csv.NewWriter(&out)
+	columns := phColumns(entries)
+	cw.Write(columns)
+	for _, e := range entries {
+		row := make([]string, len(columns))
+		for i, name := range columns {
+			row[i] = e.Get(name)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return out.Bytes(), cw.Error()
+}
+
+// phJSON writes an array with an object per entry, field names to values.
+func phJSON(entries []*PHEntry) ([]byte, error) {
+	objects := make([]map[string]string, 0, len(entries))
+	for _, e := range entries {
+		object := map[string]string{}
+		for _, f := range e.Fields {
+			if v, ok := object[f.Name]; ok {
+				object[f.Name] = v + "\n" + f.Value
+			} else {
+				object[f.Name] = f.Value
+			}
+		}
+		objects = append(objects, object)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false) // it's a file, not a page
+	enc.SetIndent("", "  ")
+	err := enc.Encode(objects)
+	return out.Bytes(), err
+}
+
+// --- vCards ---
+
+// phFieldKind sorts Ph fields into the kinds of contact data they hold, by
+// name, as the fields of a directory are named by convention only.
+func phFieldKind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "mail"):
+		return "email"
+	case strings.Contains(name, "fax"):
+		return "fax"
+	case strings.Contains(name, "phone") || name == "tel" || name == "telephone":
+		return "phone"
+	case name == "url" || name == "www" || strings.Contains(name, "home_page") || strings.Contains(name, "homepage"):
+		return "url"
+	case strings.Contains(name, "address"):
+		return "address"
+	}
+	return name
+}
+
+// phHome tells home numbers and addresses from the (default) work ones.
+func phHome(name string) bool {
+	return strings.Contains(strings.ToLower(name), "home")
+}
+
+// vcardEscape escapes a text value, or one component of a structured one.
+func vcardEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// vcardFold breaks a content line into lines of at most VCARD_LINE_OCTETS,
+// each continuation starting with a space, without splitting a character.
+func vcardFold(line string) string {
+	var out strings.Builder
+	limit := VCARD_LINE_OCTETS
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		out.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = VCARD_LINE_OCTETS - 1 // the leading space counts
+	}
+	out.WriteString(line)
+	out.WriteString("\r\n")
+	return out.String()
+}
+
+// vcardName splits a full name for the N property: "Last, First Middle",
+// or else the last word is taken as the family name.
+func vcardName(full string) string {
+	family, given := full, ""
+	if before, after, ok := strings.Cut(full, ","); ok {
+		family, given = strings.TrimSpace(before), strings.TrimSpace(after)
+	} else if i := strings.LastIndex(full, " "); i >= 0 {
+		family, given = full[i+1:], strings.TrimSpace(full[:i])
+	}
+	return vcardEscape(family) + ";" + vcardEscape(given) + ";;;"
+}
+
+// -----------------------------------------------------------
+// phVCard(entry, version) -> vCard
+//
+// Maps the fields with a vCard property of their own (name,
+// email, phones, addresses, URLs, title, department, alias)
+// and keeps the rest as X-PH- properties, so nothing is lost.
+// Versions 3.0 and 4.0 differ in how types are written and in
+// telephone numbers, which 4.0 gives as tel: URIs.
+// -----------------------------------------------------------
+func phVCard(e *PHEntry, version string) string {
+	var out strings.Builder
+	property := func(name, value string) {
+		out.WriteString(vcardFold(name + ":" + value))
+	}
+	typed := func(name, kind, value string) {
+		if version == "3.0" {
+			property(name+";TYPE="+strings.ToUpper(kind), value)
+		} else {
+			property(name+";TYPE="+kind, value)
+		}
+	}
+
+	property("BEGIN", "VCARD")
+	property("VERSION", version)
+
+	full := e.Get("name")
+	for _, fallback := range []string{"alias", "email"} {
+		if full == "" {
+			full = e.Get(fallback)
+		}
+	}
+	if full == "" {
+		full = fmt.Sprintf("entry %d", e.Index)
+	}
+	property("FN", vcardEscape(full))
+	property("N", vcardName(e.Get("name")))
+
+	for _, f := range e.Fields {
+		if f.Value == "" || f.Name == "name" {
+			continue
+		}
+		where := "work"
+		if phHome(f.Name) {
+			where = "home"
+		}
+		kind := phFieldKind(f.Name)
+		if kind == "url" && f.Link() == "" {
+			kind = "" // not a web address after all
+		}
+		switch kind {
+		case "email":
+			if version == "3.0" {
+				property("EMAIL;TYPE=INTERNET", vcardEscape(f.Value))
+			} else {
+				property("EMAIL", vcardEscape(f.Value))
+			}
+		case "phone", "fax":
+			if kind == "fax" {
+				where += ",fax"
+			} else {
+				where += ",voice"
+			}
+			if tel := phTelURI(f.Value); version == "4.0" && tel != "" {
+				typed("TEL;VALUE=uri", where, tel)
+			} else {
+				typed("TEL", where, vcardEscape(f.Value))
+			}
+		case "address":
+			// the whole address as the street, since Ph doesn't break it up
+			typed("ADR", where, ";;"+vcardEscape(f.Value)+";;;;")
+		case "url":
+			property("URL", string(f.Link()))
+		case "title":
+			property("TITLE", vcardEscape(f.Value))
+		case "department", "dept":
+			property("ORG", ";"+vcardEscape(f.Value))
+		case "alias":
+			property("NICKNAME", vcardEscape(f.Value))
+		default:
+			property("X-PH-"+phExtensionName(f.Name), vcardEscape(f.Value))
+		}
+	}
+	property("END", "VCARD")
+	return out.String()
+}
+
+// phExtensionName makes a field name fit for an X- property name, which
+// allows only letters, digits, and hyphens.
+func phExtensionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return r
+		}
+		return '-'
+	}, strings.ToUpper(name))
+}
+
+// phVCards writes one vCard per entry.
+func phVCards(entries []*PHEntry, version string) []byte {
+	var out bytes.Buffer
+	for _, e := range entries {
+		out.WriteString(phVCard(e, version))
+	}
+	return out.Bytes()
+}
+
+// --- Links ---
+
+// phTelURI makes a tel: URI of a phone number, keeping a leading + and the
+// digits, or returns "" if the value doesn't look like one number.
+func phTelURI(value string) string {
+	if strings.ContainsAny(value, "\n@") {
+		return ""
+	}
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(value) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.()/", r):
+		default:
+			return "" // letters (an extension, a note) or a second number
+		}
+	}
+	if n := strings.TrimPrefix(digits.String(), "+"); len(n) < 3 {
+		return ""
+	}
+	return "tel:" + digits.String()
+}
+
+// -----------------------------------------------------------
+// (PHField) Link() -> URL
+//
+// The link the results page makes of a field's value: mailto:
+// for email addresses, tel: for phone and fax numbers, and the
+// URL itself for web addresses. Values that don't look like
+// one address or number, by the field's kind, get no link.
+// -----------------------------------------------------------
+func (f PHField) Link() template.URL {
+	value := strings.TrimSpace(f.Value)
+	if value == "" || strings.ContainsAny(value, "\r\n") {
+		return ""
+	}
+	switch phFieldKind(f.Name) {
+	case "email":
+		if strings.Count(value, "@") == 1 && !strings.ContainsAny(value, " \t<>\"") {
+			return template.URL("mailto:" + url.PathEscape(value))
+		}
+	case "phone", "fax":
+		return template.URL(phTelURI(value))
+	}
+
+	if strings.HasPrefix(strings.ToLower(value), "www.") {
+		value = "http://" + value
+	}
+	if u, err := url.Parse(value); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return template.URL(u.String())
+	}
+	return ""
+}
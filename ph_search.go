@@ -0,0 +1,243 @@
+// Ph search form module for gofer 0.9
+// builds CSO queries from the fields a Ph server describes
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PH_FIELDS_TTL = 15 * time.Minute // how long a server's field list is trusted
+const PH_WILDCARDS = "*+?["
+
+// the two ways of searching on the "ph" page
+const (
+	PH_MODE_FIELDS = "fields" // a form with an input per field
+	PH_MODE_RAW    = "raw"    // a query typed in CSO syntax
+)
+
+// field lists by server address, so the form doesn't cost a "fields" command each time
+var phFieldsMux sync.Mutex
+var phFieldCache = map[string]phCachedFields{}
+
+type phCachedFields struct {
+	fields  []*PHFieldInfo
+	fetched time.Time
+}
+
+// phFields returns the server's field descriptions, from the cache if they're fresh.
+func phFields(c *PHClient) ([]*PHFieldInfo, error) {
+	address := net.JoinHostPort(c.Host, c.Port)
+
+	phFieldsMux.Lock()
+	cached, ok := phFieldCache[address]
+	phFieldsMux.Unlock()
+	if ok && time.Since(cached.fetched) < PH_FIELDS_TTL {
+		return cached.fields, nil
+	}
+
+	fields, err := c.Fields()
+	if err != nil {
+		return nil, err
+	}
+	phFieldsMux.Lock()
+	phFieldCache[address] = phCachedFields{fields: fields, fetched: time.Now()}
+	phFieldsMux.Unlock()
+	return fields, nil
+}
+
+// phSearchable returns the fields a query may select on (those marked
+// Lookup), indexed ones first, since every query needs one of them.
+func phSearchable(fields []*PHFieldInfo) []*PHFieldInfo {
+	var indexed, others []*PHFieldInfo
+	for _, f := range fields {
+		switch {
+		case f.Has("Indexed") && f.Has("Lookup"):
+			indexed = append(indexed, f)
+		case f.Has("Lookup"):
+			others = append(others, f)
+		}
+	}
+	return append(indexed, others...)
+}
+
+// phViewable returns the fields a return clause may ask for: the Public
+// ones, or, on servers that mark none, every field that isn't encrypted.
+func phViewable(fields []*PHFieldInfo) []*PHFieldInfo {
+	var public, unencrypted []*PHFieldInfo
+	for _, f := range fields {
+		if f.Has("Public") {
+			public = append(public, f)
+		}
+		if !f.Has("Encrypt") && !f.Has("Private") {
+			unencrypted = append(unencrypted, f)
+		}
+	}
+	if len(public) > 0 {
+		return public
+	}
+	return unencrypted
+}
+
+// -----------------------------------------------------------
+// phQuote(value) -> value for a command line
+//
+// Values with spaces or other delimiters, quotes, backslashes,
+// or "=" go in double quotes, with \n, \t, \" and \\ escaped
+// as RFC 2378 describes. Wildcards are left alone either way.
+// -----------------------------------------------------------
+func phQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"\\=,;:") {
+		return value
+	}
+	return `"` + phEscaper.Replace(value) + `"`
+}
+
+var phEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", "")
+
+// phWords splits a value at the characters Ph doesn't index.
+func phWords(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == ',' || r == ';' || r == ':'
+	})
+}
+
+// -----------------------------------------------------------
+// buildPHQuery(fields, values) -> query, error
+//
+// Turns the search form into the selection part of a query.
+// Ph matches word by word, so each word of a value becomes
+// a field=word term of its own (terms are ANDed); a value
+// typed in double quotes is sent as one quoted phrase, which
+// the server matches whole. At least one indexed field has to
+// be filled in, and fields marked NoMeta refuse wildcards.
+// -----------------------------------------------------------
+func buildPHQuery(fields []*PHFieldInfo, values map[string]string) (string, error) {
+	var terms, indexed []string
+	haveIndexed := false
+
+	for _, f := range phSearchable(fields) {
+		if f.Has("Indexed") {
+			indexed = append(indexed, f.Name)
+		}
+		value := strings.TrimSpace(values[f.Name])
+		if value == "" {
+			continue
+		}
+		if f.Max > 0 && len(value) > f.Max {
+			return "", fmt.Errorf("%s can be at most %d characters", f.Name, f.Max)
+		}
+		if f.Has("NoMeta") && strings.ContainsAny(value, PH_WILDCARDS) {
+			return "", fmt.Errorf("the server doesn't allow wildcards in %s", f.Name)
+		}
+
+		before := len(terms)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			terms = append(terms, f.Name+"="+`"`+phEscaper.Replace(value[1:len(value)-1])+`"`)
+		} else {
+			for _, word := range phWords(value) {
+				terms = append(terms, f.Name+"="+phQuote(word))
+			}
+		}
+		if f.Has("Indexed") && len(terms) > before {
+			haveIndexed = true
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("fill in at least one field to search")
+	}
+	if len(indexed) > 0 && !haveIndexed {
+		return "", fmt.Errorf("fill in at least one indexed field (%s)", strings.Join(indexed, ", "))
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// phReturnList checks the fields chosen for the return clause against the
+// server's list; "all" asks for every field the server will show.
+func phReturnList(fields []*PHFieldInfo, chosen []string) []string {
+	var list []string
+	for _, name := range chosen {
+		if name == "all" {
+			return []string{"all"}
+		}
+		for _, f := range phViewable(fields) {
+			if f.Name == name {
+				list = append(list, name)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// --- Form View ---
+
+// phFormField is one input of the search form.
+type phFormField struct {
+	Name        string
+	Description string
+	Value       string
+	Max         int
+	Indexed     bool
+}
+
+// phReturnChoice is one checkbox of the return fields chooser.
+type phReturnChoice struct {
+	Name    string
+	Checked bool
+}
+
+// phSearchForm is the field-by-field search form on the "ph" page.
+type phSearchForm struct {
+	Fields    []phFormField
+	Returns   []phReturnChoice
+	ReturnAll bool
+}
+
+// newPHSearchForm builds the form, filled in from the last search if there
+// was one; before any search the server's Default fields are checked.
+func newPHSearchForm(fields []*PHFieldInfo, form url.Values) *phSearchForm {
+	searched := form.Get("mode") == PH_MODE_FIELDS
+	chosen := map[string]bool{}
+	for _, name := range form["show"] {
+		chosen[name] = true
+	}
+
+	view := &phSearchForm{ReturnAll: chosen["all"]}
+	for _, f := range phSearchable(fields) {
+		view.Fields = append(view.Fields, phFormField{
+			Name:        f.Name,
+			Description: f.Description,
+			Value:       form.Get("field." + f.Name),
+			Max:         f.Max,
+			Indexed:     f.Has("Indexed"),
+		})
+	}
+	for _, f := range phViewable(fields) {
+		checked := chosen[f.Name]
+		if !searched {
+			checked = f.Has("Default")
+		}
+		view.Returns = append(view.Returns, phReturnChoice{Name: f.Name, Checked: checked})
+	}
+	return view
+}
+
+// phFormValues collects the field inputs of a submitted search form.
+func phFormValues(form url.Values) map[string]string {
+	values := map[string]string{}
+	for key := range form {
+		if name, ok := strings.CutPrefix(key, "field."); ok {
+			values[name] = form.Get(key)
+		}
+	}
+	return values
+}
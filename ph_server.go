@@ -0,0 +1,719 @@
+// Ph server module for gofer 0.9
+// "gofer ph-serve": the server side of CSO (RFC 2378) over a JSON or CSV
+// file, for small directories and for trying the Ph client out
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const PH_SERVE_IDLE = 5 * time.Minute // connections quiet this long are dropped
+const PH_SERVE_MAX_LINE = 8192        // longest command accepted
+const PH_SERVE_MAX_MATCHES = 200      // more than this is "too many matches"
+const PH_SERVE_FIELD_MAX = 256        // max length given to fields the data file doesn't describe
+
+// the result codes the server sends besides PH_OK and PH_NO_MATCHES (RFC 2378, appendix B)
+const (
+	PH_MATCH_COUNT     = 102
+	PH_TOO_MANY        = 502
+	PH_NOT_AUTHORIZED  = 503
+	PH_NO_FIELD        = 507
+	PH_NOT_PRESENT     = 508
+	PH_ILLEGAL_VALUE   = 512
+	PH_UNKNOWN_COMMAND = 514
+	PH_NO_INDEXED      = 515
+	PH_SYNTAX_ERROR    = 599
+)
+
+// phDirectory is the data a Ph server answers from.
+type phDirectory struct {
+	Status   []string // message of the day, sent before "Database ready."
+	SiteInfo []PHField
+	Fields   []*PHFieldInfo
+	Entries  []*PHEntry
+}
+
+// field looks up a field description by name, ignoring case.
+func (d *phDirectory) field(name string) *PHFieldInfo {
+	for _, f := range d.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------
+// phServe(args) -> error
+//
+// Runs "gofer ph-serve [-listen host:port] file". The file is
+// JSON or CSV, by its extension, and is read once at startup.
+// Like gofer itself, the server listens on loopback unless
+// told otherwise.
+// -----------------------------------------------------------
+func phServe(args []string) error {
+	flags := flag.NewFlagSet("ph-serve", flag.ExitOnError)
+	listen := flags.String("listen", net.JoinHostPort("127.0.0.1", PH_DEFAULT_PORT), "serve Ph on `host:port`")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gofer ph-serve [-listen host:port] directory.json|directory.csv")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dir, err := loadPHDirectory(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving %d Ph entries from %s on %s\n", len(dir.Entries), flags.Arg(0), ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go servePHConn(conn, dir)
+	}
+}
+
+// --- Data Files ---
+
+// -----------------------------------------------------------
+// loadPHDirectory(path) -> directory, error
+//
+// A CSV file has a header row of field names and a row per
+// entry. A JSON file is an array of entries (objects of field
+// names and values), or an object with "entries" and, if
+// wanted, "fields" (objects with "name", "max", "keywords"
+// as "fields" lists them, and "description"), "siteinfo" (an
+// object of names and values), and "status" (a message of
+// the day). Without "fields", every field found in the
+// entries can be searched and is returned by default.
+// -----------------------------------------------------------
+func loadPHDirectory(path string) (*phDirectory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := &phDirectory{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		dir.Entries, err = phCSVEntries(data)
+	case ".json":
+		err = phJSONDirectory(data, dir)
+	default:
+		return nil, fmt.Errorf("%s: the data file must be .json or .csv", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if dir.Fields == nil {
+		dir.Fields = phInferFields(dir.Entries)
+	}
+	for i, e := range dir.Entries {
+		e.Index = i + 1
+	}
+	return dir, nil
+}
+
+// phCSVEntries reads a header row of field names and an entry per row,
+// leaving out empty cells.
+func phCSVEntries(data []byte) ([]*PHEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no header row")
+	}
+
+	var entries []*PHEntry
+	header := rows[0]
+	for _, row := range rows[1:] {
+		e := &PHEntry{}
+		for i, value := range row {
+			if i < len(header) && strings.TrimSpace(value) != "" {
+				e.Fields = append(e.Fields, PHField{Name: phServeFieldName(header[i]), Value: value})
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// phServeFieldName makes a column heading or key into a field name, which
+// can't have spaces or punctuation: "office phone" becomes office_phone.
+func phServeFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && phAttribute.MatchString(string(r)) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+}
+
+// phJSONDirectory reads either form of JSON data file.
+func phJSONDirectory(data []byte, dir *phDirectory) error {
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		dir.Entries, err = phJSONEntries(trimmed)
+		return err
+	}
+
+	var file struct {
+		Entries  json.RawMessage
+		SiteInfo json.RawMessage
+		Status   string
+		Fields   []struct {
+			Name        string
+			Max         int
+			Keywords    string
+			Description string
+		}
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Entries == nil {
+		return errors.New(`no "entries"`)
+	}
+	if dir.Entries, err = phJSONEntries(file.Entries); err != nil {
+		return err
+	}
+	if file.SiteInfo != nil {
+		if dir.SiteInfo, err = phJSONObject(file.SiteInfo); err != nil {
+			return fmt.Errorf("siteinfo: %w", err)
+		}
+	}
+	if file.Status != "" {
+		dir.Status = strings.Split(file.Status, "\n")
+	}
+	for i, f := range file.Fields {
+		if !phAttribute.MatchString(f.Name) || f.Name == "" {
+			return fmt.Errorf("fields: %q isn't a usable field name", f.Name)
+		}
+		dir.Fields = append(dir.Fields, &PHFieldInfo{
+			ID:          i + 1,
+			Name:        f.Name,
+			Max:         f.Max,
+			Keywords:    strings.Fields(f.Keywords),
+			Description: f.Description,
+		})
+	}
+	return nil
+}
+
+// phJSONEntries reads an array of entry objects.
+func phJSONEntries(data []byte) ([]*PHEntry, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	entries := make([]*PHEntry, 0, len(raw))
+	for i, r := range raw {
+		fields, err := phJSONObject(r)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		entries = append(entries, &PHEntry{Fields: fields})
+	}
+	return entries, nil
+}
+
+// phJSONObject reads an object's members in the order they're written, which
+// a map would lose. Arrays become multi-line values, and null leaves the
+// field out.
+func phJSONObject(data []byte) ([]PHField, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // so 5551234 isn't 5.551234e+06
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("expected an object")
+	}
+
+	var fields []PHField
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name := phServeFieldName(t.(string)) // object keys are always strings
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if list, ok := value.([]any); ok {
+			lines := make([]string, len(list))
+			for i, v := range list {
+				lines[i] = fmt.Sprint(v)
+			}
+			fields = append(fields, PHField{Name: name, Value: strings.Join(lines, "\n")})
+		} else {
+			fields = append(fields, PHField{Name: name, Value: fmt.Sprint(value)})
+		}
+	}
+	return fields, nil
+}
+
+// phInferFields describes the fields of a data file that doesn't: each one
+// found, in the order found, searchable and returned by default.
+func phInferFields(entries []*PHEntry) []*PHFieldInfo {
+	var fields []*PHFieldInfo
+	for _, name := range phColumns(entries) {
+		if name == "" || !phAttribute.MatchString(name) {
+			continue
+		}
+		longest := PH_SERVE_FIELD_MAX
+		for _, e := range entries {
+			longest = max(longest, len(e.Get(name)))
+		}
+		fields = append(fields, &PHFieldInfo{
+			ID:          len(fields) + 1,
+			Name:        name,
+			Max:         longest,
+			Keywords:    []string{"Indexed", "Lookup", "Public", "Default"},
+			Description: name,
+		})
+	}
+	return fields
+}
+
+// --- Connections ---
+
+// phReply is what a command sends back: lines written out as they are built.
+type phReply struct {
+	out *bufio.Writer
+}
+
+// line writes "code:text", negative unless it ends the response.
+func (r *phReply) line(code int, text string) {
+	fmt.Fprintf(r.out, "%d:%s\r\n", code, text)
+}
+
+// field writes "code:index:name: value" for each line of the value, the
+// later ones with no name, as multi-line fields are sent.
+func (r *phReply) field(code, index int, width int, name, value string) {
+	for i, text := range strings.Split(value, "\n") {
+		if i > 0 {
+			name = ""
+		}
+		fmt.Fprintf(r.out, "%d:%d:%*s: %s\r\n", code, index, width, name, strings.TrimRight(text, "\r"))
+	}
+}
+
+// servePHConn answers commands on one connection until "quit" or idleness.
+func servePHConn(conn net.Conn, dir *phDirectory) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 1024), PH_SERVE_MAX_LINE)
+	reply := &phReply{out: bufio.NewWriter(conn)}
+
+	for {
+		conn.SetDeadline(time.Now().Add(PH_SERVE_IDLE))
+		if !scanner.Scan() {
+			return
+		}
+		if !dir.answer(reply, strings.TrimSpace(scanner.Text())) {
+			reply.out.Flush()
+			return
+		}
+		if reply.out.Flush() != nil {
+			return
+		}
+	}
+}
+
+// -----------------------------------------------------------
+// (d) answer(reply, command) -> keep going
+//
+// Runs one command. This server is read-only, so the commands
+// for logging in and changing entries are refused; everything
+// else it doesn't know gets 514, as from any server.
+// -----------------------------------------------------------
+func (d *phDirectory) answer(reply *phReply, command string) bool {
+	name, args, _ := strings.Cut(command, " ")
+	switch strings.ToLower(name) {
+	case "":
+		reply.line(PH_SYNTAX_ERROR, "Empty command.")
+	case "quit", "exit", "stop":
+		reply.line(PH_OK, "Bye!")
+		return false
+	case "status":
+		for _, text := range d.Status {
+			reply.line(-PH_OK, text)
+		}
+		reply.line(PH_OK, "Database ready.")
+	case "siteinfo":
+		for i, f := range d.SiteInfo {
+			reply.field(-PH_OK, i+1, 0, f.Name, f.Value)
+		}
+		reply.line(PH_OK, "Ok.")
+	case "fields":
+		d.answerFields(reply, strings.Fields(args))
+	case "query", "ph":
+		d.answerQuery(reply, args)
+	case "id":
+		reply.line(PH_OK, "Thanks.")
+	case "login", "answer", "clear", "change", "add", "delete":
+		reply.line(PH_NOT_AUTHORIZED, "This server is read-only.")
+	default:
+		reply.line(PH_UNKNOWN_COMMAND, "Unknown command.")
+	}
+	return true
+}
+
+// answerFields describes the named fields, or all of them.
+func (d *phDirectory) answerFields(reply *phReply, names []string) {
+	fields := d.Fields
+	if len(names) > 0 {
+		fields = nil
+		for _, name := range names {
+			f := d.field(name)
+			if f == nil {
+				reply.line(PH_NO_FIELD, "Field does not exist: "+name)
+				return
+			}
+			fields = append(fields, f)
+		}
+	}
+	for _, f := range fields {
+		reply.field(-PH_OK, f.ID, 0, f.Name, strings.TrimSpace(fmt.Sprintf("max %d %s", f.Max, strings.Join(f.Keywords, " "))))
+		reply.field(-PH_OK, f.ID, 0, f.Name, f.Description)
+	}
+	reply.line(PH_OK, "Ok.")
+}
+
+// --- Queries ---
+
+// phToken is one word of a command line, with quotes and escapes resolved.
+type phToken struct {
+	text  string
+	equal int // where an unquoted "=" splits it into field and value, or -1
+}
+
+// -----------------------------------------------------------
+// phTokens(line) -> tokens, error
+//
+// Splits a command's arguments at spaces outside of double
+// quotes. Inside quotes, \n, \t, \" and \\ are escapes, as in
+// RFC 2378; an "=" outside them makes the word a selector.
+// -----------------------------------------------------------
+func phTokens(line string) ([]phToken, error) {
+	var tokens []phToken
+	var current strings.Builder
+	inWord, quoted, equal := false, false, -1
+	finish := func() {
+		if inWord {
+			tokens = append(tokens, phToken{text: current.String(), equal: equal})
+		}
+		current.Reset()
+		inWord, equal = false, -1
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				current.WriteByte('\n')
+			case 't':
+				current.WriteByte('\t')
+			default:
+				current.WriteByte(line[i])
+			}
+		case c == '"':
+			quoted, inWord = !quoted, true
+		case !quoted && (c == ' ' || c == '\t'):
+			finish()
+		case !quoted && c == '=' && equal < 0:
+			equal, inWord = current.Len(), true
+			current.WriteByte(c)
+		default:
+			current.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	finish()
+	return tokens, nil
+}
+
+// phPattern compiles a value with Ph wildcards (* any characters, + one or
+// more, ? one, [set] one of a set) into a case-insensitive whole-word match.
+func phPattern(value string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '+':
+			expr.WriteString(".+")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(value[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			set := value[i+1 : i+1+end]
+			negate := strings.HasPrefix(set, "^") || strings.HasPrefix(set, "!")
+			if negate {
+				set = set[1:]
+			}
+			expr.WriteString("[")
+			if negate {
+				expr.WriteString("^")
+			}
+			for j := 0; j < len(set); j++ {
+				if set[j] == '-' && j > 0 && j < len(set)-1 {
+					expr.WriteByte('-')
+				} else {
+					expr.WriteString(regexp.QuoteMeta(string(set[j])))
+				}
+			}
+			expr.WriteString("]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// phSelector is one field=value term of a query; every word of the value
+// has to match a word of the field.
+type phSelector struct {
+	fields []*PHFieldInfo // one, or the default search fields for a bare value
+	words  []*regexp.Regexp
+}
+
+// matches reports whether an entry satisfies the selector.
+func (s phSelector) matches(e *PHEntry) bool {
+	for _, f := range s.fields {
+		value := e.Get(f.Name)
+		words := append(phWords(value), value)
+		all := true
+		for _, pattern := range s.words {
+			found := false
+			for _, w := range words {
+				if pattern.MatchString(w) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultSearch is what a value without a field name is matched against: the
+// name field, or every indexed field if there is no name.
+func (d *phDirectory) defaultSearch() []*PHFieldInfo {
+	if f := d.field("name"); f != nil && f.Has("Lookup") {
+		return []*PHFieldInfo{f}
+	}
+	var fields []*PHFieldInfo
+	for _, f := range d.Fields {
+		if f.Has("Indexed") && f.Has("Lookup") {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// -----------------------------------------------------------
+// (d) answerQuery(reply, args)
+//
+// "query [field=]value... [return field...|all]". Selectors
+// are ANDed, and at least one has to be on an indexed field.
+// Fields marked NoMeta take no wildcards. Without a return
+// clause the Default fields come back; "all" is every Public
+// field. Entries start with a 102 count; a returned field an
+// entry lacks gets a 508 line in its place.
+// -----------------------------------------------------------
+func (d *phDirectory) answerQuery(reply *phReply, args string) {
+	tokens, err := phTokens(args)
+	if err != nil {
+		reply.line(PH_SYNTAX_ERROR, "Syntax error: "+err.Error()+".")
+		return
+	}
+
+	var selectors []phSelector
+	var returnNames []string
+	indexed, returning := false, false
+	for _, t := range tokens {
+		if returning {
+			returnNames = append(returnNames, t.text)
+			continue
+		}
+		if t.equal < 0 && strings.EqualFold(t.text, "return") {
+			returning = true
+			continue
+		}
+
+		sel := phSelector{fields: d.defaultSearch()}
+		value := t.text
+		if t.equal >= 0 {
+			f := d.field(t.text[:t.equal])
+			if f == nil {
+				reply.line(PH_NO_FIELD, "Field does not exist: "+t.text[:t.equal])
+				return
+			}
+			if !f.Has("Lookup") {
+				reply.line(PH_NOT_AUTHORIZED, "Field can't be searched: "+f.Name)
+				return
+			}
+			sel.fields, value = []*PHFieldInfo{f}, t.text[t.equal+1:]
+		}
+		for _, f := range sel.fields {
+			if f.Has("NoMeta") && strings.ContainsAny(value, PH_WILDCARDS) {
+				reply.line(PH_ILLEGAL_VALUE, "Wildcards are not allowed in "+f.Name+".")
+				return
+			}
+			if f.Has("Indexed") {
+				indexed = true
+			}
+		}
+		for _, word := range phWords(value) {
+			pattern, err := phPattern(word)
+			if err != nil {
+				reply.line(PH_ILLEGAL_VALUE, "Illegal value: "+word)
+				return
+			}
+			sel.words = append(sel.words, pattern)
+		}
+		if len(sel.words) > 0 {
+			selectors = append(selectors, sel)
+		}
+	}
+	if len(selectors) == 0 {
+		reply.line(PH_SYNTAX_ERROR, "No selectors in query.")
+		return
+	}
+	if !indexed {
+		reply.line(PH_NO_INDEXED, "No indexed field in query.")
+		return
+	}
+
+	returned, code, text := d.returnFields(returnNames)
+	if code != 0 {
+		reply.line(code, text)
+		return
+	}
+
+	var found []*PHEntry
+	for _, e := range d.Entries {
+		all := true
+		for _, s := range selectors {
+			if !s.matches(e) {
+				all = false
+				break
+			}
+		}
+		if all {
+			found = append(found, e)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		reply.line(PH_NO_MATCHES, "No matches to your query.")
+		return
+	case len(found) > PH_SERVE_MAX_MATCHES:
+		reply.line(PH_TOO_MANY, "Too many entries to print.")
+		return
+	}
+
+	width := 0
+	for _, f := range returned {
+		width = max(width, len(f.Name))
+	}
+	if len(found) == 1 {
+		reply.line(PH_MATCH_COUNT, "There was 1 match to your request.")
+	} else {
+		reply.line(PH_MATCH_COUNT, fmt.Sprintf("There were %d matches to your request.", len(found)))
+	}
+	for i, e := range found {
+		for _, f := range returned {
+			if value := e.Get(f.Name); value != "" {
+				reply.field(-PH_OK, i+1, width, f.Name, value)
+			} else {
+				reply.field(-PH_NOT_PRESENT, i+1, width, f.Name, "Not present in entry.")
+			}
+		}
+	}
+	reply.line(PH_OK, "Ok.")
+}
+
+// returnFields resolves a return clause, or gives the code and text to refuse it.
+func (d *phDirectory) returnFields(names []string) ([]*PHFieldInfo, int, string) {
+	var fields []*PHFieldInfo
+	switch {
+	case len(names) == 0:
+		for _, f := range d.Fields {
+			if f.Has("Public") && f.Has("Default") {
+				fields = append(fields, f)
+			}
+		}
+	case len(names) == 1 && strings.EqualFold(names[0], "all"):
+		for _, f := range d.Fields {
+			if f.Has("Public") {
+				fields = append(fields, f)
+			}
+		}
+	default:
+		for _, name := range names {
+			f := d.field(name)
+			if f == nil {
+				return nil, PH_NO_FIELD, "Field does not exist: " + name
+			}
+			if !f.Has("Public") {
+				return nil, PH_NOT_AUTHORIZED, "Not authorized for requested information: " + f.Name
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields, 0, ""
+}
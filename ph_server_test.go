@@ -0,0 +1,313 @@
+// Ph server tests for gofer 0.9
+// "gofer ph-serve" data files, and its answers to the Ph client over loopback
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPHJSON = `{
+  "status": "Welcome to the staff directory.\nBe nice.",
+  "siteinfo": {"maildomain": "example.edu", "administrator": "ph@example.edu"},
+  "fields": [
+    {"name": "alias", "max": 32, "keywords": "Indexed Lookup Public Default", "description": "Unique name."},
+    {"name": "name", "max": 64, "keywords": "Indexed Lookup Public Default", "description": "Full name."},
+    {"name": "email", "max": 64, "keywords": "Lookup Public Default", "description": "Email address."},
+    {"name": "phone", "max": 32, "keywords": "Lookup Public NoMeta", "description": "Office phone."},
+    {"name": "address", "max": 200, "keywords": "Public", "description": "Office address."},
+    {"name": "ssn", "max": 11, "keywords": "Lookup", "description": "Not for the public."}
+  ],
+  "entries": [
+    {"alias": "jdoe", "name": "John Q. Doe", "email": "jdoe@example.edu", "phone": 5551234, "address": ["1 Main St", "Room 4"], "ssn": "123"},
+    {"alias": "asmith", "name": "Alice Smith", "email": "alice@example.edu", "phone": "555 9876"},
+    {"alias": "bdoe", "name": "Bob Doe", "email": null}
+  ]
+}`
+
+const testPHCSV = `name,email,office phone
+"Carl Johan Hedberg",cjh@example.se,+46 90 786 5000
+Par Hedberg,par@example.se,
+`
+
+// writePHFile saves a data file in the test's directory.
+func writePHFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPHDirectoryJSON(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.json", testPHJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dir.Status) != 2 || dir.Status[1] != "Be nice." {
+		t.Errorf("status = %q", dir.Status)
+	}
+	if len(dir.SiteInfo) != 2 || dir.SiteInfo[0] != (PHField{"maildomain", "example.edu"}) {
+		t.Errorf("siteinfo = %+v", dir.SiteInfo)
+	}
+	if len(dir.Fields) != 6 || dir.Fields[3].Name != "phone" || !dir.Fields[3].Has("NoMeta") || dir.Fields[3].ID != 4 {
+		t.Errorf("fields = %+v", dir.Fields)
+	}
+	if len(dir.Entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(dir.Entries))
+	}
+
+	jdoe, bdoe := dir.Entries[0], dir.Entries[2]
+	if jdoe.Index != 1 || bdoe.Index != 3 {
+		t.Errorf("indexes %d and %d, want 1 and 3", jdoe.Index, bdoe.Index)
+	}
+	if got := jdoe.Get("phone"); got != "5551234" {
+		t.Errorf("number read as %q", got)
+	}
+	if got := jdoe.Get("address"); got != "1 Main St\nRoom 4" {
+		t.Errorf("array read as %q", got)
+	}
+	if len(bdoe.Fields) != 2 {
+		t.Errorf("null wasn't left out: %+v", bdoe.Fields)
+	}
+}
+
+func TestLoadPHDirectoryCSV(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.csv", testPHCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range dir.Fields {
+		names = append(names, f.Name)
+		if !f.Has("Indexed") || !f.Has("Lookup") || !f.Has("Public") || !f.Has("Default") {
+			t.Errorf("inferred field %s has keywords %q", f.Name, f.Keywords)
+		}
+	}
+	if got := strings.Join(names, " "); got != "name email office_phone" {
+		t.Errorf("fields = %s", got)
+	}
+
+	if len(dir.Entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(dir.Entries))
+	}
+	if got := dir.Entries[0].Get("office_phone"); got != "+46 90 786 5000" {
+		t.Errorf("office_phone = %q", got)
+	}
+	if len(dir.Entries[1].Fields) != 2 {
+		t.Errorf("empty cell wasn't left out: %+v", dir.Entries[1].Fields)
+	}
+}
+
+func TestLoadPHDirectoryErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"staff.txt":    "name\nx\n",
+		"object.json":  `{"status": "no entries"}`,
+		"badname.json": `{"entries": [], "fields": [{"name": "full name"}]}`,
+		"entry.json":   `[{"name": "x"}, "not an object"]`,
+		"empty.csv":    "",
+	} {
+		if _, err := loadPHDirectory(writePHFile(t, name, data)); err == nil {
+			t.Errorf("%s loaded without an error", name)
+		}
+	}
+}
+
+// servePHDirectory serves dir on loopback and connects the Ph client to it.
+func servePHDirectory(t *testing.T, dir *phDirectory) *PHClient {
+	t.Helper()
+	host, port := listenLoopback(t, func(conn net.Conn) { servePHConn(conn, dir) })
+	c, err := DialPH(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// names lists the name field of each entry.
+func names(entries []*PHEntry) string {
+	var list []string
+	for _, e := range entries {
+		list = append(list, e.Get("name"))
+	}
+	return strings.Join(list, ", ")
+}
+
+func TestPHServeFields(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.json", testPHJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := servePHDirectory(t, dir)
+
+	fields, err := c.Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != len(dir.Fields) {
+		t.Fatalf("%d fields, want %d", len(fields), len(dir.Fields))
+	}
+	for i, f := range fields {
+		want := dir.Fields[i]
+		if f.ID != want.ID || f.Name != want.Name || f.Max != want.Max || f.Description != want.Description ||
+			strings.Join(f.Keywords, " ") != strings.Join(want.Keywords, " ") {
+			t.Errorf("field %d = %+v, want %+v", i, f, want)
+		}
+	}
+
+	if _, err := c.Command("fields nosuch"); phErrorCode(err) != PH_NO_FIELD {
+		t.Errorf("fields of an unknown field: %v, want a 507", err)
+	}
+}
+
+func TestPHServeQuery(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.json", testPHJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := servePHDirectory(t, dir)
+
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"doe", "John Q. Doe, Bob Doe"},
+		{"name=doe", "John Q. Doe, Bob Doe"},
+		{"name=DOE alias=b*", "Bob Doe"},
+		{"name=jo*", "John Q. Doe"},
+		{"name=j+", "John Q. Doe"},
+		{"alias=?doe", "John Q. Doe, Bob Doe"},
+		{"name=[ab]*", "Alice Smith, Bob Doe"},
+		{"name=[!ab]*", "John Q. Doe, Alice Smith, Bob Doe"},
+		{`name="q. doe"`, "John Q. Doe"},
+		{`name="doe john"`, "John Q. Doe"},
+		{`name="john q. doe"`, "John Q. Doe"},
+		{"name=smith email=alice@example.edu", "Alice Smith"},
+	} {
+		entries, err := c.Query(tt.query, []string{"name"})
+		if err != nil {
+			t.Errorf("query %s: %v", tt.query, err)
+			continue
+		}
+		if got := names(entries); got != tt.want {
+			t.Errorf("query %s = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := c.Query("name=nobody", nil); phErrorCode(err) != PH_NO_MATCHES {
+		t.Errorf("query without matches: %v, want a 501", err)
+	}
+}
+
+func TestPHServeReturn(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.json", testPHJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := servePHDirectory(t, dir)
+
+	fieldNames := func(e *PHEntry) string {
+		var list []string
+		for _, f := range e.Fields {
+			list = append(list, f.Name)
+		}
+		return strings.Join(list, " ")
+	}
+
+	entries, err := c.Query("alias=jdoe", nil)
+	if err != nil || len(entries) != 1 || fieldNames(entries[0]) != "alias name email" {
+		t.Errorf("default return: %v, %v", entries, err)
+	}
+
+	entries, err = c.Query("alias=jdoe", []string{"phone", "address"})
+	if err != nil || len(entries) != 1 || fieldNames(entries[0]) != "phone address" {
+		t.Fatalf("return phone address: %v, %v", entries, err)
+	}
+	if got := entries[0].Get("address"); got != "1 Main St\nRoom 4" {
+		t.Errorf("multi-line address came back as %q", got)
+	}
+
+	entries, err = c.Query("alias=jdoe", []string{"all"})
+	if err != nil || len(entries) != 1 || fieldNames(entries[0]) != "alias name email phone address" {
+		t.Errorf("return all: %v, %v", entries, err)
+	}
+
+	if _, err := c.Query("alias=jdoe", []string{"ssn"}); phErrorCode(err) != PH_NOT_AUTHORIZED {
+		t.Errorf("return of a private field: %v, want a 503", err)
+	}
+	if _, err := c.Query("alias=jdoe", []string{"nosuch"}); phErrorCode(err) != PH_NO_FIELD {
+		t.Errorf("return of an unknown field: %v, want a 507", err)
+	}
+}
+
+func TestPHServeErrors(t *testing.T) {
+	dir, err := loadPHDirectory(writePHFile(t, "staff.json", testPHJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= PH_SERVE_MAX_MATCHES; i++ {
+		dir.Entries = append(dir.Entries, &PHEntry{Index: len(dir.Entries) + 1, Fields: []PHField{
+			{"alias", fmt.Sprintf("many%d", i)}, {"name", "Many Person"},
+		}})
+	}
+	c := servePHDirectory(t, dir)
+
+	// entries lacking a returned field get a 508 line in its place
+	lines, err := c.Command("query name=doe return name email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing []string
+	for _, line := range lines {
+		if line.Code == -PH_NOT_PRESENT {
+			missing = append(missing, fmt.Sprintf("%d:%s", line.Index, line.Field))
+		}
+	}
+	if strings.Join(missing, " ") != "2:email" {
+		t.Errorf("508 lines for %q, want 2:email", missing)
+	}
+	if lines[0].Code != PH_MATCH_COUNT {
+		t.Errorf("first line %+v, want a 102 count", lines[0])
+	}
+
+	for _, tt := range []struct {
+		command string
+		code    int
+	}{
+		{"query name=many", PH_TOO_MANY},
+		{"query email=jdoe@example.edu", PH_NO_INDEXED},
+		{"query name=doe phone=555*", PH_ILLEGAL_VALUE},
+		{"query name=doe address=main", PH_NOT_AUTHORIZED},
+		{"query nosuch=x", PH_NO_FIELD},
+		{`query name="doe`, PH_SYNTAX_ERROR},
+		{"query return name", PH_SYNTAX_ERROR},
+		{"login jdoe", PH_NOT_AUTHORIZED},
+		{"change alias=jdoe make phone=1", PH_NOT_AUTHORIZED},
+		{"frobnicate", PH_UNKNOWN_COMMAND},
+	} {
+		if _, err := c.Command(tt.command); phErrorCode(err) != tt.code {
+			t.Errorf("%s: %v, want a %d", tt.command, err, tt.code)
+		}
+	}
+
+	// and the connection is still good afterwards
+	status, err := c.Status()
+	if err != nil || len(status) != 3 || status[0] != "Welcome to the staff directory." {
+		t.Errorf("status = %q, %v", status, err)
+	}
+	info, err := c.SiteInfo()
+	if err != nil || len(info) != 2 || info[1].Value != "ph@example.edu" {
+		t.Errorf("siteinfo = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,232 @@
+// Ph session module for gofer 0.9
+// keeps a Ph connection open across page loads, for logging in and
+// changing your own directory entry
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const PH_SESSION_COOKIE = "gofer_ph"
+const PH_SESSION_IDLE = 5 * time.Minute // servers drop idle connections themselves before long
+
+// things a POST to the "ph" page can do besides searching
+const (
+	PH_ACTION_LOGIN  = "login"
+	PH_ACTION_LOGOUT = "logout"
+	PH_ACTION_CHANGE = "change"
+)
+
+const PH_MODE_EDIT = "edit" // the page with your own entry's form
+
+// open connections by cookie
+var phSessionsMux sync.Mutex
+var phSessions = map[string]*phSession{}
+
+// phSession is a Ph connection kept for one browser. Only one request at a
+// time may use it, or their commands would interleave on the wire.
+type phSession struct {
+	id       string
+	client   *PHClient
+	lastUsed time.Time
+	inUse    sync.Mutex
+}
+
+// -----------------------------------------------------------
+// openPHSession(w, r, host, port) -> session, error
+//
+// Finds the session named by the request's cookie, or dials
+// the server and starts one, setting the cookie. The cookie's
+// path is the server's route, so each server has its own. A
+// session whose connection broke is replaced, which logs you
+// out; sessions left idle are closed along the way. The
+// caller has the session to itself until it calls release.
+// -----------------------------------------------------------
+func openPHSession(w http.ResponseWriter, r *http.Request, host, port string) (*phSession, error) {
+	address := net.JoinHostPort(host, port)
+	closeIdlePHSessions()
+
+	var s *phSession
+	if cookie, err := r.Cookie(PH_SESSION_COOKIE); err == nil {
+		phSessionsMux.Lock()
+		s = phSessions[cookie.Value]
+		if s != nil {
+			s.lastUsed = time.Now()
+		}
+		phSessionsMux.Unlock()
+	}
+	if s != nil {
+		s.inUse.Lock()
+		if !s.client.broken && net.JoinHostPort(s.client.Host, s.client.Port) == address {
+			return s, nil
+		}
+		s.inUse.Unlock()
+		s.close()
+	}
+
+	client, err := DialPH(host, port)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to generate Ph session id: %w", err)
+	}
+	s = &phSession{id: hex.EncodeToString(buf), client: client, lastUsed: time.Now()}
+	s.inUse.Lock()
+
+	phSessionsMux.Lock()
+	phSessions[s.id] = s
+	phSessionsMux.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     PH_SESSION_COOKIE,
+		Value:    s.id,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return s, nil
+}
+
+// release lets the next request use the session, or closes it if the
+// connection broke during this one.
+func (s *phSession) release() {
+	broken := s.client.broken
+	s.inUse.Unlock()
+	if broken {
+		s.close()
+	}
+}
+
+// close forgets the session and hangs up, once nobody is using it.
+func (s *phSession) close() {
+	phSessionsMux.Lock()
+	if phSessions[s.id] == s {
+		delete(phSessions, s.id)
+	}
+	phSessionsMux.Unlock()
+
+	s.inUse.Lock()
+	defer s.inUse.Unlock()
+	if !s.client.broken {
+		s.client.Close()
+		s.client.broken = true
+	}
+}
+
+// closeIdlePHSessions hangs up on sessions nobody has used for a while.
+func closeIdlePHSessions() {
+	var idle []*phSession
+	phSessionsMux.Lock()
+	for _, s := range phSessions {
+		if time.Since(s.lastUsed) > PH_SESSION_IDLE {
+			idle = append(idle, s)
+		}
+	}
+	phSessionsMux.Unlock()
+
+	for _, s := range idle {
+		go s.close()
+	}
+}
+
+// --- Account Actions ---
+
+// phEditField is one input of the form for changing your own entry.
+type phEditField struct {
+	Name        string
+	Description string
+	Value       string
+	Max         int
+	Long        bool // multi-line, or long enough to want a textarea
+}
+
+// -----------------------------------------------------------
+// phOwnEntry(c, fields) -> edit form, error
+//
+// Looks up the logged-in alias with "return all", which after
+// login includes fields that aren't public, and pairs the
+// values with the fields the server marks Change. Encrypted
+// fields (the password) are left off; the server never shows
+// them anyway.
+// -----------------------------------------------------------
+func phOwnEntry(c *PHClient, fields []*PHFieldInfo) ([]phEditField, error) {
+	entries, err := c.Query("alias="+phQuote(c.Alias), []string{"all"})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entry has the alias %s", c.Alias)
+	}
+
+	var form []phEditField
+	for _, f := range fields {
+		if !f.Has("Change") || f.Has("Encrypt") {
+			continue
+		}
+		value := entries[0].Get(f.Name)
+		form = append(form, phEditField{
+			Name:        f.Name,
+			Description: f.Description,
+			Value:       value,
+			Max:         f.Max,
+			Long:        strings.Contains(value, "\n") || f.Max > 128,
+		})
+	}
+	if len(form) == 0 {
+		return nil, errors.New("the server lists no fields you can change")
+	}
+	return form, nil
+}
+
+// -----------------------------------------------------------
+// changePHEntry(c, fields, form) -> changed fields, error
+//
+// Sends a "change" for each field of the edit form whose value
+// differs from the entry as it stands, one at a time so an
+// error names the field it's about. Stops at the first error;
+// the fields changed before it stay changed.
+// -----------------------------------------------------------
+func changePHEntry(c *PHClient, fields []*PHFieldInfo, form url.Values) ([]string, error) {
+	current, err := phOwnEntry(c, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, f := range current {
+		values, ok := form["field."+f.Name]
+		if !ok {
+			continue
+		}
+		value := strings.ReplaceAll(strings.TrimSpace(values[0]), "\r\n", "\n")
+		if value == f.Value {
+			continue
+		}
+		if f.Max > 0 && len(value) > f.Max {
+			return changed, fmt.Errorf("%s can be at most %d characters", f.Name, f.Max)
+		}
+		if err := c.Change(c.Alias, f.Name, value); err != nil {
+			return changed, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if f.Name == "alias" {
+			c.Alias = value // the login follows the entry
+		}
+		changed = append(changed, f.Name)
+	}
+	return changed, nil
+}
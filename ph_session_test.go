@@ -0,0 +1,337 @@
+// Ph session tests for gofer 0.9
+// logging in, changing an entry, and logging out, against a scripted server
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testPHDirectory has two entries; jdoe may change its phone and address.
+func testPHDirectory() *phDirectory {
+	return &phDirectory{
+		Fields: []*PHFieldInfo{
+			{ID: 1, Name: "alias", Max: 32, Keywords: []string{"Indexed", "Lookup", "Public", "Default"}, Description: "Unique name"},
+			{ID: 2, Name: "name", Max: 64, Keywords: []string{"Indexed", "Lookup", "Public", "Default"}, Description: "Full name"},
+			{ID: 3, Name: "phone", Max: 20, Keywords: []string{"Lookup", "Public", "Default", "Change"}, Description: "Phone"},
+			{ID: 4, Name: "address", Max: 200, Keywords: []string{"Public", "Change"}, Description: "Office address"},
+			{ID: 5, Name: "password", Max: 64, Keywords: []string{"Encrypt", "Change"}, Description: "Password"},
+		},
+		Entries: []*PHEntry{
+			{Index: 1, Fields: []PHField{{"alias", "jdoe"}, {"name", "John Doe"}, {"phone", "555-0100"}, {"address", "1 Main St"}}},
+			{Index: 2, Fields: []PHField{{"alias", "asmith"}, {"name", "Ann Smith"}, {"phone", "555-0199"}}},
+		},
+	}
+}
+
+// -----------------------------------------------------------
+// scriptedPH is a Ph server that lets jdoe log in and change
+// its own entry. Everything but login, clear, answer, change,
+// and logout is answered from dir, as ph-serve would. With
+// clearOK unset it only takes an encrypted answer, which gofer
+// doesn't send, so a login fails as on such servers.
+// -----------------------------------------------------------
+type scriptedPH struct {
+	dir      *phDirectory
+	password string
+	clearOK  bool
+
+	mu          sync.Mutex
+	connections int
+}
+
+func newScriptedPH() *scriptedPH {
+	return &scriptedPH{dir: testPHDirectory(), password: "s3cret pw", clearOK: true}
+}
+
+func (s *scriptedPH) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+
+	scanner := bufio.NewScanner(conn)
+	reply := &phReply{out: bufio.NewWriter(conn)}
+	pending, user := "", ""
+
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		name, args, _ := strings.Cut(command, " ")
+		tokens, _ := phTokens(args)
+
+		s.mu.Lock()
+		keepGoing := true
+		switch name {
+		case "login":
+			pending = ""
+			if len(tokens) == 1 {
+				pending = tokens[0].text
+			}
+			reply.line(PH_CHALLENGE, "qrstuvwx")
+		case "clear":
+			if s.clearOK && pending == "jdoe" && len(tokens) == 1 && tokens[0].text == s.password {
+				user = pending
+				reply.line(PH_OK, user+":Hi how are you?")
+			} else if !s.clearOK {
+				reply.line(500, "Clear-text passwords are not accepted.")
+			} else {
+				reply.line(500, "Login failed.")
+			}
+			pending = ""
+		case "answer":
+			reply.line(500, "Login failed.")
+			pending = ""
+		case "logout":
+			user = ""
+			reply.line(PH_OK, "Ok.")
+		case "change":
+			s.change(reply, user, tokens)
+		default:
+			keepGoing = s.dir.answer(reply, command)
+		}
+		s.mu.Unlock()
+
+		if reply.out.Flush() != nil || !keepGoing {
+			return
+		}
+	}
+}
+
+// change runs "change alias=who make field=value..." for the logged-in user.
+func (s *scriptedPH) change(reply *phReply, user string, tokens []phToken) {
+	if user == "" {
+		reply.line(506, "You must be logged in.")
+		return
+	}
+	if len(tokens) < 3 || tokens[0].text != "alias="+user || tokens[1].text != "make" {
+		reply.line(PH_NOT_AUTHORIZED, "You may only change your own entry.")
+		return
+	}
+
+	entry := s.dir.Entries[0]
+	for _, t := range tokens[2:] {
+		if t.equal < 0 {
+			reply.line(PH_SYNTAX_ERROR, "Syntax error.")
+			return
+		}
+		field, value := t.text[:t.equal], t.text[t.equal+1:]
+		if f := s.dir.field(field); f == nil || !f.Has("Change") {
+			reply.line(PH_NOT_AUTHORIZED, "Field can't be changed: "+field)
+			return
+		}
+		changed := false
+		for i := range entry.Fields {
+			if entry.Fields[i].Name == field {
+				entry.Fields[i].Value, changed = value, true
+			}
+		}
+		if !changed {
+			entry.Fields = append(entry.Fields, PHField{Name: field, Value: value})
+		}
+	}
+	reply.line(PH_OK, "1 entry changed.")
+}
+
+// get returns a field of jdoe's entry as the server holds it.
+func (s *scriptedPH) get(field string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir.Entries[0].Get(field)
+}
+
+func TestPHClientLoginChangeLogout(t *testing.T) {
+	server := newScriptedPH()
+	host, port := listenLoopback(t, server.serve)
+
+	c, err := DialPH(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Change("jdoe", "phone", "555-0101"); phErrorCode(err) != 506 {
+		t.Errorf("change before login: %v, want a 506", err)
+	}
+	if err := c.Login("jdoe", "wrong"); phErrorCode(err) != 500 || c.Alias != "" {
+		t.Errorf("login with a wrong password: %v, alias %q", err, c.Alias)
+	}
+	if err := c.Login("jdoe", "s3cret pw"); err != nil || c.Alias != "jdoe" {
+		t.Fatalf("login: %v, alias %q", err, c.Alias)
+	}
+
+	if err := c.Change("jdoe", "phone", "555-0101"); err != nil {
+		t.Errorf("change phone: %v", err)
+	}
+	if err := c.Change("jdoe", "address", "2 Main St\nUmea \"B\""); err != nil {
+		t.Errorf("change address: %v", err)
+	}
+	if got := server.get("phone"); got != "555-0101" {
+		t.Errorf("phone = %q after the change", got)
+	}
+	if got := server.get("address"); got != "2 Main St\nUmea \"B\"" {
+		t.Errorf("address = %q after the change", got)
+	}
+
+	entries, err := c.Query("alias=jdoe", []string{"all"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("query after the change: %d entries, %v", len(entries), err)
+	}
+	if got := entries[0].Get("address"); got != "2 Main St\nUmea \"B\"" {
+		t.Errorf("queried address = %q", got)
+	}
+
+	if err := c.Change("jdoe", "name", "Jon Doe"); phErrorCode(err) != PH_NOT_AUTHORIZED {
+		t.Errorf("change of a field without Change: %v, want a 503", err)
+	}
+	if err := c.Change("asmith", "phone", "0"); phErrorCode(err) != PH_NOT_AUTHORIZED {
+		t.Errorf("change of someone else's entry: %v, want a 503", err)
+	}
+
+	if err := c.Logout(); err != nil || c.Alias != "" {
+		t.Errorf("logout: %v, alias %q", err, c.Alias)
+	}
+	if err := c.Change("jdoe", "phone", "555-0102"); phErrorCode(err) != 506 {
+		t.Errorf("change after logout: %v, want a 506", err)
+	}
+}
+
+func TestPHClientLoginNoClear(t *testing.T) {
+	server := newScriptedPH()
+	server.clearOK = false
+	host, port := listenLoopback(t, server.serve)
+
+	c, err := DialPH(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Login("jdoe", "s3cret pw")
+	if phErrorCode(err) != 500 || !strings.Contains(err.Error(), "Clear-text passwords") || c.Alias != "" {
+		t.Errorf("login on a server without clear: %v, alias %q", err, c.Alias)
+	}
+}
+
+// phBrowser loads the "ph" page of a server, keeping cookies like a browser.
+type phBrowser struct {
+	t      *testing.T
+	client *http.Client
+	page   string
+}
+
+func newPHBrowser(t *testing.T, srv *httptest.Server, host, port string) *phBrowser {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &phBrowser{t: t, client: &http.Client{Jar: jar}, page: srv.URL + "/ph/" + host + ":" + port}
+}
+
+// load GETs the page (with a query string) or POSTs a form to it.
+func (b *phBrowser) load(query string, form url.Values) string {
+	b.t.Helper()
+	var resp *http.Response
+	var err error
+	if form == nil {
+		resp, err = b.client.Get(b.page + query)
+	} else {
+		resp, err = b.client.PostForm(b.page+query, form)
+	}
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestPHSessionLoginChangeLogout(t *testing.T) {
+	server := newScriptedPH()
+	host, port := listenLoopback(t, server.serve)
+	srv := httptest.NewServer(http.HandlerFunc(HandlePH))
+	t.Cleanup(func() {
+		srv.Close()
+		phSessionsMux.Lock()
+		var open []*phSession
+		for _, s := range phSessions {
+			open = append(open, s)
+		}
+		phSessionsMux.Unlock()
+		for _, s := range open {
+			s.close()
+		}
+	})
+
+	b := newPHBrowser(t, srv, host, port)
+	if page := b.load("", nil); !strings.Contains(page, `name="action" value="login"`) {
+		t.Fatalf("first page has no login form:\n%s", page)
+	}
+
+	page := b.load("", url.Values{"action": {PH_ACTION_LOGIN}, "alias": {"jdoe"}, "password": {"nope"}})
+	if !strings.Contains(page, "[ERR] Ph server error 500: Login failed.") {
+		t.Errorf("failed login isn't reported:\n%s", page)
+	}
+
+	page = b.load("", url.Values{"action": {PH_ACTION_LOGIN}, "alias": {" jdoe "}, "password": {"s3cret pw"}})
+	for _, want := range []string{
+		"logged in as <b>jdoe</b>",
+		`name="field.phone" value="555-0100"`,
+		`name="field.address" rows="4" maxlength="200">1 Main St</textarea>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page after login lacks %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "field.password") || strings.Contains(page, "field.name") {
+		t.Errorf("edit form offers fields that can't be changed:\n%s", page)
+	}
+
+	// another browser has a session (and a login) of its own
+	other := newPHBrowser(t, srv, host, port)
+	if page := other.load("?mode=edit", nil); !strings.Contains(page, "log in to change your entry") {
+		t.Errorf("a second browser shares the login:\n%s", page)
+	}
+
+	page = b.load("", url.Values{
+		"action":        {PH_ACTION_CHANGE},
+		"mode":          {PH_MODE_EDIT},
+		"field.phone":   {"555-0101"},
+		"field.address": {"2 Main St\r\nUmea"},
+	})
+	if !strings.Contains(page, "Changed phone, address.") {
+		t.Errorf("change isn't confirmed:\n%s", page)
+	}
+	if server.get("phone") != "555-0101" || server.get("address") != "2 Main St\nUmea" {
+		t.Errorf("server holds phone %q, address %q", server.get("phone"), server.get("address"))
+	}
+
+	page = b.load("", url.Values{"action": {PH_ACTION_CHANGE}, "mode": {PH_MODE_EDIT}, "field.phone": {"555-0101"}})
+	if !strings.Contains(page, "Nothing to change.") {
+		t.Errorf("unchanged form isn't reported:\n%s", page)
+	}
+
+	page = b.load("", url.Values{"action": {PH_ACTION_LOGOUT}})
+	if strings.Contains(page, "logged in as") || !strings.Contains(page, `name="action" value="login"`) {
+		t.Errorf("page after logout:\n%s", page)
+	}
+	if page := b.load("?mode=edit", nil); !strings.Contains(page, "log in to change your entry") {
+		t.Errorf("edit page after logout:\n%s", page)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.connections != 2 {
+		t.Errorf("%d connections for two browsers, want 2", server.connections)
+	}
+}
@@ -0,0 +1,306 @@
+// outbound policy for gofer 0.9
+// decides which hosts and ports gofer may connect to, so a menu link
+// can't turn the proxy against services on this machine or network
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const POLICY_FILE = "policy.json"
+
+// default ports: the gopher range, finger, ph, and anything unprivileged
+var defaultAllowedPorts = []string{"70-79", "105", "1024-65535"}
+
+// the telnet port, open to the terminal bridges (types 8 and T) only, so a
+// gopher selector from a menu can never be sent to it
+var terminalPorts = []portRange{{23, 23}}
+
+// default blocked networks: loopback, private, link-local, CGNAT, multicast, unspecified
+var defaultBlockedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// policyConfig is the on-disk form of the policy. Missing fields keep their defaults.
+type policyConfig struct {
+	AllowPorts    []string `json:"allow_ports"`    // "70", "1024-65535"
+	BlockNetworks []string `json:"block_networks"` // CIDRs
+	AllowHosts    []string `json:"allow_hosts"`    // "host", "host:port", "*.example.org"
+	DenyHosts     []string `json:"deny_hosts"`
+}
+
+type portRange struct {
+	low, high int
+}
+
+// OutboundPolicy is the parsed policy. Deny entries win over allow entries;
+// allowed hosts skip the port and network checks.
+type OutboundPolicy struct {
+	ports      []portRange
+	blocked    []*net.IPNet
+	allowHosts []string
+	denyHosts  []string
+	path       string // where the policy was loaded from, for error pages
+}
+
+// policy flags, merged into the file's lists at startup
+var policyAllowHosts, policyDenyHosts []string
+var policyAllowPrivate bool
+
+var policyOnce sync.Once
+var outboundPolicy *OutboundPolicy
+
+// currentPolicy loads the policy on first use.
+func currentPolicy() *OutboundPolicy {
+	policyOnce.Do(func() {
+		p, err := loadPolicy()
+		if err != nil {
+			fmt.Printf("Warning: %v; using the default outbound policy\n", err)
+			p, _ = newPolicy(policyConfig{})
+		}
+		outboundPolicy = p
+	})
+	return outboundPolicy
+}
+
+// loadPolicy reads policy.json from the user config directory (if present)
+// and applies the command-line flags on top.
+func loadPolicy() (*OutboundPolicy, error) {
+	var config policyConfig
+	var path string
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		path = filepath.Join(dir, "gofer", POLICY_FILE)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := json.Unmarshal(data, &config); err != nil {
+				return nil, fmt.Errorf("unreadable outbound policy %s: %w", path, err)
+			}
+		}
+	}
+
+	config.AllowHosts = append(config.AllowHosts, policyAllowHosts...)
+	config.DenyHosts = append(config.DenyHosts, policyDenyHosts...)
+	if policyAllowPrivate {
+		config.BlockNetworks = []string{}
+	}
+
+	p, err := newPolicy(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outbound policy %s: %w", path, err)
+	}
+	p.path = path
+	return p, nil
+}
+
+// newPolicy parses a config, filling in the defaults.
+func newPolicy(config policyConfig) (*OutboundPolicy, error) {
+	p := &OutboundPolicy{}
+
+	ports := config.AllowPorts
+	if ports == nil {
+		ports = defaultAllowedPorts
+	}
+	for _, spec := range ports {
+		r, err := parsePortRange(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.ports = append(p.ports, r)
+	}
+
+	networks := config.BlockNetworks
+	if networks == nil {
+		networks = defaultBlockedNetworks
+	}
+	for _, cidr := range networks {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", cidr)
+		}
+		p.blocked = append(p.blocked, n)
+	}
+
+	for _, h := range config.AllowHosts {
+		p.allowHosts = append(p.allowHosts, strings.ToLower(strings.TrimSpace(h)))
+	}
+	for _, h := range config.DenyHosts {
+		p.denyHosts = append(p.denyHosts, strings.ToLower(strings.TrimSpace(h)))
+	}
+	return p, nil
+}
+
+// parsePortRange reads "70" or "1024-65535".
+func parsePortRange(spec string) (portRange, error) {
+	spec = strings.TrimSpace(spec)
+	lo, hi, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		hi = lo
+	}
+	low, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	high, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+		return portRange{}, fmt.Errorf("invalid port range %q", spec)
+	}
+	return portRange{low, high}, nil
+}
+
+// PolicyError means the outbound policy refused a connection.
+type PolicyError struct {
+	Host   string
+	Port   string
+	Reason string
+	Policy string // the policy file, if any
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("connection to %s blocked by outbound policy: %s", net.JoinHostPort(e.Host, e.Port), e.Reason)
+}
+
+// matchHost compares host:port against a list of "host", "host:port", or "*.domain" entries.
+func matchHost(list []string, host, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range list {
+		pattern, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			pattern, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------
+// check(host, port) -> allowed, error
+//
+// The name-level half of the policy, applied before dialing.
+// allowed reports an explicit allow-list match, which also
+// skips the address check made once the name is resolved.
+// -----------------------------------------------------------
+func (p *OutboundPolicy) check(host, port string, extraPorts ...portRange) (bool, error) {
+	blocked := func(reason string) error {
+		return &PolicyError{Host: host, Port: port, Reason: reason, Policy: p.path}
+	}
+
+	if matchHost(p.denyHosts, host, port) {
+		return false, blocked("host is on the deny list")
+	}
+	if matchHost(p.allowHosts, host, port) {
+		return true, nil
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false, blocked("invalid port")
+	}
+	for _, r := range append(p.ports[:len(p.ports):len(p.ports)], extraPorts...) {
+		if n >= r.low && n <= r.high {
+			return false, nil
+		}
+	}
+	return false, blocked("port " + port + " is not in the allowed ranges")
+}
+
+// checkAddress refuses resolved addresses inside a blocked network.
+func (p *OutboundPolicy) checkAddress(host, port, address string) error {
+	ipText, _, err := net.SplitHostPort(address)
+	if err != nil {
+		ipText = address
+	}
+	ip := net.ParseIP(ipText)
+	if ip == nil {
+		return &PolicyError{Host: host, Port: port, Reason: "unresolvable address " + address, Policy: p.path}
+	}
+	for _, n := range p.blocked {
+		if n.Contains(ip) {
+			return &PolicyError{Host: host, Port: port, Reason: ip.String() + " is in blocked network " + n.String(), Policy: p.path}
+		}
+	}
+	return nil
+}
+
+// policyDialer returns a dialer that enforces the policy for host:port. The
+// address check runs on the resolved IP, so a name can't rebind past it.
+// extraPorts are allowed on top of the policy's ports for this dialer only.
+func policyDialer(host, port string, dialer *net.Dialer, extraPorts ...portRange) (*net.Dialer, error) {
+	p := currentPolicy()
+
+	allowed, err := p.check(host, port, extraPorts...)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			return p.checkAddress(host, port, address)
+		}
+	}
+	return dialer, nil
+}
+
+// checkRequestLine refuses selectors and queries that would smuggle extra
+// lines to the server; a gopher request is exactly one line.
+func checkRequestLine(host, port, request string) error {
+	if strings.ContainsAny(request, "\r\n") {
+		return &PolicyError{Host: host, Port: port, Reason: "request contains a line break", Policy: currentPolicy().path}
+	}
+	return nil
+}
+
+// --- HTTP Handler Helper ---
+
+// servePolicyBlock renders a blocked connection as a type-3 error menu.
+// It reports whether it handled the response.
+func servePolicyBlock(w http.ResponseWriter, r *http.Request, err error) bool {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	where := policyErr.Policy
+	if where == "" {
+		where = POLICY_FILE
+	}
+	address := net.JoinHostPort(policyErr.Host, policyErr.Port)
+
+	synthetic := fmt.Sprintf("3Connection to %s blocked by outbound policy: %s\t/\t%s\t%s\n", address, policyErr.Reason, policyErr.Host, policyErr.Port) +
+		fmt.Sprintf("iTo allow it, add %q to allow_hosts in %s\t\t\t\n", address, where) +
+		fmt.Sprintf("ior start gofer with -allow-host %s\t\t\t\n.\n", address)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(formatMenuHTML(synthetic, policyErr.Host, policyErr.Port, "", false)))
+	return true
+}
@@ -0,0 +1,151 @@
+// outbound policy tests for gofer 0.9
+// ports, networks, and request lines a menu link can't get past
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// withPolicy makes p the outbound policy until the test ends.
+func withPolicy(t *testing.T, config policyConfig) {
+	t.Helper()
+	p, err := newPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := currentPolicy()
+	outboundPolicy = p
+	t.Cleanup(func() { outboundPolicy = saved })
+}
+
+// wantPolicyError fails unless err is a PolicyError whose reason mentions reason.
+func wantPolicyError(t *testing.T, what string, err error, reason string) {
+	t.Helper()
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || !strings.Contains(policyErr.Reason, reason) {
+		t.Errorf("%s: err = %v, want a PolicyError about %q", what, err, reason)
+	}
+}
+
+func TestPolicyPorts(t *testing.T) {
+	p, err := newPolicy(policyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []string{"70", "79", "105", "1024", "65535"} {
+		if _, err := p.check("example.org", port); err != nil {
+			t.Errorf("port %s: %v", port, err)
+		}
+	}
+	for _, port := range []string{"22", "25", "80", "443", "1023", "0", "70000", "seventy"} {
+		_, err := p.check("example.org", port)
+		var policyErr *PolicyError
+		if !errors.As(err, &policyErr) {
+			t.Errorf("port %s: err = %v, want a PolicyError", port, err)
+		}
+	}
+
+	p, err = newPolicy(policyConfig{
+		AllowPorts: []string{"70"},
+		AllowHosts: []string{"trusted.example:25", "*.lan.example"},
+		DenyHosts:  []string{"bad.lan.example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.check("example.org", "7070"); err == nil {
+		t.Error("port 7070 allowed after allow_ports replaced the defaults")
+	}
+	if allowed, err := p.check("trusted.example", "25"); !allowed || err != nil {
+		t.Errorf("allow_hosts host:port entry: %v, %v", allowed, err)
+	}
+	if allowed, err := p.check("gopher.lan.example", "8080"); !allowed || err != nil {
+		t.Errorf("allow_hosts wildcard: %v, %v", allowed, err)
+	}
+	_, err = p.check("bad.lan.example", "70")
+	wantPolicyError(t, "deny list over a wildcard allow", err, "deny list")
+
+	for _, spec := range []string{"0", "80-70", "65536", "x-y"} {
+		if _, err := newPolicy(policyConfig{AllowPorts: []string{spec}}); err == nil {
+			t.Errorf("port range %q accepted", spec)
+		}
+	}
+}
+
+func TestPolicyBlocksResolvedAddress(t *testing.T) {
+	withPolicy(t, policyConfig{})
+
+	// a listener on this machine, reached by a name that resolves to loopback
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		dialer, err := policyDialer(host, port, &net.Dialer{Timeout: TCP_TIMEOUT})
+		if err != nil {
+			t.Fatalf("%s: the name-level check refused an allowed port: %v", host, err)
+		}
+		conn, err := dialer.Dial("tcp", net.JoinHostPort(host, port))
+		if err == nil {
+			conn.Close()
+		}
+		wantPolicyError(t, host, err, "blocked network")
+	}
+
+	p := currentPolicy()
+	for _, address := range []string{"10.1.2.3:70", "192.168.0.1:70", "169.254.169.254:70", "[::1]:70", "[fe80::1]:70", "100.64.0.1:70"} {
+		wantPolicyError(t, address, p.checkAddress("rebound.example", "70", address), "blocked network")
+	}
+	if err := p.checkAddress("example.org", "70", "93.184.216.34:70"); err != nil {
+		t.Errorf("public address: %v", err)
+	}
+}
+
+func TestCheckRequestLine(t *testing.T) {
+	withPolicy(t, policyConfig{})
+
+	for _, request := range []string{"/a\r\nSECOND", "/a\nSECOND", "/a\rb", "/s\tquery\n"} {
+		wantPolicyError(t, request, checkRequestLine("example.org", "70", request), "line break")
+
+		// refused before any connection is made
+		if _, err := openGopher("example.org", "70", request); err == nil {
+			t.Errorf("%q: openGopher sent it", request)
+		} else {
+			wantPolicyError(t, "openGopher "+request, err, "line break")
+		}
+	}
+	if err := checkRequestLine("example.org", "70", "/s\tquery\t+"); err != nil {
+		t.Errorf("tabs are part of a request line: %v", err)
+	}
+
+	g := &GopherURL{Host: "example.org", Port: "70", Type: '7', Selector: "/search", Search: "x\r\n/etc"}
+	if _, err := openGopherTarget(g); err == nil {
+		t.Error("a search string with a line break was sent")
+	}
+}
+
+func TestTelnetPortOnlyForTerminals(t *testing.T) {
+	p, err := newPolicy(policyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policyErr *PolicyError
+	if _, err := p.check("example.org", "23"); !errors.As(err, &policyErr) {
+		t.Errorf("gopher fetch to port 23: err = %v, want a PolicyError", err)
+	}
+	if _, err := p.check("example.org", "23", terminalPorts...); err != nil {
+		t.Errorf("terminal session to port 23: %v", err)
+	}
+	if _, err := p.check("example.org", "22", terminalPorts...); !errors.As(err, &policyErr) {
+		t.Errorf("terminal session to port 22: err = %v, want a PolicyError", err)
+	}
+}
@@ -6,12 +6,12 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"net"
+	"html/template"
 	"net/http"
 	"strings"
-	"time"
+
+	"gofer/gophermap"
 )
 
 func HandleSearch(w http.ResponseWriter, r *http.Request) {
@@ -33,7 +33,7 @@ func HandleSearch(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodGet:
 		// GET = search landing page (no TCP, no menu)
-		html := renderSearchFrame("", host, port, selector, returnURL)
+		html := renderSearchFrame("", host, port, "", returnURL)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write([]byte(html))
 		return
@@ -51,14 +51,29 @@ func HandleSearch(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		rawMenu, err := SearchQuery(host, port, selector, query)
+		// type + mirrors of the search server are tried after it
+		target := &GopherURL{Host: host, Port: port, Type: '7', Selector: selector, Search: query}
+		targets := append([]*GopherURL{target}, mirrorsFromQuery(r.URL.Query(), target)...)
+
+		items, answered, err := SearchQuery(targets)
+		if servePinWarning(w, r, err) || servePolicyBlock(w, r, err) {
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
 
-		menuHTML := formatMenuHTML(rawMenu, host, port, selector, true)
-		html := renderSearchFrame(menuHTML, host, port, selector, returnURL)
+		var tried []string
+		for _, t := range targets {
+			if t == answered {
+				break
+			}
+			tried = append(tried, t.Address())
+		}
+
+		menuHTML := formatItemsHTML(items, answered.Host, answered.Port, answered.Selector, true)
+		html := renderSearchFrame(menuHTML, host, port, mirrorNote(answered.Address(), tried), returnURL)
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write([]byte(html))
@@ -70,128 +85,32 @@ func HandleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func SearchQuery(host, port, selector, query string) (string, error) {
-	address := net.JoinHostPort(host, port)
-
-	conn, err := net.DialTimeout("tcp", address, TCP_TIMEOUT)
+// SearchQuery sends "selector<TAB>query" to the first of the targets that
+// answers and parses the resulting menu. It reports which target answered.
+func SearchQuery(targets []*GopherURL) ([]*gophermap.Item, *GopherURL, error) {
+	raw, answered, err := gopherRequestMirrors(targets)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	defer conn.Close()
 
-	conn.SetDeadline(time.Now().Add(TCP_TIMEOUT))
-	reader := bufio.NewReader(conn)
-
-	// Send query
-	fmt.Fprintf(conn, "%s\t%s\r\n", selector, query)
-
-	// Read response
-	var out strings.Builder
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) > 0 {
-			out.WriteString(line)
-		}
-		if err != nil {
-			break
-		}
-	}
+	items, err := gophermap.ParseMenuString(string(raw), gophermap.PARSE_LENIENT)
+	return items, answered, err
+}
 
-	return strings.TrimSpace(out.String()), nil
+// searchView is the data for the "search" template.
+type searchView struct {
+	pageHead
+	Results template.HTML // menu lines already rendered by the menu templates
+	Mirror  string        // which mirror answered, after a failover
+	Return  returnLink
 }
 
 // HTML UI formatting function
-func renderSearchFrame(innerHTML, host, port, selector, returnURL string) string {
-	var html strings.Builder
-
-	_ = selector
-
-	html.WriteString(fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>gofer search - %s:%s</title>
-			<style>
-
-				:root { color-scheme: light dark; }
-
-				body {
-					font-family: monospace;
-					line-height: 1.4;
-					width: 100ch;
-					margin: 0 auto;
-					padding-bottom: 1ch;
-				}
-				
-				.gopher-link { 
-					margin: 0;
-				 	white-space: pre;
-				} 
-
-				.gopher-link:last-child {
-					margin-bottom: 1ch;
-				}
-				
-				.return { 
-					margin-top: 1ch;
-				} 				
-				
-				.results { 
-					margin-top: 1ch;
-				} 
-
-				.query-bar {
-					width: 100%%;
-					margin: 1ch 0 1ch 0;		
-				}
-				
-				.query-bar form {
-        			display: flex; /* Activate Flexbox */
-        			width: 100%%; /* Ensure the form uses the full 100ch of .query-bar */
-        			align-items: center; /* Vertically center the text and input */
-    			}
-
-				.query-label {
-					font-size: 1.5em;
-					font-weight: bold;
-					padding: 0 1ch 0 0;
-					flex-shrink: 0;
-				}
-
-				input[type="text"] {
-					font-family: monospace;
-					font-size: 1.5em;
-					font-weight: bold;
-
-					flex-grow: 1;
-					min-width: 0; 
-
-					outline: 0;
-					caret-style: underscore;
-				}
-				
-			</style>
-		</head>
-		<body>
-
-		<div class="query-bar">
-			<form method="POST">
-				<span class="query-label">query</span>
-				<input type="text" name="query" autofocus>
-			</form>
-		</div>
-
-		<div class="results">
-			%s
-		</div>
-
-		<div class="return">
-			<a href="%s">Exit Search</a>
-		</div>
-
-		</body>
-		</html>
-`, host, port, innerHTML, returnURL))
-
-	return html.String()
+func renderSearchFrame(innerHTML, host, port, mirror, returnURL string) string {
+	return renderString("search", searchView{
+		pageHead: pageHead{Title: fmt.Sprintf("gofer search - %s:%s", host, port)},
+		Results:  template.HTML(innerHTML),
+		Mirror:   mirror,
+		Return:   returnLink{Href: returnURL, Label: "Exit Search"},
+	})
 }
@@ -0,0 +1,747 @@
+// legacy sound decoders for gofer 0.9
+// Sun .au, Creative .voc, WAV, and AIFF, decoded to 16-bit PCM for browsers
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const SOUND_MAX_SAMPLES = DECODE_MAX_SIZE / 2 // so the WAV gofer sends stays under the decode limit
+
+// sound is decoded audio: 16-bit samples, channels interleaved.
+type sound struct {
+	Rate     int
+	Channels int
+	Samples  []int16
+	Native   bool // the original is already a WAV every browser plays
+}
+
+// Frames is the number of samples per channel.
+func (s *sound) Frames() int {
+	return len(s.Samples) / s.Channels
+}
+
+// checkSoundFormat rejects sample rates and channel counts no real file has.
+func checkSoundFormat(rate, channels int) error {
+	if rate < 1 || rate > 768000 {
+		return fmt.Errorf("bad sample rate %d", rate)
+	}
+	if channels < 1 || channels > 8 {
+		return fmt.Errorf("%d channels aren't supported", channels)
+	}
+	return nil
+}
+
+var errSoundTruncated = errors.New("sound data is truncated")
+
+// --- Sample Encodings ---
+
+type sampleEncoding int
+
+const (
+	SAMPLE_U8 sampleEncoding = iota
+	SAMPLE_S8
+	SAMPLE_S16
+	SAMPLE_S24
+	SAMPLE_S32
+	SAMPLE_F32
+	SAMPLE_F64
+	SAMPLE_ULAW
+	SAMPLE_ALAW
+)
+
+var sampleBytes = [...]int{1, 1, 2, 3, 4, 4, 8, 1, 1}
+
+var sampleNames = [...]string{
+	"8-bit unsigned PCM", "8-bit signed PCM", "16-bit PCM", "24-bit PCM", "32-bit PCM",
+	"32-bit float", "64-bit float", "mu-law", "A-law",
+}
+
+// the G.711 tables, built once
+var ulawSamples, alawSamples = func() (ulaw, alaw [256]int16) {
+	for i := range ulaw {
+		u := ^byte(i)
+		t := (int(u&0x0F)<<3 + 0x84) << (u & 0x70 >> 4)
+		if u&0x80 != 0 {
+			ulaw[i] = int16(0x84 - t)
+		} else {
+			ulaw[i] = int16(t - 0x84)
+		}
+
+		a := byte(i) ^ 0x55
+		t = int(a&0x0F) << 4
+		switch seg := a & 0x70 >> 4; seg {
+		case 0:
+			t += 8
+		case 1:
+			t += 0x108
+		default:
+			t = (t + 0x108) << (seg - 1)
+		}
+		if a&0x80 != 0 {
+			alaw[i] = int16(t)
+		} else {
+			alaw[i] = int16(-t)
+		}
+	}
+	return ulaw, alaw
+}()
+
+// -----------------------------------------------------------
+// appendSamples(dst, in, enc, bigEndian) -> samples, error
+//
+// Converts raw samples to 16 bits and appends them. Wider
+// samples keep their top 16 bits; floats are scaled from
+// [-1, 1] and clipped. A partial sample at the end of the
+// input is dropped.
+// -----------------------------------------------------------
+func appendSamples(dst []int16, in []byte, enc sampleEncoding, bigEndian bool) ([]int16, error) {
+	size := sampleBytes[enc]
+	n := len(in) / size
+	if len(dst)+n > SOUND_MAX_SAMPLES {
+		return nil, errors.New("sound is too long to convert")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	// the most significant byte of a sample, and the one after it
+	hi, mid := size-1, size-2
+	if bigEndian {
+		hi, mid = 0, 1
+	}
+
+	for i := 0; i < n; i++ {
+		b := in[i*size : (i+1)*size]
+		var v int16
+		switch enc {
+		case SAMPLE_U8:
+			v = int16(int(b[0])-128) << 8
+		case SAMPLE_S8:
+			v = int16(int8(b[0])) << 8
+		case SAMPLE_S16, SAMPLE_S24, SAMPLE_S32:
+			v = int16(uint16(b[hi])<<8 | uint16(b[mid]))
+		case SAMPLE_F32:
+			v = floatSample(float64(math.Float32frombits(order.Uint32(b))))
+		case SAMPLE_F64:
+			v = floatSample(math.Float64frombits(order.Uint64(b)))
+		case SAMPLE_ULAW:
+			v = ulawSamples[b[0]]
+		case SAMPLE_ALAW:
+			v = alawSamples[b[0]]
+		}
+		dst = append(dst, v)
+	}
+	return dst, nil
+}
+
+func floatSample(f float64) int16 {
+	switch {
+	case f != f: // NaN
+		return 0
+	case f >= 1:
+		return math.MaxInt16
+	case f <= -1:
+		return -math.MaxInt16
+	}
+	return int16(f * math.MaxInt16)
+}
+
+// pcmEncoding picks the encoding for plain PCM of the given width.
+func pcmEncoding(bits int, signed8 bool) (sampleEncoding, error) {
+	switch bits {
+	case 8:
+		if signed8 {
+			return SAMPLE_S8, nil
+		}
+		return SAMPLE_U8, nil
+	case 16:
+		return SAMPLE_S16, nil
+	case 24:
+		return SAMPLE_S24, nil
+	case 32:
+		return SAMPLE_S32, nil
+	}
+	return 0, fmt.Errorf("%d-bit samples aren't supported", bits)
+}
+
+// --- Sun .au ---
+
+const (
+	AU_MAGIC       = ".snd"
+	AU_HEADER_SIZE = 24
+	AU_SIZE_UNSET  = 0xFFFFFFFF
+)
+
+// Sun's encoding numbers
+var auEncodings = map[uint32]sampleEncoding{
+	1: SAMPLE_ULAW, 2: SAMPLE_S8, 3: SAMPLE_S16, 4: SAMPLE_S24, 5: SAMPLE_S32,
+	6: SAMPLE_F32, 7: SAMPLE_F64, 27: SAMPLE_ALAW,
+}
+
+func isAU(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(AU_MAGIC))
+}
+
+// -----------------------------------------------------------
+// decodeAU(data) -> sound, details, error
+//
+// Sun and NeXT audio: a big-endian header giving the data
+// offset and size, the encoding, the rate, and the channels.
+// Most files are 8 kHz mu-law. A size of all ones means the
+// data runs to the end of the file.
+// -----------------------------------------------------------
+func decodeAU(data []byte) (*sound, string, error) {
+	if len(data) < AU_HEADER_SIZE {
+		return nil, "", errSoundTruncated
+	}
+	be := binary.BigEndian
+	offset, size := be.Uint32(data[4:]), be.Uint32(data[8:])
+	code, rate, channels := be.Uint32(data[12:]), int(be.Uint32(data[16:])), int(be.Uint32(data[20:]))
+
+	enc, ok := auEncodings[code]
+	if !ok {
+		return nil, "", fmt.Errorf("Sun audio encoding %d isn't supported", code)
+	}
+	if err := checkSoundFormat(rate, channels); err != nil {
+		return nil, "", err
+	}
+	if offset < AU_HEADER_SIZE || uint64(offset) > uint64(len(data)) {
+		return nil, "", errSoundTruncated
+	}
+	body := data[offset:]
+	if size != AU_SIZE_UNSET && uint64(size) < uint64(len(body)) {
+		body = body[:size]
+	}
+
+	samples, err := appendSamples(nil, body, enc, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return &sound{Rate: rate, Channels: channels, Samples: samples}, sampleNames[enc], nil
+}
+
+// decodeRawULaw reads a headerless .au file: the original Sun format,
+// 8 kHz mono mu-law with no header at all.
+func decodeRawULaw(data []byte) (*sound, string, error) {
+	samples, err := appendSamples(nil, data, SAMPLE_ULAW, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return &sound{Rate: 8000, Channels: 1, Samples: samples}, "headerless mu-law, taken as 8 kHz", nil
+}
+
+// --- Creative .voc ---
+
+const VOC_MAGIC = "Creative Voice File\x1A"
+
+// VOC block types
+const (
+	VOC_END       = 0
+	VOC_SOUND     = 1
+	VOC_CONTINUE  = 2
+	VOC_SILENCE   = 3
+	VOC_EXTENDED  = 8
+	VOC_NEW_SOUND = 9
+)
+
+func isVOC(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(VOC_MAGIC))
+}
+
+// vocCodec maps a VOC codec number and sample width to an encoding.
+func vocCodec(codec, bits int) (sampleEncoding, error) {
+	switch codec {
+	case 0:
+		if bits == 16 {
+			return SAMPLE_S16, nil
+		}
+		return SAMPLE_U8, nil
+	case 4:
+		return SAMPLE_S16, nil
+	case 6:
+		return SAMPLE_ALAW, nil
+	case 7:
+		return SAMPLE_ULAW, nil
+	case 1, 2, 3, 0x200:
+		return 0, errors.New("Creative ADPCM .voc files aren't supported")
+	}
+	return 0, fmt.Errorf("VOC codec %d isn't supported", codec)
+}
+
+// -----------------------------------------------------------
+// decodeVOC(data) -> sound, details, error
+//
+// Sound Blaster files are a run of blocks. Type 1 blocks give
+// the rate as a time constant (1000000 / (256 - d)) and hold
+// mono samples; a type 8 block before one overrides its rate
+// and can make it stereo. Type 9 blocks, from later versions,
+// give the rate, width, and channels outright. Continuations
+// and silences take the format of the block before them. The
+// first sound block sets the format for the whole file, and
+// repeat markers are ignored, so loops play once.
+// -----------------------------------------------------------
+func decodeVOC(data []byte) (*sound, string, error) {
+	if len(data) < 26 {
+		return nil, "", errSoundTruncated
+	}
+	s := &sound{}
+	var enc sampleEncoding
+	extRate, extChannels := 0, 0 // from a type 8 block, for the next type 1 block
+	haveFormat := false
+
+	// setFormat records the first block's format; later blocks reuse it
+	setFormat := func(rate, channels int, e sampleEncoding) error {
+		enc = e
+		if haveFormat {
+			return nil
+		}
+		if err := checkSoundFormat(rate, channels); err != nil {
+			return err
+		}
+		s.Rate, s.Channels, haveFormat = rate, channels, true
+		return nil
+	}
+
+	var err error
+	for i := max(26, int(binary.LittleEndian.Uint16(data[20:]))); i < len(data); {
+		kind := data[i]
+		if kind == VOC_END || i+4 > len(data) {
+			break
+		}
+		size := int(data[i+1]) | int(data[i+2])<<8 | int(data[i+3])<<16
+		body := data[i+4 : min(i+4+size, len(data))]
+		i += 4 + size
+
+		switch kind {
+		case VOC_SOUND:
+			if len(body) < 2 {
+				return nil, "", errSoundTruncated
+			}
+			rate, channels := 1000000/(256-int(body[0])), 1
+			if extRate > 0 {
+				rate, channels, extRate = extRate, extChannels, 0
+			}
+			e, err := vocCodec(int(body[1]), 8)
+			if err != nil {
+				return nil, "", err
+			}
+			if err := setFormat(rate, channels, e); err != nil {
+				return nil, "", err
+			}
+			body = body[2:]
+		case VOC_NEW_SOUND:
+			if len(body) < 12 {
+				return nil, "", errSoundTruncated
+			}
+			le := binary.LittleEndian
+			e, err := vocCodec(int(le.Uint16(body[6:])), int(body[4]))
+			if err != nil {
+				return nil, "", err
+			}
+			if err := setFormat(int(le.Uint32(body)), int(body[5]), e); err != nil {
+				return nil, "", err
+			}
+			body = body[12:]
+		case VOC_CONTINUE:
+			if !haveFormat {
+				continue
+			}
+		case VOC_SILENCE:
+			if !haveFormat || len(body) < 3 {
+				continue
+			}
+			n := (int(binary.LittleEndian.Uint16(body)) + 1) * s.Channels
+			if len(s.Samples)+n > SOUND_MAX_SAMPLES {
+				return nil, "", errors.New("sound is too long to convert")
+			}
+			s.Samples = append(s.Samples, make([]int16, n)...)
+			continue
+		case VOC_EXTENDED:
+			if len(body) >= 4 {
+				tc := int(binary.LittleEndian.Uint16(body))
+				extChannels = int(body[3]) + 1
+				extRate = 256000000 / ((65536 - tc) * extChannels)
+			}
+			continue
+		default: // markers, text, repeats
+			continue
+		}
+
+		if s.Samples, err = appendSamples(s.Samples, body, enc, false); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if !haveFormat {
+		return nil, "", errors.New("no sound data in this .voc file")
+	}
+	s.Samples = s.Samples[:s.Frames()*s.Channels]
+	return s, sampleNames[enc], nil
+}
+
+// --- WAV ---
+
+// WAVE format tags
+const (
+	WAV_PCM        = 0x0001
+	WAV_MS_ADPCM   = 0x0002
+	WAV_FLOAT      = 0x0003
+	WAV_ALAW       = 0x0006
+	WAV_ULAW       = 0x0007
+	WAV_IMA_ADPCM  = 0x0011
+	WAV_EXTENSIBLE = 0xFFFE // the real tag is the start of the subformat GUID
+)
+
+func isWAV(b []byte) bool {
+	return len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE"
+}
+
+// riffChunks returns the chunks of a RIFF or IFF file by ID, padded to even
+// sizes as both formats do. A chunk cut short by the end of the file is
+// returned as far as it goes.
+func riffChunks(data []byte, start int, order binary.ByteOrder) map[string][]byte {
+	chunks := map[string][]byte{}
+	for i := start; i+8 <= len(data); {
+		id := string(data[i : i+4])
+		size := int(order.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if size < 0 || end > len(data) || end < i {
+			end = len(data)
+		}
+		if _, seen := chunks[id]; !seen {
+			chunks[id] = data[i+8 : end]
+		}
+		i = end + end%2
+	}
+	return chunks
+}
+
+// -----------------------------------------------------------
+// decodeWAV(data) -> sound, details, error
+//
+// RIFF WAVE files: the fmt chunk gives the encoding, and the
+// data chunk holds the samples. 16-bit PCM is what browsers
+// play, so those files are marked Native and sent as they
+// are; 8-bit and wide PCM, floats, mu-law and A-law, and IMA
+// and Microsoft ADPCM are decoded.
+// -----------------------------------------------------------
+func decodeWAV(data []byte) (*sound, string, error) {
+	chunks := riffChunks(data, 12, binary.LittleEndian)
+	format, body := chunks["fmt "], chunks["data"]
+	if len(format) < 16 || body == nil {
+		return nil, "", errSoundTruncated
+	}
+
+	le := binary.LittleEndian
+	tag := int(le.Uint16(format))
+	channels, rate := int(le.Uint16(format[2:])), int(le.Uint32(format[4:]))
+	blockAlign, bits := int(le.Uint16(format[12:])), int(le.Uint16(format[14:]))
+	if tag == WAV_EXTENSIBLE && len(format) >= 26 {
+		tag = int(le.Uint16(format[24:]))
+	}
+	if err := checkSoundFormat(rate, channels); err != nil {
+		return nil, "", err
+	}
+
+	s := &sound{Rate: rate, Channels: channels}
+	var details string
+	var err error
+	switch tag {
+	case WAV_PCM, WAV_FLOAT, WAV_ALAW, WAV_ULAW:
+		var enc sampleEncoding
+		switch tag {
+		case WAV_PCM:
+			enc, err = pcmEncoding(bits, false)
+		case WAV_FLOAT:
+			enc, err = SAMPLE_F32, nil
+			if bits == 64 {
+				enc = SAMPLE_F64
+			}
+		case WAV_ALAW:
+			enc = SAMPLE_ALAW
+		case WAV_ULAW:
+			enc = SAMPLE_ULAW
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		s.Native = enc == SAMPLE_S16 && le.Uint16(format) == WAV_PCM
+		details = sampleNames[enc]
+		s.Samples, err = appendSamples(nil, body, enc, false)
+	case WAV_IMA_ADPCM:
+		details = "IMA ADPCM"
+		s.Samples, err = decodeIMAADPCM(body, channels, blockAlign)
+	case WAV_MS_ADPCM:
+		details = "Microsoft ADPCM"
+		s.Samples, err = decodeMSADPCM(body, channels, blockAlign)
+	default:
+		return nil, "", fmt.Errorf("WAV format 0x%04X isn't supported", tag)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	s.Samples = s.Samples[:s.Frames()*s.Channels]
+	return s, details, nil
+}
+
+// the step sizes and index changes shared by IMA ADPCM encoders
+var imaSteps = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17, 19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118, 130, 143, 157, 173, 190, 209, 230,
+	253, 279, 307, 337, 371, 408, 449, 494, 544, 598, 658, 724, 796, 876, 963,
+	1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066, 2272, 2499, 2749, 3024, 3327,
+	3660, 4026, 4428, 4871, 5358, 5894, 6484, 7132, 7845, 8630, 9493, 10442,
+	11487, 12635, 13899, 15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794,
+	32767,
+}
+
+var imaIndexChange = [8]int{-1, -1, -1, -1, 2, 4, 6, 8}
+
+func clamp16(v int) int {
+	return max(math.MinInt16, min(math.MaxInt16, v))
+}
+
+// -----------------------------------------------------------
+// decodeIMAADPCM(data, channels, blockAlign) -> samples, error
+//
+// Each block starts with a four-byte header per channel (the
+// first sample and the step index), followed by 4-bit codes,
+// low nibble first, in runs of eight samples per channel.
+// -----------------------------------------------------------
+func decodeIMAADPCM(data []byte, channels, blockAlign int) ([]int16, error) {
+	if blockAlign <= 4*channels || (blockAlign-4*channels)%(4*channels) != 0 {
+		return nil, fmt.Errorf("bad IMA ADPCM block size %d", blockAlign)
+	}
+	perBlock := (blockAlign-4*channels)*2/channels + 1
+	if len(data)/blockAlign*perBlock*channels > SOUND_MAX_SAMPLES {
+		return nil, errors.New("sound is too long to convert")
+	}
+
+	var out []int16
+	sample := make([]int, channels)
+	index := make([]int, channels)
+	frame := make([]int16, perBlock*channels)
+	for len(data) >= blockAlign {
+		block := data[:blockAlign]
+		data = data[blockAlign:]
+
+		for c := 0; c < channels; c++ {
+			h := block[4*c:]
+			sample[c] = int(int16(binary.LittleEndian.Uint16(h)))
+			index[c] = min(int(h[2]), len(imaSteps)-1)
+			frame[c] = int16(sample[c])
+		}
+		codes := block[4*channels:]
+		for g := 0; g < len(codes)/(4*channels); g++ {
+			for c := 0; c < channels; c++ {
+				group := codes[(g*channels+c)*4:]
+				for k := 0; k < 8; k++ {
+					nibble := int(group[k/2] >> (4 * (k % 2)) & 0x0F)
+					step := imaSteps[index[c]]
+					diff := step >> 3
+					if nibble&1 != 0 {
+						diff += step >> 2
+					}
+					if nibble&2 != 0 {
+						diff += step >> 1
+					}
+					if nibble&4 != 0 {
+						diff += step
+					}
+					if nibble&8 != 0 {
+						diff = -diff
+					}
+					sample[c] = clamp16(sample[c] + diff)
+					index[c] = max(0, min(len(imaSteps)-1, index[c]+imaIndexChange[nibble&7]))
+					frame[(1+g*8+k)*channels+c] = int16(sample[c])
+				}
+			}
+		}
+		out = append(out, frame...)
+	}
+	return out, nil
+}
+
+// Microsoft ADPCM's standard predictor coefficients and step adaptation
+var (
+	msADPCMCoefs = [7][2]int{{256, 0}, {512, -256}, {0, 0}, {192, 64}, {240, 0}, {460, -208}, {392, -232}}
+	msADPCMAdapt = [16]int{230, 230, 230, 230, 307, 409, 512, 614, 768, 614, 512, 409, 307, 230, 230, 230}
+)
+
+// -----------------------------------------------------------
+// decodeMSADPCM(data, channels, blockAlign) -> samples, error
+//
+// Each block starts with a predictor number, a step size, and
+// two samples for each channel, the older sample first in the
+// output. The 4-bit codes follow high nibble first, alternating
+// channels in stereo.
+// -----------------------------------------------------------
+func decodeMSADPCM(data []byte, channels, blockAlign int) ([]int16, error) {
+	header := 7 * channels
+	if blockAlign <= header {
+		return nil, fmt.Errorf("bad Microsoft ADPCM block size %d", blockAlign)
+	}
+	perBlock := (blockAlign-header)*2/channels + 2
+	if len(data)/blockAlign*perBlock*channels > SOUND_MAX_SAMPLES {
+		return nil, errors.New("sound is too long to convert")
+	}
+
+	le := binary.LittleEndian
+	var out []int16
+	coef := make([][2]int, channels)
+	delta := make([]int, channels)
+	s1, s2 := make([]int, channels), make([]int, channels)
+	for len(data) >= blockAlign {
+		block := data[:blockAlign]
+		data = data[blockAlign:]
+
+		for c := 0; c < channels; c++ {
+			coef[c] = msADPCMCoefs[min(int(block[c]), len(msADPCMCoefs)-1)]
+			delta[c] = int(int16(le.Uint16(block[channels+2*c:])))
+			s1[c] = int(int16(le.Uint16(block[3*channels+2*c:])))
+			s2[c] = int(int16(le.Uint16(block[5*channels+2*c:])))
+		}
+		for c := 0; c < channels; c++ {
+			out = append(out, int16(s2[c]))
+		}
+		for c := 0; c < channels; c++ {
+			out = append(out, int16(s1[c]))
+		}
+
+		for i, b := range block[header:] {
+			for k, nibble := range [2]int{int(b >> 4), int(b & 0x0F)} {
+				c := (2*i + k) % channels
+				signed := nibble
+				if signed >= 8 {
+					signed -= 16
+				}
+				predicted := (s1[c]*coef[c][0] + s2[c]*coef[c][1]) / 256
+				v := clamp16(predicted + signed*delta[c])
+				s2[c], s1[c] = s1[c], v
+				delta[c] = max(16, msADPCMAdapt[nibble]*delta[c]/256)
+				out = append(out, int16(v))
+			}
+		}
+	}
+	return out, nil
+}
+
+// --- AIFF ---
+
+func isAIFF(b []byte) bool {
+	return len(b) >= 12 && string(b[0:4]) == "FORM" && (string(b[8:12]) == "AIFF" || string(b[8:12]) == "AIFC")
+}
+
+// AIFF-C compression types gofer decodes
+var aifcEncodings = map[string]sampleEncoding{
+	"ulaw": SAMPLE_ULAW, "ULAW": SAMPLE_ULAW, "alaw": SAMPLE_ALAW, "ALAW": SAMPLE_ALAW,
+	"fl32": SAMPLE_F32, "FL32": SAMPLE_F32, "fl64": SAMPLE_F64, "FL64": SAMPLE_F64,
+}
+
+// extendedFloat reads the 80-bit IEEE extended number AIFF stores the rate in.
+func extendedFloat(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mantissa == 0 {
+		return 0
+	}
+	return math.Ldexp(float64(mantissa), exp-16383-63)
+}
+
+// -----------------------------------------------------------
+// decodeAIFF(data) -> sound, details, error
+//
+// Mac sound files: the COMM chunk gives the channels, frames,
+// sample width, and rate, and SSND holds big-endian signed
+// samples after an offset. AIFF-C adds a compression type:
+// "sowt" is little-endian PCM, and mu-law, A-law, and floats
+// are decoded as well.
+// -----------------------------------------------------------
+func decodeAIFF(data []byte) (*sound, string, error) {
+	chunks := riffChunks(data, 12, binary.BigEndian)
+	comm, ssnd := chunks["COMM"], chunks["SSND"]
+	if len(comm) < 18 || len(ssnd) < 8 {
+		return nil, "", errSoundTruncated
+	}
+
+	be := binary.BigEndian
+	channels, frames, bits := int(be.Uint16(comm)), int(be.Uint32(comm[2:])), int(be.Uint16(comm[6:]))
+	rate := int(math.Round(extendedFloat(comm[8:18])))
+	if err := checkSoundFormat(rate, channels); err != nil {
+		return nil, "", err
+	}
+
+	enc, err := pcmEncoding(bits, true)
+	bigEndian := true
+	if string(data[8:12]) == "AIFC" && len(comm) >= 22 {
+		switch compression := string(comm[18:22]); compression {
+		case "NONE", "twos":
+		case "sowt":
+			bigEndian = false
+		default:
+			var ok bool
+			if enc, ok = aifcEncodings[compression]; !ok {
+				return nil, "", fmt.Errorf("AIFF-C compression %q isn't supported", compression)
+			}
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	offset := int(be.Uint32(ssnd))
+	if 8+offset > len(ssnd) || offset < 0 {
+		return nil, "", errSoundTruncated
+	}
+	body := ssnd[8+offset:]
+	if want := frames * channels * sampleBytes[enc]; want >= 0 && want < len(body) {
+		body = body[:want]
+	}
+
+	s := &sound{Rate: rate, Channels: channels}
+	if s.Samples, err = appendSamples(nil, body, enc, bigEndian); err != nil {
+		return nil, "", err
+	}
+	s.Samples = s.Samples[:s.Frames()*s.Channels]
+	return s, sampleNames[enc], nil
+}
+
+// -----------------------------------------------------------
+// encodeWAV(s) -> bytes
+//
+// Writes the sound as a canonical 16-bit PCM WAV file, the
+// one format every browser's audio element plays.
+// -----------------------------------------------------------
+func encodeWAV(s *sound) []byte {
+	dataSize := len(s.Samples) * 2
+	out := make([]byte, 44, 44+dataSize)
+	le := binary.LittleEndian
+
+	copy(out[0:], "RIFF")
+	le.PutUint32(out[4:], uint32(36+dataSize))
+	copy(out[8:], "WAVEfmt ")
+	le.PutUint32(out[16:], 16)
+	le.PutUint16(out[20:], WAV_PCM)
+	le.PutUint16(out[22:], uint16(s.Channels))
+	le.PutUint32(out[24:], uint32(s.Rate))
+	le.PutUint32(out[28:], uint32(s.Rate*s.Channels*2))
+	le.PutUint16(out[32:], uint16(s.Channels*2))
+	le.PutUint16(out[34:], 16)
+	copy(out[36:], "data")
+	le.PutUint32(out[40:], uint32(dataSize))
+
+	for _, v := range s.Samples {
+		out = le.AppendUint16(out, uint16(v))
+	}
+	return out
+}
@@ -0,0 +1,172 @@
+// sounds module for gofer 0.9
+// sound items (types s and <): a player page, and WAV transcoding of
+// formats browsers can't play
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// soundFormat is one sound format gofer recognizes by its first bytes.
+type soundFormat struct {
+	Name   string
+	MIME   string                               // for formats the browser plays itself
+	match  func([]byte) bool                    // recognizes the format
+	decode func([]byte) (*sound, string, error) // decodes it, with details for the player page
+}
+
+// browsers play these as they are; the rest are transcoded to WAV
+var nativeSoundFormats = []*soundFormat{
+	{Name: "MP3", MIME: "audio/mpeg", match: isMP3},
+	{Name: "Ogg", MIME: "audio/ogg", match: prefixMatch("OggS")},
+	{Name: "FLAC", MIME: "audio/flac", match: prefixMatch("fLaC")},
+	{Name: "MPEG-4 audio", MIME: "audio/mp4", match: func(b []byte) bool {
+		return len(b) >= 8 && string(b[4:8]) == "ftyp"
+	}},
+}
+
+var legacySoundFormats = []*soundFormat{
+	{Name: "WAV", MIME: "audio/wav", match: isWAV, decode: decodeWAV},
+	{Name: "Sun audio", match: isAU, decode: decodeAU},
+	{Name: "Creative Voice", match: isVOC, decode: decodeVOC},
+	{Name: "AIFF", match: isAIFF, decode: decodeAIFF},
+}
+
+// the first Sun files had no header at all; only the extension gives them away
+var rawULawFormat = &soundFormat{Name: "Sun audio", decode: decodeRawULaw}
+
+// isMP3 looks for an ID3 tag or an MPEG audio frame header.
+func isMP3(b []byte) bool {
+	return strings.HasPrefix(string(b), "ID3") || len(b) >= 2 && b[0] == 0xFF && b[1]&0xE6 == 0xE2
+}
+
+// identifySound finds the format of a sound, or returns nil.
+func identifySound(data []byte, selector string) *soundFormat {
+	for _, f := range nativeSoundFormats {
+		if f.match(data) {
+			return f
+		}
+	}
+	for _, f := range legacySoundFormats {
+		if f.match(data) {
+			return f
+		}
+	}
+	if ext := strings.ToLower(path.Ext(selector)); ext == ".au" || ext == ".snd" {
+		return rawULawFormat
+	}
+	return nil
+}
+
+// soundLength formats a duration in seconds as m:ss.
+func soundLength(s *sound) string {
+	seconds := (s.Frames() + s.Rate/2) / s.Rate
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// soundChannels names the channel layout.
+func soundChannels(n int) string {
+	switch n {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	}
+	return fmt.Sprintf("%d channels", n)
+}
+
+// --- HTTP Handler ---
+
+// soundView is the data for the "sound" template.
+type soundView struct {
+	pageHead
+	Name     string
+	Format   string
+	Details  string
+	Rate     int
+	Channels string
+	Length   string
+	Src      string // the sound as the browser can play it, if it can
+	Type     string // its media type, for the source element
+	Note     string
+	Error    string
+	Download string
+	Return   returnLink
+}
+
+// -----------------------------------------------------------
+// serveSound(w, r, g, conn) -> error
+//
+// Sound items open a player page with the format, rate, and
+// length. sound=wav sends the sound transcoded to 16-bit PCM
+// WAV, falling back to the original bytes if it can't be
+// decoded, and raw=1 (handled by serveGopher) the original.
+// Both find the reply in the decode cache readDecodable fills,
+// so the page and its player cost a single fetch.
+// -----------------------------------------------------------
+func serveSound(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	data, err := readDecodable(conn)
+
+	var format *soundFormat
+	var snd *sound
+	var details string
+	if err == nil {
+		if format = identifySound(data, g.Selector); format != nil && format.decode != nil {
+			snd, details, err = format.decode(data)
+		}
+	}
+
+	if r.URL.Query().Get("sound") == "wav" && data != nil {
+		if snd != nil {
+			setRemoteHeaders(w, "audio/wav")
+			_, err := w.Write(encodeWAV(snd))
+			return err
+		}
+		ct := declaredType(g)
+		if format != nil && format.MIME != "" {
+			ct = format.MIME
+		} else if ct == "" {
+			ct = http.DetectContentType(data)
+		}
+		setRemoteHeaders(w, ct)
+		_, err := w.Write(data)
+		return err
+	}
+
+	view := soundView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Name:     itemFileName(g),
+		Details:  details,
+		Download: withParams(r, "raw", "1", "download", "1"),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	}
+	switch {
+	case err != nil:
+		view.Error = err.Error()
+	case format == nil:
+		view.Src, view.Type = withParams(r, "raw", "1"), declaredType(g)
+		view.Note = "gofer doesn't recognize this format; your browser may still play it."
+	case snd != nil:
+		view.Rate, view.Channels, view.Length = snd.Rate, soundChannels(snd.Channels), soundLength(snd)
+		if snd.Native {
+			view.Src, view.Type = withParams(r, "raw", "1"), format.MIME
+		} else {
+			view.Src, view.Type = withParams(r, "sound", "wav"), "audio/wav"
+			view.Note = "Converted to WAV for playback."
+		}
+	default:
+		view.Src, view.Type = withParams(r, "raw", "1"), format.MIME
+	}
+	if format != nil {
+		view.Format = format.Name
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return renderTemplate(w, "sound", view)
+}
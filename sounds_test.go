@@ -0,0 +1,33 @@
+// sounds tests for gofer 0.9
+// a player page and the requests it makes back cost one fetch
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServeSoundFetchesOnce(t *testing.T) {
+	ulaw := strings.Repeat("\xFF\x7F", 400) // a headerless .au: silence
+	host, port, fetches := listenGopher(t, ulaw)
+	item := "/?type=s&host=" + host + "&port=" + port + "&selector=%2Fbeep.au"
+
+	page := getGopher(t, item).Body.String()
+	if !strings.Contains(page, "Sun audio") || !strings.Contains(page, "sound=wav") {
+		t.Errorf("player page lacks the format or the transcoded sound:\n%s", page)
+	}
+	wav := getGopher(t, item+"&sound=wav")
+	if ct := wav.Header().Get("Content-Type"); ct != "audio/wav" || !strings.HasPrefix(wav.Body.String(), "RIFF") {
+		t.Errorf("sound=wav sent %q", ct)
+	}
+	if body := getGopher(t, item+"&raw=1").Body.String(); body != ulaw {
+		t.Errorf("raw=1 sent %d bytes, want %d", len(body), len(ulaw))
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("%d fetches for one player page, want 1", n)
+	}
+}
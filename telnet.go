@@ -0,0 +1,450 @@
+// telnet module for gofer 0.9
+// type 8 items: an in-browser terminal bridged to the telnet host over a WebSocket
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TELNET_ENDPOINT        = "/telnet"
+	TELNET_SOCKET_ENDPOINT = "/telnet/socket"
+	TELNET_DEFAULT_PORT    = "23"
+	TELNET_COLS            = 80
+	TELNET_ROWS            = 24
+)
+
+// telnet commands and options (RFC 854, 855, 857, 858, 1073, 1091)
+const (
+	TELNET_SE   = 240
+	TELNET_SB   = 250
+	TELNET_WILL = 251
+	TELNET_WONT = 252
+	TELNET_DO   = 253
+	TELNET_DONT = 254
+	TELNET_IAC  = 255
+
+	TELOPT_BINARY = 0
+	TELOPT_ECHO   = 1
+	TELOPT_SGA    = 3
+	TELOPT_TTYPE  = 24
+	TELOPT_NAWS   = 31
+
+	TTYPE_IS   = 0
+	TTYPE_SEND = 1
+)
+
+// terminal types offered in answer to successive TTYPE SEND requests; the last repeats
+var telnetTerminalTypes = []string{"ANSI", "VT100"}
+
+// -----------------------------------------------------------
+// telnetParser separates the data stream from IAC commands and
+// subnegotiations. It keeps its state between reads, so a
+// command split across two packets is still recognized.
+// -----------------------------------------------------------
+type telnetParser struct {
+	state int // one of the telnetState values below
+	cmd   byte
+	sbOpt byte
+	sb    []byte
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBData
+	telnetStateSBIAC
+)
+
+// feed parses b. Data is delivered in runs; onCommand gets option commands
+// (opt is meaningful for WILL/WONT/DO/DONT only); onSub gets subnegotiations.
+func (p *telnetParser) feed(b []byte, onData func([]byte), onCommand func(cmd, opt byte), onSub func(opt byte, data []byte)) {
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			onData(b[start:end])
+		}
+	}
+
+	for i, c := range b {
+		switch p.state {
+		case telnetStateData:
+			if c == TELNET_IAC {
+				flush(i)
+				p.state = telnetStateIAC
+			}
+			continue
+
+		case telnetStateIAC:
+			switch {
+			case c == TELNET_IAC: // escaped 0xFF is data
+				onData([]byte{TELNET_IAC})
+				p.state = telnetStateData
+			case c == TELNET_SB:
+				p.state = telnetStateSB
+			case c >= TELNET_WILL:
+				p.cmd = c
+				p.state = telnetStateOption
+			default: // NOP, GA, EOR and friends
+				onCommand(c, 0)
+				p.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			onCommand(p.cmd, c)
+			p.state = telnetStateData
+
+		case telnetStateSB:
+			p.sbOpt = c
+			p.sb = p.sb[:0]
+			p.state = telnetStateSBData
+
+		case telnetStateSBData:
+			if c == TELNET_IAC {
+				p.state = telnetStateSBIAC
+			} else {
+				p.sb = append(p.sb, c)
+			}
+
+		case telnetStateSBIAC:
+			switch c {
+			case TELNET_SE:
+				onSub(p.sbOpt, p.sb)
+				p.state = telnetStateData
+			case TELNET_IAC:
+				p.sb = append(p.sb, TELNET_IAC)
+				p.state = telnetStateSBData
+			default: // malformed; drop the subnegotiation
+				p.state = telnetStateData
+			}
+		}
+		start = i + 1
+	}
+
+	if p.state == telnetStateData {
+		flush(len(b))
+	}
+}
+
+// telnetEscape doubles IAC bytes in outgoing data.
+func telnetEscape(b []byte) []byte {
+	if bytes.IndexByte(b, TELNET_IAC) < 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+4)
+	for _, c := range b {
+		out = append(out, c)
+		if c == TELNET_IAC {
+			out = append(out, TELNET_IAC)
+		}
+	}
+	return out
+}
+
+// dialTelnet opens a plain connection through the outbound policy, which
+// lets the terminal bridges alone reach the telnet port. It bypasses
+// dialRemote, since a TLS probe would garble a telnet session.
+func dialTelnet(host, port string) (net.Conn, error) {
+	dialer, err := policyDialer(host, port, &net.Dialer{Timeout: TCP_TIMEOUT}, terminalPorts...)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.Dial("tcp", net.JoinHostPort(host, port))
+}
+
+// -----------------------------------------------------------
+// telnetSession relays between the browser terminal and the
+// remote host, answering option negotiation on the way: we
+// offer NAWS and TTYPE, and let the server take over ECHO.
+// -----------------------------------------------------------
+type telnetSession struct {
+	remote  net.Conn
+	ws      *wsConn
+	writeMu sync.Mutex
+
+	parser telnetParser
+
+	// negotiation state, shared by the relay goroutines
+	mu         sync.Mutex
+	us         map[byte]bool    // options enabled on our side
+	him        map[byte]bool    // options enabled on the server's side
+	refused    map[[2]byte]bool // refusals already sent, by reply and option
+	ttypeIndex int
+	cols, rows int
+}
+
+func newTelnetSession(remote net.Conn, ws *wsConn) *telnetSession {
+	return &telnetSession{
+		remote:  remote,
+		ws:      ws,
+		us:      map[byte]bool{},
+		him:     map[byte]bool{},
+		refused: map[[2]byte]bool{},
+		cols:    TELNET_COLS,
+		rows:    TELNET_ROWS,
+	}
+}
+
+func (s *telnetSession) send(b ...byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.remote.Write(b)
+	return err
+}
+
+// run relays until either side closes.
+func (s *telnetSession) run() {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := s.remote.Read(buf)
+			if n > 0 {
+				updateActivity()
+				s.parser.feed(buf[:n], s.onData, s.onCommand, s.onSub)
+			}
+			if err != nil {
+				s.ws.CloseWithReason(1000, "connection closed by remote host")
+				return
+			}
+		}
+	}()
+
+	for {
+		opcode, message, err := s.ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		updateActivity()
+
+		if opcode == WS_OP_TEXT {
+			s.onControl(string(message))
+			continue
+		}
+		if err := s.sendInput(message); err != nil {
+			break
+		}
+	}
+
+	s.remote.Close()
+	<-done
+}
+
+// sendInput forwards keystrokes. Enter arrives as CR, which NVT needs as CR LF
+// unless both sides agreed on binary transmission.
+func (s *telnetSession) sendInput(b []byte) error {
+	s.mu.Lock()
+	binary := s.us[TELOPT_BINARY] && s.him[TELOPT_BINARY]
+	s.mu.Unlock()
+
+	if !binary {
+		b = []byte(strings.ReplaceAll(strings.ReplaceAll(string(b), "\r\n", "\r"), "\r", "\r\n"))
+	}
+	return s.send(telnetEscape(b)...)
+}
+
+// onControl handles text messages from the browser, e.g. "resize 80 24".
+func (s *telnetSession) onControl(msg string) {
+	fields := strings.Fields(msg)
+	if len(fields) == 3 && fields[0] == "resize" {
+		cols, err1 := strconv.Atoi(fields[1])
+		rows, err2 := strconv.Atoi(fields[2])
+		if err1 == nil && err2 == nil && cols > 0 && rows > 0 && cols < 1000 && rows < 1000 {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.cols, s.rows = cols, rows
+			if s.us[TELOPT_NAWS] {
+				s.sendWindowSize()
+			}
+		}
+	}
+}
+
+func (s *telnetSession) onData(b []byte) {
+	s.ws.WriteMessage(WS_OP_BINARY, append([]byte(nil), b...))
+}
+
+func (s *telnetSession) onCommand(cmd, opt byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd {
+	case TELNET_DO:
+		switch opt {
+		case TELOPT_NAWS, TELOPT_TTYPE, TELOPT_SGA, TELOPT_BINARY:
+			if !s.us[opt] {
+				s.us[opt] = true
+				s.send(TELNET_IAC, TELNET_WILL, opt)
+			}
+			if opt == TELOPT_NAWS {
+				s.sendWindowSize()
+			}
+		default:
+			s.refuse(TELNET_WONT, opt)
+		}
+
+	case TELNET_DONT:
+		if s.us[opt] {
+			s.us[opt] = false
+			s.send(TELNET_IAC, TELNET_WONT, opt)
+		}
+
+	case TELNET_WILL:
+		switch opt {
+		case TELOPT_ECHO, TELOPT_SGA, TELOPT_BINARY:
+			if !s.him[opt] {
+				s.him[opt] = true
+				s.send(TELNET_IAC, TELNET_DO, opt)
+			}
+			if opt == TELOPT_ECHO {
+				s.ws.WriteMessage(WS_OP_TEXT, []byte("echo remote"))
+			}
+		default:
+			s.refuse(TELNET_DONT, opt)
+		}
+
+	case TELNET_WONT:
+		if s.him[opt] {
+			s.him[opt] = false
+			s.send(TELNET_IAC, TELNET_DONT, opt)
+		}
+		if opt == TELOPT_ECHO {
+			s.ws.WriteMessage(WS_OP_TEXT, []byte("echo local"))
+		}
+	}
+}
+
+// refuse declines an option once; repeating the refusal could loop forever.
+func (s *telnetSession) refuse(reply, opt byte) {
+	key := [2]byte{reply, opt}
+	if s.refused[key] {
+		return
+	}
+	s.refused[key] = true
+	s.send(TELNET_IAC, reply, opt)
+}
+
+func (s *telnetSession) onSub(opt byte, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opt != TELOPT_TTYPE || len(data) == 0 || data[0] != TTYPE_SEND {
+		return
+	}
+
+	name := telnetTerminalTypes[len(telnetTerminalTypes)-1]
+	if s.ttypeIndex < len(telnetTerminalTypes) {
+		name = telnetTerminalTypes[s.ttypeIndex]
+		s.ttypeIndex++
+	}
+
+	reply := []byte{TELNET_IAC, TELNET_SB, TELOPT_TTYPE, TTYPE_IS}
+	reply = append(reply, name...)
+	reply = append(reply, TELNET_IAC, TELNET_SE)
+	s.send(reply...)
+}
+
+// sendWindowSize reports the terminal size (RFC 1073); 255 bytes in the size must be doubled.
+// Callers hold s.mu.
+func (s *telnetSession) sendWindowSize() {
+	size := []byte{byte(s.cols >> 8), byte(s.cols), byte(s.rows >> 8), byte(s.rows)}
+
+	reply := []byte{TELNET_IAC, TELNET_SB, TELOPT_NAWS}
+	reply = append(reply, telnetEscape(size)...)
+	reply = append(reply, TELNET_IAC, TELNET_SE)
+	s.send(reply...)
+}
+
+// --- HTTP Handlers ---
+
+// telnetLink builds the /telnet route for a type 8 menu item.
+func telnetLink(host, port, selector, returnTo string) string {
+	return fmt.Sprintf("%s?host=%s&port=%s&selector=%s&return=%s",
+		TELNET_ENDPOINT,
+		url.QueryEscape(host), url.QueryEscape(port),
+		url.QueryEscape(selector), url.QueryEscape(returnTo),
+	)
+}
+
+// terminalView is the data for the "telnet" template.
+type terminalView struct {
+	pageHead
+	Address    string
+	Login      string // the selector: the name to log in as (RFC 1436)
+	SocketPath string
+	Return     returnLink
+}
+
+// handleTelnet renders the terminal page; the page then opens the socket.
+func handleTelnet(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	if port == "" || port == "0" {
+		port = TELNET_DEFAULT_PORT
+	}
+	if host == "" {
+		http.Error(w, "Missing host", http.StatusBadRequest)
+		return
+	}
+
+	returnURL := query.Get("return")
+	if returnURL == "" {
+		returnURL = "/"
+	}
+
+	address := net.JoinHostPort(host, port)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderTemplate(w, "telnet", terminalView{
+		pageHead: pageHead{Title: "gofer telnet - " + address},
+		Address:  address,
+		Login:    query.Get("selector"),
+		SocketPath: fmt.Sprintf("%s?host=%s&port=%s&%s=%s",
+			TELNET_SOCKET_ENDPOINT, url.QueryEscape(host), url.QueryEscape(port),
+			SESSION_TOKEN_FIELD, url.QueryEscape(sessionToken)),
+		Return: returnLink{Href: returnURL, Label: "Exit Telnet"},
+	})
+}
+
+// handleTelnetSocket upgrades to a WebSocket and relays it to the telnet host.
+func handleTelnetSocket(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	if host == "" || port == "" {
+		http.Error(w, "Missing host or port", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+
+	remote, err := dialTelnet(host, port)
+	if err != nil {
+		ws.CloseWithReason(1011, err.Error())
+		return
+	}
+	defer remote.Close()
+
+	newTelnetSession(remote, ws).run()
+}
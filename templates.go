@@ -0,0 +1,1052 @@
+// page templates for gofer 0.9
+// every page is rendered through html/template, so text from gopher
+// servers (display strings, selectors, hosts, Ph output) is always escaped
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+)
+
+// --- Shared Layout ---
+
+const layoutTemplates = `
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+	<title>{{.Title}}</title>
+	<style>
+
+		:root { color-scheme: light dark; }
+
+		body {
+			font-family: monospace;
+			line-height: 1.4;
+			width: 100ch;
+			margin: 0 auto;
+			padding-bottom: 1ch;
+		}
+
+		.gopher-link {
+			margin: 0;
+			white-space: pre;
+		}
+
+		.gopher-link:last-child {
+			margin-bottom: 1ch;
+		}
+
+		.gopher-plus {
+			margin: 0 0 0.5ch 5ch;
+			white-space: pre-wrap;
+			color: gray;
+		}
+
+		.mirror-note { color: gray; }
+
+		.icon-error { color: red; }
+		.icon-info { color: gray; }
+		.tls-lock { color: green; }
+		.external { color: gray; }
+
+		.return { margin-top: 1ch; }
+		.results { margin-top: 1ch; }
+
+		.query-bar {
+			width: 100%;
+			margin: 1ch 0 1ch 0;
+		}
+
+		.query-bar form {
+			display: flex; /* Activate Flexbox */
+			width: 100%; /* Ensure the form uses the full 100ch of .query-bar */
+			align-items: center; /* Vertically center the text and input */
+		}
+
+		.query-label {
+			font-size: 1.5em;
+			font-weight: bold;
+			padding: 0 0 0 0;
+			flex-shrink: 0;
+		}
+
+		.query-label.prompt {
+			padding: 0 1ch 0 0;
+		}
+
+		.query-bar input[type="text"] {
+			font-family: monospace;
+			font-size: 1.5em;
+			font-weight: bold;
+
+			flex-grow: 1;
+			min-width: 0;
+
+			outline: 0;
+			caret-style: underscore;
+		}
+
+		pre {
+			width: 100%;
+			padding: 0 0 1ch 0;
+			white-space: pre;
+		}
+
+		pre.wrap { white-space: pre-wrap; }
+
+		.warning {
+			color: red;
+			font-weight: bold;
+		}
+
+		.ph-entry {
+			border-collapse: collapse;
+			margin: 0 0 1ch 0;
+		}
+
+		.ph-entry caption {
+			text-align: left;
+			color: gray;
+		}
+
+		.ph-entry th {
+			text-align: right;
+			vertical-align: top;
+			font-weight: normal;
+			color: gray;
+			padding: 0 2ch 0 0;
+		}
+
+		.ph-entry td { white-space: pre-wrap; }
+
+		.ph-return {
+			margin: 1ch 0 1ch 0;
+			border: 1px dotted gray;
+		}
+
+		.ph-account {
+			margin: 2ch 0 1ch 0;
+			color: gray;
+		}
+
+		.ph-return label {
+			display: inline-block;
+			margin-right: 2ch;
+		}
+
+		.ask-row {
+			display: flex;
+			flex-direction: column;
+			margin: 1ch 0 1ch 0;
+		}
+
+		.ask-note {
+			margin: 0;
+			white-space: pre-wrap;
+		}
+
+		.ask-row input[type="text"], .ask-row input[type="password"], .ask-row textarea, .ask-row select {
+			font-family: monospace;
+			font-size: 1.2em;
+			outline: 0;
+		}
+
+		button {
+			font-family: monospace;
+			cursor: pointer;
+		}
+
+		.media { display: block; width: 100%; margin: 1ch 0 1ch 0; }
+		.media.viewer { height: 80vh; border: 1px solid gray; }
+
+		.term-bar {
+			margin: 1ch 0 1ch 0;
+		}
+
+		.terminal {
+			display: inline-block;
+			width: auto;
+			margin: 0;
+			padding: 0.5ch;
+			line-height: 1.2;
+			background: #000;
+			color: #aaa;
+			outline: 0;
+			cursor: text;
+		}
+
+		.terminal:focus { box-shadow: 0 0 0 2px #555; }
+		.terminal .cursor { outline: 1px solid #aaa; }
+		.terminal .u { text-decoration: underline; }
+
+		.f0 { color: #000; } .f1 { color: #a00; } .f2 { color: #0a0; } .f3 { color: #a50; }
+		.f4 { color: #00a; } .f5 { color: #a0a; } .f6 { color: #0aa; } .f7 { color: #aaa; }
+		.f8 { color: #555; } .f9 { color: #f55; } .f10 { color: #5f5; } .f11 { color: #ff5; }
+		.f12 { color: #55f; } .f13 { color: #f5f; } .f14 { color: #5ff; } .f15 { color: #fff; }
+		.b0 { background: #000; } .b1 { background: #a00; } .b2 { background: #0a0; } .b3 { background: #a50; }
+		.b4 { background: #00a; } .b5 { background: #a0a; } .b6 { background: #0aa; } .b7 { background: #aaa; }
+
+		.tn3270 input {
+			font: inherit;
+			line-height: inherit;
+			border: 0;
+			margin: 0;
+			padding: 0;
+			background: #111;
+			outline: 0;
+			caret-color: #fff;
+		}
+
+		.tn3270 input:focus { background: #222; }
+		.tn3270 .bold { font-weight: bold; }
+		.tn3270.alarm { box-shadow: 0 0 0 2px #f44; }
+
+		.c-blue { color: #58f; } .c-red { color: #f44; } .c-pink { color: #f6f; } .c-green { color: #4f4; }
+		.c-turquoise { color: #4ff; } .c-yellow { color: #ff4; } .c-white { color: #fff; }
+
+	</style>
+</head>
+<body>
+{{end}}
+
+{{define "foot"}}
+</body>
+</html>
+{{end}}
+
+{{define "token"}}<input type="hidden" name="token" value="{{sessionToken}}">{{end}}
+
+{{define "return"}}
+<div class="return">
+	<a href="{{.Href}}">{{.Label}}</a>
+</div>
+{{end}}
+`
+
+// --- Menu Pages ---
+
+const menuTemplates = `
+{{define "menu-head"}}{{template "head" .}}
+<div class="query-bar">
+	<form action="/" method="GET">
+		<span class="query-label">{{.Scheme}}</span>
+		<input type="text" id="uri" name="uri" value="{{.URI}}" placeholder="freeshell.org:70/">
+	</form>
+</div>
+{{with .Mirror}}<p class="mirror-note">{{.}}</p>{{end}}
+{{end}}
+
+{{define "menu-foot"}}{{template "foot"}}{{end}}
+
+{{define "menu-item"}}<p class="gopher-link">
+	{{- if .IconStyle}}<span class="icon-{{.IconStyle}}">{{.Icon}}</span>{{else}}{{.Icon}}{{end}}
+	{{- if .Href}}<a href="{{.Href}}"{{with .Title}} title="{{.}}"{{end}}>{{.Display}}</a>{{else}}{{.Display}}{{end}}
+	{{- with .External}} <span class="external" title="leaves gopherspace">&#8599; {{.}}</span>{{end}}
+	{{- if .Secure}} <span class="tls-lock" title="reached over TLS">&#128274;</span>{{end -}}
+</p>
+{{with .Plus}}{{template "plus-details" .}}{{end}}
+{{- if .Mirrors}}<div class="gopher-plus">Mirrors:{{range .Mirrors}} <a href="{{.Href}}">{{.Label}}</a>{{end}}</div>
+{{end}}{{end}}
+
+{{define "plus-details"}}<div class="gopher-plus">
+	{{- with .Abstract}}<div>{{.}}</div>{{end}}
+	{{- with .Meta}}<div>{{.}}</div>{{end}}
+	{{- if .Views}}<div>Views:{{range .Views}} <a href="{{.Href}}">{{.Label}}</a>{{with .Size}} ({{.}}){{end}}{{end}}</div>{{end -}}
+</div>
+{{end}}
+`
+
+// --- Form Pages ---
+
+const formTemplates = `
+{{define "search"}}{{template "head" .}}
+<div class="query-bar">
+	<form method="POST">
+		{{template "token"}}
+		<span class="query-label prompt">query</span>
+		<input type="text" name="query" autofocus>
+	</form>
+</div>
+
+<div class="results">
+	{{with .Mirror}}<p class="mirror-note">{{.}}</p>{{end}}
+	{{.Results}}
+</div>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "ph"}}{{template "head" .}}
+{{if .Edit}}<form method="POST">
+{{template "token"}}
+<input type="hidden" name="action" value="change">
+<input type="hidden" name="mode" value="edit">
+<p>your entry, as <b>{{.Alias}}</b></p>
+{{range .Edit}}<div class="ask-row">
+	<label for="ph-{{.Name}}">{{.Name}}{{with .Description}} <span class="icon-info">{{.}}</span>{{end}}</label>
+	{{- if .Long}}
+	<textarea id="ph-{{.Name}}" name="field.{{.Name}}" rows="4"{{if .Max}} maxlength="{{.Max}}"{{end}}>{{.Value}}</textarea>
+	{{- else}}
+	<input type="text" id="ph-{{.Name}}" name="field.{{.Name}}" value="{{.Value}}"{{if .Max}} maxlength="{{.Max}}"{{end}}>
+	{{- end}}
+</div>
+{{end}}<button type="submit">save changes</button>
+</form>
+{{with .Saved}}<p class="icon-info">{{.}}</p>
+{{end}}<p class="icon-info"><a href="{{.FieldsHref}}">back to search</a></p>
+{{else if .Raw}}<div class="query-bar">
+	<form method="POST">
+		{{template "token"}}
+		<input type="hidden" name="mode" value="raw">
+		<span class="query-label prompt">query</span>
+		<input type="text" name="query" value="{{.Query}}" autofocus>
+	</form>
+</div>
+{{with .Note}}<p class="icon-info">{{.}}</p>
+{{else}}<p class="icon-info"><a href="{{.FieldsHref}}">search by field</a></p>
+{{end}}{{else}}{{with .Form}}<form method="POST">
+{{template "token"}}
+<input type="hidden" name="mode" value="fields">
+{{range .Fields}}<div class="ask-row">
+	<label for="ph-{{.Name}}">{{.Name}}{{if .Indexed}} *{{end}}{{with .Description}} <span class="icon-info">{{.}}</span>{{end}}</label>
+	<input type="text" id="ph-{{.Name}}" name="field.{{.Name}}" value="{{.Value}}"{{if .Max}} maxlength="{{.Max}}"{{end}}>
+</div>
+{{end}}<fieldset class="ph-return">
+	<legend>return fields</legend>
+	<label><input type="checkbox" name="show" value="all"{{if .ReturnAll}} checked{{end}}> all</label>
+	{{- range .Returns}}
+	<label><input type="checkbox" name="show" value="{{.Name}}"{{if .Checked}} checked{{end}}> {{.Name}}</label>
+	{{- end}}
+</fieldset>
+<button type="submit">search</button>
+</form>
+{{end}}<p class="icon-info">* indexed: fill in at least one. Each word is matched on its own; "quote" a value to match it as a phrase.
+Wildcards: * any characters, + one or more, ? one, [abc] one of a set.
+<a href="{{.RawHref}}">raw query</a></p>
+{{end}}{{with .Error}}<p class="warning">[ERR] {{.}}</p>
+{{end}}{{with .Greeting}}<pre class="wrap">{{range .}}{{.}}
+{{end}}</pre>
+{{end}}{{with .Status}}<pre class="wrap">{{range .}}{{.}}
+{{end}}</pre>
+{{end}}{{with .SiteInfo}}<table class="ph-entry">
+<caption>site information</caption>
+{{range .}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}{{if and .Searched (not .Error)}}<p>{{len .Entries}} {{if eq (len .Entries) 1}}entry{{else}}entries{{end}} for <b>{{.Query}}</b></p>
+{{end}}{{with .Exports}}<p class="icon-info">export:{{range .}} <a href="{{.Href}}">{{.Label}}</a>{{end}}</p>
+{{end}}{{range .Entries}}<table class="ph-entry">
+<caption>entry {{.Index}}</caption>
+{{range .Fields}}<tr><th>{{.Name}}</th><td>{{if .Link}}<a href="{{.Link}}">{{.Value}}</a>{{else}}{{.Value}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}<div class="ph-account">
+<form method="POST">
+{{template "token"}}
+{{- if .Alias}}
+<input type="hidden" name="action" value="logout">
+logged in as <b>{{.Alias}}</b>{{if not .Edit}} <a href="{{.EditHref}}">edit your entry</a>{{end}}
+<button type="submit">log out</button>
+{{- else}}
+<input type="hidden" name="action" value="login">
+<label>alias <input type="text" name="alias"></label>
+<label>password <input type="password" name="password"></label>
+<button type="submit">log in</button>
+{{- end}}
+</form>
+</div>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "ask"}}{{template "head" .}}
+<form method="POST" enctype="multipart/form-data">
+{{template "token"}}
+{{range .Fields}}<div class="ask-row">
+	{{- if eq .Kind "Note"}}
+	<p class="ask-note">{{.Prompt}}</p>
+	{{- else if eq .Kind "Ask"}}
+	<label for="{{.Name}}">{{.Prompt}}</label>
+	<input type="text" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}">
+	{{- else if eq .Kind "AskP"}}
+	<label for="{{.Name}}">{{.Prompt}}</label>
+	<input type="password" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}">
+	{{- else if eq .Kind "AskL"}}
+	<label for="{{.Name}}">{{.Prompt}}</label>
+	<textarea id="{{.Name}}" name="{{.Name}}" rows="6">{{.Value}}</textarea>
+	{{- else if eq .Kind "Choose"}}
+	<label for="{{.Name}}">{{.Prompt}}</label>
+	<select id="{{.Name}}" name="{{.Name}}">
+	{{- range .Options}}
+		<option value="{{.}}">{{.}}</option>
+	{{- end}}
+	</select>
+	{{- else if eq .Kind "Select"}}
+	<label><input type="checkbox" name="{{.Name}}" value="1"{{if .Checked}} checked{{end}}> {{.Prompt}}</label>
+	{{- else if eq .Kind "ChooseFile"}}
+	<label for="{{.Name}}">{{.Prompt}}</label>
+	<input type="file" id="{{.Name}}" name="{{.Name}}">
+	{{- end}}
+</div>
+{{end}}
+	<button type="submit">submit</button>
+</form>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "external"}}{{template "head" .}}
+<p class="warning">[URL] This link leaves gopherspace for {{.Destination}}.</p>
+
+<pre class="wrap">{{.Text}}</pre>
+
+<p>The address above was given by the gopher server. It opens outside
+gofer, so gofer's outbound policy and certificate pins don't apply to it.</p>
+
+<p><a href="{{.URL}}" rel="noopener noreferrer">continue to {{.Text}}</a></p>
+{{with .Terminal}}<p><a href="{{.}}">open it in gofer's terminal instead</a></p>{{end}}
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "pin-warning"}}{{template "head" .}}
+<p class="warning">[ERR] The TLS certificate for {{.Address}} has changed.</p>
+
+<pre class="wrap">pinned on {{.FirstSeen}}:   {{.Known}}
+presented now:          {{.Presented}}</pre>
+
+<p>This can happen when a server renews a self-signed certificate, but it
+can also mean someone is intercepting the connection. Only accept the new
+certificate if you have confirmed the change with the server's operator.</p>
+
+<form method="POST" action="{{.TrustEndpoint}}">
+	{{template "token"}}
+	<input type="hidden" name="address" value="{{.Address}}">
+	<input type="hidden" name="fingerprint" value="{{.Presented}}">
+	<input type="hidden" name="return" value="{{.ReturnURL}}">
+	<button type="submit">trust the new certificate</button>
+</form>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+`
+
+// --- Terminal Pages ---
+
+const terminalTemplates = `
+{{define "telnet"}}{{template "head" .}}
+<div class="term-bar">
+	telnet {{.Address}} &mdash; <span id="status">connecting...</span>
+	<label>charset <select id="charset"><option value="utf-8">UTF-8</option><option value="cp437">CP437</option></select></label>
+</div>
+{{with .Login}}<div class="term-bar">Log in as: <b>{{.}}</b> <button id="send-login">type it</button></div>{{end}}
+<pre id="screen" class="terminal" tabindex="0"></pre>
+{{template "return" .Return}}
+<script>
+	const socketPath = {{.SocketPath}};
+	const loginName = {{.Login}};
+{{template "ansi-terminal"}}
+</script>
+{{template "foot"}}{{end}}
+
+{{define "ansi-terminal"}}
+(function () {
+	const COLS = 80, ROWS = 24;
+	const DEFAULT_ATTR = 7; // attr bits: fg 0-3, bg 4-6, bold 8, reverse 9, underline 10, cursor 11
+	const CP437 = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0";
+
+	const screen = document.getElementById("screen");
+	const status = document.getElementById("status");
+	const charset = document.getElementById("charset");
+	const decoder = new TextDecoder("utf-8");
+	const encoder = new TextEncoder();
+
+	let grid = [], x = 0, y = 0, attr = DEFAULT_ATTR, wrapNext = false;
+	let saved = [0, 0], top = 0, bottom = ROWS - 1;
+	let state = "text", params = "";
+	let localEcho = true, socket = null, dirty = false;
+
+	// --- screen model ---
+
+	function blankCell() { return [" ", (attr & 0x70) | 7]; }
+	function blankRow() { const row = []; for (let i = 0; i < COLS; i++) row.push(blankCell()); return row; }
+	function reset() { grid = []; for (let r = 0; r < ROWS; r++) grid.push(blankRow()); x = y = 0; wrapNext = false; }
+
+	function scrollUp() { grid.splice(top, 1); grid.splice(bottom, 0, blankRow()); }
+	function scrollDown() { grid.splice(bottom, 1); grid.splice(top, 0, blankRow()); }
+	function lineFeed() { if (y === bottom) scrollUp(); else if (y < ROWS - 1) y++; }
+
+	function clamp() {
+		x = Math.max(0, Math.min(COLS - 1, x));
+		y = Math.max(0, Math.min(ROWS - 1, y));
+		wrapNext = false;
+	}
+
+	function put(ch) {
+		if (wrapNext) { x = 0; lineFeed(); wrapNext = false; }
+		grid[y][x] = [ch, attr];
+		if (x === COLS - 1) wrapNext = true; else x++;
+	}
+
+	function eraseInLine(mode) {
+		const from = mode === 0 ? x : 0, to = mode === 1 ? x : COLS - 1;
+		for (let i = from; i <= to; i++) grid[y][i] = blankCell();
+	}
+
+	function eraseInDisplay(mode) {
+		if (mode === 2 || mode === 3) {
+			for (let r = 0; r < ROWS; r++) grid[r] = blankRow();
+			x = y = 0; // ANSI.SYS homes the cursor, and BBS screens rely on it
+			return;
+		}
+		eraseInLine(mode);
+		const from = mode === 0 ? y + 1 : 0, to = mode === 0 ? ROWS : y;
+		for (let r = from; r < to; r++) grid[r] = blankRow();
+	}
+
+	function sgr(args) {
+		if (args.length === 0) args = [0];
+		for (const n of args) {
+			if (n === 0) attr = DEFAULT_ATTR;
+			else if (n === 1) attr |= 0x100;
+			else if (n === 22) attr &= ~0x100;
+			else if (n === 4) attr |= 0x400;
+			else if (n === 24) attr &= ~0x400;
+			else if (n === 7) attr |= 0x200;
+			else if (n === 27) attr &= ~0x200;
+			else if (n >= 30 && n <= 37) attr = (attr & ~0x0F) | (n - 30);
+			else if (n === 39) attr = (attr & ~0x0F) | 7;
+			else if (n >= 40 && n <= 47) attr = (attr & ~0x70) | ((n - 40) << 4);
+			else if (n === 49) attr &= ~0x70;
+			else if (n >= 90 && n <= 97) attr = (attr & ~0x0F) | (n - 90) | 0x100;
+		}
+	}
+
+	function csi(final, raw) {
+		const priv = /^[?>=]/.test(raw);
+		const args = raw.replace(/^[?>=]/, "").split(";").map(function (v) { return v === "" ? NaN : Number(v); });
+		const n = function (i, d) { return (isNaN(args[i]) || args[i] === 0) ? d : args[i]; };
+
+		switch (final) {
+		case "A": y -= n(0, 1); break;
+		case "B": case "e": y += n(0, 1); break;
+		case "C": case "a": x += n(0, 1); break;
+		case "D": x -= n(0, 1); break;
+		case "E": y += n(0, 1); x = 0; break;
+		case "F": y -= n(0, 1); x = 0; break;
+		case "G": case "\x60": x = n(0, 1) - 1; break;
+		case "d": y = n(0, 1) - 1; break;
+		case "H": case "f": y = n(0, 1) - 1; x = n(1, 1) - 1; break;
+		case "J": eraseInDisplay(args[0] || 0); break;
+		case "K": eraseInLine(args[0] || 0); break;
+		case "L": for (let i = 0; i < n(0, 1); i++) { grid.splice(bottom, 1); grid.splice(y, 0, blankRow()); } break;
+		case "M": for (let i = 0; i < n(0, 1); i++) { grid.splice(y, 1); grid.splice(bottom, 0, blankRow()); } break;
+		case "P": grid[y].splice(x, n(0, 1)); while (grid[y].length < COLS) grid[y].push(blankCell()); break;
+		case "@": for (let i = 0; i < n(0, 1); i++) grid[y].splice(x, 0, blankCell()); grid[y].length = COLS; break;
+		case "X": for (let i = 0; i < n(0, 1) && x + i < COLS; i++) grid[y][x + i] = blankCell(); break;
+		case "S": for (let i = 0; i < n(0, 1); i++) scrollUp(); break;
+		case "T": for (let i = 0; i < n(0, 1); i++) scrollDown(); break;
+		case "r":
+			top = n(0, 1) - 1; bottom = n(1, ROWS) - 1;
+			if (top >= bottom || bottom >= ROWS) { top = 0; bottom = ROWS - 1; }
+			x = y = 0;
+			break;
+		case "m": sgr(args.map(function (v) { return isNaN(v) ? 0 : v; })); return;
+		case "s": saved = [x, y]; return;
+		case "u": x = saved[0]; y = saved[1]; break;
+		case "n": // status reports; BBSes use 6n to detect ANSI support
+			if (args[0] === 6) send("\x1b[" + (y + 1) + ";" + (x + 1) + "R");
+			else if (args[0] === 5) send("\x1b[0n");
+			return;
+		case "c": if (!priv) send("\x1b[?1;0c"); return;
+		default: return; // modes (h, l) and anything else are ignored
+		}
+		clamp();
+	}
+
+	function feed(text) {
+		for (const ch of text) {
+			const c = ch.codePointAt(0);
+
+			if (state === "esc") {
+				state = "text";
+				if (ch === "[") { state = "csi"; params = ""; }
+				else if (ch === "]") state = "osc";
+				else if (ch === "(" || ch === ")") state = "charset";
+				else if (ch === "7") saved = [x, y];
+				else if (ch === "8") { x = saved[0]; y = saved[1]; clamp(); }
+				else if (ch === "D") lineFeed();
+				else if (ch === "E") { x = 0; lineFeed(); }
+				else if (ch === "M") { if (y === top) scrollDown(); else if (y > 0) y--; }
+				else if (ch === "c") { attr = DEFAULT_ATTR; top = 0; bottom = ROWS - 1; reset(); }
+				continue;
+			}
+			if (state === "csi") {
+				if (c >= 0x40 && c <= 0x7e) { state = "text"; csi(ch, params); }
+				else if (params.length < 64) params += ch;
+				continue;
+			}
+			if (state === "osc") {
+				if (c === 7) state = "text";
+				else if (c === 27) state = "esc";
+				continue;
+			}
+			if (state === "charset") { state = "text"; continue; }
+
+			switch (c) {
+			case 27: state = "esc"; break;
+			case 13: x = 0; wrapNext = false; break;
+			case 10: case 11: case 12: lineFeed(); wrapNext = false; break;
+			case 8: if (x > 0) x--; wrapNext = false; break;
+			case 9: x = Math.min(COLS - 1, (x & ~7) + 8); break;
+			default: if (c >= 32) put(ch);
+			}
+		}
+		schedule();
+	}
+
+	// --- rendering ---
+
+	function schedule() {
+		if (!dirty) { dirty = true; requestAnimationFrame(render); }
+	}
+
+	function escapeHTML(s) {
+		return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+	}
+
+	function span(text, a) {
+		let fg = (a & 0x0F) + ((a & 0x100) ? 8 : 0), bg = (a >> 4) & 0x07;
+		if (a & 0x200) { const t = fg & 7; fg = bg; bg = t; }
+		let cls = "f" + fg + " b" + bg;
+		if (a & 0x400) cls += " u";
+		if (a & 0x800) cls += " cursor";
+		return "<span class=\"" + cls + "\">" + escapeHTML(text) + "</span>";
+	}
+
+	function render() {
+		dirty = false;
+		let out = "";
+		for (let r = 0; r < ROWS; r++) {
+			let run = "", runAttr = -1;
+			for (let col = 0; col < COLS; col++) {
+				const cell = grid[r][col];
+				const a = (r === y && col === x) ? cell[1] | 0x800 : cell[1];
+				if (a !== runAttr) {
+					if (run) out += span(run, runAttr);
+					run = ""; runAttr = a;
+				}
+				run += cell[0];
+			}
+			out += span(run, runAttr) + "\n";
+		}
+		screen.innerHTML = out;
+	}
+
+	// --- connection ---
+
+	function send(text) {
+		if (socket && socket.readyState === WebSocket.OPEN) socket.send(encoder.encode(text));
+	}
+
+	function decode(bytes) {
+		if (charset.value !== "cp437") return decoder.decode(bytes, { stream: true });
+		let s = "";
+		for (const b of bytes) s += b < 128 ? String.fromCharCode(b) : CP437[b - 128];
+		return s;
+	}
+
+	function echo(out) {
+		if (!localEcho) return;
+		if (out === "\r") feed("\r\n");
+		else if (out === "\b") feed("\b \b");
+		else if (out.length === 1 && out >= " ") feed(out);
+	}
+
+	const keys = {
+		Enter: "\r", Backspace: "\b", Tab: "\t", Escape: "\x1b",
+		ArrowUp: "\x1b[A", ArrowDown: "\x1b[B", ArrowRight: "\x1b[C", ArrowLeft: "\x1b[D",
+		Home: "\x1b[H", End: "\x1b[F", Insert: "\x1b[2~", Delete: "\x1b[3~",
+		PageUp: "\x1b[5~", PageDown: "\x1b[6~"
+	};
+
+	screen.addEventListener("keydown", function (e) {
+		let out = null;
+		if (e.ctrlKey && !e.altKey && e.key.length === 1) {
+			const c = e.key.toUpperCase().charCodeAt(0);
+			if (c >= 64 && c <= 95) out = String.fromCharCode(c - 64);
+		} else if (keys[e.key]) {
+			out = keys[e.key];
+		} else if (e.key.length === 1 && !e.metaKey) {
+			out = e.key;
+		}
+		if (out === null) return;
+
+		e.preventDefault();
+		echo(out);
+		send(out);
+	});
+
+	screen.addEventListener("paste", function (e) {
+		e.preventDefault();
+		const text = e.clipboardData.getData("text").replace(/\r?\n/g, "\r");
+		if (localEcho) feed(text.replace(/\r/g, "\r\n"));
+		send(text);
+	});
+
+	screen.addEventListener("click", function () { screen.focus(); });
+
+	const login = document.getElementById("send-login");
+	if (login) {
+		login.addEventListener("click", function () {
+			echo(loginName);
+			echo("\r");
+			send(loginName + "\r");
+			screen.focus();
+		});
+	}
+
+	function connect() {
+		const scheme = location.protocol === "https:" ? "wss://" : "ws://";
+		socket = new WebSocket(scheme + location.host + socketPath);
+		socket.binaryType = "arraybuffer";
+
+		socket.onopen = function () {
+			status.textContent = "connected";
+			socket.send("resize " + COLS + " " + ROWS);
+			screen.focus();
+		};
+		socket.onmessage = function (e) {
+			if (typeof e.data === "string") {
+				if (e.data === "echo local") localEcho = true;
+				else if (e.data === "echo remote") localEcho = false;
+				return;
+			}
+			feed(decode(new Uint8Array(e.data)));
+		};
+		socket.onclose = function (e) {
+			status.textContent = "disconnected" + (e.reason ? ": " + e.reason : "");
+		};
+	}
+
+	reset();
+	render();
+	connect();
+})();
+{{end}}
+
+{{define "tn3270"}}{{template "head" .}}
+<div class="term-bar">
+	tn3270 {{.Address}} &mdash; <span id="status">connecting...</span> <span id="lock" class="warning"></span>
+</div>
+{{with .Login}}<div class="term-bar">Log in as: <b>{{.}}</b></div>{{end}}
+<pre id="screen" class="terminal tn3270" tabindex="0"></pre>
+<div class="term-bar">
+	<button data-aid="enter">Enter</button>
+	<button data-aid="clear">Clear</button>
+	<button data-aid="pa1">PA1</button>
+	<button data-aid="pa2">PA2</button>
+	<button data-aid="pa3">PA3</button>
+	<button data-aid="reset">Reset</button>
+</div>
+<div class="term-bar" id="pf-keys"></div>
+{{template "return" .Return}}
+<script>
+	const socketPath = {{.SocketPath}};
+{{template "tn3270-screen"}}
+</script>
+{{template "foot"}}{{end}}
+
+{{define "tn3270-screen"}}
+(function () {
+	const screen = document.getElementById("screen");
+	const status = document.getElementById("status");
+	const lock = document.getElementById("lock");
+	const pfKeys = document.getElementById("pf-keys");
+
+	let socket = null, inputs = [];
+
+	// --- screen ---
+	// each row arrives as segments: protected text becomes a span,
+	// unprotected fields become inputs sized to the field
+
+	function render(update) {
+		const view = update.screen;
+		status.textContent = update.status;
+		lock.textContent = view.locked ? "X SYSTEM" : "";
+
+		let focus = null, offset = 0;
+		screen.textContent = "";
+		inputs = [];
+
+		view.rows.forEach(function (row, r) {
+			if (r > 0) screen.appendChild(document.createTextNode("\n"));
+			for (const seg of row) {
+				const style = "c-" + seg.c + (seg.b ? " bold" : "");
+				if (!seg.in) {
+					const span = document.createElement("span");
+					span.className = style;
+					span.textContent = seg.t;
+					screen.appendChild(span);
+					continue;
+				}
+
+				const input = document.createElement("input");
+				input.type = seg.hide ? "password" : "text";
+				input.inputMode = seg.num ? "numeric" : "text";
+				input.className = style;
+				input.maxLength = seg.n;
+				input.style.width = seg.n + "ch";
+				input.value = seg.t;
+				input.readOnly = view.locked;
+				input.spellcheck = false;
+				input.autocomplete = "off";
+				input.dataset.addr = seg.a;
+				input.dataset.len = seg.n;
+				input.dataset.orig = seg.t;
+				screen.appendChild(input);
+				inputs.push(input);
+
+				if (view.cursor >= seg.a && view.cursor < seg.a + seg.n) {
+					focus = input;
+					offset = Math.min(view.cursor - seg.a, seg.t.length);
+				}
+			}
+		});
+
+		if (!focus && inputs.length > 0) focus = inputs[0];
+		if (focus) {
+			focus.focus();
+			focus.setSelectionRange(offset, offset);
+		} else {
+			screen.focus();
+		}
+
+		if (view.alarm) {
+			screen.classList.add("alarm");
+			setTimeout(function () { screen.classList.remove("alarm"); }, 200);
+		}
+	}
+
+	// --- keys ---
+
+	function sendAID(aid) {
+		if (!socket || socket.readyState !== WebSocket.OPEN) return;
+
+		const fields = [];
+		for (const input of inputs) {
+			if (input.value !== input.dataset.orig) {
+				fields.push({ a: Number(input.dataset.addr), n: Number(input.dataset.len), v: input.value });
+			}
+		}
+
+		let cursor = -1;
+		const active = document.activeElement;
+		if (inputs.includes(active)) cursor = Number(active.dataset.addr) + (active.selectionStart || 0);
+
+		socket.send(JSON.stringify({ aid: aid, cursor: cursor, fields: fields }));
+	}
+
+	function keyAID(e) {
+		if (e.key === "Enter") return "enter";
+		if (e.key === "PageUp") return "pf7";
+		if (e.key === "PageDown") return "pf8";
+		if (e.key === "Escape") return "reset";
+		const pf = /^F([1-9]|1[0-2])$/.exec(e.key);
+		if (pf) return "pf" + (Number(pf[1]) + (e.shiftKey ? 12 : 0));
+		return null;
+	}
+
+	screen.addEventListener("keydown", function (e) {
+		const aid = keyAID(e);
+		if (aid) {
+			e.preventDefault();
+			sendAID(aid);
+		}
+	});
+
+	for (let n = 1; n <= 24; n++) {
+		const button = document.createElement("button");
+		button.dataset.aid = "pf" + n;
+		button.textContent = "PF" + n;
+		pfKeys.appendChild(button);
+		pfKeys.appendChild(document.createTextNode(" "));
+	}
+
+	for (const button of document.querySelectorAll("[data-aid]")) {
+		button.addEventListener("click", function () { sendAID(button.dataset.aid); });
+	}
+
+	function connect() {
+		const scheme = location.protocol === "https:" ? "wss://" : "ws://";
+		socket = new WebSocket(scheme + location.host + socketPath);
+
+		socket.onmessage = function (e) { render(JSON.parse(e.data)); };
+		socket.onclose = function (e) {
+			status.textContent = "disconnected" + (e.reason ? ": " + e.reason : "");
+			for (const input of inputs) input.readOnly = true;
+		};
+	}
+
+	connect();
+})();
+{{end}}
+`
+
+// --- Media Pages ---
+
+const mediaTemplates = `
+{{define "media"}}{{template "head" .}}
+<p>{{.Name}}{{with .Type}} <span class="icon-info">({{.}})</span>{{end}}</p>
+{{if eq .Page "video"}}<video class="media" controls>{{if .Type}}<source src="{{.Src}}" type="{{.Type}}">{{end}}<source src="{{.Src}}"></video>
+{{else if .Inline}}<iframe class="media viewer" src="{{.Src}}" title="{{.Name}}"></iframe>
+{{else}}<p>Your browser can't show this document itself; download it to open it.</p>
+{{end}}
+<p><a href="{{.Download}}" download="{{.Name}}">download {{.Name}}</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "image"}}{{template "head" .}}
+<p>{{.Name}}{{with .Format}} <span class="icon-info">({{.}}{{if $.Width}}, {{$.Width}}x{{$.Height}}{{end}}{{with $.Details}}, {{.}}{{end}})</span>{{end}}</p>
+{{with .Error}}<p class="warning">[ERR] gofer could not convert this image: {{.}}</p>
+{{end}}{{with .Src}}<img class="media" src="{{.}}" alt="{{$.Name}}">
+{{end}}{{with .Note}}<p class="icon-info">{{.}}</p>
+{{end}}<p><a href="{{.Download}}">download the original {{.Name}}</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "sound"}}{{template "head" .}}
+<p>{{.Name}}{{with .Format}} <span class="icon-info">({{.}}{{with $.Details}}, {{.}}{{end}}{{if $.Rate}}, {{$.Rate}} Hz {{$.Channels}}, {{$.Length}}{{end}})</span>{{end}}</p>
+{{with .Error}}<p class="warning">[ERR] gofer could not convert this sound: {{.}}</p>
+{{end}}{{with .Src}}<audio class="media" controls>{{if $.Type}}<source src="{{.}}" type="{{$.Type}}">{{end}}<source src="{{.}}"></audio>
+{{end}}{{with .Note}}<p class="icon-info">{{.}}</p>
+{{end}}<p><a href="{{.Download}}">download the original {{.Name}}</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+`
+
+// --- Decoded Files ---
+
+const decodedTemplates = `
+{{define "binhex"}}{{template "head" .}}
+<p>[HQX] {{.Name}}</p>
+{{with .Error}}<p class="warning">[ERR] gofer could not decode this BinHex file: {{.}}</p>
+{{else}}<pre class="wrap">type           {{.Type}}
+creator        {{.Creator}}
+Finder flags   {{.Flags}}
+data fork      {{.DataSize}} bytes
+resource fork  {{.ResourceSize}} bytes</pre>
+
+<p><a href="{{.Data}}">download {{.FileName}}</a> <span class="icon-info">(data fork)</span></p>
+{{if .ResourceSize}}<p><a href="{{.Resource}}">download {{.FileName}}.rsrc</a> <span class="icon-info">(resource fork)</span></p>
+{{end}}<p><a href="{{.AppleDouble}}">download ._{{.FileName}}</a> <span class="icon-info">(AppleDouble: resource fork and Finder info, kept beside the data fork)</span></p>
+{{end}}<p><a href="{{.Original}}">download the original .hqx</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "uudecode"}}{{template "head" .}}
+<p>[UUE] {{.Name}}</p>
+{{with .Error}}<p class="warning">[ERR] gofer could not decode this file: {{.}}</p>
+{{end}}{{with .Files}}<p>{{if $.Error}}Decoded before the error:{{else}}This item holds {{len .}} files:{{end}}</p>
+{{range .}}<p class="gopher-link"><a href="{{.Href}}">{{.Name}}</a> <span class="icon-info">({{.Size}} bytes, mode {{.Mode}})</span></p>
+{{end}}{{end}}<p><a href="{{.Original}}">download the encoded text</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+
+{{define "archive"}}{{template "head" .}}
+<p>[ARC] {{.Name}}{{with .Format}} <span class="icon-info">({{.}}, {{$.Count}} file{{if ne $.Count 1}}s{{end}})</span>{{end}}</p>
+{{with .Error}}<p class="warning">[ERR] gofer could not open this archive: {{.}}</p>
+{{end}}{{with .Dir}}<p>/{{.}}</p>
+{{end}}{{range .Lines}}<p class="gopher-link">{{.Icon}}
+	{{- if .Href}}<a href="{{.Href}}">{{.Name}}</a>{{else}}{{.Name}}{{end -}}
+	{{- if .Download}} <span class="icon-info">({{.Size}} bytes)</span> <a href="{{.Download}}">download</a>{{end -}}
+</p>
+{{end}}<p><a href="{{.Original}}">download {{.Name}}</a></p>
+{{template "return" .Return}}
+{{template "foot"}}{{end}}
+`
+
+// --- Heartbeat Monitor ---
+
+const heartmonTemplate = `
+{{define "heartmon"}}<!DOCTYPE html>
+<html>
+<head>
+	<title>gofer — running</title>
+	<style>
+		body {
+			font-family: monospace;
+			text-align: center;
+			margin-top: 2em;
+		}
+		button {
+			margin-top: 1em;
+			font-family: monospace;
+			cursor: pointer;
+		}
+	</style>
+</head>
+<body>
+
+	<p>close this tab or window to exit gofer</p>
+
+	<button onclick="popout()">pop out</button>
+
+	<script>
+		function ping() {
+			fetch({{.HeartbeatURL}})
+				.catch(() => {
+					window.close();
+				});
+		}
+
+		function popout() {
+			const w = window.open(
+				"/heartmon",
+				"gofer-heartmon",
+				"width=240,height=240,resizable=yes"
+			);
+
+			// If popup succeeded, close this tab
+			if (w) {
+				window.close();
+			}
+		}
+
+		ping();
+		setInterval(ping, 30000);
+	</script>
+
+</body>
+</html>
+{{end}}
+`
+
+var pageTemplates = template.Must(template.New("gofer").Funcs(template.FuncMap{
+	"sessionToken": func() string { return sessionToken },
+}).Parse(
+	layoutTemplates + menuTemplates + formTemplates + terminalTemplates + mediaTemplates + decodedTemplates + heartmonTemplate,
+))
+
+// pageHead is the data every page passes to the shared "head" template.
+type pageHead struct {
+	Title string
+}
+
+// returnLink is the "Exit ..." link at the bottom of form pages.
+type returnLink struct {
+	Href  string
+	Label string
+}
+
+// renderTemplate executes one named template into w.
+func renderTemplate(w io.Writer, name string, data any) error {
+	return pageTemplates.ExecuteTemplate(w, name, data)
+}
+
+// renderString executes one named template into a string. Template errors
+// are programming errors, so they are reported in the page rather than returned.
+func renderString(name string, data any) string {
+	var out strings.Builder
+	if err := renderTemplate(&out, name, data); err != nil {
+		out.WriteString(template.HTMLEscapeString("template error: " + err.Error()))
+	}
+	return out.String()
+}
@@ -0,0 +1,341 @@
+// gophers module for gofer 0.9
+// TLS transport for gopher, with trust-on-first-use certificate pinning
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_GOPHERS_PORT = "70"
+	PIN_STORE_FILE       = "pins.json"
+	TLS_PROBE_TIMEOUT    = 2 * time.Second
+	TRUST_ENDPOINT       = "/trust"
+)
+
+// opportunisticTLS makes plain gopher:// connections try TLS first (-tls-upgrade).
+var opportunisticTLS bool
+
+// --- Transport Registry ---
+// remembers which host:port pairs speak TLS (and, for opportunistic
+// upgrades, which ones were already found not to)
+
+var transportMux sync.Mutex
+var secureHosts = map[string]bool{}
+var plainHosts = map[string]bool{}
+
+// markSecureHost records that host:port is reached over TLS.
+func markSecureHost(host, port string) {
+	transportMux.Lock()
+	secureHosts[net.JoinHostPort(host, port)] = true
+	transportMux.Unlock()
+}
+
+// isSecureHost reports whether host:port is reached over TLS, either in this
+// session or because a certificate was pinned for it earlier.
+func isSecureHost(host, port string) bool {
+	address := net.JoinHostPort(host, port)
+
+	transportMux.Lock()
+	secure := secureHosts[address]
+	transportMux.Unlock()
+
+	if secure {
+		return true
+	}
+	_, pinned := pins.get(address)
+	return pinned
+}
+
+// -----------------------------------------------------------
+// dialRemote(host, port, timeout, wantTLS) -> conn
+//
+// The one place gofer opens outbound connections, so the outbound
+// policy is enforced here. Secure hosts always get TLS, and so
+// does a connection made with wantTLS (a gophers:// link); with
+// -tls-upgrade, plain hosts are probed once. A host only becomes
+// secure for the session after a TLS handshake with it succeeds,
+// so a link can't force TLS onto hosts it doesn't control.
+// -----------------------------------------------------------
+func dialRemote(host, port string, timeout time.Duration, wantTLS bool) (net.Conn, error) {
+	address := net.JoinHostPort(host, port)
+
+	// every connection goes through the outbound policy first
+	dialer, err := policyDialer(host, port, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	if wantTLS || isSecureHost(host, port) {
+		conn, err := dialTLS(host, port, dialer)
+		if err == nil {
+			markSecureHost(host, port)
+		}
+		return conn, err
+	}
+
+	transportMux.Lock()
+	probe := opportunisticTLS && !plainHosts[address]
+	transportMux.Unlock()
+
+	if probe {
+		probeDialer := *dialer
+		probeDialer.Timeout = TLS_PROBE_TIMEOUT
+		conn, err := dialTLS(host, port, &probeDialer)
+		if err == nil {
+			markSecureHost(host, port)
+			return conn, nil
+		}
+
+		var pinErr *PinMismatchError
+		var policyErr *PolicyError
+		if errors.As(err, &pinErr) || errors.As(err, &policyErr) {
+			return nil, err // never downgrade around a changed certificate or a blocked address
+		}
+
+		transportMux.Lock()
+		plainHosts[address] = true
+		transportMux.Unlock()
+	}
+
+	return dialer.Dial("tcp", address)
+}
+
+// dialTLS connects with TLS, accepting either a CA-verified chain or a pinned certificate.
+func dialTLS(host, port string, dialer *net.Dialer) (net.Conn, error) {
+	address := net.JoinHostPort(host, port)
+
+	config := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // verification is done by verifyPeer
+		VerifyConnection:   verifyPeer(address, host),
+	}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("TLS connection to %s failed: %w", address, err)
+	}
+	return conn, nil
+}
+
+// verifyPeer accepts certificates that chain to a system root; anything else
+// (typically self-signed) is pinned the first time it is seen.
+func verifyPeer(address, serverName string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		leaf := cs.PeerCertificates[0]
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}); err == nil {
+			return nil
+		}
+
+		presented := certFingerprint(leaf)
+		known, ok := pins.get(address)
+		if !ok {
+			fmt.Printf("Pinning certificate for %s on first use: %s\n", address, presented)
+			return pins.set(address, presented)
+		}
+		if known.Fingerprint != presented {
+			return &PinMismatchError{Address: address, Known: known.Fingerprint, Presented: presented, FirstSeen: known.FirstSeen}
+		}
+		return nil
+	}
+}
+
+// certFingerprint is the colon-separated SHA-256 of the certificate.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// --- Pin Store ---
+// a small JSON file in the user config directory: host:port -> fingerprint
+
+type pinEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"first_seen"`
+}
+
+type pinStore struct {
+	mu     sync.Mutex
+	loaded bool
+	path   string
+	pins   map[string]pinEntry
+}
+
+var pins = &pinStore{}
+
+// PinMismatchError means a host presented a different certificate than the pinned one.
+type PinMismatchError struct {
+	Address   string
+	Known     string
+	Presented string
+	FirstSeen time.Time
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate for %s has changed (pinned %s, presented %s)", e.Address, e.Known, e.Presented)
+}
+
+// load reads the store once; a missing or unreadable file starts an empty store.
+// Callers must hold s.mu.
+func (s *pinStore) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	s.pins = map[string]pinEntry{}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		fmt.Printf("Warning: no config directory, certificate pins will not persist: %v\n", err)
+		return
+	}
+	s.path = filepath.Join(dir, "gofer", PIN_STORE_FILE)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &s.pins); err != nil {
+		fmt.Printf("Warning: ignoring unreadable pin store %s: %v\n", s.path, err)
+		s.pins = map[string]pinEntry{}
+	}
+}
+
+func (s *pinStore) get(address string) (pinEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	entry, ok := s.pins[address]
+	return entry, ok
+}
+
+// set records (or replaces) the pin for address and writes the store to disk.
+func (s *pinStore) set(address, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+
+	s.pins[address] = pinEntry{Fingerprint: fingerprint, FirstSeen: time.Now()}
+
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pin store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create pin store directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write pin store: %w", err)
+	}
+	return nil
+}
+
+// --- HTTP Handlers ---
+
+// servePinWarning renders the changed-certificate page if err is a pin mismatch.
+// It reports whether it handled the response.
+func servePinWarning(w http.ResponseWriter, r *http.Request, err error) bool {
+	var pinErr *PinMismatchError
+	if !errors.As(err, &pinErr) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(formatPinWarningPage(pinErr, r.URL.RequestURI())))
+	return true
+}
+
+// handleTrust replaces a pin after the user accepted the new certificate.
+func handleTrust(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	address := r.FormValue("address")
+	fingerprint := r.FormValue("fingerprint")
+	if address == "" || fingerprint == "" {
+		http.Error(w, "Missing address or fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	if err := pins.set(address, fingerprint); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	returnURL := r.FormValue("return")
+	if !strings.HasPrefix(returnURL, "/") || strings.HasPrefix(returnURL, "//") {
+		returnURL = "/"
+	}
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+// pinWarningView is the data for the "pin-warning" template.
+type pinWarningView struct {
+	pageHead
+	Address       string
+	FirstSeen     string
+	Known         string
+	Presented     string
+	TrustEndpoint string
+	ReturnURL     string
+	Return        returnLink
+}
+
+// HTML UI formatting function
+func formatPinWarningPage(e *PinMismatchError, returnURL string) string {
+	firstSeen := "unknown"
+	if !e.FirstSeen.IsZero() {
+		firstSeen = e.FirstSeen.Format("2006-01-02 15:04")
+	}
+
+	return renderString("pin-warning", pinWarningView{
+		pageHead:      pageHead{Title: "gofer - certificate changed for " + e.Address},
+		Address:       e.Address,
+		FirstSeen:     firstSeen,
+		Known:         e.Known,
+		Presented:     e.Presented,
+		TrustEndpoint: TRUST_ENDPOINT,
+		ReturnURL:     returnURL,
+		Return:        returnLink{Href: "/", Label: "Go back"},
+	})
+}
@@ -0,0 +1,44 @@
+// gophers tests for gofer 0.9
+// a tls=1 link is a hint for one request, not a setting for the host
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTLSHintDoesNotPinHost(t *testing.T) {
+	host, port := listenLoopback(t, func(c net.Conn) {
+		defer c.Close()
+		r := bufio.NewReader(c)
+		if b, err := r.Peek(1); err != nil || b[0] == 0x16 {
+			return // a TLS ClientHello: this server only speaks plain gopher
+		}
+		r.ReadString('\n')
+		c.Write([]byte("iplain\t\terror.host\t1\r\n.\r\n"))
+	})
+	g := &GopherURL{Host: host, Port: port, Type: '1', Selector: "/"}
+
+	hinted := *g
+	hinted.TLS = true
+	if _, err := openGopherTarget(&hinted); err == nil {
+		t.Fatal("TLS request to a plain server succeeded")
+	}
+	if isSecureHost(host, port) {
+		t.Fatal("a failed tls=1 request marked the host as TLS-only")
+	}
+
+	conn, err := openGopherTarget(g)
+	if err != nil {
+		t.Fatalf("plain fetch after the tls=1 hint: %v", err)
+	}
+	defer conn.Close()
+	if reply, _ := conn.ReadAll(); !strings.Contains(string(reply), "plain") {
+		t.Errorf("plain fetch after the tls=1 hint got %q", reply)
+	}
+}
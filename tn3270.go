@@ -0,0 +1,449 @@
+// tn3270 module for gofer 0.9
+// type T items: TN3270E (RFC 2355) with TN3270 (RFC 1576) fallback,
+// bridged to a browser page with input fields and AID keys
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	TN3270_ENDPOINT        = "/tn3270"
+	TN3270_SOCKET_ENDPOINT = "/tn3270/socket"
+	TN3270_DEVICE_TYPE     = "IBM-3278-2-E" // model 2 (24x80) with extended data stream
+	TN3270_MAX_RECORD      = 1 << 16
+)
+
+// telnet additions used by TN3270 (RFC 885, 2355)
+const (
+	TELNET_EOR     = 239
+	TELOPT_EOR     = 25
+	TELOPT_TN3270E = 40
+
+	TN3270E_CONNECT     = 1
+	TN3270E_DEVICE_TYPE = 2
+	TN3270E_FUNCTIONS   = 3
+	TN3270E_IS          = 4
+	TN3270E_REJECT      = 6
+	TN3270E_REQUEST     = 7
+	TN3270E_SEND        = 8
+
+	TN3270E_DT_3270_DATA    = 0
+	TN3270E_DT_UNBIND       = 4
+	TN3270E_DT_NVT_DATA     = 5
+	TN3270E_DT_SSCP_LU_DATA = 7
+	TN3270E_HEADER_LENGTH   = 5
+)
+
+// -----------------------------------------------------------
+// tn3270Session relays one 3270 session. The host's records are
+// decoded into the screen model and pushed to the browser as
+// JSON; AID keys from the browser become inbound records.
+// -----------------------------------------------------------
+type tn3270Session struct {
+	remote  net.Conn
+	ws      *wsConn
+	writeMu sync.Mutex
+
+	parser telnetParser
+
+	// session state, shared by the relay goroutines
+	mu       sync.Mutex
+	screen   *screen3270
+	us       map[byte]bool
+	him      map[byte]bool
+	refused  map[[2]byte]bool
+	extended bool   // TN3270E in effect: records carry a 5-byte header
+	record   []byte // the record being received, up to IAC EOR
+	device   string // device type and LU name granted by the host
+	lu       string
+	status   string
+}
+
+func newTN3270Session(remote net.Conn, ws *wsConn) *tn3270Session {
+	return &tn3270Session{
+		remote:  remote,
+		ws:      ws,
+		screen:  newScreen3270(TN3270_ROWS, TN3270_COLS),
+		us:      map[byte]bool{},
+		him:     map[byte]bool{},
+		refused: map[[2]byte]bool{},
+		status:  "negotiating",
+	}
+}
+
+func (s *tn3270Session) send(b ...byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.remote.Write(b)
+	return err
+}
+
+// sendRecord sends one inbound record, with the TN3270E header if negotiated.
+// Callers hold s.mu.
+func (s *tn3270Session) sendRecord(data []byte) error {
+	var record []byte
+	if s.extended {
+		record = append(record, TN3270E_DT_3270_DATA, 0, 0, 0, 0)
+	}
+	record = append(record, data...)
+	record = append(telnetEscape(record), TELNET_IAC, TELNET_EOR)
+	return s.send(record...)
+}
+
+// run relays until either side closes.
+func (s *tn3270Session) run() {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := s.remote.Read(buf)
+			if n > 0 {
+				updateActivity()
+				s.parser.feed(buf[:n], s.onData, s.onCommand, s.onSub)
+			}
+			if err != nil {
+				s.ws.CloseWithReason(1000, "connection closed by remote host")
+				return
+			}
+		}
+	}()
+
+	for {
+		opcode, message, err := s.ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		updateActivity()
+
+		if opcode == WS_OP_TEXT {
+			if err := s.onKey(message); err != nil {
+				break
+			}
+		}
+	}
+
+	s.remote.Close()
+	<-done
+}
+
+// --- Host Side ---
+
+func (s *tn3270Session) onData(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.record)+len(b) > TN3270_MAX_RECORD {
+		s.record = s.record[:0]
+		s.status = "record too large, dropped"
+		return
+	}
+	s.record = append(s.record, b...)
+}
+
+func (s *tn3270Session) onCommand(cmd, opt byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd {
+	case TELNET_EOR:
+		s.onRecord()
+
+	case TELNET_DO:
+		switch opt {
+		case TELOPT_TN3270E, TELOPT_TTYPE, TELOPT_EOR, TELOPT_BINARY:
+			if !s.us[opt] {
+				s.us[opt] = true
+				s.send(TELNET_IAC, TELNET_WILL, opt)
+			}
+		default:
+			s.refuse(TELNET_WONT, opt)
+		}
+
+	case TELNET_DONT:
+		if s.us[opt] {
+			s.us[opt] = false
+			s.send(TELNET_IAC, TELNET_WONT, opt)
+		}
+		if opt == TELOPT_TN3270E {
+			s.extended = false
+		}
+
+	case TELNET_WILL:
+		switch opt {
+		case TELOPT_EOR, TELOPT_BINARY:
+			if !s.him[opt] {
+				s.him[opt] = true
+				s.send(TELNET_IAC, TELNET_DO, opt)
+			}
+		default:
+			s.refuse(TELNET_DONT, opt)
+		}
+
+	case TELNET_WONT:
+		if s.him[opt] {
+			s.him[opt] = false
+			s.send(TELNET_IAC, TELNET_DONT, opt)
+		}
+	}
+}
+
+// refuse declines an option once; repeating the refusal could loop forever.
+func (s *tn3270Session) refuse(reply, opt byte) {
+	key := [2]byte{reply, opt}
+	if s.refused[key] {
+		return
+	}
+	s.refused[key] = true
+	s.send(TELNET_IAC, reply, opt)
+}
+
+func (s *tn3270Session) onSub(opt byte, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch opt {
+	case TELOPT_TTYPE: // plain TN3270: the terminal type names the model
+		if len(data) > 0 && data[0] == TTYPE_SEND {
+			s.sendSub(TELOPT_TTYPE, append([]byte{TTYPE_IS}, TN3270_DEVICE_TYPE...))
+		}
+
+	case TELOPT_TN3270E:
+		s.onTN3270E(data)
+	}
+}
+
+// onTN3270E runs the TN3270E subnegotiation: device type, then functions.
+// We ask for no optional functions, which every server must accept.
+func (s *tn3270Session) onTN3270E(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+
+	switch {
+	case data[0] == TN3270E_SEND && data[1] == TN3270E_DEVICE_TYPE:
+		s.sendSub(TELOPT_TN3270E, append([]byte{TN3270E_DEVICE_TYPE, TN3270E_REQUEST}, TN3270_DEVICE_TYPE...))
+
+	case data[0] == TN3270E_DEVICE_TYPE && data[1] == TN3270E_IS:
+		device, lu, _ := strings.Cut(string(data[2:]), string(rune(TN3270E_CONNECT)))
+		s.device, s.lu = device, lu
+		s.sendSub(TELOPT_TN3270E, []byte{TN3270E_FUNCTIONS, TN3270E_REQUEST})
+
+	case data[0] == TN3270E_DEVICE_TYPE && data[1] == TN3270E_REJECT:
+		// fall back to plain TN3270 (RFC 1576)
+		s.us[TELOPT_TN3270E] = false
+		s.send(TELNET_IAC, TELNET_WONT, TELOPT_TN3270E)
+
+	case data[0] == TN3270E_FUNCTIONS && data[1] == TN3270E_REQUEST:
+		if len(data) == 2 {
+			s.sendSub(TELOPT_TN3270E, []byte{TN3270E_FUNCTIONS, TN3270E_IS})
+		} else {
+			s.sendSub(TELOPT_TN3270E, []byte{TN3270E_FUNCTIONS, TN3270E_REQUEST})
+		}
+
+	case data[0] == TN3270E_FUNCTIONS && data[1] == TN3270E_IS:
+		s.extended = true
+		s.status = "connected, TN3270E " + s.device
+		if s.lu != "" {
+			s.status += " on " + s.lu
+		}
+		s.push()
+	}
+}
+
+func (s *tn3270Session) sendSub(opt byte, data []byte) {
+	reply := []byte{TELNET_IAC, TELNET_SB, opt}
+	reply = append(reply, telnetEscape(data)...)
+	reply = append(reply, TELNET_IAC, TELNET_SE)
+	s.send(reply...)
+}
+
+// onRecord handles one complete record from the host. Callers hold s.mu.
+func (s *tn3270Session) onRecord() {
+	record := s.record
+	s.record = nil
+
+	if s.extended {
+		if len(record) < TN3270E_HEADER_LENGTH {
+			return
+		}
+		dataType := record[0]
+		record = record[TN3270E_HEADER_LENGTH:]
+
+		switch dataType {
+		case TN3270E_DT_3270_DATA:
+		case TN3270E_DT_SSCP_LU_DATA:
+			s.status = "SSCP: " + strings.TrimSpace(ebcdicString(record))
+			s.push()
+			return
+		case TN3270E_DT_NVT_DATA:
+			s.status = strings.TrimSpace(string(record))
+			s.push()
+			return
+		case TN3270E_DT_UNBIND:
+			s.status = "session unbound by host"
+			s.push()
+			return
+		default:
+			return
+		}
+	} else if s.status == "negotiating" {
+		s.status = "connected"
+	}
+
+	reply, err := s.screen.process(record)
+	if err != nil {
+		s.status = err.Error()
+	}
+	if reply != nil {
+		s.sendRecord(reply)
+	}
+	s.push()
+}
+
+// --- Browser Side ---
+
+// tn3270Key is an AID key press from the browser, with the inputs that changed.
+type tn3270Key struct {
+	AID    string `json:"aid"`
+	Cursor int    `json:"cursor"`
+	Fields []struct {
+		Addr  int    `json:"a"`
+		Len   int    `json:"n"`
+		Value string `json:"v"`
+	} `json:"fields"`
+}
+
+// tn3270Update is what the browser receives after every change.
+type tn3270Update struct {
+	Screen screenView3270 `json:"screen"`
+	Status string         `json:"status"`
+}
+
+func (s *tn3270Session) onKey(message []byte) error {
+	var key tn3270Key
+	if err := json.Unmarshal(message, &key); err != nil {
+		return nil // ignore what we don't understand
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reset unlocks the keyboard locally, like the 3270 key of that name
+	if key.AID == "reset" {
+		s.screen.locked = false
+		s.push()
+		return nil
+	}
+
+	code, ok := aidCodes[key.AID]
+	if !ok {
+		return nil
+	}
+	if s.screen.locked {
+		s.push() // the browser re-renders and shows the lock
+		return nil
+	}
+
+	for _, f := range key.Fields {
+		s.screen.typeInto(f.Addr, f.Len, f.Value)
+	}
+	if key.Cursor >= 0 && key.Cursor < s.screen.size() {
+		s.screen.cursor = key.Cursor
+	}
+
+	err := s.sendRecord(s.screen.aid(code))
+	s.push()
+	return err
+}
+
+// push sends the current screen to the browser. Callers hold s.mu.
+func (s *tn3270Session) push() {
+	update, err := json.Marshal(tn3270Update{Screen: s.screen.view(), Status: s.status})
+	if err != nil {
+		return
+	}
+	s.ws.WriteMessage(WS_OP_TEXT, update)
+}
+
+// --- HTTP Handlers ---
+
+// tn3270Link builds the /tn3270 route for a type T menu item.
+func tn3270Link(host, port, selector, returnTo string) string {
+	return fmt.Sprintf("%s?host=%s&port=%s&selector=%s&return=%s",
+		TN3270_ENDPOINT,
+		url.QueryEscape(host), url.QueryEscape(port),
+		url.QueryEscape(selector), url.QueryEscape(returnTo),
+	)
+}
+
+// handleTN3270 renders the 3270 screen page; the page then opens the socket.
+func handleTN3270(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	if port == "" || port == "0" {
+		port = TELNET_DEFAULT_PORT
+	}
+	if host == "" {
+		http.Error(w, "Missing host", http.StatusBadRequest)
+		return
+	}
+
+	returnURL := query.Get("return")
+	if returnURL == "" {
+		returnURL = "/"
+	}
+
+	address := net.JoinHostPort(host, port)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderTemplate(w, "tn3270", terminalView{
+		pageHead: pageHead{Title: "gofer tn3270 - " + address},
+		Address:  address,
+		Login:    query.Get("selector"),
+		SocketPath: fmt.Sprintf("%s?host=%s&port=%s&%s=%s",
+			TN3270_SOCKET_ENDPOINT, url.QueryEscape(host), url.QueryEscape(port),
+			SESSION_TOKEN_FIELD, url.QueryEscape(sessionToken)),
+		Return: returnLink{Href: returnURL, Label: "Exit TN3270"},
+	})
+}
+
+// handleTN3270Socket upgrades to a WebSocket and relays it to the 3270 host.
+func handleTN3270Socket(w http.ResponseWriter, r *http.Request) {
+	updateActivity()
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	port := query.Get("port")
+	if host == "" || port == "" {
+		http.Error(w, "Missing host or port", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+
+	remote, err := dialTelnet(host, port)
+	if err != nil {
+		ws.CloseWithReason(1011, err.Error())
+		return
+	}
+	defer remote.Close()
+
+	newTN3270Session(remote, ws).run()
+}
@@ -0,0 +1,350 @@
+// tn3270 tests for gofer 0.9
+// a scripted 3270 host on a net.Pipe, and a browser on another
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const MOCK_3270_WAIT = 2 * time.Second
+
+// mock3270 drives a tn3270Session from both ends: it plays the host on
+// one pipe and the browser's websocket on the other.
+type mock3270 struct {
+	t       *testing.T
+	host    net.Conn
+	browser net.Conn
+
+	received chan []byte // bytes the session sent to the host
+	pending  []byte
+	updates  chan tn3270Update
+}
+
+func newMock3270(t *testing.T) *mock3270 {
+	host, remote := net.Pipe()
+	browser, server := net.Pipe()
+	m := &mock3270{
+		t:        t,
+		host:     host,
+		browser:  browser,
+		received: make(chan []byte, 64),
+		updates:  make(chan tn3270Update, 256),
+	}
+
+	s := newTN3270Session(remote, &wsConn{conn: server, reader: bufio.NewReader(server)})
+	go s.run()
+
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := host.Read(buf)
+			if err != nil {
+				return
+			}
+			m.received <- buf[:n]
+		}
+	}()
+
+	go func() {
+		br := bufio.NewReader(browser)
+		for {
+			op, payload, err := readServerFrame(br)
+			if err != nil {
+				return
+			}
+			var u tn3270Update
+			if op == WS_OP_TEXT && json.Unmarshal(payload, &u) == nil {
+				m.updates <- u
+			}
+		}
+	}()
+
+	t.Cleanup(func() {
+		host.Close()
+		browser.Close()
+	})
+	return m
+}
+
+// readServerFrame reads one unmasked frame as gofer sends them.
+func readServerFrame(br *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(br, payload)
+	return head[0] & 0x0F, payload, err
+}
+
+// send writes raw telnet bytes from the host.
+func (m *mock3270) send(b ...byte) {
+	m.t.Helper()
+	if _, err := m.host.Write(b); err != nil {
+		m.t.Fatalf("host write: %v", err)
+	}
+}
+
+// sub sends a subnegotiation from the host.
+func (m *mock3270) sub(opt byte, data ...byte) {
+	m.t.Helper()
+	m.send(stream([]byte{TELNET_IAC, TELNET_SB, opt}, data, []byte{TELNET_IAC, TELNET_SE})...)
+}
+
+// record sends one outbound record, with a TN3270E header if extended.
+func (m *mock3270) record(extended bool, data []byte) {
+	m.t.Helper()
+	if extended {
+		data = stream([]byte{TN3270E_DT_3270_DATA, 0, 0, 0, 0}, data)
+	}
+	m.send(append(telnetEscape(data), TELNET_IAC, TELNET_EOR)...)
+}
+
+// expect waits until the session has sent want to the host, and drops
+// everything up to and including it.
+func (m *mock3270) expect(want ...byte) {
+	m.t.Helper()
+	deadline := time.After(MOCK_3270_WAIT)
+	for {
+		if i := bytes.Index(m.pending, want); i >= 0 {
+			m.pending = m.pending[i+len(want):]
+			return
+		}
+		select {
+		case b := <-m.received:
+			m.pending = append(m.pending, b...)
+		case <-deadline:
+			m.t.Fatalf("host waited for % x, got % x", want, m.pending)
+		}
+	}
+}
+
+// expectSub waits for a subnegotiation from the session.
+func (m *mock3270) expectSub(opt byte, data ...byte) {
+	m.t.Helper()
+	m.expect(stream([]byte{TELNET_IAC, TELNET_SB, opt}, data, []byte{TELNET_IAC, TELNET_SE})...)
+}
+
+// expectRecord waits for the next inbound record and returns it, unescaped.
+func (m *mock3270) expectRecord() []byte {
+	m.t.Helper()
+	deadline := time.After(MOCK_3270_WAIT)
+	for {
+		if i := bytes.Index(m.pending, []byte{TELNET_IAC, TELNET_EOR}); i >= 0 {
+			record := bytes.ReplaceAll(m.pending[:i], []byte{TELNET_IAC, TELNET_IAC}, []byte{TELNET_IAC})
+			m.pending = m.pending[i+2:]
+			return record
+		}
+		select {
+		case b := <-m.received:
+			m.pending = append(m.pending, b...)
+		case <-deadline:
+			m.t.Fatalf("host waited for a record, got % x", m.pending)
+		}
+	}
+}
+
+// waitFor returns the first screen update the browser gets that satisfies ok.
+func (m *mock3270) waitFor(what string, ok func(tn3270Update) bool) tn3270Update {
+	m.t.Helper()
+	deadline := time.After(MOCK_3270_WAIT)
+	for {
+		select {
+		case u := <-m.updates:
+			if ok(u) {
+				return u
+			}
+		case <-deadline:
+			m.t.Fatalf("browser waited for %s", what)
+		}
+	}
+}
+
+// key sends a key press from the browser, masked as clients must.
+func (m *mock3270) key(message string) {
+	m.t.Helper()
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | WS_OP_TEXT, 0x80 | byte(len(message))}
+	frame = append(frame, mask...)
+	for i := 0; i < len(message); i++ {
+		frame = append(frame, message[i]^mask[i%4])
+	}
+	if _, err := m.browser.Write(frame); err != nil {
+		m.t.Fatalf("browser write: %v", err)
+	}
+}
+
+// rowText is the text of one screen row as the browser shows it.
+func rowText(u tn3270Update, row int) string {
+	var b strings.Builder
+	for _, seg := range u.Screen.Rows[row] {
+		b.WriteString(seg.Text)
+	}
+	return b.String()
+}
+
+// negotiateTN3270E runs the RFC 2355 handshake from the host's side.
+func (m *mock3270) negotiateTN3270E() {
+	m.t.Helper()
+	m.send(TELNET_IAC, TELNET_DO, TELOPT_TN3270E)
+	m.expect(TELNET_IAC, TELNET_WILL, TELOPT_TN3270E)
+
+	m.sub(TELOPT_TN3270E, TN3270E_SEND, TN3270E_DEVICE_TYPE)
+	m.expectSub(TELOPT_TN3270E, append([]byte{TN3270E_DEVICE_TYPE, TN3270E_REQUEST}, TN3270_DEVICE_TYPE...)...)
+
+	m.sub(TELOPT_TN3270E, stream([]byte{TN3270E_DEVICE_TYPE, TN3270E_IS}, []byte(TN3270_DEVICE_TYPE), []byte{TN3270E_CONNECT}, []byte("LU01"))...)
+	m.expectSub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_REQUEST)
+
+	m.sub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_IS)
+}
+
+func TestTN3270ENegotiation(t *testing.T) {
+	m := newMock3270(t)
+	m.negotiateTN3270E()
+	m.waitFor("the TN3270E status", func(u tn3270Update) bool {
+		return u.Status == "connected, TN3270E "+TN3270_DEVICE_TYPE+" on LU01"
+	})
+
+	m.record(true, stream([]byte{CMD_EW, WCC_RESTORE}, ebcdic("WELCOME")))
+	m.waitFor("the welcome screen", func(u tn3270Update) bool {
+		return strings.HasPrefix(rowText(u, 0), "WELCOME")
+	})
+
+	// SSCP-LU data is shown as the status, not written to the screen
+	m.send(append(stream([]byte{TN3270E_DT_SSCP_LU_DATA, 0, 0, 0, 0}, ebcdic("LOGON PLEASE")), TELNET_IAC, TELNET_EOR)...)
+	u := m.waitFor("the SSCP message", func(u tn3270Update) bool { return u.Status == "SSCP: LOGON PLEASE" })
+	if !strings.HasPrefix(rowText(u, 0), "WELCOME") {
+		t.Errorf("SSCP data changed the screen: %q", rowText(u, 0))
+	}
+}
+
+func TestTN3270EFunctionsCounterProposal(t *testing.T) {
+	m := newMock3270(t)
+	m.send(TELNET_IAC, TELNET_DO, TELOPT_TN3270E)
+	m.expect(TELNET_IAC, TELNET_WILL, TELOPT_TN3270E)
+	m.sub(TELOPT_TN3270E, stream([]byte{TN3270E_DEVICE_TYPE, TN3270E_IS}, []byte(TN3270_DEVICE_TYPE))...)
+	m.expectSub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_REQUEST)
+
+	// the host proposes functions; gofer insists on none
+	m.sub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_REQUEST, 0x00, 0x02)
+	m.expectSub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_REQUEST)
+
+	// and agrees when the host does
+	m.sub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_REQUEST)
+	m.expectSub(TELOPT_TN3270E, TN3270E_FUNCTIONS, TN3270E_IS)
+}
+
+func TestTN3270Fallback(t *testing.T) {
+	m := newMock3270(t)
+	m.send(TELNET_IAC, TELNET_DO, TELOPT_TN3270E)
+	m.expect(TELNET_IAC, TELNET_WILL, TELOPT_TN3270E)
+	m.sub(TELOPT_TN3270E, TN3270E_SEND, TN3270E_DEVICE_TYPE)
+	m.expectSub(TELOPT_TN3270E, append([]byte{TN3270E_DEVICE_TYPE, TN3270E_REQUEST}, TN3270_DEVICE_TYPE...)...)
+
+	// a host that can't serve the device type falls back to RFC 1576
+	m.sub(TELOPT_TN3270E, TN3270E_DEVICE_TYPE, TN3270E_REJECT, 0x00, 0x06)
+	m.expect(TELNET_IAC, TELNET_WONT, TELOPT_TN3270E)
+
+	m.send(TELNET_IAC, TELNET_DO, TELOPT_TTYPE)
+	m.expect(TELNET_IAC, TELNET_WILL, TELOPT_TTYPE)
+	m.sub(TELOPT_TTYPE, TTYPE_SEND)
+	m.expectSub(TELOPT_TTYPE, append([]byte{TTYPE_IS}, TN3270_DEVICE_TYPE...)...)
+
+	for _, opt := range []byte{TELOPT_EOR, TELOPT_BINARY} {
+		m.send(TELNET_IAC, TELNET_DO, opt)
+		m.expect(TELNET_IAC, TELNET_WILL, opt)
+		m.send(TELNET_IAC, TELNET_WILL, opt)
+		m.expect(TELNET_IAC, TELNET_DO, opt)
+	}
+
+	// options gofer doesn't speak are refused
+	m.send(TELNET_IAC, TELNET_DO, TELOPT_NAWS)
+	m.expect(TELNET_IAC, TELNET_WONT, TELOPT_NAWS)
+
+	// records now come without the TN3270E header
+	m.record(false, stream([]byte{CMD_EW, WCC_RESTORE}, ebcdic("PLAIN")))
+	m.waitFor("the plain TN3270 screen", func(u tn3270Update) bool {
+		return u.Status == "connected" && strings.HasPrefix(rowText(u, 0), "PLAIN")
+	})
+}
+
+func TestTN3270AIDReadBack(t *testing.T) {
+	m := newMock3270(t)
+	m.negotiateTN3270E()
+
+	m.record(true, stream(
+		[]byte{CMD_EW, 0xC3},
+		[]byte{ORDER_SBA}, encodeAddress(0),
+		[]byte{ORDER_SF, 0x60}, ebcdic("NAME:"),
+		[]byte{ORDER_SF, 0x40, ORDER_IC},
+		[]byte{ORDER_SBA}, encodeAddress(20),
+		[]byte{ORDER_SF, 0x60}, ebcdic("OK"),
+	))
+	u := m.waitFor("the logon screen", func(u tn3270Update) bool {
+		return strings.HasPrefix(rowText(u, 0), " NAME:")
+	})
+	if u.Screen.Cursor != 7 || u.Screen.Locked {
+		t.Fatalf("cursor %d locked %v, want 7 and unlocked", u.Screen.Cursor, u.Screen.Locked)
+	}
+
+	m.key(`{"aid":"enter","cursor":10,"fields":[{"a":7,"n":13,"v":"BOB"}]}`)
+	got := m.expectRecord()
+	want := stream([]byte{TN3270E_DT_3270_DATA, 0, 0, 0, 0, AID_ENTER}, encodeAddress(10), []byte{ORDER_SBA}, encodeAddress(7), ebcdic("BOB"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("enter record = % x, want % x", got, want)
+	}
+	m.waitFor("the locked keyboard", func(u tn3270Update) bool { return u.Screen.Locked })
+
+	// a locked keyboard sends nothing until the host (or Reset) unlocks it
+	m.key(`{"aid":"pf3","cursor":10}`)
+	m.key(`{"aid":"reset"}`)
+	m.waitFor("the unlocked keyboard", func(u tn3270Update) bool { return !u.Screen.Locked })
+	m.key(`{"aid":"pa1","cursor":10}`)
+	if got := m.expectRecord(); !bytes.Equal(got, []byte{TN3270E_DT_3270_DATA, 0, 0, 0, 0, AID_PA1}) {
+		t.Errorf("after reset, record = % x, want the PA1 short read", got)
+	}
+
+	// queries are answered without the browser
+	m.record(true, []byte{CMD_WSF, 0x00, 0x05, 0x01, 0xFF, 0x02})
+	if got := m.expectRecord(); !bytes.HasPrefix(got, []byte{TN3270E_DT_3270_DATA, 0, 0, 0, 0, AID_SF}) {
+		t.Errorf("query reply = % x", got)
+	}
+}
+
+func TestTN3270RecordEscaping(t *testing.T) {
+	m := newMock3270(t)
+	m.negotiateTN3270E()
+
+	// 0xFF (a control, shown as a blank) is doubled on the wire, and must
+	// come out as one position
+	m.record(true, stream([]byte{CMD_EW, WCC_RESTORE}, ebcdic("A"), []byte{0xFF}, ebcdic("B")))
+	m.waitFor("the escaped screen", func(u tn3270Update) bool {
+		return strings.HasPrefix(rowText(u, 0), "A B")
+	})
+}
@@ -0,0 +1,387 @@
+// item type registry for gofer 0.9
+// one entry per gopher item type: how it looks in a menu and how it is served
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serveStrategy says how a fetched reply reaches the browser.
+type serveStrategy int
+
+const (
+	SERVE_RAW      serveStrategy = iota // the bytes as they are, under the registry's (or a guessed) Content-Type
+	SERVE_TEXT                          // plain text
+	SERVE_MENU                          // rendered as a gopher menu
+	SERVE_BINHEX                        // decoded, with a page describing the Mac file
+	SERVE_UUDECODE                      // decoded and sent under the embedded file name
+	SERVE_ARCHIVE                       // archives listed as a menu, other files downloaded
+	SERVE_IMAGE                         // a view page, with legacy formats converted to PNG
+	SERVE_SOUND                         // a player page, with legacy formats transcoded to WAV
+)
+
+// pages gofer wraps around video and documents; the page loads the item itself with raw=1
+const (
+	PAGE_VIDEO    = "video"
+	PAGE_DOCUMENT = "document"
+)
+
+// itemKind describes one item type. Adding a type means adding an entry
+// to itemKinds; the menu renderer and serveGopher only consult the registry.
+type itemKind struct {
+	Icon   string
+	Style  string // "" for links, "error" or "info" for colored icons
+	NoLink bool   // error and info lines are not links
+
+	MIME     string        // Content-Type to serve; "" guesses from the selector's extension, then sniffs
+	Serve    serveStrategy // how a fetched reply reaches the browser
+	Page     string        // PAGE_VIDEO or PAGE_DOCUMENT: shown in a gofer page
+	Download bool          // offered as an attachment instead of shown
+
+	// types with a route of their own (Ph, searches, terminals) link there
+	// instead of to a fetch; Route returns "" when the fetch applies after all
+	Route       func(g *GopherURL, returnTo string) string
+	Mirrors     bool   // the route accepts type + mirrors
+	DefaultPort string // port for items that leave it out, instead of the menu's own
+}
+
+var itemKinds = map[byte]*itemKind{
+	// RFC 1436
+	'0': {Icon: "[TXT]", Serve: SERVE_TEXT},
+	'1': {Icon: "[ 1 ]", Serve: SERVE_MENU},
+	'2': {Icon: "[PhC]", Route: phLink, DefaultPort: PH_DEFAULT_PORT},
+	'3': {Icon: "[ERR]", Style: "error", NoLink: true},
+	'4': {Icon: "[HQX]", MIME: "application/mac-binhex40", Serve: SERVE_BINHEX},
+	'5': {Icon: "[DOS]", Download: true, Serve: SERVE_ARCHIVE},
+	'6': {Icon: "[UUE]", MIME: "text/plain; charset=utf-8", Serve: SERVE_UUDECODE},
+	'7': {Icon: "[ 7 ]", Serve: SERVE_MENU, Route: searchLink, Mirrors: true},
+	'8': {Icon: "[TEL]", Route: telnetRoute, DefaultPort: TELNET_DEFAULT_PORT},
+	'9': {Icon: "[BIN]", Download: true, Serve: SERVE_ARCHIVE},
+	'+': {Icon: "[ + ]", Style: "error"}, // a redundant server with no item before it
+	'T': {Icon: "[TN3]", Route: tn3270Route, DefaultPort: TELNET_DEFAULT_PORT},
+	'g': {Icon: "[GIF]", MIME: "image/gif", Serve: SERVE_IMAGE},
+	'I': {Icon: "[IMG]", Serve: SERVE_IMAGE},
+
+	// Gopher+ and common practice
+	'i': {Icon: "[ i ]", Style: "info", NoLink: true, Serve: SERVE_TEXT},
+	'h': {Icon: "[HTM]", MIME: "text/html; charset=utf-8"},
+	's': {Icon: "[SND]", Serve: SERVE_SOUND},
+	'<': {Icon: "[SND]", Serve: SERVE_SOUND},
+	';': {Icon: "[VID]", Page: PAGE_VIDEO},
+	'd': {Icon: "[DOC]", Page: PAGE_DOCUMENT},
+	'P': {Icon: "[PDF]", MIME: "application/pdf", Page: PAGE_DOCUMENT},
+	'p': {Icon: "[PNG]", MIME: "image/png"},
+	':': {Icon: "[BMP]", Serve: SERVE_IMAGE},
+	'c': {Icon: "[CAL]", Serve: SERVE_TEXT},
+	'M': {Icon: "[MIM]", Serve: SERVE_TEXT},
+	'X': {Icon: "[XML]", Serve: SERVE_TEXT},
+}
+
+// kindOf looks up an item type; unknown types are served as opaque bytes.
+func kindOf(itemType byte) *itemKind {
+	if kind, ok := itemKinds[itemType]; ok {
+		return kind
+	}
+	return &itemKind{Icon: fmt.Sprintf("[!%c!]", itemType), Style: "error"}
+}
+
+// mediaTypes covers the extensions gopher holes actually use, ahead of the
+// platform's table, which often lacks the older sound and document formats.
+var mediaTypes = map[string]string{
+	".au": "audio/basic", ".snd": "audio/basic", ".wav": "audio/wav", ".voc": "audio/x-voc",
+	".mp3": "audio/mpeg", ".ogg": "audio/ogg", ".oga": "audio/ogg", ".opus": "audio/ogg",
+	".flac": "audio/flac", ".mid": "audio/midi", ".midi": "audio/midi", ".m4a": "audio/mp4",
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".webm": "video/webm", ".ogv": "video/ogg",
+	".mov": "video/quicktime", ".avi": "video/x-msvideo", ".mkv": "video/x-matroska",
+	".pdf": "application/pdf", ".ps": "application/postscript", ".rtf": "application/rtf",
+	".doc": "application/msword", ".odt": "application/vnd.oasis.opendocument.text",
+	".txt": "text/plain; charset=utf-8", ".bmp": "image/bmp", ".ics": "text/calendar",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+}
+
+// guessType returns the media type suggested by the selector's extension, or "".
+func guessType(selector string) string {
+	ext := strings.ToLower(path.Ext(selector))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// contentType picks the Content-Type for a fetched item: the registry's,
+// then the extension's, then whatever the first bytes suggest.
+func contentType(g *GopherURL, conn *gopherConn) string {
+	if t := declaredType(g); t != "" {
+		return t
+	}
+	return conn.Sniff()
+}
+
+// declaredType is the Content-Type the item type or extension gives, or "".
+func declaredType(g *GopherURL) string {
+	if kind := kindOf(g.Type); kind.MIME != "" {
+		return kind.MIME
+	}
+	return guessType(g.Selector)
+}
+
+// setRemoteHeaders marks a reply as remote content. Remote HTML is sandboxed,
+// since on gofer's origin its scripts could otherwise drive gofer itself.
+func setRemoteHeaders(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// a type Go can't parse may still be one a browser renders
+		media, _, _ = strings.Cut(strings.ToLower(contentType), ";")
+	}
+	switch {
+	case strings.Contains(media, "html"), strings.Contains(media, "xml"):
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+}
+
+// setDownload makes the browser save the reply under the given name.
+func setDownload(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
+
+const (
+	DECODE_MAX_SIZE   = 64 << 20            // bounds the items gofer buffers in order to decode them
+	DECODE_CACHE_SIZE = 2 * DECODE_MAX_SIZE // decoded items kept for their view pages' own requests
+	DECODE_CACHE_TTL  = 2 * time.Minute
+)
+
+// readDecodable buffers a whole reply that has to be decoded before any of it is sent.
+func readDecodable(conn *gopherConn) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(conn, DECODE_MAX_SIZE+1))
+	if err != nil {
+		return nil, fmt.Errorf("transfer interrupted: %w", err)
+	}
+	if len(b) > DECODE_MAX_SIZE {
+		return nil, fmt.Errorf("larger than %d MB, too large to decode", DECODE_MAX_SIZE>>20)
+	}
+	if conn.conn != nil {
+		rememberDecodable(conn.Host, conn.Port, conn.Selector, b)
+	}
+	return b, nil
+}
+
+// a buffered reply, kept for the requests its view page makes back
+type decodedReply struct {
+	data    []byte
+	expires time.Time
+}
+
+var decodeCacheMux sync.Mutex
+var decodeCache = map[string]*decodedReply{}
+
+func decodeCacheKey(host, port, selector string) string {
+	return net.JoinHostPort(host, port) + "\t" + selector
+}
+
+// -----------------------------------------------------------
+// rememberDecodable(host, port, selector, data)
+//
+// Keeps a reply that was buffered for decoding. The page gofer
+// renders from it links back to the same item (image=png, raw=1,
+// fork=data, member=name), and without the cache every one of
+// those requests fetched the whole item again. Entries expire
+// after DECODE_CACHE_TTL; past DECODE_CACHE_SIZE the ones
+// closest to expiring go first.
+// -----------------------------------------------------------
+func rememberDecodable(host, port, selector string, data []byte) {
+	key := decodeCacheKey(host, port, selector)
+	now := time.Now()
+
+	decodeCacheMux.Lock()
+	defer decodeCacheMux.Unlock()
+	delete(decodeCache, key)
+	total := len(data)
+	for k, e := range decodeCache {
+		if now.After(e.expires) {
+			delete(decodeCache, k)
+		} else {
+			total += len(e.data)
+		}
+	}
+	for total > DECODE_CACHE_SIZE {
+		oldest := ""
+		for k, e := range decodeCache {
+			if oldest == "" || e.expires.Before(decodeCache[oldest].expires) {
+				oldest = k
+			}
+		}
+		total -= len(decodeCache[oldest].data)
+		delete(decodeCache, oldest)
+	}
+	decodeCache[key] = &decodedReply{data: data, expires: now.Add(DECODE_CACHE_TTL)}
+}
+
+// cachedGopherConn replays a remembered reply from the first of the targets
+// that has one, or returns nil. The replay has no socket behind it.
+func cachedGopherConn(targets []*GopherURL) *gopherConn {
+	decodeCacheMux.Lock()
+	defer decodeCacheMux.Unlock()
+	for i, g := range targets {
+		e := decodeCache[decodeCacheKey(g.Host, g.Port, g.Selector)]
+		if e == nil || time.Now().After(e.expires) {
+			continue
+		}
+		c := &gopherConn{Host: g.Host, Port: g.Port, Selector: g.Selector}
+		for _, t := range targets[:i] {
+			c.Tried = append(c.Tried, t.Address())
+		}
+		c.reader = bufio.NewReaderSize(bytes.NewReader(e.data), SNIFF_LENGTH)
+		return c
+	}
+	return nil
+}
+
+// safeFileName makes a name embedded in an encoded file safe to save under:
+// no directories, no separators, no control characters.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "untitled"
+	}
+	return name
+}
+
+// sendDownload sends a file gofer decoded from an item as an attachment.
+func sendDownload(w http.ResponseWriter, name string, data []byte) error {
+	setRemoteHeaders(w, "application/octet-stream")
+	setDownload(w, name)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err := w.Write(data)
+	return err
+}
+
+// withParams links back to the current item with query parameters set,
+// e.g. to one part of a decoded file.
+func withParams(r *http.Request, pairs ...string) string {
+	query := r.URL.Query()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		query.Set(pairs[i], pairs[i+1])
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// itemFileName names a download after the last part of its selector.
+func itemFileName(g *GopherURL) string {
+	name := path.Base(strings.ReplaceAll(g.Selector, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = g.Host + "-item"
+	}
+	return name
+}
+
+// --- Routes ---
+
+// phLink opens a Ph/CSO server in the Ph client.
+func phLink(g *GopherURL, returnTo string) string {
+	href := fmt.Sprintf("/ph/%s:%s?return=%s",
+		url.PathEscape(g.Host),
+		url.PathEscape(g.Port),
+		url.QueryEscape(returnTo),
+	)
+
+	// Only attach selector parameter if the gopher entry actually had one
+	if g.Selector != "" {
+		href += "&selector=" + url.QueryEscape(g.Selector)
+	}
+	return href
+}
+
+// searchLink asks for a search string first; a search that has one is fetched.
+func searchLink(g *GopherURL, returnTo string) string {
+	if g.Search != "" {
+		return ""
+	}
+	return fmt.Sprintf("/search?host=%s&port=%s&selector=%s&return=%s",
+		url.QueryEscape(g.Host),
+		url.QueryEscape(g.Port),
+		url.QueryEscape(g.Selector),
+		url.QueryEscape(returnTo),
+	)
+}
+
+func telnetRoute(g *GopherURL, returnTo string) string {
+	return telnetLink(g.Host, g.Port, g.Selector, returnTo)
+}
+
+func tn3270Route(g *GopherURL, returnTo string) string {
+	return tn3270Link(g.Host, g.Port, g.Selector, returnTo)
+}
+
+// --- Media and Document Pages ---
+
+// mediaView is the data for the "media" template.
+type mediaView struct {
+	pageHead
+	Page     string // PAGE_VIDEO or PAGE_DOCUMENT
+	Type     string // media type, for the player's source element
+	Inline   bool   // the browser can show the document itself
+	Src      string
+	Download string
+	Name     string
+	Return   returnLink
+}
+
+// serveMediaPage wraps a video or document item in a page with a
+// player or viewer and a download link. Nothing is fetched until the page loads it.
+func serveMediaPage(w http.ResponseWriter, r *http.Request, g *GopherURL, mirrors []*GopherURL) {
+	raw := g.LocalPath() + "&raw=1" + mirrorQuery(mirrors)
+	media := guessType(g.Selector)
+	if kind := kindOf(g.Type); kind.MIME != "" {
+		media = kind.MIME
+	}
+
+	// PDFs, text, and images are shown by the browser; other documents are downloaded
+	inline := strings.HasPrefix(media, "application/pdf") || strings.HasPrefix(media, "text/") || strings.HasPrefix(media, "image/")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderTemplate(w, "media", mediaView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Page:     kindOf(g.Type).Page,
+		Type:     media,
+		Inline:   inline,
+		Src:      raw,
+		Download: raw + "&download=1",
+		Name:     itemFileName(g),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	})
+}
+
+// refererPath returns the local page that linked here, or the root menu.
+func refererPath(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.RequestURI()
+}
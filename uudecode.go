@@ -0,0 +1,288 @@
+// uudecode module for gofer 0.9
+// decodes uuencoded, xxencoded, and begin-base64 files (type 6 items)
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	UU_LINE_BYTES   = 45 // bytes per full line, for uuencode and xxencode alike
+	XXENCODE_CHARS  = "+-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	UU_BASE64_BEGIN = "begin-base64"
+	UU_BASE64_END   = "===="
+)
+
+// "begin 644 name" or "begin-base64 644 name"; the name may contain spaces
+var uuBeginLine = regexp.MustCompile(`^(begin|begin-base64) +([0-7]{3,4}) +(.+?)\s*$`)
+
+// "size 1234", which some encoders write after "end"
+var uuSizeLine = regexp.MustCompile(`^size +([0-9]+)\s*$`)
+
+// uuValues and xxValues map characters to their 6-bit values, or 0xFF.
+var uuValues, xxValues = func() (uu, xx [256]byte) {
+	for i := range uu {
+		uu[i], xx[i] = 0xFF, 0xFF
+	}
+	for c := 0x20; c <= 0x60; c++ {
+		uu[c] = byte(c-0x20) & 0x3F // "`" stands in for space
+	}
+	for i := 0; i < len(XXENCODE_CHARS); i++ {
+		xx[XXENCODE_CHARS[i]] = byte(i)
+	}
+	return uu, xx
+}()
+
+// UUFile is one file decoded from a type 6 item.
+type UUFile struct {
+	Name string
+	Mode string // octal, as written in the begin line
+	Data []byte
+}
+
+// FileName is the embedded name made safe to save under.
+func (f *UUFile) FileName() string {
+	return safeFileName(path.Base(strings.ReplaceAll(f.Name, "\\", "/")))
+}
+
+// -----------------------------------------------------------
+// DecodeUU(text) -> files, error
+//
+// Decodes every begin...end block in the text. Lines that are
+// not encoded data (mail headers, "cut here" lines, blank
+// lines) are skipped, so a file posted in several parts
+// decodes once the parts are joined. On an error the files
+// decoded before it are returned with it.
+// -----------------------------------------------------------
+func DecodeUU(text []byte) ([]*UUFile, error) {
+	lines := strings.Split(string(text), "\n")
+	var files []*UUFile
+
+	for i := 0; i < len(lines); i++ {
+		begin := uuBeginLine.FindStringSubmatch(strings.TrimRight(lines[i], "\r"))
+		if begin == nil {
+			continue
+		}
+
+		f := &UUFile{Name: begin[3], Mode: begin[2]}
+		var err error
+		if begin[1] == UU_BASE64_BEGIN {
+			f.Data, i, err = decodeBase64Block(lines, i+1)
+		} else {
+			f.Data, i, err = decodeUUBlock(lines, i+1)
+		}
+		if err != nil {
+			return files, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		files = append(files, f)
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no \"begin\" line found; this doesn't look like a uuencoded file")
+	}
+	return files, nil
+}
+
+// decodeUUBlock decodes uuencode or xxencode lines up to "end", returning
+// the data and the index of the last line it used.
+func decodeUUBlock(lines []string, start int) ([]byte, int, error) {
+	var table *[256]byte
+	var out []byte
+
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+
+		switch {
+		case strings.TrimSpace(line) == "end":
+			end, err := checkUUSize(lines, i, len(out))
+			return out, end, err
+		case uuBeginLine.MatchString(line):
+			return nil, i, fmt.Errorf("line %d: a new \"begin\" before this file's \"end\"", i+1)
+		case line == "":
+			continue
+		}
+
+		// the first data line tells the two encodings apart: xxencode
+		// uses lower case letters, which uuencode never does
+		if table == nil {
+			table = &uuValues
+			if strings.IndexFunc(line, func(r rune) bool { return r > 0x60 }) >= 0 {
+				table = &xxValues
+			}
+		}
+
+		data, ok, err := decodeUULine(line, table)
+		if err != nil {
+			return nil, i, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !ok {
+			continue // not encoded data, e.g. the headers between two parts
+		}
+		out = append(out, data...)
+		if len(out) > DECODE_MAX_SIZE {
+			return nil, i, errors.New("decoded file is too large")
+		}
+	}
+	return nil, len(lines), errors.New("no \"end\" line; the file is truncated, or a part of it is missing")
+}
+
+// -----------------------------------------------------------
+// decodeUULine(line, table) -> data, ok, error
+//
+// The first character gives the number of bytes on the line;
+// every four characters after it carry three bytes. Encoders
+// that strip trailing spaces leave lines short, so missing
+// padding characters count as zero. One character more than
+// needed is the line checksum some encoders add (the sum of
+// the bytes, mod 64). ok is false for lines that aren't data,
+// judged by their characters and length.
+// -----------------------------------------------------------
+func decodeUULine(line string, table *[256]byte) ([]byte, bool, error) {
+	for i := 0; i < len(line); i++ {
+		if table[line[i]] == 0xFF {
+			return nil, false, nil
+		}
+	}
+
+	n := int(table[line[0]])
+	chars := line[1:]
+	need := (n + 2) / 3 * 4
+	if n > UU_LINE_BYTES || len(chars) < (n*4+2)/3 || len(chars) > need+1 {
+		return nil, false, nil
+	}
+
+	checksum := -1
+	if len(chars) == need+1 {
+		checksum = int(table[chars[need]])
+		chars = chars[:need]
+	}
+	values := make([]byte, need) // short lines leave zeros at the end
+	for i, c := range chars {
+		values[i] = table[c]
+	}
+
+	out := make([]byte, 0, need/4*3)
+	for i := 0; i < need; i += 4 {
+		a, b, c, d := values[i], values[i+1], values[i+2], values[i+3]
+		out = append(out, a<<2|b>>4, b<<4|c>>2, c<<6|d)
+	}
+	out = out[:n]
+
+	if checksum >= 0 {
+		sum := 0
+		for _, b := range out {
+			sum += int(b)
+		}
+		if sum%64 != checksum {
+			return nil, false, fmt.Errorf("checksum mismatch (line says %d, data sums to %d)", checksum, sum%64)
+		}
+	}
+	return out, true, nil
+}
+
+// checkUUSize compares the optional "size" line after "end" with the data.
+func checkUUSize(lines []string, end int, size int) (int, error) {
+	if end+1 >= len(lines) {
+		return end, nil
+	}
+	m := uuSizeLine.FindStringSubmatch(strings.TrimRight(lines[end+1], "\r"))
+	if m == nil {
+		return end, nil
+	}
+	if want, _ := strconv.Atoi(m[1]); want != size {
+		return end + 1, fmt.Errorf("length mismatch: the size line says %s bytes, but %d were decoded", m[1], size)
+	}
+	return end + 1, nil
+}
+
+// decodeBase64Block decodes begin-base64 lines up to "====".
+func decodeBase64Block(lines []string, start int) ([]byte, int, error) {
+	var encoded strings.Builder
+
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == UU_BASE64_END {
+			data, err := base64.StdEncoding.DecodeString(encoded.String())
+			if err != nil {
+				return nil, i, fmt.Errorf("bad base64 data: %w", err)
+			}
+			return data, i, nil
+		}
+		if encoded.Len() > DECODE_MAX_SIZE/3*4 {
+			return nil, i, errors.New("decoded file is too large")
+		}
+		encoded.WriteString(line)
+	}
+	return nil, len(lines), errors.New("no \"====\" line; the file is truncated, or a part of it is missing")
+}
+
+// --- HTTP Handler ---
+
+// uuFileView is one decoded file on the "uudecode" page.
+type uuFileView struct {
+	Name string
+	Mode string
+	Size int
+	Href string
+}
+
+// uudecodeView is the data for the "uudecode" template.
+type uudecodeView struct {
+	pageHead
+	Name     string
+	Error    string
+	Files    []uuFileView
+	Original string
+	Return   returnLink
+}
+
+// serveUUDecode decodes a type 6 item. A single file is sent straight away
+// under its embedded name; an item holding several files, or one that fails
+// to decode, gets a page instead. file=N sends the Nth file, and raw=1
+// (handled by serveGopher) the encoded text.
+func serveUUDecode(w http.ResponseWriter, r *http.Request, g *GopherURL, conn *gopherConn) error {
+	text, err := readDecodable(conn)
+
+	var files []*UUFile
+	if err == nil {
+		files, err = DecodeUU(text)
+	}
+
+	if n, convErr := strconv.Atoi(r.URL.Query().Get("file")); convErr == nil && n >= 1 && n <= len(files) {
+		return sendDownload(w, files[n-1].FileName(), files[n-1].Data)
+	}
+	if err == nil && len(files) == 1 {
+		return sendDownload(w, files[0].FileName(), files[0].Data)
+	}
+
+	view := uudecodeView{
+		pageHead: pageHead{Title: "gofer - " + itemFileName(g)},
+		Name:     itemFileName(g),
+		Original: withParams(r, "raw", "1", "download", "1"),
+		Return:   returnLink{Href: refererPath(r), Label: "Return"},
+	}
+	if err != nil {
+		view.Error = err.Error()
+	}
+	for i, f := range files {
+		view.Files = append(view.Files, uuFileView{
+			Name: f.FileName(),
+			Mode: f.Mode,
+			Size: len(f.Data),
+			Href: withParams(r, "file", strconv.Itoa(i+1)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return renderTemplate(w, "uudecode", view)
+}
@@ -0,0 +1,111 @@
+// uudecode tests for gofer 0.9
+// uuencode, xxencode, and begin-base64 samples, good and corrupt
+// (C) 2025 Isaac Roll
+// See github.com/iroll/gofer for license
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// the same 60 bytes in each encoding, made by independent encoders
+const testUUText = "The quick brown fox jumps over the lazy gopher, twice over.\n"
+
+const (
+	testUU = "begin 644 fox.txt\n" +
+		"M5&AE('%U:6-K(&)R;W=N(&9O>\"!J=6UP<R!O=F5R('1H92!L87IY(&=O<&AE\n" +
+		"/<BP@='=I8V4@;W9E<BX*\n" +
+		"`\nend\nsize 60\n"
+	testXX = "begin 600 fox.xx\n" +
+		"hJ4VZ653pOKBf647mPrRi64NjS0-eRKpkQm-jRaJm65FcNG-gMLdt64RjQ4VZ\n" +
+		"DQWkUR5RdMqIUPrNZQWs8\n" +
+		"+\nend\n"
+	testUU64 = "begin-base64 644 fox.b64\n" +
+		"VGhlIHF1aWNrIGJyb3duIGZveCBqdW1wcyBvdmVyIHRoZSBsYXp5IGdvcGhlciwgdHdpY2Ugb3Zl\n" +
+		"ci4K\n" +
+		"====\n"
+)
+
+func TestDecodeUU(t *testing.T) {
+	tests := []struct {
+		name, text, file, mode string
+	}{
+		{"uuencode", testUU, "fox.txt", "644"},
+		{"uuencode, CRLF", strings.ReplaceAll(testUU, "\n", "\r\n"), "fox.txt", "644"},
+		{"uuencode in a mail", "From: someone\nSubject: fox\n\n--- cut here ---\n" + testUU + "--- cut here ---\n", "fox.txt", "644"},
+		{"xxencode", testXX, "fox.xx", "600"},
+		{"begin-base64", testUU64, "fox.b64", "644"},
+	}
+	for _, tt := range tests {
+		files, err := DecodeUU([]byte(tt.text))
+		if err != nil || len(files) != 1 {
+			t.Errorf("%s: %d files, %v", tt.name, len(files), err)
+			continue
+		}
+		f := files[0]
+		if f.Name != tt.file || f.Mode != tt.mode || string(f.Data) != testUUText {
+			t.Errorf("%s: %q %s %q", tt.name, f.Name, f.Mode, f.Data)
+		}
+	}
+
+	files, err := DecodeUU([]byte(testUU + "\n" + testXX))
+	if err != nil || len(files) != 2 || files[1].Name != "fox.xx" {
+		t.Errorf("two files in one item: %d files, %v", len(files), err)
+	}
+}
+
+func TestDecodeUULine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+		err  string
+	}{
+		{line: "#8V%T", want: "cat", ok: true},
+		{line: "#8V%TX", want: "cat", ok: true},    // with the line checksum, 56
+		{line: "#8V%TY", err: "checksum mismatch"}, // checksum off by one
+		{line: "\"8V$`", want: "ca", ok: true},
+		{line: "\"8V$", want: "ca", ok: true}, // the same, with the trailing space stripped
+		{line: "#8V", ok: false},              // too short for three bytes
+		{line: "#8V%TXXX", ok: false},         // too long
+		{line: "Subject: cat", ok: false},     // lower case isn't uuencode
+		{line: "`", want: "", ok: true},       // the empty last line
+	}
+	for _, tt := range tests {
+		got, ok, err := decodeUULine(tt.line, &uuValues)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err = %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || ok != tt.ok || ok && string(got) != tt.want {
+			t.Errorf("%q = %q, %v, %v, want %q, %v", tt.line, got, ok, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDecodeUUCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"no begin line", "just some text\n", "no \"begin\" line"},
+		{"no end line", strings.TrimSuffix(testUU, "end\nsize 60\n"), "no \"end\" line"},
+		{"size line too large", strings.Replace(testUU, "size 60", "size 61", 1), "length mismatch"},
+		{"size line too small", strings.Replace(testUU, "size 60", "size 6", 1), "size line says 6 bytes"},
+		{"begin before end", "begin 644 a\n#8V%T\nbegin 644 b\n#8V%T\n`\nend\n", "new \"begin\""},
+		{"bad checksum", "begin 644 a\n#8V%TY\n`\nend\n", "line 2: checksum mismatch"},
+		{"no base64 end", strings.TrimSuffix(testUU64, "====\n"), "no \"====\" line"},
+		{"bad base64", "begin-base64 644 a\n!!!!\n====\n", "bad base64 data"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeUU([]byte(tt.text))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}